@@ -0,0 +1,535 @@
+package approx
+
+import (
+	"container/heap"
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/CraigKelly/grample/model"
+)
+
+// Schedule is the order in which BP messages are updated
+type Schedule int
+
+// Supported schedules
+const (
+	Flooding Schedule = iota // Every message is updated (synchronously) each iteration
+	Residual                 // The message that would change the most is always updated next
+)
+
+// Schedules lists every supported schedule
+var Schedules = []Schedule{Flooding, Residual}
+
+// String implements fmt.Stringer
+func (s Schedule) String() string {
+	switch s {
+	case Flooding:
+		return "flooding"
+	case Residual:
+		return "residual"
+	}
+	return "unknown"
+}
+
+// ParseSchedule returns the schedule for the given name (as returned by
+// String)
+func ParseSchedule(name string) (Schedule, error) {
+	for _, s := range Schedules {
+		if strings.ToLower(name) == s.String() {
+			return s, nil
+		}
+	}
+	return Residual, errors.Errorf("Unknown BP schedule %s", name)
+}
+
+// BeliefProp is loopy belief propagation on the factor graph of a model. Each
+// function is a factor node and there is an edge between a function and every
+// variable in its scope. On a tree the resulting marginals are exact; on a
+// loopy graph they are an approximation (and BP might not converge at all).
+// Evidence is read from the model's variables every time Run is called.
+type BeliefProp struct {
+	Damping   float64  // Weight given to the old message on update: 0 is no damping
+	Tolerance float64  // Converged when no message changes by more than this
+	MaxIters  int      // Maximum iterations (a residual iteration is one update per edge)
+	Schedule  Schedule // Message update order
+
+	Iterations  int     // Iterations performed by the last Run
+	Converged   bool    // True if the last Run converged
+	MaxResidual float64 // Largest message change remaining after the last Run
+
+	pgm      *model.Model
+	funcs    []*model.Function // Linear space copies of the model's functions
+	varEdges [][]edge          // Edges for each variable
+	toVar    [][][]float64     // toVar[f][j] is the message from function f to its j'th var
+	toFunc   [][][]float64     // toFunc[f][j] is the message to function f from its j'th var
+	ran      bool
+}
+
+// edge identifies the connection between function f and its j'th variable
+type edge struct {
+	f int
+	j int
+}
+
+// NewBeliefProp creates a BP solver for the model with default settings:
+// residual schedule, no damping, tolerance of 1e-6, and 1000 iterations.
+func NewBeliefProp(m *model.Model) (*BeliefProp, error) {
+	if m == nil {
+		return nil, errors.New("No model supplied")
+	}
+
+	bp := &BeliefProp{
+		Damping:   0.0,
+		Tolerance: 1e-6,
+		MaxIters:  1000,
+		Schedule:  Residual,
+		pgm:       m,
+		funcs:     make([]*model.Function, len(m.Funcs)),
+		varEdges:  make([][]edge, len(m.Vars)),
+		toVar:     make([][][]float64, len(m.Funcs)),
+		toFunc:    make([][][]float64, len(m.Funcs)),
+	}
+
+	for i, v := range m.Vars {
+		if i != v.ID {
+			return nil, errors.Errorf("Invalid ID for var %s: expected %d but was %d", v.Name, i, v.ID)
+		}
+	}
+
+	for fi, f := range m.Funcs {
+		lin := f.Clone()
+		if lin.IsLog {
+			for i, v := range lin.Table {
+				lin.Table[i] = math.Exp(v)
+			}
+			lin.IsLog = false
+		}
+		bp.funcs[fi] = lin
+
+		bp.toVar[fi] = make([][]float64, len(f.Vars))
+		bp.toFunc[fi] = make([][]float64, len(f.Vars))
+		for j, v := range f.Vars {
+			if v.ID < 0 || v.ID >= len(m.Vars) {
+				return nil, errors.Errorf("Function %s has invalid var ID %d", f.Name, v.ID)
+			}
+			bp.toVar[fi][j] = make([]float64, v.Card)
+			bp.toFunc[fi][j] = make([]float64, v.Card)
+			bp.varEdges[v.ID] = append(bp.varEdges[v.ID], edge{fi, j})
+		}
+	}
+
+	return bp, nil
+}
+
+// Run performs message passing until convergence or MaxIters. Messages
+// always start out uniform, so Run is deterministic. Not converging is NOT an
+// error: check Converged and MaxResidual. An error is returned if the
+// settings are invalid or if a message becomes zero (which happens when the
+// evidence is impossible).
+func (bp *BeliefProp) Run() error {
+	if bp.Damping < 0.0 || bp.Damping >= 1.0 {
+		return errors.Errorf("Damping must be in [0, 1): %f", bp.Damping)
+	}
+	if bp.MaxIters < 1 {
+		return errors.Errorf("MaxIters must be positive: %d", bp.MaxIters)
+	}
+
+	bp.Iterations = 0
+	bp.Converged = false
+	bp.MaxResidual = math.Inf(1)
+	bp.ran = false
+
+	// Uniform messages everywhere, except that variables with evidence only
+	// send their allowed values (so a fixed variable always sends its value)
+	for fi, f := range bp.funcs {
+		for j, v := range f.Vars {
+			uniform(bp.toVar[fi][j])
+			bp.evidenceMessage(bp.toFunc[fi][j], v.ID)
+		}
+	}
+
+	var err error
+	switch bp.Schedule {
+	case Flooding:
+		err = bp.runFlooding()
+	case Residual:
+		err = bp.runResidual()
+	default:
+		err = errors.Errorf("Unknown BP schedule %d", int(bp.Schedule))
+	}
+	if err != nil {
+		return err
+	}
+
+	// Messages to fixed vars are never used, but they tell us if a function
+	// contradicts the evidence
+	for fi, f := range bp.funcs {
+		for j, v := range f.Vars {
+			fixed := bp.pgm.Vars[v.ID].FixedVal
+			if fixed < 0 {
+				continue
+			}
+			msg := make([]float64, v.Card)
+			err = bp.factorMessage(fi, j, msg)
+			if err == nil && msg[fixed] <= 0.0 {
+				err = errors.Errorf("Function %s is zero for var %d=%d: evidence has zero probability", f.Name, v.ID, fixed)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	bp.ran = true
+	return nil
+}
+
+// runFlooding updates every factor-to-variable message each iteration using
+// the variable-to-factor messages from the previous iteration.
+func (bp *BeliefProp) runFlooding() error {
+	next := make([][][]float64, len(bp.funcs))
+	for fi, f := range bp.funcs {
+		next[fi] = make([][]float64, len(f.Vars))
+		for j, v := range f.Vars {
+			next[fi][j] = make([]float64, v.Card)
+		}
+	}
+
+	for bp.Iterations < bp.MaxIters {
+		bp.Iterations++
+
+		for fi, f := range bp.funcs {
+			for j, v := range f.Vars {
+				if bp.pgm.Vars[v.ID].FixedVal >= 0 {
+					continue // Never used
+				}
+				err := bp.factorMessage(fi, j, next[fi][j])
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		resid := 0.0
+		for fi, f := range bp.funcs {
+			for j, v := range f.Vars {
+				if bp.pgm.Vars[v.ID].FixedVal >= 0 {
+					continue
+				}
+				r := bp.commit(bp.toVar[fi][j], next[fi][j])
+				if r > resid {
+					resid = r
+				}
+			}
+		}
+		bp.MaxResidual = resid
+
+		for vi := range bp.varEdges {
+			err := bp.updateVar(vi)
+			if err != nil {
+				return err
+			}
+		}
+
+		if resid < bp.Tolerance {
+			bp.Converged = true
+			break
+		}
+	}
+
+	return nil
+}
+
+// runResidual is residual BP (Elidan, McGraw, and Koller 2006): we keep a
+// candidate for every factor-to-variable message and always commit the one
+// that differs most from the current message.
+func (bp *BeliefProp) runResidual() error {
+	cand := make([][][]float64, len(bp.funcs))
+	items := make([][]*residItem, len(bp.funcs))
+	queue := make(residQueue, 0)
+
+	for fi, f := range bp.funcs {
+		cand[fi] = make([][]float64, len(f.Vars))
+		items[fi] = make([]*residItem, len(f.Vars))
+		for j, v := range f.Vars {
+			cand[fi][j] = make([]float64, v.Card)
+			if bp.pgm.Vars[v.ID].FixedVal >= 0 {
+				continue // Never used
+			}
+
+			err := bp.factorMessage(fi, j, cand[fi][j])
+			if err != nil {
+				return err
+			}
+			it := &residItem{e: edge{fi, j}, resid: maxAbsDiff(cand[fi][j], bp.toVar[fi][j])}
+			items[fi][j] = it
+			heap.Push(&queue, it)
+		}
+	}
+
+	if len(queue) < 1 {
+		bp.Converged = true
+		bp.MaxResidual = 0.0
+		return nil
+	}
+
+	updates := 0
+	for {
+		top := queue[0]
+		bp.MaxResidual = top.resid
+		if top.resid < bp.Tolerance {
+			bp.Converged = true
+			break
+		}
+		if bp.Iterations >= bp.MaxIters {
+			break
+		}
+
+		// Commit the candidate: the candidate doesn't change (it only depends
+		// on messages from the other vars), but with damping it is still
+		// different from the committed message.
+		fi, j := top.e.f, top.e.j
+		bp.commit(bp.toVar[fi][j], cand[fi][j])
+		top.resid = maxAbsDiff(cand[fi][j], bp.toVar[fi][j])
+		heap.Fix(&queue, top.index)
+
+		// The var now sends new messages to all its other functions, which
+		// changes the candidates from those functions to their other vars
+		vi := bp.funcs[fi].Vars[j].ID
+		err := bp.updateVar(vi)
+		if err != nil {
+			return err
+		}
+		for _, e := range bp.varEdges[vi] {
+			if e == top.e {
+				continue
+			}
+			for k, v := range bp.funcs[e.f].Vars {
+				if k == e.j || items[e.f][k] == nil || v.ID == vi {
+					continue
+				}
+				err = bp.factorMessage(e.f, k, cand[e.f][k])
+				if err != nil {
+					return err
+				}
+				it := items[e.f][k]
+				it.resid = maxAbsDiff(cand[e.f][k], bp.toVar[e.f][k])
+				heap.Fix(&queue, it.index)
+			}
+		}
+
+		updates++
+		if updates >= len(queue) {
+			bp.Iterations++
+			updates = 0
+		}
+	}
+	if updates > 0 {
+		bp.Iterations++ // Partial iteration
+	}
+
+	return nil
+}
+
+// commit copies next into cur with damping and returns the largest change
+func (bp *BeliefProp) commit(cur []float64, next []float64) float64 {
+	resid := 0.0
+	for i, n := range next {
+		val := (1.0-bp.Damping)*n + bp.Damping*cur[i]
+		if d := math.Abs(val - cur[i]); d > resid {
+			resid = d
+		}
+		cur[i] = val
+	}
+	return resid
+}
+
+// factorMessage calculates the (normalized) message from function fi to its
+// j'th variable into out.
+func (bp *BeliefProp) factorMessage(fi int, j int, out []float64) error {
+	f := bp.funcs[fi]
+	in := bp.toFunc[fi]
+
+	for i := range out {
+		out[i] = 0.0
+	}
+
+	assign := make([]int, len(f.Vars))
+	for _, val := range f.Table {
+		for k, a := range assign {
+			if k != j {
+				val *= in[k][a]
+			}
+		}
+		out[assign[j]] += val
+
+		for k := len(assign) - 1; k >= 0; k-- {
+			assign[k]++
+			if assign[k] < f.Vars[k].Card {
+				break
+			}
+			assign[k] = 0
+		}
+	}
+
+	if !normalize(out) {
+		return errors.Errorf("Message from %s to var %d is zero: evidence has zero probability", f.Name, f.Vars[j].ID)
+	}
+	return nil
+}
+
+// updateVar recalculates every message from variable vi to its functions
+func (bp *BeliefProp) updateVar(vi int) error {
+	if bp.pgm.Vars[vi].FixedVal >= 0 {
+		return nil // Evidence messages never change
+	}
+
+	edges := bp.varEdges[vi]
+	for _, out := range edges {
+		msg := bp.toFunc[out.f][out.j]
+		bp.allowedOnes(msg, vi)
+		for _, in := range edges {
+			if in == out {
+				continue
+			}
+			for i, p := range bp.toVar[in.f][in.j] {
+				msg[i] *= p
+			}
+		}
+		if !normalize(msg) {
+			return errors.Errorf("Message from var %d to %s is zero: evidence has zero probability", vi, bp.funcs[out.f].Name)
+		}
+	}
+
+	return nil
+}
+
+// evidenceMessage sets msg to uniform over the values the evidence for var vi
+// allows (which is one-hot for a fixed var)
+func (bp *BeliefProp) evidenceMessage(msg []float64, vi int) {
+	bp.allowedOnes(msg, vi)
+	normalize(msg)
+}
+
+// allowedOnes sets msg to 1 for every value the evidence for var vi allows
+// and 0 for the rest (like Function.Mask)
+func (bp *BeliefProp) allowedOnes(msg []float64, vi int) {
+	v := bp.pgm.Vars[vi]
+	for i := range msg {
+		if v.Allows(i) {
+			msg[i] = 1.0
+		} else {
+			msg[i] = 0.0
+		}
+	}
+}
+
+// Marginal returns a copy of the variable with the BP belief as its marginal.
+// Run must be called first.
+func (bp *BeliefProp) Marginal(varIdx int) (*model.Variable, error) {
+	if !bp.ran {
+		return nil, errors.New("BP has not been run")
+	}
+	if varIdx < 0 || varIdx >= len(bp.pgm.Vars) {
+		return nil, errors.Errorf("Invalid variable index %d", varIdx)
+	}
+
+	v := bp.pgm.Vars[varIdx].Clone()
+	if v.FixedVal >= 0 {
+		bp.evidenceMessage(v.Marginal, v.ID)
+		return v, nil
+	}
+
+	bp.allowedOnes(v.Marginal, v.ID)
+	for _, e := range bp.varEdges[v.ID] {
+		for i, p := range bp.toVar[e.f][e.j] {
+			v.Marginal[i] *= p
+		}
+	}
+	if !normalize(v.Marginal) {
+		return nil, errors.Errorf("Belief for var %s is zero: evidence has zero probability", v.Name)
+	}
+
+	return v, nil
+}
+
+// Marginals returns the BP beliefs for every variable in the model
+func (bp *BeliefProp) Marginals() ([]*model.Variable, error) {
+	vars := make([]*model.Variable, len(bp.pgm.Vars))
+	for i := range bp.pgm.Vars {
+		v, err := bp.Marginal(i)
+		if err != nil {
+			return nil, err
+		}
+		vars[i] = v
+	}
+	return vars, nil
+}
+
+// uniform sets every entry in p to 1/len(p)
+func uniform(p []float64) {
+	for i := range p {
+		p[i] = 1.0 / float64(len(p))
+	}
+}
+
+// normalize scales p to sum to 1. False is returned if p sums to 0.
+func normalize(p []float64) bool {
+	sum := 0.0
+	for _, v := range p {
+		sum += v
+	}
+	if sum <= 0.0 || math.IsNaN(sum) || math.IsInf(sum, 0) {
+		return false
+	}
+	for i, v := range p {
+		p[i] = v / sum
+	}
+	return true
+}
+
+// maxAbsDiff is the largest absolute difference between p1 and p2
+func maxAbsDiff(p1 []float64, p2 []float64) float64 {
+	max := 0.0
+	for i, v := range p1 {
+		if d := math.Abs(v - p2[i]); d > max {
+			max = d
+		}
+	}
+	return max
+}
+
+// residItem is an entry in our residual priority queue
+type residItem struct {
+	e     edge
+	resid float64
+	index int
+}
+
+// residQueue is a max heap of residuals (implements heap.Interface)
+type residQueue []*residItem
+
+func (q residQueue) Len() int           { return len(q) }
+func (q residQueue) Less(i, j int) bool { return q[i].resid > q[j].resid }
+
+func (q residQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *residQueue) Push(x interface{}) {
+	it := x.(*residItem)
+	it.index = len(*q)
+	*q = append(*q, it)
+}
+
+func (q *residQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	it := old[n-1]
+	*q = old[:n-1]
+	return it
+}
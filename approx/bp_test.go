@@ -0,0 +1,223 @@
+package approx
+
+import (
+	"math"
+	"testing"
+
+	"github.com/CraigKelly/grample/exact"
+	"github.com/CraigKelly/grample/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// exactMarginals uses variable elimination to get the true marginals
+func exactMarginals(t *testing.T, m *model.Model) []*model.Variable {
+	ve, err := exact.NewVarElim(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, err := ve.Marginals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vars
+}
+
+// loopModel is a small loopy model with weak pairwise functions
+func loopModel(t *testing.T) *model.Model {
+	cards := []int{2, 3, 2, 2}
+	vars := make([]*model.Variable, len(cards))
+	for i, c := range cards {
+		v, err := model.NewVariable(i, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vars[i] = v
+	}
+
+	scopes := [][]int{{0, 1}, {1, 2}, {2, 3}, {3, 0}, {0}}
+	funcs := make([]*model.Function, len(scopes))
+	for i, sc := range scopes {
+		fv := make([]*model.Variable, len(sc))
+		for j, idx := range sc {
+			fv[j] = vars[idx]
+		}
+		f, err := model.NewFunction(i, fv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k := range f.Table {
+			f.Table[k] = 1.0 + 0.5*math.Mod(float64(k*5+i), 3.0)
+		}
+		funcs[i] = f
+	}
+
+	return &model.Model{Type: model.MARKOV, Name: "loop", Vars: vars, Funcs: funcs}
+}
+
+func TestParseSchedule(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, s := range Schedules {
+		p, err := ParseSchedule(s.String())
+		assert.NoError(err)
+		assert.Equal(s, p)
+	}
+
+	_, err := ParseSchedule("random")
+	assert.Error(err)
+}
+
+func TestBeliefPropTree(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := model.UAIReader{}
+	mod, err := model.NewModelFromFile(reader, "../res/sample.uai", false)
+	assert.NoError(err)
+
+	bp, err := NewBeliefProp(mod)
+	assert.NoError(err)
+
+	_, err = bp.Marginal(0)
+	assert.Error(err) // Not run yet
+
+	// BP is exact on a tree, for every schedule and with damping
+	for _, sched := range Schedules {
+		for _, damp := range []float64{0.0, 0.5} {
+			for _, fixed := range []int{-1, 1} {
+				mod.Vars[0].FixedVal = fixed
+				expected := exactMarginals(t, mod)
+
+				bp.Schedule = sched
+				bp.Damping = damp
+				assert.NoError(bp.Run())
+				assert.True(bp.Converged)
+				assert.True(bp.MaxResidual < bp.Tolerance)
+
+				vars, err := bp.Marginals()
+				assert.NoError(err)
+				for i, v := range vars {
+					assert.InDeltaSlice(expected[i].Marginal, v.Marginal, 1e-5)
+				}
+			}
+		}
+	}
+	mod.Vars[0].FixedVal = -1
+
+	// Bad settings
+	bp.Damping = 1.0
+	assert.Error(bp.Run())
+	bp.Damping = 0.0
+	bp.MaxIters = 0
+	assert.Error(bp.Run())
+	bp.MaxIters = 10
+	bp.Schedule = Schedule(42)
+	assert.Error(bp.Run())
+}
+
+func TestBeliefPropLoop(t *testing.T) {
+	assert := assert.New(t)
+
+	mod := loopModel(t)
+	expected := exactMarginals(t, mod)
+
+	bp, err := NewBeliefProp(mod)
+	assert.NoError(err)
+
+	var prev []*model.Variable
+	for _, sched := range Schedules {
+		bp.Schedule = sched
+		assert.NoError(bp.Run())
+		assert.True(bp.Converged)
+		assert.True(bp.Iterations > 0)
+
+		vars, err := bp.Marginals()
+		assert.NoError(err)
+
+		// Approximate, but close on a weak loop
+		for i, v := range vars {
+			assert.InDeltaSlice(expected[i].Marginal, v.Marginal, 0.02)
+		}
+
+		// Both schedules find the same fixed point
+		if prev != nil {
+			for i, v := range vars {
+				assert.InDeltaSlice(prev[i].Marginal, v.Marginal, 1e-5)
+			}
+		}
+		prev = vars
+	}
+
+	// Limiting iterations means no convergence (and no error)
+	bp.MaxIters = 1
+	bp.Schedule = Flooding
+	assert.NoError(bp.Run())
+	assert.False(bp.Converged)
+	assert.Equal(1, bp.Iterations)
+}
+
+func TestBeliefPropEvidence(t *testing.T) {
+	assert := assert.New(t)
+
+	mod := loopModel(t)
+	mod.Vars[1].FixedVal = 2
+	expected := exactMarginals(t, mod)
+
+	bp, err := NewBeliefProp(mod)
+	assert.NoError(err)
+	assert.NoError(bp.Run())
+	assert.True(bp.Converged)
+
+	vars, err := bp.Marginals()
+	assert.NoError(err)
+	assert.Equal([]float64{0.0, 0.0, 1.0}, vars[1].Marginal)
+
+	// Fixing a var on the loop turns the rest into a tree: BP is exact
+	for i, v := range vars {
+		assert.InDeltaSlice(expected[i].Marginal, v.Marginal, 1e-5)
+	}
+
+	// Impossible evidence
+	for i := range mod.Funcs[4].Table {
+		mod.Funcs[4].Table[i] = 0.0
+	}
+	mod.Funcs[4].Table[1] = 1.0
+	mod.Vars[0].FixedVal = 0
+	bp, err = NewBeliefProp(mod)
+	assert.NoError(err)
+	assert.Error(bp.Run())
+}
+
+// Domain evidence zeros out the disallowed values
+func TestBeliefPropDomain(t *testing.T) {
+	assert := assert.New(t)
+
+	mod := loopModel(t)
+	assert.NoError(mod.RestrictDomains(model.DomainEvidence{1: {2}, 3: {0, 1}}))
+	expected := exactMarginals(t, mod)
+
+	bp, err := NewBeliefProp(mod)
+	assert.NoError(err)
+	assert.NoError(bp.Run())
+	assert.True(bp.Converged)
+
+	vars, err := bp.Marginals()
+	assert.NoError(err)
+	assert.Equal([]float64{0.0, 0.0, 1.0}, vars[1].Marginal)
+
+	// Restricting a var on the loop to one value turns the rest into a tree
+	for i, v := range vars {
+		assert.InDeltaSlice(expected[i].Marginal, v.Marginal, 1e-5)
+	}
+
+	// A restriction with more than one value still zeros the rest
+	mod = loopModel(t)
+	assert.NoError(mod.RestrictDomains(model.DomainEvidence{1: {0, 2}}))
+	bp, err = NewBeliefProp(mod)
+	assert.NoError(err)
+	assert.NoError(bp.Run())
+	v, err := bp.Marginal(1)
+	assert.NoError(err)
+	assert.Equal(0.0, v.Marginal[1])
+	assert.InDeltaSlice(exactMarginals(t, mod)[1].Marginal, v.Marginal, 0.05)
+}
@@ -0,0 +1,295 @@
+package approx
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+
+	"github.com/CraigKelly/grample/model"
+)
+
+// MeanField is naive mean-field variational inference: we approximate the
+// model with a fully factored distribution Q(X) = prod q_i(X_i) and use
+// coordinate ascent to maximize the ELBO (evidence lower bound). We work with
+// the model's exact log functions, so zero entries are -Inf and q_i gets
+// exact zeros on values that are impossible under the rest of Q. The ELBO is
+// a lower bound on log Z for the model as given, and it's -Inf only if Q puts
+// mass on a zero entry when we stop (see Run). Evidence is read from the
+// model's variables every time Run is called.
+type MeanField struct {
+	Tolerance float64 // Converged when no q_i entry changes by more than this in a sweep
+	MaxIters  int     // Maximum number of sweeps over all variables
+
+	Iterations int     // Sweeps performed by the last Run
+	Converged  bool    // True if the last Run converged
+	ELBO       float64 // Lower bound on log Z (including evidence) after the last Run
+
+	pgm      *model.Model
+	funcs    []*model.Function // Log space copies of the model's functions (log(0) is -Inf)
+	varEdges [][]edge          // Edges for each variable
+	q        [][]float64       // q[i] is the current approximate marginal for var i
+	ran      bool
+}
+
+// NewMeanField creates a mean-field solver for the model with default
+// settings: tolerance of 1e-6 and 1000 sweeps.
+func NewMeanField(m *model.Model) (*MeanField, error) {
+	if m == nil {
+		return nil, errors.New("No model supplied")
+	}
+
+	mf := &MeanField{
+		Tolerance: 1e-6,
+		MaxIters:  1000,
+		pgm:       m,
+		funcs:     make([]*model.Function, len(m.Funcs)),
+		varEdges:  make([][]edge, len(m.Vars)),
+		q:         make([][]float64, len(m.Vars)),
+	}
+
+	for i, v := range m.Vars {
+		if i != v.ID {
+			return nil, errors.Errorf("Invalid ID for var %s: expected %d but was %d", v.Name, i, v.ID)
+		}
+		mf.q[i] = make([]float64, v.Card)
+	}
+
+	for fi, f := range m.Funcs {
+		mf.funcs[fi] = f.ToLog()
+
+		for j, v := range f.Vars {
+			if v.ID < 0 || v.ID >= len(m.Vars) {
+				return nil, errors.Errorf("Function %s has invalid var ID %d", f.Name, v.ID)
+			}
+			mf.varEdges[v.ID] = append(mf.varEdges[v.ID], edge{fi, j})
+		}
+	}
+
+	return mf, nil
+}
+
+// Run performs coordinate ascent until convergence or MaxIters. Not
+// converging is NOT an error: the ELBO is still a valid lower bound. We start
+// from a uniform Q, unless the model has zero entries: then we start from a
+// single state in the model's support (see Model.SupportState), so the ELBO is
+// finite and coordinate ascent keeps it that way. If we can't find such a
+// state we start from uniform and hope the updates settle in to the support.
+// Either way Run is deterministic, and q_i is always zero on the values that
+// the evidence for var i doesn't allow.
+func (mf *MeanField) Run() error {
+	if mf.MaxIters < 1 {
+		return errors.Errorf("MaxIters must be positive: %d", mf.MaxIters)
+	}
+
+	mf.Iterations = 0
+	mf.Converged = false
+	mf.ELBO = math.Inf(-1)
+	mf.ran = false
+
+	var start []int
+	if mf.pgm.HasZeros() {
+		start, _ = mf.pgm.SupportState(model.SupportTries)
+	}
+	for i, v := range mf.pgm.Vars {
+		q := mf.q[i]
+		if v.FixedVal >= 0 || start != nil {
+			for c := range q {
+				q[c] = 0.0
+			}
+			if v.FixedVal >= 0 {
+				q[v.FixedVal] = 1.0
+			} else {
+				q[start[i]] = 1.0
+			}
+		} else {
+			for c := range q {
+				q[c] = 0.0
+				if v.Allows(c) {
+					q[c] = 1.0
+				}
+			}
+			normalize(q)
+		}
+	}
+
+	maxCard := 0
+	for _, v := range mf.pgm.Vars {
+		if v.Card > maxCard {
+			maxCard = v.Card
+		}
+	}
+	next := make([]float64, maxCard)
+	zeros := make([]float64, maxCard)
+
+	for mf.Iterations < mf.MaxIters {
+		mf.Iterations++
+
+		change := 0.0
+		for i, v := range mf.pgm.Vars {
+			if v.FixedVal >= 0 {
+				continue
+			}
+
+			// log q_i(x) = sum over our functions of E[log f | X_i=x] + const,
+			// which is -Inf if there's any mass on a zero entry
+			logq := next[:v.Card]
+			zero := zeros[:v.Card]
+			for c := range logq {
+				logq[c] = 0.0
+				zero[c] = 0.0
+			}
+			for _, e := range mf.varEdges[i] {
+				mf.expectLog(e, logq, zero)
+			}
+			for c := range zero {
+				if !v.Allows(c) {
+					zero[c] = math.Inf(1) // Domain evidence: never a candidate
+				}
+			}
+
+			// Only the values with the least mass on zero entries are
+			// candidates. Usually that's every value with no mass (so the
+			// rest are exact zeros), but if every value is impossible we
+			// pick the single best one so the rest of Q can settle in to
+			// the model's support.
+			minZero := math.Inf(1)
+			for _, z := range zero {
+				minZero = math.Min(minZero, z)
+			}
+			best := -1
+			for c, z := range zero {
+				if z > minZero {
+					logq[c] = math.Inf(-1)
+				} else if best < 0 || logq[c] > logq[best] {
+					best = c
+				}
+			}
+			if minZero > 0.0 {
+				for c := range logq {
+					if c != best {
+						logq[c] = math.Inf(-1)
+					}
+				}
+			}
+
+			max := logq[best]
+			for c, lp := range logq {
+				logq[c] = math.Exp(lp - max)
+			}
+			if !normalize(logq) {
+				return errors.Errorf("Invalid mean field update for var %s", v.Name)
+			}
+
+			for c, p := range logq {
+				if d := math.Abs(p - mf.q[i][c]); d > change {
+					change = d
+				}
+				mf.q[i][c] = p
+			}
+		}
+
+		if change < mf.Tolerance {
+			mf.Converged = true
+			break
+		}
+	}
+
+	mf.ELBO = mf.elbo()
+	mf.ran = true
+	return nil
+}
+
+// expectLog adds E[log f] to out for every value of the variable at edge e,
+// where the expectation is over the current q of the other vars in f. The
+// mass on zero entries (where log f is -Inf) is added to zeros instead.
+func (mf *MeanField) expectLog(e edge, out []float64, zeros []float64) {
+	f := mf.funcs[e.f]
+	assign := make([]int, len(f.Vars))
+	for _, lv := range f.Table {
+		w := 1.0
+		for k, a := range assign {
+			if k != e.j {
+				w *= mf.q[f.Vars[k].ID][a]
+			}
+		}
+		if w > 0.0 {
+			if math.IsInf(lv, -1) {
+				zeros[assign[e.j]] += w
+			} else {
+				out[assign[e.j]] += w * lv
+			}
+		}
+
+		for k := len(assign) - 1; k >= 0; k-- {
+			assign[k]++
+			if assign[k] < f.Vars[k].Card {
+				break
+			}
+			assign[k] = 0
+		}
+	}
+}
+
+// elbo returns E_Q[log P~(X)] + H(Q), where P~ is the unnormalized model
+func (mf *MeanField) elbo() float64 {
+	total := 0.0
+
+	for _, f := range mf.funcs {
+		assign := make([]int, len(f.Vars))
+		for _, lv := range f.Table {
+			w := 1.0
+			for k, a := range assign {
+				w *= mf.q[f.Vars[k].ID][a]
+			}
+			if w > 0.0 {
+				total += w * lv
+			}
+
+			for k := len(assign) - 1; k >= 0; k-- {
+				assign[k]++
+				if assign[k] < f.Vars[k].Card {
+					break
+				}
+				assign[k] = 0
+			}
+		}
+	}
+
+	for _, q := range mf.q {
+		for _, p := range q {
+			if p > 0.0 {
+				total -= p * math.Log(p)
+			}
+		}
+	}
+
+	return total
+}
+
+// Marginal returns a copy of the variable with q_i as its marginal. Run must
+// be called first.
+func (mf *MeanField) Marginal(varIdx int) (*model.Variable, error) {
+	if !mf.ran {
+		return nil, errors.New("Mean field has not been run")
+	}
+	if varIdx < 0 || varIdx >= len(mf.pgm.Vars) {
+		return nil, errors.Errorf("Invalid variable index %d", varIdx)
+	}
+
+	v := mf.pgm.Vars[varIdx].Clone()
+	copy(v.Marginal, mf.q[varIdx])
+	return v, nil
+}
+
+// Marginals returns the mean field marginals for every variable in the model
+func (mf *MeanField) Marginals() ([]*model.Variable, error) {
+	vars := make([]*model.Variable, len(mf.pgm.Vars))
+	for i := range mf.pgm.Vars {
+		v, err := mf.Marginal(i)
+		if err != nil {
+			return nil, err
+		}
+		vars[i] = v
+	}
+	return vars, nil
+}
@@ -0,0 +1,216 @@
+package approx
+
+import (
+	"math"
+	"testing"
+
+	"github.com/CraigKelly/grample/exact"
+	"github.com/CraigKelly/grample/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bruteLogZ enumerates every (evidence consistent) state to find log Z
+func bruteLogZ(t *testing.T, m *model.Model) float64 {
+	vi, err := model.NewVariableIter(m.Vars, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z := 0.0
+	state := make([]int, len(m.Vars))
+	for {
+		if err := vi.Val(state); err != nil {
+			t.Fatal(err)
+		}
+
+		p := 1.0
+		for _, f := range m.Funcs {
+			vals := make([]int, len(f.Vars))
+			for i, v := range f.Vars {
+				vals[i] = state[v.ID]
+			}
+			r, err := f.Eval(vals)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p *= r
+		}
+		z += p
+
+		if !vi.Next() {
+			break
+		}
+	}
+
+	return math.Log(z)
+}
+
+func TestMeanFieldIndependent(t *testing.T) {
+	assert := assert.New(t)
+
+	// With only unary functions, mean field is exact
+	vars := make([]*model.Variable, 3)
+	funcs := make([]*model.Function, 3)
+	for i := range vars {
+		v, err := model.NewVariable(i, i+2)
+		assert.NoError(err)
+		vars[i] = v
+
+		f, err := model.NewFunction(i, []*model.Variable{v})
+		assert.NoError(err)
+		for k := range f.Table {
+			f.Table[k] = float64(k + 1)
+		}
+		funcs[i] = f
+	}
+	mod := &model.Model{Type: model.MARKOV, Name: "indep", Vars: vars, Funcs: funcs}
+
+	mf, err := NewMeanField(mod)
+	assert.NoError(err)
+
+	_, err = mf.Marginal(0)
+	assert.Error(err) // Not run yet
+
+	assert.NoError(mf.Run())
+	assert.True(mf.Converged)
+	assert.InDelta(bruteLogZ(t, mod), mf.ELBO, 1e-9)
+
+	vs, err := mf.Marginals()
+	assert.NoError(err)
+	assert.InDeltaSlice([]float64{1.0 / 3.0, 2.0 / 3.0}, vs[0].Marginal, 1e-9)
+	assert.InDeltaSlice([]float64{1.0 / 6.0, 2.0 / 6.0, 3.0 / 6.0}, vs[1].Marginal, 1e-9)
+
+	// Evidence
+	vars[2].FixedVal = 3
+	assert.NoError(mf.Run())
+	assert.InDelta(bruteLogZ(t, mod), mf.ELBO, 1e-9)
+	vs, err = mf.Marginals()
+	assert.NoError(err)
+	assert.Equal([]float64{0.0, 0.0, 0.0, 1.0}, vs[2].Marginal)
+
+	mf.MaxIters = 0
+	assert.Error(mf.Run())
+}
+
+func TestMeanFieldLoop(t *testing.T) {
+	assert := assert.New(t)
+
+	mod := loopModel(t)
+	logZ := bruteLogZ(t, mod)
+	expected := exactMarginals(t, mod)
+
+	mf, err := NewMeanField(mod)
+	assert.NoError(err)
+	assert.NoError(mf.Run())
+	assert.True(mf.Converged)
+
+	// A lower bound, but a decent one for weak interactions
+	assert.True(mf.ELBO <= logZ)
+	assert.InDelta(logZ, mf.ELBO, 0.15)
+
+	vs, err := mf.Marginals()
+	assert.NoError(err)
+	for i, v := range vs {
+		sum := 0.0
+		for _, p := range v.Marginal {
+			sum += p
+		}
+		assert.InDelta(1.0, sum, 1e-9)
+		assert.InDeltaSlice(expected[i].Marginal, v.Marginal, 0.05)
+	}
+
+	// Fewer sweeps can only give a worse (or equal) bound
+	best := mf.ELBO
+	mf.MaxIters = 1
+	assert.NoError(mf.Run())
+	assert.Equal(1, mf.Iterations)
+	assert.True(mf.ELBO <= best+1e-12)
+
+	// Log space functions in the model are used as-is
+	for _, f := range mod.Funcs {
+		assert.NoError(f.UseLogSpace())
+	}
+	mf, err = NewMeanField(mod)
+	assert.NoError(err)
+	assert.NoError(mf.Run())
+	assert.InDelta(best, mf.ELBO, 1e-9)
+}
+
+// Domain evidence gives exact zeros in Q for the disallowed values, and the
+// ELBO is a lower bound on log Z with the restriction
+func TestMeanFieldDomain(t *testing.T) {
+	assert := assert.New(t)
+
+	mod := loopModel(t)
+	assert.NoError(mod.RestrictDomains(model.DomainEvidence{1: {0, 2}, 3: {1}}))
+	logZ := bruteLogZ(t, mod)
+	expected := exactMarginals(t, mod)
+
+	mf, err := NewMeanField(mod)
+	assert.NoError(err)
+	assert.NoError(mf.Run())
+	assert.True(mf.Converged)
+	assert.True(mf.ELBO <= logZ)
+	assert.InDelta(logZ, mf.ELBO, 0.15)
+
+	vs, err := mf.Marginals()
+	assert.NoError(err)
+	assert.Equal(0.0, vs[1].Marginal[1])
+	assert.Equal([]float64{0.0, 1.0}, vs[3].Marginal)
+	for i, v := range vs {
+		assert.InDeltaSlice(expected[i].Marginal, v.Marginal, 0.05)
+	}
+}
+
+// Zero entries get exact zeros in Q, so the ELBO is a finite lower bound on
+// models with zeros (even when the model's Z is tiny)
+func TestMeanFieldZeros(t *testing.T) {
+	assert := assert.New(t)
+
+	v, err := model.NewVariable(0, 2)
+	assert.NoError(err)
+	f, err := model.NewFunction(0, []*model.Variable{v})
+	assert.NoError(err)
+	f.Table = []float64{0.0, 1e-8}
+	mod := &model.Model{Type: model.MARKOV, Name: "Zeros", Vars: []*model.Variable{v}, Funcs: []*model.Function{f}}
+	assert.NoError(mod.Check())
+
+	mf, err := NewMeanField(mod)
+	assert.NoError(err)
+	assert.NoError(mf.Run())
+	assert.InDelta(bruteLogZ(t, mod), mf.ELBO, 1e-9)
+	assert.Equal([]float64{0, 1}, mf.q[0])
+}
+
+// Models with deterministic functions get a finite ELBO that's still a lower
+// bound
+func TestMeanFieldDeterministic(t *testing.T) {
+	assert := assert.New(t)
+
+	mod, err := model.NewModelFromFile(model.UAIReader{}, "../res/deterministic.uai", false)
+	assert.NoError(err)
+	logZ := bruteLogZ(t, mod)
+
+	mf, err := NewMeanField(mod)
+	assert.NoError(err)
+	assert.NoError(mf.Run())
+	assert.True(mf.Converged)
+	assert.False(math.IsInf(mf.ELBO, 0))
+	assert.True(mf.ELBO <= logZ)
+
+	// A model with deterministic functions and evidence: a uniform start
+	// never reaches the support, but a support state does
+	mod, err = model.NewModelFromFile(model.UAIReader{}, "../res/Pedigree_11.uai", true)
+	assert.NoError(err)
+	ve, err := exact.NewVarElim(mod)
+	assert.NoError(err)
+	logZ, err = ve.LogZ()
+	assert.NoError(err)
+
+	mf, err = NewMeanField(mod)
+	assert.NoError(err)
+	assert.NoError(mf.Run())
+	assert.False(math.IsInf(mf.ELBO, 0))
+	assert.True(mf.ELBO <= logZ)
+}
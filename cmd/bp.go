@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/CraigKelly/grample/approx"
+	"github.com/CraigKelly/grample/model"
+)
+
+// beliefPropMarginals runs loopy BP on the model using our startup params and
+// returns the resulting marginals. Failing to converge is reported but is NOT
+// an error (the beliefs are still usable as an estimate).
+func beliefPropMarginals(sp *startupParams, mod *model.Model) ([]*model.Variable, error) {
+	sched, err := approx.ParseSchedule(sp.bpSchedule)
+	if err != nil {
+		return nil, err
+	}
+
+	bp, err := approx.NewBeliefProp(mod)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not create belief propagation")
+	}
+	bp.Schedule = sched
+	bp.Damping = sp.bpDamping
+	bp.Tolerance = sp.bpTolerance
+	bp.MaxIters = int(sp.bpMaxIters)
+
+	sp.out.Printf("Running belief propagation (%s schedule)\n", bp.Schedule)
+	err = bp.Run()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Belief propagation failed")
+	}
+
+	if bp.Converged {
+		sp.out.Printf("BP converged after %d iterations (max residual %g)\n", bp.Iterations, bp.MaxResidual)
+	} else {
+		sp.out.Printf("WARNING: BP did NOT converge after %d iterations (max residual %g)\n", bp.Iterations, bp.MaxResidual)
+	}
+
+	vars, err := bp.Marginals()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not get BP marginals")
+	}
+	for _, v := range vars {
+		v.State["BP-Iterations"] = float64(bp.Iterations)
+	}
+
+	return vars, nil
+}
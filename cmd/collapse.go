@@ -41,8 +41,7 @@ func CollapsedIteration(sp *startupParams) error {
 
 	// Read model from file
 	sp.out.Printf("Reading model from %s\n", sp.uaiFile)
-	reader := model.UAIReader{}
-	mod, err = model.NewModelFromFile(reader, sp.uaiFile, sp.useEvidence)
+	mod, err = readModel(sp, model.ReaderForFile(sp.uaiFile), sp.useEvidence, sp.out)
 	if err != nil {
 		return err
 	}
@@ -52,8 +51,8 @@ func CollapsedIteration(sp *startupParams) error {
 		return errors.New("Itertive collapse check only works with a solution file")
 	}
 
-	solFilename := sp.uaiFile + ".MAR"
-	sol, err = model.NewSolutionFromFile(reader, solFilename)
+	solFilename := model.UncompressedName(sp.uaiFile) + ".MAR"
+	sol, err = model.NewSolutionFromFile(model.UAIReader{}, solFilename)
 	if err != nil {
 		return errors.Wrapf(err, "Could not read solution file %s", solFilename)
 	}
@@ -64,10 +63,10 @@ func CollapsedIteration(sp *startupParams) error {
 	}
 	errorReport(sp, "ASSUME ALL MARGINALS ARE UNIFORM", score, false, sp.out)
 
-	merlinFilename := sp.uaiFile + ".merlin.MAR"
+	merlinFilename := model.UncompressedName(sp.uaiFile) + ".merlin.MAR"
 	var merlin *model.Solution
 	if _, err := os.Stat(merlinFilename); !os.IsNotExist(err) {
-		merlin, err = model.NewSolutionFromFile(reader, merlinFilename)
+		merlin, err = model.NewSolutionFromFile(model.UAIReader{}, merlinFilename)
 		if err != nil {
 			return errors.Wrapf(err, "Found merlin MAR file but could not read it")
 		}
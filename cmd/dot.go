@@ -2,9 +2,12 @@ package cmd
 
 import (
 	"log"
+	"regexp"
+	"strings"
 
 	"github.com/pkg/errors"
 
+	"github.com/CraigKelly/grample/elim"
 	"github.com/CraigKelly/grample/model"
 )
 
@@ -21,31 +24,20 @@ func DotOutput(sp *startupParams) error {
 
 	// Read model from file
 	sp.out.Printf("// Reading model from %s\n", sp.uaiFile)
-	reader := model.UAIReader{}
-	mod, err = model.NewModelFromFile(reader, sp.uaiFile, sp.useEvidence)
+	reader := model.ReaderForFile(sp.uaiFile)
+	mod, err = readModel(sp, reader, sp.useEvidence, log.New(sp.out.Writer(), "// ", 0))
 	if err != nil {
 		return err
 	}
 	sp.out.Printf("// Model has %d vars and %d functions\n", len(mod.Vars), len(mod.Funcs))
 
 	// Find all variable linkages
-	type AdjMap map[int]bool
-	varAdj := make(map[int]AdjMap)
-
 	for i, v := range mod.Vars {
 		if i != v.ID {
 			return errors.Errorf("Var %v has ID %d != idx %d", v.Name, v.ID, i)
 		}
-		varAdj[v.ID] = make(AdjMap)
-	}
-
-	for _, f := range mod.Funcs {
-		for i, v1 := range f.Vars {
-			for _, v2 := range f.Vars[i+1:] {
-				varAdj[v1.ID][v2.ID] = true
-			}
-		}
 	}
+	varAdj := elim.NewGraph(mod, false).Adj
 
 	var target *log.Logger
 	if len(sp.traceFile) > 0 {
@@ -58,17 +50,23 @@ func DotOutput(sp *startupParams) error {
 	// Start graph
 	target.Printf("strict graph G {\n")
 
-	// Output vars
-	//for _, v := range mod.Vars {
-	//	target.Printf("    node %s\n", v.Name)
-	//}
+	// Output vars with state labels
+	for _, v := range mod.Vars {
+		if v.Labels != nil {
+			label := v.Name + "\n(" + strings.Join(v.Labels, ", ") + ")"
+			target.Printf("    %s [label=%s];\n", dotID(v.Name), dotQuote(label))
+		}
+	}
 
 	// Output links
 	for _, v1 := range mod.Vars {
 		adj := varAdj[v1.ID]
 		for v2id := range adj {
+			if v2id < v1.ID {
+				continue // Adjacency is symmetric, so only output each edge once
+			}
 			v2 := mod.Vars[v2id]
-			target.Printf("    %s -- %s;\n", v1.Name, v2.Name)
+			target.Printf("    %s -- %s;\n", dotID(v1.Name), dotID(v2.Name))
 		}
 	}
 
@@ -77,3 +75,27 @@ func DotOutput(sp *startupParams) error {
 
 	return nil
 }
+
+// dotPlainID matches names that can be used as graphviz IDs without quoting
+var dotPlainID = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$|^-?[0-9]+$`)
+
+// dotID returns the graphviz ID for a variable name
+func dotID(name string) string {
+	switch strings.ToLower(name) {
+	case "node", "edge", "graph", "digraph", "subgraph", "strict":
+		return dotQuote(name)
+	}
+	if dotPlainID.MatchString(name) {
+		return name
+	}
+	return dotQuote(name)
+}
+
+// dotQuote returns s as a graphviz quoted string (newlines become centered
+// line breaks)
+func dotQuote(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	s = strings.Replace(s, "\n", "\\n", -1)
+	return "\"" + s + "\""
+}
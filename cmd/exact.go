@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/CraigKelly/grample/elim"
+	"github.com/CraigKelly/grample/exact"
+	"github.com/CraigKelly/grample/model"
+	"github.com/CraigKelly/grample/rand"
+)
+
+// ExactMarginals uses variable elimination or a junction tree to calculate
+// the exact marginals for a model and write them as a UAI MAR solution. This
+// is how you can generate a solution file for a model that doesn't already
+// have one.
+func ExactMarginals(sp *startupParams) error {
+	var mod *model.Model
+	var err error
+
+	// If the solution is going to stdout, our status goes to stderr
+	info := sp.out
+	if len(sp.outputFile) < 1 {
+		info = log.New(os.Stderr, "", 0)
+	}
+
+	// Read model from file
+	info.Printf("Reading model from %s\n", sp.uaiFile)
+	mod, err = readModel(sp, model.ReaderForFile(sp.uaiFile), sp.useEvidence, info)
+	if err != nil {
+		return err
+	}
+	info.Printf("Model has %d vars and %d functions\n", len(mod.Vars), len(mod.Funcs))
+
+	var vars []*model.Variable
+
+	if strings.ToLower(sp.exactMethod) == "ve" {
+		ve, err := exact.NewVarElim(mod)
+		if err != nil {
+			return errors.Wrapf(err, "Could not create variable elimination engine")
+		}
+
+		vars, err = ve.Marginals()
+		if err != nil {
+			return errors.Wrapf(err, "Variable elimination failed")
+		}
+	} else if strings.ToLower(sp.exactMethod) == "jtree" {
+		jt, err := buildJunctionTree(sp, mod, info)
+		if err != nil {
+			return err
+		}
+
+		err = jt.Calibrate()
+		if err != nil {
+			return errors.Wrapf(err, "Junction tree calibration failed")
+		}
+
+		vars, err = jt.Marginals()
+		if err != nil {
+			return errors.Wrapf(err, "Junction tree marginals failed")
+		}
+	} else {
+		return errors.Errorf("Unknown exact method: %s", sp.exactMethod)
+	}
+	for _, v := range vars {
+		sp.verb.Printf("Variable[%d] %s (Card:%d) %+v\n", v.ID, v.Name, v.Card, v.Marginal)
+	}
+
+	// Score vs the existing solution if requested
+	if sp.solFile {
+		solFilename := model.UncompressedName(sp.uaiFile) + ".MAR"
+		sol, err := model.NewSolutionFromFile(model.UAIReader{}, solFilename)
+		if err != nil {
+			return errors.Wrapf(err, "Could not read solution file %s", solFilename)
+		}
+
+		score, err := sol.Error(vars)
+		if err != nil {
+			return errors.Wrapf(err, "Error calculating score")
+		}
+		errorReport(sp, "EXACT", score, false, info)
+	}
+
+	// Write our results: stdout if no output file was given
+	result := &model.Solution{Vars: vars}
+	writer := model.UAIWriter{}
+	if len(sp.outputFile) > 0 {
+		info.Printf("Writing MAR solution to %s\n", sp.outputFile)
+		return result.WriteToFile(writer, sp.outputFile)
+	}
+
+	return writer.WriteMargSolution(os.Stdout, result)
+}
+
+// buildJunctionTree creates a junction tree using the best of sp.orderIters
+// randomized min-fill elimination orders.
+func buildJunctionTree(sp *startupParams, mod *model.Model, info *log.Logger) (*exact.JunctionTree, error) {
+	var gen *rand.Generator
+	var err error
+	if sp.orderIters > 1 {
+		gen, err = rand.NewGenerator(sp.randomSeed)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not create Generator from seed %d", sp.randomSeed)
+		}
+	}
+	order, err := elim.Search(elim.NewGraph(mod, false), elim.MinFill, gen, int(sp.orderIters))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not compute elimination order")
+	}
+	info.Printf("Elimination order width %d (log2 max table %.2f)\n", order.Width, order.Log2MaxTableSize())
+
+	jt, err := exact.NewJunctionTreeFromOrder(mod, order.Vars)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not create junction tree")
+	}
+	info.Printf("Junction tree has %d cliques (max clique size %d)\n", len(jt.Cliques), jt.MaxCliqueSize())
+
+	return jt, nil
+}
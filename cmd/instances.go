@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CraigKelly/grample/model"
+)
+
+// instanceMarginals handles evidence files with more than one evidence
+// instance: the model is only parsed once and each instance is run (and
+// scored if we have a solution file) in turn. A UAI MAR result is written to
+// the output for each instance, and we finish with a summary table.
+func instanceMarginals(sp *startupParams, mod *model.Model, evidence []model.Evidence) error {
+	reader := model.UAIReader{}
+	writer := model.UAIWriter{}
+
+	// The solution file needs a solution for every instance
+	var sols []*model.Solution
+	if sp.solFile {
+		solFilename := model.UncompressedName(sp.uaiFile) + ".MAR"
+		var err error
+		sols, err = model.NewSolutionsFromFile(reader, solFilename)
+		if err != nil {
+			return errors.Wrapf(err, "Could not read solution file %s", solFilename)
+		}
+		if len(sols) != len(evidence) {
+			return errors.Errorf(
+				"Solution file %s has %d solutions but there are %d evidence instances",
+				solFilename, len(sols), len(evidence),
+			)
+		}
+	}
+
+	if err := sampleDefaults(sp, mod); err != nil {
+		return err
+	}
+
+	// Report what's going on
+	sp.Report()
+	sp.out.Printf("Evidence Instances:     %12d\n", len(evidence))
+	sp.mon.BurnIn.Set(sp.burnIn)
+	sp.mon.ConvergeWindow.Set(sp.convergeWindow)
+	sp.mon.MaxIters.Set(sp.maxIters)
+	sp.mon.MaxSeconds.Set(sp.maxSecs)
+
+	results := make([]*model.Solution, len(evidence))
+	scores := make([]*model.ErrorSuite, len(evidence))
+	runTimes := make([]float64, len(evidence))
+
+	for i, evid := range evidence {
+		sp.out.Printf("EVIDENCE INSTANCE %d of %d (%d evidence vars)\n", i+1, len(evidence), len(evid))
+		sp.trace.Printf("// EVIDENCE INSTANCE %d\n", i+1)
+
+		// Our first instance gets the benefit of our early start time
+		runStart := time.Now()
+		if i == 0 {
+			runStart = startTime
+		}
+
+		instMod := mod.Clone()
+		err := instMod.SetEvidence(evid)
+		if err != nil {
+			return errors.Wrapf(err, "Could not apply evidence instance %d", i+1)
+		}
+
+		var sol *model.Solution
+		if sols != nil {
+			sol = sols[i]
+			score, err := sol.Error(instMod.Vars)
+			if err != nil {
+				return errors.Wrapf(err, "Error calculating init score for instance %d", i+1)
+			}
+			errorReport(sp, "START", score, false, nil)
+		}
+
+		finalVars, runTime, err := estimateMarginals(sp, instMod, sol, runStart)
+		if err != nil {
+			return errors.Wrapf(err, "Failed on evidence instance %d", i+1)
+		}
+		runTimes[i] = runTime
+
+		if sol != nil {
+			scores[i], err = querySolution(sp, sol).Error(finalVars)
+			if err != nil {
+				return errors.Wrapf(err, "Error calculating final score for instance %d", i+1)
+			}
+			errorReport(sp, fmt.Sprintf("INSTANCE %d FINAL", i+1), scores[i], false, nil)
+		}
+
+		// Our result for this instance
+		result, err := model.NewSolution(finalVars)
+		if err != nil {
+			return errors.Wrapf(err, "Could not create result for instance %d", i+1)
+		}
+		results[i] = result
+		sp.out.Printf("RESULT %d\n", i+1)
+		err = writer.WriteMargSolution(sp.out.Writer(), result)
+		if err != nil {
+			return errors.Wrapf(err, "Could not write result for instance %d", i+1)
+		}
+		err = writer.WriteMargSolution(sp.trace.Writer(), result)
+		if err != nil {
+			return errors.Wrapf(err, "Could not trace result for instance %d", i+1)
+		}
+	}
+
+	sp.out.Printf("DONE\n")
+	instanceSummary(sp, evidence, scores, runTimes)
+
+	sp.trace.Printf("// OPERATING PARAMS\n")
+	sp.Trace()
+
+	return writeMarginals(sp, results)
+}
+
+// instanceSummary writes a table with a row per evidence instance (to the
+// output and trace file). Error columns are only included if we have scores.
+func instanceSummary(sp *startupParams, evidence []model.Evidence, scores []*model.ErrorSuite, runTimes []float64) {
+	haveScores := len(scores) > 0 && scores[0] != nil
+
+	header := fmt.Sprintf("%8s %8s %12s", "Instance", "EvidVars", "RunSecs")
+	if haveScores {
+		header += fmt.Sprintf(" %12s %12s %12s %12s %12s", "MeanHell", "MaxHell", "MeanJSD", "MaxJSD", "MaxMaxAE")
+	}
+
+	lines := []string{header}
+	var total model.ErrorSuite
+	totalTime := 0.0
+	for i, evid := range evidence {
+		ln := fmt.Sprintf("%8d %8d %12.2f", i+1, len(evid), runTimes[i])
+		totalTime += runTimes[i]
+		if haveScores {
+			es := scores[i]
+			ln += fmt.Sprintf(" %12.6f %12.6f %12.6f %12.6f %12.6f",
+				es.MeanHellinger, es.MaxHellinger, es.MeanJSDiverge, es.MaxJSDiverge, es.MaxMaxAbsError,
+			)
+			total.MeanHellinger += es.MeanHellinger
+			total.MaxHellinger += es.MaxHellinger
+			total.MeanJSDiverge += es.MeanJSDiverge
+			total.MaxJSDiverge += es.MaxJSDiverge
+			total.MaxMaxAbsError += es.MaxMaxAbsError
+		}
+		lines = append(lines, ln)
+	}
+
+	n := float64(len(evidence))
+	ln := fmt.Sprintf("%8s %8s %12.2f", "MEAN", "", totalTime/n)
+	if haveScores {
+		ln += fmt.Sprintf(" %12.6f %12.6f %12.6f %12.6f %12.6f",
+			total.MeanHellinger/n, total.MaxHellinger/n, total.MeanJSDiverge/n, total.MaxJSDiverge/n, total.MaxMaxAbsError/n,
+		)
+	}
+	lines = append(lines, ln)
+
+	sp.out.Printf("SUMMARY (%d evidence instances)\n", len(evidence))
+	sp.trace.Printf("// SUMMARY\n")
+	for _, ln := range lines {
+		sp.out.Printf("%s\n", ln)
+		sp.trace.Printf("%s\n", ln)
+	}
+}
+
+// namedEvidenceReader adapts a model reader whose evidence can only be read
+// against the model (e.g. the variable and state names used for BIF) to
+// model.EvidenceReader. These formats have a single evidence instance.
+type namedEvidenceReader struct {
+	reader model.Reader
+	mod    *model.Model
+}
+
+// ReadEvidence implements model.EvidenceReader
+func (r namedEvidenceReader) ReadEvidence(data []byte) ([]model.Evidence, error) {
+	cp := r.mod.Clone()
+	for _, v := range cp.Vars {
+		v.FixedVal = -1
+	}
+	if err := r.reader.ApplyEvidence(data, cp); err != nil {
+		return nil, err
+	}
+	return []model.Evidence{cp.Evidence()}, nil
+}
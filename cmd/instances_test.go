@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/CraigKelly/grample/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// MPE tracking is rejected for every sampler without chains, whether we have
+// one evidence instance or many
+func TestInstancesRejectMPE(t *testing.T) {
+	assert := assert.New(t)
+
+	mod, err := model.NewModelFromFile(model.UAIReader{}, "../res/sample.uai", false)
+	assert.NoError(err)
+	evidence := []model.Evidence{{0: 1}, {1: 0}}
+
+	for _, name := range []string{"bp", "meanfield", "ancestral", "lw", "importance"} {
+		sp := &startupParams{
+			samplerName: name,
+			trackMPE:    true,
+			out:         log.New(ioutil.Discard, "", 0),
+		}
+		err = instanceMarginals(sp, mod, evidence)
+		if assert.Error(err, name) {
+			assert.Contains(err.Error(), "MPE tracking", name)
+		}
+		assert.Error(sampleDefaults(sp, mod), name)
+	}
+
+	sp := &startupParams{samplerName: "simple", trackMPE: true, out: log.New(ioutil.Discard, "", 0)}
+	assert.NoError(sampleDefaults(sp, mod))
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"log"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/CraigKelly/grample/exact"
+	"github.com/CraigKelly/grample/model"
+	"github.com/CraigKelly/grample/rand"
+	"github.com/CraigKelly/grample/sampler"
+)
+
+// LogPartition estimates the log partition function (probability of
+// evidence) for a model and writes it as a UAI PR solution. The exact methods
+// are variable elimination and the junction tree; annealed importance
+// sampling is available for models that are too large for exact inference.
+func LogPartition(sp *startupParams) error {
+	var mod *model.Model
+	var err error
+
+	// If the solution is going to stdout, our status goes to stderr
+	info := sp.out
+	if len(sp.outputFile) < 1 {
+		info = log.New(os.Stderr, "", 0)
+	}
+
+	// Read model from file
+	info.Printf("Reading model from %s\n", sp.uaiFile)
+	mod, err = readModel(sp, model.ReaderForFile(sp.uaiFile), sp.useEvidence, info)
+	if err != nil {
+		return err
+	}
+	info.Printf("Model has %d vars and %d functions\n", len(mod.Vars), len(mod.Funcs))
+
+	var logZ float64
+	method := strings.ToLower(sp.exactMethod)
+
+	if method == "ve" {
+		ve, err := exact.NewVarElim(mod)
+		if err != nil {
+			return errors.Wrapf(err, "Could not create variable elimination engine")
+		}
+		logZ, err = ve.LogZ()
+		if err != nil {
+			return errors.Wrapf(err, "Variable elimination failed")
+		}
+	} else if method == "jtree" {
+		jt, err := buildJunctionTree(sp, mod, info)
+		if err != nil {
+			return err
+		}
+		err = jt.Calibrate()
+		if err != nil {
+			return errors.Wrapf(err, "Junction tree calibration failed")
+		}
+		logZ, err = jt.LogZ()
+		if err != nil {
+			return err
+		}
+	} else if method == "ais" {
+		gen, err := rand.NewGenerator(sp.randomSeed)
+		if err != nil {
+			return errors.Wrapf(err, "Could not create Generator from seed %d", sp.randomSeed)
+		}
+		ais, err := sampler.NewAIS(gen, mod)
+		if err != nil {
+			return errors.Wrapf(err, "Could not create AIS estimator")
+		}
+		ais.Temps = int(sp.aisTemps)
+		ais.Runs = int(sp.aisRuns)
+
+		info.Printf("Running AIS: %d runs with %d temperatures (seed %d)\n", ais.Runs, ais.Temps, sp.randomSeed)
+		logZ, err = ais.LogZ()
+		if err != nil {
+			return errors.Wrapf(err, "AIS failed")
+		}
+		info.Printf("AIS effective sample size: %.2f out of %d runs\n", ais.ESS(), ais.Runs)
+	} else {
+		return errors.Errorf("Unknown log Z method: %s", sp.exactMethod)
+	}
+	info.Printf("log Z = %.8f (log10 Z = %.8f)\n", logZ, logZ/math.Ln10)
+
+	// Score vs the existing solution if requested: we prefer a PR file, but
+	// Merlin MAR files also have a PR section
+	if sp.solFile {
+		solFilename := model.UncompressedName(sp.uaiFile) + ".PR"
+		if _, err := os.Stat(solFilename); os.IsNotExist(err) {
+			solFilename = model.UncompressedName(sp.uaiFile) + ".merlin.MAR"
+		}
+		sol, err := model.NewPRSolutionFromFile(model.UAIReader{}, solFilename)
+		if err != nil {
+			return errors.Wrapf(err, "Could not read PR solution file %s", solFilename)
+		}
+
+		score := sol.Error(logZ)
+		info.Printf("SCORE vs %s ... expected log Z %.8f\n", solFilename, score.Expected)
+		info.Printf("%15s => %.8f\n", "AbsError", score.AbsError)
+		info.Printf("%15s => %.8f\n", "RelError", score.RelError)
+	}
+
+	// Write our results: stdout if no output file was given
+	result := &model.PRSolution{LogZ: logZ}
+	writer := model.UAIWriter{}
+	if len(sp.outputFile) > 0 {
+		info.Printf("Writing PR solution to %s\n", sp.outputFile)
+		return result.WriteToFile(writer, sp.outputFile)
+	}
+
+	return writer.WritePRSolution(os.Stdout, result)
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+
+	"github.com/CraigKelly/grample/approx"
+	"github.com/CraigKelly/grample/model"
+)
+
+// meanFieldMarginals runs mean-field coordinate ascent on the model using our
+// startup params and returns the resulting marginals. The ELBO is written to
+// both the output and the trace file.
+func meanFieldMarginals(sp *startupParams, mod *model.Model) ([]*model.Variable, error) {
+	mf, err := approx.NewMeanField(mod)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not create mean field solver")
+	}
+	mf.Tolerance = sp.mfTolerance
+	mf.MaxIters = int(sp.mfMaxIters)
+
+	sp.out.Printf("Running mean field coordinate ascent\n")
+	err = mf.Run()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Mean field failed")
+	}
+
+	if mf.Converged {
+		sp.out.Printf("Mean field converged after %d sweeps\n", mf.Iterations)
+	} else {
+		sp.out.Printf("WARNING: Mean field did NOT converge after %d sweeps\n", mf.Iterations)
+	}
+	sp.out.Printf("ELBO (lower bound on log Z): %.8f\n", mf.ELBO)
+	if math.IsInf(mf.ELBO, -1) {
+		sp.out.Printf("WARNING: ELBO is -Inf: the mean field marginals put mass on zero entries in the model\n")
+	}
+	sp.trace.Printf("// ELBO %.8f\n", mf.ELBO)
+
+	vars, err := mf.Marginals()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not get mean field marginals")
+	}
+	for _, v := range vars {
+		v.State["MF-Iterations"] = float64(mf.Iterations)
+		v.State["MF-ELBO"] = mf.ELBO
+	}
+
+	return vars, nil
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CraigKelly/grample/model"
+	"github.com/CraigKelly/grample/rand"
+	"github.com/CraigKelly/grample/sampler"
+)
+
+// MPESearch looks for the most probable explanation (the single most likely
+// joint state given any evidence) with simulated annealing and writes it as a
+// UAI MPE solution.
+func MPESearch(sp *startupParams) error {
+	var mod *model.Model
+	var err error
+
+	// If the solution is going to stdout, our status goes to stderr
+	info := sp.out
+	if len(sp.outputFile) < 1 {
+		info = log.New(os.Stderr, "", 0)
+	}
+
+	// Read model from file
+	info.Printf("Reading model from %s\n", sp.uaiFile)
+	reader := model.ReaderForFile(sp.uaiFile)
+	mod, err = readModel(sp, reader, sp.useEvidence, info)
+	if err != nil {
+		return err
+	}
+	info.Printf("Model has %d vars and %d functions\n", len(mod.Vars), len(mod.Funcs))
+
+	if sp.randomSeed < 1 {
+		n := time.Now()
+		sp.randomSeed = int64(n.Second()) + int64(n.Nanosecond()) + int64(n.Minute())
+	}
+	gen, err := rand.NewGenerator(sp.randomSeed)
+	if err != nil {
+		return errors.Wrapf(err, "Could not create Generator from seed %d", sp.randomSeed)
+	}
+
+	sa, err := sampler.NewAnnealer(gen, mod)
+	if err != nil {
+		return errors.Wrapf(err, "Could not create annealer")
+	}
+	sa.StartTemp = sp.saStartTemp
+	sa.EndTemp = sp.saEndTemp
+	sa.Sweeps = int(sp.saSweeps)
+
+	info.Printf(
+		"Annealing: %d sweeps from temperature %g to %g (seed %d)\n",
+		sa.Sweeps, sa.StartTemp, sa.EndTemp, sp.randomSeed,
+	)
+	state, logProb, err := sa.Run()
+	if err != nil {
+		return errors.Wrapf(err, "Simulated annealing failed")
+	}
+	if math.IsInf(logProb, -1) {
+		return errors.New("Simulated annealing found no state with non-zero probability: no MPE solution written")
+	}
+
+	err = mpeReport(sp, mod, state, logProb, sp.solFile, info)
+	if err != nil {
+		return err
+	}
+
+	// Write our results: stdout if no output file was given
+	result := &model.MPESolution{Values: state}
+	writer := model.UAIWriter{}
+	if len(sp.outputFile) > 0 {
+		info.Printf("Writing MPE solution to %s\n", sp.outputFile)
+		return result.WriteToFile(writer, sp.outputFile)
+	}
+
+	return writer.WriteMPESolution(os.Stdout, result)
+}
+
+// mpeReport logs the MPE state we found (and writes it to the trace file). If
+// a UAI MPE solution file can be found, we also score against it: it is an
+// error for the file to be missing if requireSol is true.
+func mpeReport(sp *startupParams, mod *model.Model, state []int, logProb float64, requireSol bool, target *log.Logger) error {
+	target.Printf("MPE log prob (unnormalized) = %.8f\n", logProb)
+	sp.trace.Printf("// MPE %.8f\n", logProb)
+	sp.traceJ.SetIndent("", "")
+	PanicIf(sp.traceJ.Encode(state))
+
+	solFilename := model.UncompressedName(sp.uaiFile) + ".MPE"
+	if _, err := os.Stat(solFilename); os.IsNotExist(err) {
+		if requireSol {
+			return errors.Errorf("Could not find MPE solution file %s", solFilename)
+		}
+		return nil
+	}
+
+	sol, err := model.NewMPESolutionFromFile(model.UAIReader{}, solFilename)
+	if err != nil {
+		return errors.Wrapf(err, "Could not read MPE solution file %s", solFilename)
+	}
+	score, err := sol.Error(mod, state)
+	if err != nil {
+		return errors.Wrapf(err, "Error calculating MPE score")
+	}
+
+	target.Printf("MPE SCORE vs %s ... expected log prob %.8f\n", solFilename, score.ExpectedLogProb)
+	target.Printf("%15s => %.8f\n", "LogProbGap", score.LogProbGap)
+	target.Printf("%15s => %d\n", "Hamming", score.Hamming)
+	sp.trace.Printf("// MPE SCORE gap=%.8f hamming=%d\n", score.LogProbGap, score.Hamming)
+
+	return nil
+}
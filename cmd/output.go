@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/CraigKelly/grample/model"
+)
+
+// margWriter can write a single marginal solution or one per evidence instance
+type margWriter interface {
+	model.SolWriter
+	model.MultiSolWriter
+}
+
+// outputFormat returns the format for our marginal output file: if one
+// wasn't specified, we use the output file's extension (defaulting to MAR).
+func outputFormat(sp *startupParams) string {
+	format := strings.ToLower(sp.outputFormat)
+	if len(format) < 1 {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(sp.outputFile)), ".")
+		if format != "json" && format != "csv" {
+			format = "mar"
+		}
+	}
+	return format
+}
+
+// outputWriter returns the writer for our marginal output format
+func outputWriter(sp *startupParams) (margWriter, error) {
+	switch outputFormat(sp) {
+	case "mar", "uai":
+		return model.UAIWriter{}, nil
+	case "json":
+		return model.JSONSolWriter{}, nil
+	case "csv":
+		return model.CSVSolWriter{}, nil
+	}
+	return nil, errors.Errorf("Unknown output format: %s", sp.outputFormat)
+}
+
+// writeMarginals writes our final marginals to the output file (if there is
+// one). There should be one solution per evidence instance.
+func writeMarginals(sp *startupParams, sols []*model.Solution) error {
+	if len(sp.outputFile) < 1 {
+		return nil
+	}
+
+	w, err := outputWriter(sp)
+	if err != nil {
+		return err
+	}
+
+	sp.out.Printf("Writing %s marginals to %s\n", strings.ToUpper(outputFormat(sp)), sp.outputFile)
+	if len(sols) == 1 {
+		return sols[0].WriteToFile(w, sp.outputFile)
+	}
+	return model.WriteSolutionsToFile(w, sp.outputFile, sols)
+}
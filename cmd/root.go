@@ -27,6 +27,7 @@ var startTime = time.Now()
 // Parameter
 type startupParams struct {
 	verbose        bool
+	repairCPT      bool
 	uaiFile        string
 	useEvidence    bool
 	solFile        bool
@@ -41,6 +42,26 @@ type startupParams struct {
 	traceFile      string
 	monitorAddr    string
 	experiment     bool
+	outputFile     string
+	outputFormat   string
+	exactMethod    string
+	orderIters     int64
+	bpDamping      float64
+	bpTolerance    float64
+	bpMaxIters     int64
+	bpSchedule     string
+	bpSeed         bool
+	mfTolerance    float64
+	mfMaxIters     int64
+	aisTemps       int64
+	aisRuns        int64
+	trackMPE       bool
+	reduce         bool
+	query          string
+	saStartTemp    float64
+	saEndTemp      float64
+	saSweeps       int64
+	proposalName   string
 
 	// These are created/handled by Setup
 	out    *log.Logger
@@ -48,6 +69,9 @@ type startupParams struct {
 	trace  *log.Logger
 	traceJ JSONLogger
 	mon    *monitor
+
+	// Query variable IDs (from query) set when the model is read
+	queryIDs []int
 }
 
 // JSONLogger is a simple interface for JSON logging (matches json.Encoder) and
@@ -97,6 +121,7 @@ func (s *startupParams) Setup() error {
 func (s *startupParams) dump(out *log.Logger) {
 	out.Printf("Verbose:                %v\n", s.verbose)
 	out.Printf("Model:                  %s\n", s.uaiFile)
+	out.Printf("Repair CPT's:           %v\n", s.repairCPT)
 	out.Printf("Apply Evidence:         %v\n", s.useEvidence)
 	out.Printf("Solution:               %v\n", s.solFile)
 	out.Printf("Sampler:                %s\n", s.samplerName)
@@ -109,6 +134,28 @@ func (s *startupParams) dump(out *log.Logger) {
 	out.Printf("Rnd Seed:               %12d\n", s.randomSeed)
 	out.Printf("Monitor Addr:           %s\n", s.monitorAddr)
 	out.Printf("Experiment Mode:        %v\n", s.experiment)
+	out.Printf("Track MPE:              %v\n", s.trackMPE)
+	out.Printf("Absorb Evidence:        %v\n", s.reduce)
+	if len(s.query) > 0 {
+		out.Printf("Query:                  %s\n", s.query)
+	}
+	if len(s.outputFile) > 0 {
+		out.Printf("Output File:            %s (%s)\n", s.outputFile, outputFormat(s))
+	}
+	if strings.ToLower(s.samplerName) == "bp" || s.bpSeed || (s.isImportance() && strings.ToLower(s.proposalName) == "bp") {
+		out.Printf("BP Schedule:            %s\n", s.bpSchedule)
+		out.Printf("BP Damping:             %12.4f\n", s.bpDamping)
+		out.Printf("BP Tolerance:           %12g\n", s.bpTolerance)
+		out.Printf("BP Max Iters:           %12d\n", s.bpMaxIters)
+		out.Printf("BP Seeded Chains:       %v\n", s.bpSeed)
+	}
+	if s.isImportance() {
+		out.Printf("IS Proposal:            %s\n", s.proposalName)
+	}
+	if strings.ToLower(s.samplerName) == "meanfield" {
+		out.Printf("MF Tolerance:           %12g\n", s.mfTolerance)
+		out.Printf("MF Max Iters:           %12d\n", s.mfMaxIters)
+	}
 }
 
 // Report just writes commands - must be called after Setup
@@ -131,13 +178,46 @@ func PanicIf(err error) {
 // Help text for root command
 const cmdHelp = `grample provides sampling-based inference for PGM's. Features include:
 
-- The ability to read UAI PGM files (for models and evidence)
+- The ability to read UAI PGM files (for models and evidence, including
+  evidence files with many instances)
 - A Gibbs sampler
+- Ancestral sampling and likelihood weighting for Bayesian networks
+- Importance sampling with uniform, BP or Gibbs proposals (with ESS and
+  log Z estimates)
 - An experimental version of an Adaptive Gibbs sampler
+- Loopy belief propagation (as a baseline or to seed Gibbs chains)
+- Naive mean field variational inference (with an ELBO bound on log Z)
+- Exact marginals via variable elimination or a junction tree (for
+  generating solution files)
+- Log partition function (PR) estimates: exact or via annealed importance
+  sampling
+- Elimination order width estimates (min-degree, min-fill, weighted min-fill)
+- MPE search via simulated annealing (or by tracking the best Gibbs sample)
+- Query-aware pruning of Bayesian networks (barren variables and d-separation)
+- Bayesian network validation (CPT's and acyclicity) with optional CPT repair
 `
 
 type grampleCmd func(*startupParams) error
 
+// readModel reads the model file with the given reader. If CPT repair is on,
+// the CPT's of a BAYES model are renormalized before the model is checked
+// (and the number of repaired rows is written to info).
+func readModel(sp *startupParams, reader model.Reader, useEvidence bool, info *log.Logger) (*model.Model, error) {
+	if !sp.repairCPT {
+		return model.NewModelFromFile(reader, sp.uaiFile, useEvidence)
+	}
+
+	repair := &model.CPTRepairReader{Reader: reader}
+	mod, err := model.NewModelFromFile(repair, sp.uaiFile, useEvidence)
+	if err != nil {
+		return nil, err
+	}
+	if repair.Repaired > 0 {
+		info.Printf("Repaired %d CPT rows that did not sum to 1\n", repair.Repaired)
+	}
+	return mod, nil
+}
+
 func runGrampleCmd(sp *startupParams, f grampleCmd) error {
 	err := sp.Setup()
 	if err != nil {
@@ -174,6 +254,7 @@ func Execute() {
 	pf.BoolVarP(&sp.verbose, "verbose", "v", false, "Verbose logging (ALL samples written to --trace file)")
 	pf.Int64VarP(&sp.randomSeed, "seed", "e", 0, "Random seed to use")
 	pf.StringVarP(&sp.traceFile, "trace", "t", "", "Optional trace file")
+	pf.BoolVarP(&sp.repairCPT, "repaircpt", "", false, "Renormalize the CPT's of BAYES models instead of rejecting CPT's that don't sum to 1")
 
 	// IMPORTANT: note that startup params get changed based on the command.
 	// For instance, sampler creates a monitor and collapse always turns on
@@ -192,8 +273,8 @@ func Execute() {
 	cmd.AddCommand(sampleCmd)
 
 	pf = sampleCmd.PersistentFlags()
-	pf.StringVarP(&sp.samplerName, "sampler", "s", "", "Name of sampler to use (simple, collapsed, adaptive)")
-	pf.StringVarP(&sp.uaiFile, "model", "m", "", "UAI model file to read")
+	pf.StringVarP(&sp.samplerName, "sampler", "s", "", "Name of sampler to use (simple, collapsed, adaptive, bp, meanfield, ancestral, lw, importance)")
+	pf.StringVarP(&sp.uaiFile, "model", "m", "", "Model file to read (UAI, or by extension .bif, .xml, .net, .fg, .cnf, .wcnf, .wcsp or .json; .gz and .xz are decompressed)")
 	pf.BoolVarP(&sp.useEvidence, "evidence", "d", false, "Apply evidence from evidence file (name inferred from model file")
 	pf.BoolVarP(&sp.solFile, "solution", "o", false, "Use UAI MAR solution file to score (name inferred from model file)")
 	pf.Int64VarP(&sp.burnIn, "burnin", "b", -1, "Burn-In iteration count - if < 0, will use 2000*n (n= # vars)")
@@ -204,6 +285,19 @@ func Execute() {
 	pf.Int64VarP(&sp.maxSecs, "maxsecs", "x", 300, "Maximum seconds to run (0 for no maximum)")
 	pf.StringVarP(&sp.monitorAddr, "addr", "", ":8000", "Address (ip:port) that the monitor will listen at")
 	pf.BoolVarP(&sp.experiment, "experiment", "p", false, "Experiment mode - every chain advance status is written to trace file")
+	pf.StringVarP(&sp.bpSchedule, "bpschedule", "", "residual", "Belief propagation message schedule (flooding, residual)")
+	pf.Float64VarP(&sp.bpDamping, "bpdamp", "", 0.0, "Belief propagation damping in [0, 1) - 0 is no damping")
+	pf.Float64VarP(&sp.bpTolerance, "bptol", "", 1e-6, "Belief propagation convergence tolerance")
+	pf.Int64VarP(&sp.bpMaxIters, "bpiters", "", 1000, "Belief propagation maximum iterations")
+	pf.BoolVarP(&sp.bpSeed, "bpseed", "", false, "Seed chain starting points from belief propagation marginals")
+	pf.StringVarP(&sp.proposalName, "proposal", "", "uniform", "Importance sampling proposal (uniform, bp, gibbs)")
+	pf.Float64VarP(&sp.mfTolerance, "mftol", "", 1e-6, "Mean field convergence tolerance")
+	pf.Int64VarP(&sp.mfMaxIters, "mfiters", "", 1000, "Mean field maximum sweeps")
+	pf.BoolVarP(&sp.trackMPE, "mpe", "", false, "Track the best (MPE) state seen by each chain and report it")
+	pf.BoolVarP(&sp.reduce, "reduce", "", false, "Absorb evidence in to a smaller model before sampling (evidence vars are dropped)")
+	pf.StringVarP(&sp.query, "query", "q", "", "Comma separated query vars (names or IDs): BAYES models are pruned to the query and only query marginals are reported")
+	pf.StringVarP(&sp.outputFile, "output", "", "", "File to write final marginals to (evidence vars are one-hot)")
+	pf.StringVarP(&sp.outputFormat, "outformat", "", "", "Output file format (mar, json, csv) - default is from the output file extension")
 
 	PanicIf(sampleCmd.MarkPersistentFlagRequired("model"))
 	PanicIf(sampleCmd.MarkPersistentFlagRequired("sampler"))
@@ -222,7 +316,7 @@ func Execute() {
 	cmd.AddCommand(collapseCmd)
 
 	pf = collapseCmd.PersistentFlags()
-	pf.StringVarP(&sp.uaiFile, "model", "m", "", "UAI model file (evidence and MAR files expected)")
+	pf.StringVarP(&sp.uaiFile, "model", "m", "", "Model file (evidence and MAR files expected)")
 
 	PanicIf(collapseCmd.MarkPersistentFlagRequired("model"))
 
@@ -238,10 +332,94 @@ func Execute() {
 	cmd.AddCommand(dotCmd)
 
 	pf = dotCmd.PersistentFlags()
-	pf.StringVarP(&sp.uaiFile, "model", "m", "", "UAI model file")
+	pf.StringVarP(&sp.uaiFile, "model", "m", "", "Model file (UAI, or by extension .bif, .xml, .net, .fg, .cnf, .wcnf, .wcsp or .json; .gz and .xz are decompressed)")
 
 	PanicIf(dotCmd.MarkPersistentFlagRequired("model"))
 
+	// EXACT command
+	var exactCmd = &cobra.Command{
+		Use:   "exact",
+		Short: "Exact marginals written as a UAI MAR file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runGrampleCmd(sp, ExactMarginals)
+		},
+	}
+
+	cmd.AddCommand(exactCmd)
+
+	pf = exactCmd.PersistentFlags()
+	pf.StringVarP(&sp.uaiFile, "model", "m", "", "Model file to read (UAI, or by extension .bif, .xml, .net, .fg, .cnf, .wcnf, .wcsp or .json; .gz and .xz are decompressed)")
+	pf.BoolVarP(&sp.useEvidence, "evidence", "d", false, "Apply evidence from evidence file (name inferred from model file")
+	pf.BoolVarP(&sp.solFile, "solution", "o", false, "Use UAI MAR solution file to score (name inferred from model file)")
+	pf.StringVarP(&sp.outputFile, "output", "", "", "MAR file to write (default is stdout)")
+	pf.StringVarP(&sp.exactMethod, "method", "", "jtree", "Exact inference method (ve, jtree)")
+	pf.Int64VarP(&sp.orderIters, "orderiters", "", 1, "Randomized min-fill orders to try for jtree (best is used)")
+
+	PanicIf(exactCmd.MarkPersistentFlagRequired("model"))
+
+	// LOGZ command
+	var logzCmd = &cobra.Command{
+		Use:   "logz",
+		Short: "Log partition function written as a UAI PR file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runGrampleCmd(sp, LogPartition)
+		},
+	}
+
+	cmd.AddCommand(logzCmd)
+
+	pf = logzCmd.PersistentFlags()
+	pf.StringVarP(&sp.uaiFile, "model", "m", "", "Model file to read (UAI, or by extension .bif, .xml, .net, .fg, .cnf, .wcnf, .wcsp or .json; .gz and .xz are decompressed)")
+	pf.BoolVarP(&sp.useEvidence, "evidence", "d", false, "Apply evidence from evidence file (name inferred from model file")
+	pf.BoolVarP(&sp.solFile, "solution", "o", false, "Use UAI PR solution file to score (name inferred from model file, Merlin MAR files work too)")
+	pf.StringVarP(&sp.outputFile, "output", "", "", "PR file to write (default is stdout)")
+	pf.StringVarP(&sp.exactMethod, "method", "", "jtree", "Log Z method (ve, jtree, ais)")
+	pf.Int64VarP(&sp.orderIters, "orderiters", "", 1, "Randomized min-fill orders to try for jtree (best is used)")
+	pf.Int64VarP(&sp.aisTemps, "aistemps", "", 1000, "Number of AIS temperatures per run")
+	pf.Int64VarP(&sp.aisRuns, "aisruns", "", 100, "Number of AIS runs")
+
+	PanicIf(logzCmd.MarkPersistentFlagRequired("model"))
+
+	// MPE command
+	var mpeCmd = &cobra.Command{
+		Use:   "mpe",
+		Short: "MPE state via simulated annealing written as a UAI MPE file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runGrampleCmd(sp, MPESearch)
+		},
+	}
+
+	cmd.AddCommand(mpeCmd)
+
+	pf = mpeCmd.PersistentFlags()
+	pf.StringVarP(&sp.uaiFile, "model", "m", "", "Model file to read (UAI, or by extension .bif, .xml, .net, .fg, .cnf, .wcnf, .wcsp or .json; .gz and .xz are decompressed)")
+	pf.BoolVarP(&sp.useEvidence, "evidence", "d", false, "Apply evidence from evidence file (name inferred from model file")
+	pf.BoolVarP(&sp.solFile, "solution", "o", false, "Use UAI MPE solution file to score (name inferred from model file)")
+	pf.StringVarP(&sp.outputFile, "output", "", "", "MPE file to write (default is stdout)")
+	pf.Float64VarP(&sp.saStartTemp, "starttemp", "", 2.0, "Starting annealing temperature")
+	pf.Float64VarP(&sp.saEndTemp, "endtemp", "", 0.01, "Final annealing temperature")
+	pf.Int64VarP(&sp.saSweeps, "sweeps", "", 1000, "Number of annealing sweeps over all variables")
+
+	PanicIf(mpeCmd.MarkPersistentFlagRequired("model"))
+
+	// WIDTH command
+	var widthCmd = &cobra.Command{
+		Use:   "width",
+		Short: "Report elimination order width estimates for a model",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runGrampleCmd(sp, WidthReport)
+		},
+	}
+
+	cmd.AddCommand(widthCmd)
+
+	pf = widthCmd.PersistentFlags()
+	pf.StringVarP(&sp.uaiFile, "model", "m", "", "Model file to read (UAI, or by extension .bif, .xml, .net, .fg, .cnf, .wcnf, .wcsp or .json; .gz and .xz are decompressed)")
+	pf.BoolVarP(&sp.useEvidence, "evidence", "d", false, "Apply evidence from evidence file (name inferred from model file")
+	pf.Int64VarP(&sp.orderIters, "orderiters", "", 1, "Randomized orders to try per heuristic (best is reported)")
+
+	PanicIf(widthCmd.MarkPersistentFlagRequired("model"))
+
 	// Finally time time to execute
 	if err := cmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -316,19 +494,88 @@ func modelMarginals(sp *startupParams) error {
 		return errors.New("Experiment mode requires a trace file")
 	}
 
-	// Read model from file
+	// Find out about a bad output format before we do any work
+	if len(sp.outputFile) > 0 {
+		if _, err := outputWriter(sp); err != nil {
+			return err
+		}
+	}
+
+	// Read model from file: evidence is handled below since there may be
+	// more than one evidence instance
 	sp.out.Printf("Reading model from %s\n", sp.uaiFile)
-	reader := model.UAIReader{}
-	mod, err = model.NewModelFromFile(reader, sp.uaiFile, sp.useEvidence)
+	reader := model.ReaderForFile(sp.uaiFile)
+	mod, err = readModel(sp, reader, false, sp.out)
 	if err != nil {
 		return err
 	}
 	sp.out.Printf("Model has %d vars and %d functions\n", len(mod.Vars), len(mod.Funcs))
 
+	// Find our query variables (the model is pruned for each evidence instance)
+	if len(sp.query) > 0 {
+		if mod.Type != model.BAYES {
+			return errors.Errorf("A query requires a %s model but %s is %s", model.BAYES, sp.uaiFile, mod.Type)
+		}
+		if sp.trackMPE {
+			return errors.New("MPE tracking can not be used with a query")
+		}
+		names := strings.Split(sp.query, ",")
+		for i := range names {
+			names[i] = strings.TrimSpace(names[i])
+		}
+		sp.queryIDs, err = mod.LookupVars(names)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid query %s", sp.query)
+		}
+	}
+
+	if sp.useEvidence {
+		eviFilename := model.UncompressedName(sp.uaiFile) + ".evid"
+		evidReader, ok := reader.(model.EvidenceReader)
+		if !ok {
+			// Named evidence (BIF, etc) only has a single instance
+			evidReader = namedEvidenceReader{reader, mod}
+		}
+		evidence, err := model.NewEvidenceFromFile(evidReader, eviFilename)
+		if err != nil {
+			return err
+		}
+
+		// Soft and domain evidence apply to every instance
+		if softReader, ok := reader.(model.SoftEvidenceReader); ok {
+			soft, err := model.NewSoftEvidenceFromFile(softReader, eviFilename)
+			if err != nil {
+				return err
+			}
+			if err = mod.AddSoftEvidence(soft); err != nil {
+				return errors.Wrapf(err, "Could not apply soft evidence from %s", eviFilename)
+			}
+		}
+		if domainReader, ok := reader.(model.DomainEvidenceReader); ok {
+			domains, err := model.NewDomainEvidenceFromFile(domainReader, eviFilename)
+			if err != nil {
+				return err
+			}
+			if err = mod.RestrictDomains(domains); err != nil {
+				return errors.Wrapf(err, "Could not apply domain evidence from %s", eviFilename)
+			}
+		}
+
+		if len(evidence) > 1 {
+			return instanceMarginals(sp, mod, evidence)
+		}
+		if len(evidence) == 1 {
+			err = mod.SetEvidence(evidence[0])
+			if err != nil {
+				return errors.Wrapf(err, "Could not apply evidence from %s", eviFilename)
+			}
+		}
+	}
+
 	// Read solution file (if we have one)
 	if sp.solFile {
-		solFilename := sp.uaiFile + ".MAR"
-		sol, err = model.NewSolutionFromFile(reader, solFilename)
+		solFilename := model.UncompressedName(sp.uaiFile) + ".MAR"
+		sol, err = model.NewSolutionFromFile(model.UAIReader{}, solFilename)
 		if err != nil {
 			return errors.Wrapf(err, "Could not read solution file %s", solFilename)
 		}
@@ -340,7 +587,33 @@ func modelMarginals(sp *startupParams) error {
 		errorReport(sp, "START", score, false, nil)
 	}
 
-	// Some of our parameters are based on variable count
+	if err := sampleDefaults(sp, mod); err != nil {
+		return err
+	}
+
+	// Report what's going on
+	sp.Report()
+	sp.mon.BurnIn.Set(sp.burnIn)
+	sp.mon.ConvergeWindow.Set(sp.convergeWindow)
+	sp.mon.MaxIters.Set(sp.maxIters)
+	sp.mon.MaxSeconds.Set(sp.maxSecs)
+
+	finalVars, runTime, err := estimateMarginals(sp, mod, sol, startTime)
+	if err != nil {
+		return err
+	}
+
+	return reportMarginals(sp, mod, querySolution(sp, sol), finalVars, runTime)
+}
+
+// sampleDefaults sets any of our sampling parameters that are based on the
+// model (like variable count) and checks that our options make sense for our
+// sampler
+func sampleDefaults(sp *startupParams, mod *model.Model) error {
+	if (sp.isChainless() || sp.isWeighted()) && sp.trackMPE {
+		return errors.Errorf("MPE tracking requires a Gibbs sampler, not %s", sp.samplerName)
+	}
+
 	if sp.randomSeed < 1 {
 		n := time.Now()
 		sp.randomSeed = int64(n.Second()) + int64(n.Nanosecond()) + int64(n.Minute())
@@ -362,17 +635,144 @@ func modelMarginals(sp *startupParams) error {
 		sp.baseCount = 2
 	}
 
-	// Report what's going on
-	sp.Report()
-	sp.mon.BurnIn.Set(sp.burnIn)
-	sp.mon.ConvergeWindow.Set(sp.convergeWindow)
-	sp.mon.MaxIters.Set(sp.maxIters)
-	sp.mon.MaxSeconds.Set(sp.maxSecs)
+	return nil
+}
+
+// isChainless is true if our sampler is deterministic (belief propagation and
+// mean field) and doesn't use any chains
+func (s *startupParams) isChainless() bool {
+	name := strings.ToLower(s.samplerName)
+	return name == "bp" || name == "meanfield"
+}
+
+// querySolution returns the solution for just our query variables (see
+// --query), or the solution as-is if there is no query
+func querySolution(sp *startupParams, sol *model.Solution) *model.Solution {
+	if sol == nil || sp.queryIDs == nil {
+		return sol
+	}
+
+	qs := &model.Solution{Vars: make([]*model.Variable, len(sp.queryIDs))}
+	for i, id := range sp.queryIDs {
+		qs.Vars[i] = sol.Vars[id]
+	}
+	return qs
+}
+
+// estimateMarginals runs our selected sampler on the model (with whatever
+// evidence has been set) and returns the final normalized marginals and the
+// run time in seconds. Our time limits are measured from runStart.
+func estimateMarginals(sp *startupParams, mod *model.Model, sol *model.Solution, runStart time.Time) ([]*model.Variable, float64, error) {
+	var err error
+
+	// Optionally prune a BAYES model to our query: our solution is reduced to
+	// match, and only the query variables are returned
+	var prune *model.Pruning
+	if sp.queryIDs != nil {
+		prune, err = model.NewPruning(mod, sp.queryIDs)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "Could not prune model for query")
+		}
+		sp.out.Printf("Pruned model has %d vars and %d functions\n", len(prune.Model.Vars), len(prune.Model.Funcs))
+		mod = prune.Model
+
+		if sol != nil {
+			vars, err := prune.ReduceVars(sol.Vars)
+			if err != nil {
+				return nil, 0, errors.Wrapf(err, "Could not prune solution")
+			}
+			sol = &model.Solution{Vars: vars}
+		}
+	}
+
+	// Optionally sample a reduced model with the evidence absorbed: our
+	// solution is reduced to match, and results are expanded back to the
+	// original model
+	var red *model.Reduction
+	if sp.reduce {
+		red, err = model.NewReduction(mod)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "Could not absorb evidence")
+		}
+		sp.out.Printf("Reduced model has %d vars and %d functions\n", len(red.Model.Vars), len(red.Model.Funcs))
+		mod = red.Model
+
+		if sol != nil {
+			vars, err := red.ReduceVars(sol.Vars)
+			if err != nil {
+				return nil, 0, errors.Wrapf(err, "Could not reduce solution")
+			}
+			sol = &model.Solution{Vars: vars}
+		}
+	}
+	expand := func(vars []*model.Variable) ([]*model.Variable, error) {
+		var err error
+		if red != nil {
+			if vars, err = red.ExpandVars(vars); err != nil {
+				return nil, err
+			}
+		}
+		if prune != nil {
+			return prune.QueryVars(vars)
+		}
+		return vars, nil
+	}
+
+	// Belief propagation and mean field are deterministic and don't need any
+	// chains
+	if strings.ToLower(sp.samplerName) == "bp" {
+		finalVars, err := beliefPropMarginals(sp, mod)
+		if err != nil {
+			return nil, 0, err
+		}
+		finalVars, err = expand(finalVars)
+		if err != nil {
+			return nil, 0, err
+		}
+		return finalVars, time.Since(runStart).Seconds(), nil
+	} else if strings.ToLower(sp.samplerName) == "meanfield" {
+		finalVars, err := meanFieldMarginals(sp, mod)
+		if err != nil {
+			return nil, 0, err
+		}
+		finalVars, err = expand(finalVars)
+		if err != nil {
+			return nil, 0, err
+		}
+		return finalVars, time.Since(runStart).Seconds(), nil
+	}
 
 	// Create our concurrent PRNG
 	gen, err := rand.NewGenerator(sp.randomSeed)
 	if err != nil {
-		return errors.Wrapf(err, "Could not create Generator from seed %d", sp.randomSeed)
+		return nil, 0, errors.Wrapf(err, "Could not create Generator from seed %d", sp.randomSeed)
+	}
+
+	// Ancestral sampling, likelihood weighting and importance sampling take
+	// independent weighted samples instead of running chains
+	if sp.isWeighted() {
+		if sp.bpSeed {
+			return nil, 0, errors.Errorf("Sampler %s does not support seeding", sp.samplerName)
+		}
+		finalVars, err := weightedMarginals(sp, mod, sol, gen, runStart)
+		if err != nil {
+			return nil, 0, err
+		}
+		runTime := time.Since(runStart).Seconds()
+		finalVars, err = expand(finalVars)
+		if err != nil {
+			return nil, 0, err
+		}
+		return finalVars, runTime, nil
+	}
+
+	// Optionally get BP marginals to seed our chains
+	var seedVars []*model.Variable
+	if sp.bpSeed {
+		seedVars, err = beliefPropMarginals(sp, mod)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "Could not get BP marginals for chain seeding")
+		}
 	}
 
 	// Create chains and do burnin
@@ -390,17 +790,17 @@ func modelMarginals(sp *startupParams) error {
 			// Simple Gibbs - just created the chains we need
 			samp, err = sampler.NewGibbsSimple(gen, modCopy)
 			if err != nil {
-				return errors.Wrapf(err, "Could not create %s", sp.samplerName)
+				return nil, 0, errors.Wrapf(err, "Could not create %s", sp.samplerName)
 			}
 		} else if strings.ToLower(sp.samplerName) == "collapsed" {
 			// Collapsed Gibbs - collapse a random variable per chain
 			coll, err := sampler.NewGibbsCollapsed(gen, modCopy)
 			if err != nil {
-				return errors.Wrapf(err, "Could not create %s", sp.samplerName)
+				return nil, 0, errors.Wrapf(err, "Could not create %s", sp.samplerName)
 			}
 			colVar, err := coll.Collapse(-1)
 			if err != nil {
-				return errors.Wrapf(err, "Could not collapse random var on startup")
+				return nil, 0, errors.Wrapf(err, "Could not collapse random var on startup")
 			}
 			sp.out.Printf("        - Collaped variable %v:%v\n", colVar.ID, colVar.Name)
 			sp.out.Printf("MARGINAL: %+v\n", colVar.Marginal)
@@ -410,18 +810,39 @@ func modelMarginals(sp *startupParams) error {
 			// adaptive sampler strategy will handle that for us
 			coll, err := sampler.NewGibbsCollapsed(gen, modCopy)
 			if err != nil {
-				return errors.Wrapf(err, "Could not create %s", sp.samplerName)
+				return nil, 0, errors.Wrapf(err, "Could not create %s", sp.samplerName)
 			}
 			samp = coll
 		} else {
 			// Doh! We don't know this sampler
-			return errors.Errorf("Unknown Sampler: %s", sp.samplerName)
+			return nil, 0, errors.Errorf("Unknown Sampler: %s", sp.samplerName)
+		}
+
+		// Start from the BP marginals instead of a uniform sample if requested
+		if seedVars != nil {
+			seeded, ok := samp.(sampler.SeededSampler)
+			if !ok {
+				return nil, 0, errors.Errorf("Sampler %s does not support seeding", sp.samplerName)
+			}
+			err = seeded.SeedFrom(seedVars)
+			if err != nil {
+				return nil, 0, errors.Wrapf(err, "Could not seed chain from BP marginals")
+			}
 		}
 
 		// Create our chains and update the monitor
 		ch, err := sampler.NewChain(modCopy, samp, int(sp.convergeWindow), sp.burnIn)
 		if err != nil {
-			return errors.Wrapf(err, "Could not create initial chain")
+			return nil, 0, errors.Wrapf(err, "Could not create initial chain")
+		}
+
+		// Note that we score samples on the original model (not the
+		// possibly collapsed copy)
+		if sp.trackMPE {
+			ch.Best, err = sampler.NewMPETracker(mod)
+			if err != nil {
+				return nil, 0, errors.Wrapf(err, "Could not create MPE tracker")
+			}
 		}
 
 		chains[idx] = ch
@@ -438,12 +859,12 @@ func modelMarginals(sp *startupParams) error {
 	} else {
 		// Everything just skips adaptation
 		if sp.chainAdds != 1 {
-			return errors.Errorf("Sampler is not adaptive: ChainAdds=%d makes no sense", sp.chainAdds)
+			return nil, 0, errors.Errorf("Sampler is not adaptive: ChainAdds=%d makes no sense", sp.chainAdds)
 		}
 		adapt, err = sampler.NewIdentitySampler()
 	}
 	if err != nil {
-		return errors.Wrapf(err, "Could not create adaptation strategy for %s", sp.samplerName)
+		return nil, 0, errors.Wrapf(err, "Could not create adaptation strategy for %s", sp.samplerName)
 	}
 
 	// Trace file warning - it can get huge in verbose mode
@@ -461,12 +882,12 @@ func modelMarginals(sp *startupParams) error {
 	sp.out.Printf("Main Sampling Start\n")
 
 	// Note that our first status will happen faster than all later updates
-	stopTime := startTime.Add(time.Duration(sp.maxSecs) * time.Second)
+	stopTime := runStart.Add(time.Duration(sp.maxSecs) * time.Second)
 	untilStatus := time.Duration(5) * time.Second
-	nextStatus := startTime.Add(untilStatus / 2)
+	nextStatus := runStart.Add(untilStatus / 2)
 
 	keepAdapting := true
-	noAdaptTime := startTime.Add(time.Duration(sp.maxSecs/2) * time.Second)
+	noAdaptTime := runStart.Add(time.Duration(sp.maxSecs/2) * time.Second)
 
 	wg := sync.WaitGroup{}
 
@@ -496,7 +917,7 @@ func modelMarginals(sp *startupParams) error {
 
 		// Status update (including experiment file)
 		if now.After(nextStatus) || !keepWorking || sp.experiment {
-			runTime := time.Since(startTime).Seconds()
+			runTime := time.Since(runStart).Seconds()
 
 			if now.After(nextStatus) || !keepWorking {
 				sp.mon.RunTime.Set(runTime)
@@ -506,11 +927,11 @@ func modelMarginals(sp *startupParams) error {
 			if sp.solFile {
 				merged, err := sampler.MergeChains(chains)
 				if err != nil {
-					return errors.Wrapf(err, "Could not merge chains to calculate score")
+					return nil, 0, errors.Wrapf(err, "Could not merge chains to calculate score")
 				}
 				score, err := sol.Error(merged)
 				if err != nil {
-					return errors.Wrapf(err, "Error calculating score")
+					return nil, 0, errors.Wrapf(err, "Error calculating score")
 				}
 
 				if now.After(nextStatus) || !keepWorking {
@@ -549,7 +970,7 @@ func modelMarginals(sp *startupParams) error {
 			preCount := len(chains)
 			chains, err = adapt.Adapt(chains, int(sp.chainAdds))
 			if err != nil {
-				return err
+				return nil, 0, err
 			}
 			postCount := len(chains)
 
@@ -561,15 +982,73 @@ func modelMarginals(sp *startupParams) error {
 	}
 
 	// COMPLETED! grab results and normalize our marginals
-	runTime := time.Since(startTime).Seconds()
+	runTime := time.Since(runStart).Seconds()
 	finalVars, err := sampler.MergeChains(chains)
 	if err != nil {
-		return errors.Wrapf(err, "Error in final chain merge")
+		return nil, 0, errors.Wrapf(err, "Error in final chain merge")
 	}
 	for _, v := range finalVars {
 		PanicIf(v.NormMarginal())
 	}
 
+	// Get final convergence scores
+	hellConverge, err := sampler.ChainConvergence(chains, model.HellingerDiff, finalVars)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "Error getting final Hellinger Convergence")
+	}
+	jsConverge, err := sampler.ChainConvergence(chains, model.JSDivergence, finalVars)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "Error getting final JS Convergence")
+	}
+	maxaeConverge, err := sampler.ChainConvergence(chains, model.MaxAbsDiff, finalVars)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "Error getting final MaxAbsDiff Convergence")
+	}
+	avgaeConverge, err := sampler.ChainConvergence(chains, model.MeanAbsDiff, finalVars)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "Error getting final MeanAbsDiff Convergence")
+	}
+
+	if sp.trackMPE {
+		best, logProb, err := sampler.BestOfChains(chains)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "Could not find best MPE state")
+		}
+		mpeMod := mod
+		if red != nil {
+			mpeMod = red.Original
+			logProb += red.LogConst
+			if best, err = red.ExpandState(best); err != nil {
+				return nil, 0, errors.Wrapf(err, "Could not expand best MPE state")
+			}
+		}
+		err = mpeReport(sp, mpeMod, best, logProb, false, sp.out)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	for i, v := range finalVars {
+		v.State["Hell-Convergence"] = hellConverge[i]
+		v.State["JS-Convergence"] = jsConverge[i]
+		v.State["MaxAD-Convergence"] = maxaeConverge[i]
+		v.State["AvgAD-Convergence"] = avgaeConverge[i]
+	}
+
+	finalVars, err = expand(finalVars)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return finalVars, runTime, nil
+}
+
+// reportMarginals handles final output for the marginals we estimated: the
+// final score (if we have a solution), the Merlin comparison, and the trace
+// file. The caller should have already set any method-specific variable state.
+func reportMarginals(sp *startupParams, mod *model.Model, sol *model.Solution, finalVars []*model.Variable, runTime float64) error {
+	reader := model.UAIReader{}
+
 	// Output the marginals we found and our final evaluation
 	sp.out.Printf("DONE\n")
 
@@ -609,13 +1088,14 @@ func modelMarginals(sp *startupParams) error {
 		}
 
 		// Go ahead and include Merlin info if we can find a merlin file
-		merlinFilename := sp.uaiFile + ".merlin.MAR"
+		merlinFilename := model.UncompressedName(sp.uaiFile) + ".merlin.MAR"
 		if _, err := os.Stat(merlinFilename); !os.IsNotExist(err) {
 			var re error
 			merlin, re = model.NewSolutionFromFile(reader, merlinFilename)
 			if re != nil {
 				return errors.Wrapf(re, "Found merlin MAR file but could not read it")
 			}
+			merlin = querySolution(sp, merlin)
 
 			//merlinError, re := merlin.Error(sol.Vars)
 			merlinError, re := sol.Error(merlin.Vars)
@@ -636,30 +1116,8 @@ func modelMarginals(sp *startupParams) error {
 		}
 	}
 
-	// Get final convergence scores (and individual errors)
-	hellConverge, err := sampler.ChainConvergence(chains, model.HellingerDiff, finalVars)
-	if err != nil {
-		return errors.Wrapf(err, "Error getting final Hellinger Convergence")
-	}
-	jsConverge, err := sampler.ChainConvergence(chains, model.JSDivergence, finalVars)
-	if err != nil {
-		return errors.Wrapf(err, "Error getting final JS Convergence")
-	}
-	maxaeConverge, err := sampler.ChainConvergence(chains, model.MaxAbsDiff, finalVars)
-	if err != nil {
-		return errors.Wrapf(err, "Error getting final MaxAbsDiff Convergence")
-	}
-	avgaeConverge, err := sampler.ChainConvergence(chains, model.MeanAbsDiff, finalVars)
-	if err != nil {
-		return errors.Wrapf(err, "Error getting final MeanAbsDiff Convergence")
-	}
-
-	for i, v := range finalVars {
-		v.State["Hell-Convergence"] = hellConverge[i]
-		v.State["JS-Convergence"] = jsConverge[i]
-		v.State["MaxAD-Convergence"] = maxaeConverge[i]
-		v.State["AvgAD-Convergence"] = avgaeConverge[i]
-		if sp.solFile {
+	if sp.solFile {
+		for i, v := range finalVars {
 			v.State["Hell-Error"] = model.HellingerDiff(v, sol.Vars[i])
 			v.State["JS-Error"] = model.JSDivergence(v, sol.Vars[i])
 			v.State["MaxAD-Error"] = model.MaxAbsDiff(v, sol.Vars[i])
@@ -715,5 +1173,9 @@ func modelMarginals(sp *startupParams) error {
 	sp.traceJ.SetIndent("", "  ")
 	PanicIf(sp.traceJ.Encode(mod))
 
-	return nil
+	result, err := model.NewSolution(finalVars)
+	if err != nil {
+		return errors.Wrapf(err, "Could not create final solution")
+	}
+	return writeMarginals(sp, []*model.Solution{result})
 }
@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CraigKelly/grample/model"
+	"github.com/CraigKelly/grample/rand"
+	"github.com/CraigKelly/grample/sampler"
+)
+
+// weightedBatch is the number of samples each worker takes between status
+// checks
+const weightedBatch = 1000
+
+// isWeighted is true if our sampler draws independent weighted samples
+// (ancestral sampling, likelihood weighting and importance sampling) instead
+// of running chains
+func (s *startupParams) isWeighted() bool {
+	name := strings.ToLower(s.samplerName)
+	return name == "ancestral" || name == "lw" || name == "importance"
+}
+
+// isImportance is true if our sampler is importance sampling (and so needs a
+// proposal)
+func (s *startupParams) isImportance() bool {
+	return strings.ToLower(s.samplerName) == "importance"
+}
+
+// newWeightedSampler creates the weighted sampler named in our startup params.
+// The proposal is only used (and required) for importance sampling.
+func newWeightedSampler(sp *startupParams, gen *rand.Generator, mod *model.Model, proposal sampler.Proposal) (sampler.WeightedFullSampler, error) {
+	switch strings.ToLower(sp.samplerName) {
+	case "ancestral":
+		return sampler.NewAncestralSampler(gen, mod)
+	case "lw":
+		return sampler.NewLikelihoodWeighting(gen, mod)
+	case "importance":
+		return sampler.NewImportanceSampler(mod, proposal)
+	}
+	return nil, errors.Errorf("Unknown weighted sampler: %s", sp.samplerName)
+}
+
+// importanceProposal creates the proposal named in our startup params. It is
+// built once and shared by every worker (proposing only reads the
+// distribution, and our generator is safe for concurrent use).
+func importanceProposal(sp *startupParams, gen *rand.Generator, mod *model.Model) (sampler.Proposal, error) {
+	switch strings.ToLower(sp.proposalName) {
+	case "uniform":
+		return sampler.NewUniformProposal(gen, mod)
+	case "bp":
+		vars, err := beliefPropMarginals(sp, mod)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not get BP marginals for proposal")
+		}
+		return sampler.NewMarginalProposal(gen, mod, vars)
+	case "gibbs":
+		return gibbsProposal(sp, gen, mod)
+	}
+	return nil, errors.Errorf("Unknown proposal: %s", sp.proposalName)
+}
+
+// gibbsProposal runs one simple Gibbs chain per worker (burn-in plus a single
+// convergence window) and uses the merged marginals as the proposal
+func gibbsProposal(sp *startupParams, gen *rand.Generator, mod *model.Model) (sampler.Proposal, error) {
+	sp.out.Printf("Running %d Gibbs chains for proposal (burn-in %d)\n", sp.baseCount, sp.burnIn)
+
+	chains := make([]*sampler.Chain, sp.baseCount)
+	wg := sync.WaitGroup{}
+	for i := range chains {
+		modCopy := mod.Clone()
+		samp, err := sampler.NewGibbsSimple(gen, modCopy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not create Gibbs sampler for proposal")
+		}
+		chains[i], err = sampler.NewChain(modCopy, samp, int(sp.convergeWindow), sp.burnIn)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not create Gibbs chain for proposal")
+		}
+		if err = chains[i].AdvanceChain(&wg); err != nil {
+			return nil, errors.Wrapf(err, "Could not advance Gibbs chain for proposal")
+		}
+	}
+	wg.Wait()
+
+	return sampler.ChainProposal(gen, mod, chains)
+}
+
+// weightedWorker is a single sampler with its own accumulated marginals
+type weightedWorker struct {
+	samp   sampler.WeightedFullSampler
+	marg   *sampler.WeightedMarginals
+	sample []int
+}
+
+// run takes count samples
+func (w *weightedWorker) run(count int) error {
+	for i := 0; i < count; i++ {
+		logWeight, err := w.samp.SampleWeighted(w.sample)
+		if err != nil {
+			return err
+		}
+		err = w.marg.Add(w.sample, logWeight)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeWorkers returns the marginals accumulated by every worker
+func mergeWorkers(mod *model.Model, workers []*weightedWorker) (*sampler.WeightedMarginals, error) {
+	merged, err := sampler.NewWeightedMarginals(mod)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range workers {
+		if err = merged.Merge(w.marg); err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+// weightedMarginals estimates marginals with independent weighted samples
+// from one worker per base chain. There is no burn in or convergence
+// checking: we sample until we run out of time or iterations (where each
+// full sample is an iteration).
+func weightedMarginals(sp *startupParams, mod *model.Model, sol *model.Solution, gen *rand.Generator, runStart time.Time) ([]*model.Variable, error) {
+	var proposal sampler.Proposal
+	if sp.isImportance() {
+		var err error
+		proposal, err = importanceProposal(sp, gen, mod)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not create %s proposal", sp.proposalName)
+		}
+	}
+
+	workers := make([]*weightedWorker, sp.baseCount)
+	for i := range workers {
+		samp, err := newWeightedSampler(sp, gen, mod, proposal)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not create %s", sp.samplerName)
+		}
+		marg, err := sampler.NewWeightedMarginals(mod)
+		if err != nil {
+			return nil, err
+		}
+		workers[i] = &weightedWorker{samp: samp, marg: marg, sample: make([]int, len(mod.Vars))}
+	}
+	sp.out.Printf("Created %d %s samplers\n", len(workers), sp.samplerName)
+
+	sp.out.Printf("Main Sampling Start\n")
+
+	stopTime := runStart.Add(time.Duration(sp.maxSecs) * time.Second)
+	untilStatus := time.Duration(5) * time.Second
+	nextStatus := runStart.Add(untilStatus / 2)
+
+	var merged *sampler.WeightedMarginals
+	keepWorking := true
+	for keepWorking {
+		wg := sync.WaitGroup{}
+		errs := make([]error, len(workers))
+		for i, w := range workers {
+			wg.Add(1)
+			go func(i int, w *weightedWorker) {
+				defer wg.Done()
+				errs[i] = w.run(weightedBatch)
+			}(i, w)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return nil, errors.Wrapf(err, "Weighted sampling failed")
+			}
+		}
+
+		now := time.Now()
+		if sp.maxSecs > 0 && now.After(stopTime) {
+			keepWorking = false
+		}
+
+		sampleCount := int64(0)
+		for _, w := range workers {
+			sampleCount += w.marg.SampleCount
+		}
+		sp.mon.Iterations.Set(sampleCount)
+		if sp.maxIters > 0 && sampleCount > sp.maxIters {
+			keepWorking = false
+		}
+
+		if now.After(nextStatus) || !keepWorking {
+			var err error
+			merged, err = mergeWorkers(mod, workers)
+			if err != nil {
+				return nil, err
+			}
+
+			runTime := time.Since(runStart).Seconds()
+			sp.mon.RunTime.Set(runTime)
+			sp.out.Printf("  Samps: %12d | Accepted %12d | ESS %14.2f | RT %12.2fsec\n", sampleCount, merged.Accepted, merged.ESS(), runTime)
+
+			if sp.solFile && merged.Accepted > 0 {
+				vars, err := merged.Marginals()
+				if err != nil {
+					return nil, err
+				}
+				score, err := sol.Error(vars)
+				if err != nil {
+					return nil, errors.Wrapf(err, "Error calculating score")
+				}
+				errorReport(sp, "", score, true, nil)
+			}
+
+			nextStatus = now.Add(untilStatus)
+		}
+	}
+
+	finalVars, err := merged.Marginals()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Weighted sampling failed")
+	}
+	for _, v := range finalVars {
+		v.State["Weighted-Samples"] = float64(merged.SampleCount)
+		v.State["Weighted-Accepted"] = float64(merged.Accepted)
+		v.State["Weighted-ESS"] = merged.ESS()
+		v.State["Weighted-LogZ"] = merged.LogZ()
+	}
+	sp.out.Printf("Weighted ESS = %.2f, log Z = %.8f\n", merged.ESS(), merged.LogZ())
+
+	return finalVars, nil
+}
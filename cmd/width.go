@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/CraigKelly/grample/elim"
+	"github.com/CraigKelly/grample/model"
+	"github.com/CraigKelly/grample/rand"
+)
+
+// WidthReport reads a model and reports the induced width and largest table
+// size for each elimination order heuristic. This is a quick way to decide
+// if exact inference is feasible or if we should just sample.
+func WidthReport(sp *startupParams) error {
+	var mod *model.Model
+	var err error
+
+	// Read model from file
+	sp.out.Printf("Reading model from %s\n", sp.uaiFile)
+	reader := model.ReaderForFile(sp.uaiFile)
+	mod, err = readModel(sp, reader, sp.useEvidence, sp.out)
+	if err != nil {
+		return err
+	}
+	sp.out.Printf("Model has %d vars and %d functions\n", len(mod.Vars), len(mod.Funcs))
+
+	for i, v := range mod.Vars {
+		if i != v.ID {
+			return errors.Errorf("Var %v has ID %d != idx %d", v.Name, v.ID, i)
+		}
+	}
+
+	graph := elim.NewGraph(mod, true)
+	sp.out.Printf("Graph has %d unobserved vars\n", graph.Size())
+
+	var gen *rand.Generator
+	if sp.orderIters > 1 {
+		gen, err = rand.NewGenerator(sp.randomSeed)
+		if err != nil {
+			return errors.Wrapf(err, "Could not create Generator from seed %d", sp.randomSeed)
+		}
+		sp.out.Printf("Using best of %d randomized orders per heuristic\n", sp.orderIters)
+	}
+
+	for _, h := range elim.Heuristics {
+		order, err := elim.Search(graph, h, gen, int(sp.orderIters))
+		if err != nil {
+			return errors.Wrapf(err, "Could not compute %s order", h)
+		}
+		sp.out.Printf(
+			"%-18s Width:%4d MaxTable:%14.0f Log2(MaxTable):%7.2f\n",
+			h.String(), order.Width, order.MaxTableSize, order.Log2MaxTableSize(),
+		)
+	}
+
+	return nil
+}
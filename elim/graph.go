@@ -0,0 +1,98 @@
+package elim
+
+import (
+	"github.com/CraigKelly/grample/model"
+)
+
+// Graph is the Markov (interaction) graph of a model: two variables are
+// adjacent if they appear together in at least one function. Variables are
+// identified by their ID, which must match their index in the model.
+type Graph struct {
+	Adj     []map[int]bool // Adj[i] is the set of neighbors for variable i
+	Card    []int          // Card[i] is the cardinality of variable i
+	InGraph []bool         // False for variables excluded from the graph
+}
+
+// NewGraph builds the graph for the given model, which must have variable IDs
+// that match their index. If skipFixed is true, variables with evidence are
+// left out of the graph entirely (since conditioning on evidence removes them
+// from every function).
+func NewGraph(m *model.Model, skipFixed bool) *Graph {
+	g := &Graph{
+		Adj:     make([]map[int]bool, len(m.Vars)),
+		Card:    make([]int, len(m.Vars)),
+		InGraph: make([]bool, len(m.Vars)),
+	}
+
+	for i, v := range m.Vars {
+		g.Adj[i] = make(map[int]bool)
+		g.Card[i] = v.Card
+		g.InGraph[i] = !skipFixed || v.FixedVal < 0
+	}
+
+	for _, f := range m.Funcs {
+		for i, v1 := range f.Vars {
+			if !g.InGraph[v1.ID] {
+				continue
+			}
+			for _, v2 := range f.Vars[i+1:] {
+				if !g.InGraph[v2.ID] || v1.ID == v2.ID {
+					continue
+				}
+				g.Adj[v1.ID][v2.ID] = true
+				g.Adj[v2.ID][v1.ID] = true
+			}
+		}
+	}
+
+	return g
+}
+
+// Clone returns a deep copy of the graph
+func (g *Graph) Clone() *Graph {
+	cp := &Graph{
+		Adj:     make([]map[int]bool, len(g.Adj)),
+		Card:    make([]int, len(g.Card)),
+		InGraph: make([]bool, len(g.InGraph)),
+	}
+
+	for i, adj := range g.Adj {
+		cp.Adj[i] = make(map[int]bool, len(adj))
+		for n := range adj {
+			cp.Adj[i][n] = true
+		}
+	}
+	copy(cp.Card, g.Card)
+	copy(cp.InGraph, g.InGraph)
+
+	return cp
+}
+
+// Size returns the number of variables in the graph
+func (g *Graph) Size() int {
+	count := 0
+	for _, in := range g.InGraph {
+		if in {
+			count++
+		}
+	}
+	return count
+}
+
+// eliminate connects all the neighbors of id and then removes id from the
+// graph. The neighbors of id (before removal) are returned.
+func (g *Graph) eliminate(id int) map[int]bool {
+	nbrs := g.Adj[id]
+	for n1 := range nbrs {
+		for n2 := range nbrs {
+			if n1 != n2 {
+				g.Adj[n1][n2] = true
+			}
+		}
+		delete(g.Adj[n1], id)
+	}
+
+	g.Adj[id] = make(map[int]bool)
+	g.InGraph[id] = false
+	return nbrs
+}
@@ -0,0 +1,235 @@
+package elim
+
+import (
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/CraigKelly/grample/rand"
+)
+
+// Heuristic is a greedy scoring rule for choosing the next variable to
+// eliminate: the variable with the lowest score is chosen.
+type Heuristic int
+
+// Supported heuristics
+const (
+	MinDegree       Heuristic = iota // Fewest neighbors
+	MinFill                          // Fewest fill-in edges added
+	WeightedMinFill                  // Fill-in edges weighted by the product of endpoint cardinalities
+)
+
+// Heuristics lists every supported heuristic (handy for reporting)
+var Heuristics = []Heuristic{MinDegree, MinFill, WeightedMinFill}
+
+// String implements fmt.Stringer
+func (h Heuristic) String() string {
+	switch h {
+	case MinDegree:
+		return "min-degree"
+	case MinFill:
+		return "min-fill"
+	case WeightedMinFill:
+		return "weighted-min-fill"
+	}
+	return "unknown"
+}
+
+// ParseHeuristic returns the heuristic for the given name (as returned by
+// String)
+func ParseHeuristic(name string) (Heuristic, error) {
+	for _, h := range Heuristics {
+		if strings.ToLower(name) == h.String() {
+			return h, nil
+		}
+	}
+	return MinFill, errors.Errorf("Unknown elimination heuristic %s", name)
+}
+
+// score returns the heuristic score for eliminating id from g
+func (h Heuristic) score(g *Graph, id int) float64 {
+	nbrs := g.Adj[id]
+	if h == MinDegree {
+		return float64(len(nbrs))
+	}
+
+	fill := 0.0
+	for n1 := range nbrs {
+		for n2 := range nbrs {
+			if n1 < n2 && !g.Adj[n1][n2] {
+				if h == WeightedMinFill {
+					fill += float64(g.Card[n1] * g.Card[n2])
+				} else {
+					fill += 1.0
+				}
+			}
+		}
+	}
+	return fill
+}
+
+// Order is an elimination order along with the cost of using it
+type Order struct {
+	Vars         []int   // Variable IDs in elimination order
+	Width        int     // Induced width: the largest neighborhood of an eliminated variable
+	MaxTableSize float64 // Entry count for the largest clique table (a float since it can be huge)
+}
+
+// Log2MaxTableSize is a handy way to report table size
+func (o *Order) Log2MaxTableSize() float64 {
+	return math.Log2(o.MaxTableSize)
+}
+
+// better returns true if o is a cheaper order than other
+func (o *Order) better(other *Order) bool {
+	if other == nil {
+		return true
+	}
+	if o.MaxTableSize != other.MaxTableSize {
+		return o.MaxTableSize < other.MaxTableSize
+	}
+	return o.Width < other.Width
+}
+
+// NewOrder greedily computes an elimination order for every variable in the
+// graph (the graph is not modified). If gen is nil, ties are broken by the
+// lowest variable ID so the order is deterministic. Otherwise ties are broken
+// uniformly at random.
+func NewOrder(g *Graph, h Heuristic, gen *rand.Generator) (*Order, error) {
+	if h != MinDegree && h != MinFill && h != WeightedMinFill {
+		return nil, errors.Errorf("Unknown heuristic %d", int(h))
+	}
+
+	work := g.Clone()
+	size := work.Size()
+
+	o := &Order{
+		Vars:         make([]int, 0, size),
+		Width:        0,
+		MaxTableSize: 0.0,
+	}
+
+	// Scores only change in the neighborhood of an eliminated variable, so we
+	// cache them and only recalculate dirty entries
+	scores := make([]float64, len(work.Adj))
+	dirty := make([]bool, len(work.Adj))
+	for i, in := range work.InGraph {
+		if in {
+			scores[i] = h.score(work, i)
+		}
+	}
+
+	for len(o.Vars) < size {
+		best := -1
+		ties := 0
+		for i, in := range work.InGraph {
+			if !in {
+				continue
+			}
+			if dirty[i] {
+				scores[i] = h.score(work, i)
+				dirty[i] = false
+			}
+
+			if best < 0 || scores[i] < scores[best] {
+				best = i
+				ties = 1
+			} else if scores[i] == scores[best] && gen != nil {
+				// Reservoir sampling over the tied variables
+				ties++
+				if gen.Int31n(int32(ties)) == 0 {
+					best = i
+				}
+			}
+		}
+
+		if best < 0 {
+			return nil, errors.Errorf("Logic error: no variable found to eliminate")
+		}
+
+		tabSize := float64(work.Card[best])
+		for n := range work.Adj[best] {
+			tabSize *= float64(work.Card[n])
+		}
+		if len(work.Adj[best]) > o.Width {
+			o.Width = len(work.Adj[best])
+		}
+		if tabSize > o.MaxTableSize {
+			o.MaxTableSize = tabSize
+		}
+
+		nbrs := work.eliminate(best)
+		for n := range nbrs {
+			dirty[n] = true
+			if h != MinDegree {
+				for n2 := range work.Adj[n] {
+					dirty[n2] = true
+				}
+			}
+		}
+
+		o.Vars = append(o.Vars, best)
+	}
+
+	return o, nil
+}
+
+// Evaluate returns the width and max table size for eliminating the graph in
+// the given order. Every variable in the graph must appear exactly once.
+func Evaluate(g *Graph, vars []int) (*Order, error) {
+	work := g.Clone()
+	if len(vars) != work.Size() {
+		return nil, errors.Errorf("Order has %d vars but graph has %d", len(vars), work.Size())
+	}
+
+	o := &Order{
+		Vars:         make([]int, len(vars)),
+		Width:        0,
+		MaxTableSize: 0.0,
+	}
+	copy(o.Vars, vars)
+
+	for _, id := range vars {
+		if id < 0 || id >= len(work.InGraph) || !work.InGraph[id] {
+			return nil, errors.Errorf("Invalid or duplicate variable %d in order", id)
+		}
+
+		tabSize := float64(work.Card[id])
+		for n := range work.Adj[id] {
+			tabSize *= float64(work.Card[n])
+		}
+		if len(work.Adj[id]) > o.Width {
+			o.Width = len(work.Adj[id])
+		}
+		if tabSize > o.MaxTableSize {
+			o.MaxTableSize = tabSize
+		}
+
+		work.eliminate(id)
+	}
+
+	return o, nil
+}
+
+// Search runs NewOrder with random tie-breaking iters times and returns the
+// order with the smallest max table size. If gen is nil, only a single
+// deterministic order is computed.
+func Search(g *Graph, h Heuristic, gen *rand.Generator, iters int) (*Order, error) {
+	if gen == nil || iters < 1 {
+		iters = 1
+	}
+
+	var best *Order
+	for i := 0; i < iters; i++ {
+		o, err := NewOrder(g, h, gen)
+		if err != nil {
+			return nil, err
+		}
+		if o.better(best) {
+			best = o
+		}
+	}
+
+	return best, nil
+}
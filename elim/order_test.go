@@ -0,0 +1,184 @@
+package elim
+
+import (
+	"testing"
+
+	"github.com/CraigKelly/grample/model"
+	"github.com/CraigKelly/grample/rand"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pairModel creates a model with one pairwise function per edge
+func pairModel(t *testing.T, cards []int, edges [][2]int) *model.Model {
+	vars := make([]*model.Variable, len(cards))
+	for i, c := range cards {
+		v, err := model.NewVariable(i, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vars[i] = v
+	}
+
+	funcs := make([]*model.Function, len(edges))
+	for i, e := range edges {
+		f, err := model.NewFunction(i, []*model.Variable{vars[e[0]], vars[e[1]]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		funcs[i] = f
+	}
+
+	return &model.Model{Type: model.MARKOV, Name: "pairs", Vars: vars, Funcs: funcs}
+}
+
+// gridModel creates an n x n grid of binary variables
+func gridModel(t *testing.T, n int) *model.Model {
+	cards := make([]int, n*n)
+	edges := make([][2]int, 0, 2*n*n)
+	for r := 0; r < n; r++ {
+		for c := 0; c < n; c++ {
+			i := r*n + c
+			cards[i] = 2
+			if c+1 < n {
+				edges = append(edges, [2]int{i, i + 1})
+			}
+			if r+1 < n {
+				edges = append(edges, [2]int{i, i + n})
+			}
+		}
+	}
+	return pairModel(t, cards, edges)
+}
+
+func TestGraph(t *testing.T) {
+	assert := assert.New(t)
+
+	mod := pairModel(t, []int{2, 3, 4}, [][2]int{{0, 1}, {1, 2}})
+	g := NewGraph(mod, false)
+	assert.Equal(3, g.Size())
+	assert.Equal([]int{2, 3, 4}, g.Card)
+	assert.Equal(map[int]bool{1: true}, g.Adj[0])
+	assert.Equal(map[int]bool{0: true, 2: true}, g.Adj[1])
+	assert.Equal(map[int]bool{1: true}, g.Adj[2])
+
+	// Clones are independent
+	cp := g.Clone()
+	nbrs := cp.eliminate(1)
+	assert.Equal(map[int]bool{0: true, 2: true}, nbrs)
+	assert.Equal(map[int]bool{2: true}, cp.Adj[0])
+	assert.Equal(2, cp.Size())
+	assert.Equal(3, g.Size())
+	assert.Equal(map[int]bool{1: true}, g.Adj[0])
+
+	// Evidence removes the variable
+	mod.Vars[1].FixedVal = 0
+	g = NewGraph(mod, true)
+	assert.Equal(2, g.Size())
+	assert.False(g.InGraph[1])
+	assert.Equal(0, len(g.Adj[0]))
+	assert.Equal(0, len(g.Adj[2]))
+}
+
+func TestParseHeuristic(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, h := range Heuristics {
+		p, err := ParseHeuristic(h.String())
+		assert.NoError(err)
+		assert.Equal(h, p)
+	}
+
+	p, err := ParseHeuristic("Min-Fill")
+	assert.NoError(err)
+	assert.Equal(MinFill, p)
+
+	_, err = ParseHeuristic("nope")
+	assert.Error(err)
+
+	_, err = NewOrder(NewGraph(gridModel(t, 2), false), Heuristic(42), nil)
+	assert.Error(err)
+}
+
+func TestOrderChain(t *testing.T) {
+	assert := assert.New(t)
+
+	// A chain is a tree, so every heuristic should find width 1
+	mod := pairModel(t, []int{2, 3, 4, 3, 2}, [][2]int{{0, 1}, {1, 2}, {2, 3}, {3, 4}})
+	g := NewGraph(mod, false)
+	for _, h := range Heuristics {
+		o, err := NewOrder(g, h, nil)
+		assert.NoError(err)
+		assert.Equal(5, len(o.Vars))
+		assert.Equal(1, o.Width)
+		assert.Equal(12.0, o.MaxTableSize) // 3x4 or 4x3
+	}
+
+	// Deterministic order: lowest ID wins ties
+	o, err := NewOrder(g, MinFill, nil)
+	assert.NoError(err)
+	assert.Equal([]int{0, 1, 2, 3, 4}, o.Vars)
+
+	// Eliminating the middle first is worse
+	o, err = Evaluate(g, []int{2, 0, 1, 3, 4})
+	assert.NoError(err)
+	assert.Equal(2, o.Width)
+	assert.Equal(36.0, o.MaxTableSize)
+	assert.InDelta(5.1699, o.Log2MaxTableSize(), 1e-4)
+
+	// Bad orders
+	_, err = Evaluate(g, []int{0, 1, 2, 3})
+	assert.Error(err)
+	_, err = Evaluate(g, []int{0, 1, 2, 3, 3})
+	assert.Error(err)
+	_, err = Evaluate(g, []int{0, 1, 2, 3, 9})
+	assert.Error(err)
+}
+
+func TestOrderGrid(t *testing.T) {
+	assert := assert.New(t)
+
+	// The treewidth of an n x n grid is n
+	const n = 5
+	g := NewGraph(gridModel(t, n), false)
+
+	gen, err := rand.NewGenerator(42)
+	assert.NoError(err)
+
+	for _, h := range Heuristics {
+		o, err := NewOrder(g, h, nil)
+		assert.NoError(err)
+		assert.True(o.Width >= n)
+
+		// Evaluate agrees with the greedy construction
+		ev, err := Evaluate(g, o.Vars)
+		assert.NoError(err)
+		assert.Equal(o.Width, ev.Width)
+		assert.Equal(o.MaxTableSize, ev.MaxTableSize)
+
+		// Random search is never worse than a single deterministic run
+		best, err := Search(g, h, gen, 20)
+		assert.NoError(err)
+		assert.True(best.Width >= n)
+		ev, err = Evaluate(g, best.Vars)
+		assert.NoError(err)
+		assert.Equal(best.MaxTableSize, ev.MaxTableSize)
+	}
+
+	// The graph itself is never modified
+	assert.Equal(n*n, g.Size())
+}
+
+func TestOrderEvidence(t *testing.T) {
+	assert := assert.New(t)
+
+	// Observing the center of a star leaves only disconnected vars
+	mod := pairModel(t, []int{3, 2, 2, 2}, [][2]int{{0, 1}, {0, 2}, {0, 3}})
+	mod.Vars[0].FixedVal = 1
+
+	o, err := NewOrder(NewGraph(mod, true), MinFill, nil)
+	assert.NoError(err)
+	assert.Equal([]int{1, 2, 3}, o.Vars)
+	assert.Equal(0, o.Width)
+	assert.Equal(2.0, o.MaxTableSize)
+}
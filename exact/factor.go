@@ -0,0 +1,88 @@
+package exact
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+
+	"github.com/CraigKelly/grample/model"
+)
+
+// The helpers in this file work on model.Function factors in linear space
+// (see model/factor.go for the factor operations). Since a model.Function
+// can not have an empty scope, any operation that would produce a factor over
+// zero variables returns a scalar instead (the returned function will be
+// nil).
+
+// checkModel makes sure the model is non-nil and that every variable ID
+// matches the variable's index (which everything in this package assumes).
+func checkModel(m *model.Model) error {
+	if m == nil {
+		return errors.New("No model supplied")
+	}
+
+	for i, v := range m.Vars {
+		if i != v.ID {
+			return errors.Errorf("Invalid ID for var %s: expected %d but was %d", v.Name, i, v.ID)
+		}
+	}
+
+	return nil
+}
+
+// varIndex returns the index of the variable with the given ID in vars (or
+// -1 if it isn't there)
+func varIndex(vars []*model.Variable, id int) int {
+	for i, v := range vars {
+		if v.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// reduce conditions f on the evidence in vars (any variable with a FixedVal
+// >= 0). The variables in evid are looked up by ID, so f may be defined on
+// cloned variables. If every variable in f is fixed, the scalar value is
+// returned with a nil function. Entries for values ruled out by domain
+// evidence are zero in the result.
+func reduce(f *model.Function, evid []*model.Variable) (*model.Function, float64, error) {
+	e := make(model.Evidence)
+	for _, v := range f.Vars {
+		if val := evid[v.ID].FixedVal; val >= 0 {
+			e[v.ID] = val
+		}
+	}
+
+	dest, c, err := f.ToLinear().Reduce(e)
+	if err != nil || dest == nil {
+		return nil, c, err
+	}
+
+	dest, err = dest.Mask(evid)
+	if err != nil {
+		return nil, math.NaN(), err
+	}
+	return dest, c, nil
+}
+
+// scale divides every entry in f by the maximum entry and returns the log of
+// that maximum. This keeps intermediate factors from underflowing. An error
+// is returned if every entry is zero (which means the evidence is impossible).
+func scale(f *model.Function) (float64, error) {
+	max := 0.0
+	for _, v := range f.Table {
+		if v > max {
+			max = v
+		}
+	}
+	if max <= 0.0 {
+		return math.NaN(), errors.Errorf("Function %s is zero everywhere: evidence has zero probability", f.Name)
+	}
+
+	for i, v := range f.Table {
+		f.Table[i] = v / max
+	}
+
+	return math.Log(max), nil
+}
@@ -0,0 +1,518 @@
+package exact
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/CraigKelly/grample/elim"
+	"github.com/CraigKelly/grample/model"
+)
+
+// Clique is a single node in a JunctionTree. After calibration, Belief holds
+// the normalized joint distribution over the clique's variables given the
+// current evidence.
+type Clique struct {
+	ID        int               // Index of the clique in the tree
+	Vars      []*model.Variable // Variables in the clique (from the model)
+	Neighbors []int             // Indexes of adjacent cliques
+	Belief    *model.Function   // Calibrated (normalized) belief - nil before Calibrate
+
+	potential *model.Function // Product of the model functions assigned to this clique
+}
+
+// JunctionTree (or clique tree) supports exact inference by calibrating
+// messages between the cliques of a triangulated Markov graph. The tree
+// structure only depends on the model's functions, so evidence may be changed
+// (via the FixedVal of the model's variables) and the tree re-calibrated
+// without rebuilding it. If the Markov graph is disconnected, the "tree" is
+// really a forest.
+type JunctionTree struct {
+	Cliques []*Clique
+
+	pgm        *model.Model
+	varClique  []int                      // Smallest clique containing each variable
+	sepsets    map[[2]int]*model.Function // Message from [0] to [1] (over the sepset)
+	calibrated bool
+	logZ       float64 // Log partition function from the last calibration
+}
+
+// NewJunctionTree triangulates the model's Markov graph with a min-fill
+// elimination order, builds the maximal cliques and connects them with a
+// maximum-weight spanning tree. Note that the model functions are copied into
+// the tree, so later changes to the model's functions are not seen (but
+// evidence changes are).
+func NewJunctionTree(m *model.Model) (*JunctionTree, error) {
+	err := checkModel(m)
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := elim.NewOrder(elim.NewGraph(m, false), elim.MinFill, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not find elimination order")
+	}
+
+	return NewJunctionTreeFromOrder(m, o.Vars)
+}
+
+// NewJunctionTreeFromOrder is NewJunctionTree using the given elimination
+// order, which must contain every variable in the model (see elim.Search for
+// finding a good order).
+func NewJunctionTreeFromOrder(m *model.Model, order []int) (*JunctionTree, error) {
+	err := checkModel(m)
+	if err != nil {
+		return nil, err
+	}
+	if len(order) != len(m.Vars) {
+		return nil, errors.Errorf("Elimination order has %d vars, but model has %d", len(order), len(m.Vars))
+	}
+
+	jt := &JunctionTree{
+		Cliques:    make([]*Clique, 0, len(m.Vars)),
+		pgm:        m,
+		varClique:  make([]int, len(m.Vars)),
+		sepsets:    make(map[[2]int]*model.Function),
+		calibrated: false,
+	}
+
+	err = jt.buildCliques(order)
+	if err != nil {
+		return nil, err
+	}
+	jt.buildTree()
+
+	err = jt.assignFunctions()
+	if err != nil {
+		return nil, err
+	}
+
+	return jt, nil
+}
+
+// buildCliques runs the elimination order to triangulate the graph, keeping
+// only the maximal cliques produced.
+func (jt *JunctionTree) buildCliques(order []int) error {
+	m := jt.pgm
+	adj := elim.NewGraph(m, false).Adj
+
+	// Each elimination step creates a candidate clique: the variable and its
+	// current neighbors.
+	candidates := make([][]int, 0, len(order))
+	for _, id := range order {
+		if id < 0 || id >= len(adj) || adj[id] == nil {
+			return errors.Errorf("Invalid or duplicate variable %d in elimination order", id)
+		}
+		cl := make([]int, 0, len(adj[id])+1)
+		cl = append(cl, id)
+		for n1 := range adj[id] {
+			cl = append(cl, n1)
+			for n2 := range adj[id] {
+				if n1 != n2 {
+					adj[n1][n2] = true
+				}
+			}
+			delete(adj[n1], id)
+		}
+		adj[id] = nil
+		sort.Ints(cl)
+		candidates = append(candidates, cl)
+	}
+
+	// Keep maximal cliques: a candidate can only be contained in a clique
+	// that has at least as many variables
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return len(candidates[i]) > len(candidates[j])
+	})
+
+	varCliques := make([][]int, len(m.Vars)) // clique indexes per var
+	for _, cl := range candidates {
+		subset := false
+		for _, ci := range varCliques[cl[0]] {
+			if containsAll(jt.Cliques[ci].Vars, cl) {
+				subset = true
+				break
+			}
+		}
+		if subset {
+			continue
+		}
+
+		c := &Clique{
+			ID:        len(jt.Cliques),
+			Vars:      make([]*model.Variable, len(cl)),
+			Neighbors: make([]int, 0, 2),
+			Belief:    nil,
+			potential: nil,
+		}
+		for i, id := range cl {
+			c.Vars[i] = m.Vars[id]
+			varCliques[id] = append(varCliques[id], c.ID)
+		}
+		jt.Cliques = append(jt.Cliques, c)
+	}
+
+	// Remember the smallest clique for each variable: it's the cheapest place
+	// to find the variable's marginal
+	for id, cls := range varCliques {
+		best := cls[0]
+		for _, ci := range cls[1:] {
+			if tableSize(jt.Cliques[ci].Vars) < tableSize(jt.Cliques[best].Vars) {
+				best = ci
+			}
+		}
+		jt.varClique[id] = best
+	}
+
+	return nil
+}
+
+// tableSize returns the size of a table over the given variables
+func tableSize(vars []*model.Variable) int {
+	size := 1
+	for _, v := range vars {
+		size *= v.Card
+	}
+	return size
+}
+
+// containsAll returns true if every variable ID in ids is in vars
+func containsAll(vars []*model.Variable, ids []int) bool {
+	for _, id := range ids {
+		if varIndex(vars, id) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// sharedVars returns the variables in both c1 and c2 (in c1's order)
+func sharedVars(c1 *Clique, c2 *Clique) []*model.Variable {
+	shared := make([]*model.Variable, 0, len(c1.Vars))
+	for _, v := range c1.Vars {
+		if varIndex(c2.Vars, v.ID) >= 0 {
+			shared = append(shared, v)
+		}
+	}
+	return shared
+}
+
+// buildTree connects the cliques with a maximum-weight spanning tree (weight
+// is sepset size). This gives us the running intersection property.
+func (jt *JunctionTree) buildTree() {
+	type edge struct {
+		c1, c2 int
+		weight int
+	}
+
+	// Only cliques that share a variable are candidates
+	varCliques := make([][]int, len(jt.pgm.Vars))
+	for _, c := range jt.Cliques {
+		for _, v := range c.Vars {
+			varCliques[v.ID] = append(varCliques[v.ID], c.ID)
+		}
+	}
+
+	seen := make(map[[2]int]bool)
+	edges := make([]edge, 0, len(jt.Cliques)*2)
+	for _, cls := range varCliques {
+		for i, c1 := range cls {
+			for _, c2 := range cls[i+1:] {
+				key := [2]int{c1, c2}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				w := len(sharedVars(jt.Cliques[c1], jt.Cliques[c2]))
+				edges = append(edges, edge{c1, c2, w})
+			}
+		}
+	}
+
+	sort.SliceStable(edges, func(i, j int) bool {
+		return edges[i].weight > edges[j].weight
+	})
+
+	// Kruskal with a simple union-find
+	parent := make([]int, len(jt.Cliques))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for _, e := range edges {
+		r1, r2 := find(e.c1), find(e.c2)
+		if r1 == r2 {
+			continue
+		}
+		parent[r1] = r2
+		c1, c2 := jt.Cliques[e.c1], jt.Cliques[e.c2]
+		c1.Neighbors = append(c1.Neighbors, c2.ID)
+		c2.Neighbors = append(c2.Neighbors, c1.ID)
+	}
+}
+
+// assignFunctions gives each model function to the smallest clique that
+// contains the function's scope. Each clique's potential is the product of
+// the functions it is assigned.
+func (jt *JunctionTree) assignFunctions() error {
+	for _, c := range jt.Cliques {
+		pot, err := model.NewFunction(0, c.Vars)
+		if err != nil {
+			return errors.Wrapf(err, "Clique %d is too large", c.ID)
+		}
+		pot.Name = fmt.Sprintf("CLIQUE-%d", c.ID)
+		for i := range pot.Table {
+			pot.Table[i] = 1.0
+		}
+		c.potential = pot
+	}
+
+	for _, f := range jt.pgm.Funcs {
+		ids := make([]int, len(f.Vars))
+		for i, v := range f.Vars {
+			ids[i] = v.ID
+		}
+
+		best := -1
+		for _, c := range jt.Cliques {
+			if !containsAll(c.Vars, ids) {
+				continue
+			}
+			if best < 0 || len(c.Vars) < len(jt.Cliques[best].Vars) {
+				best = c.ID
+			}
+		}
+		if best < 0 {
+			return errors.Errorf("No clique found for function %s", f.Name)
+		}
+
+		pot, err := jt.Cliques[best].potential.Multiply(f.ToLinear())
+		if err != nil {
+			return err
+		}
+		jt.Cliques[best].potential = pot
+	}
+
+	return nil
+}
+
+// message calculates the message from clique src to clique dest using the
+// src belief. If divide is true the message dest already sent to src is
+// divided out (this is the downward pass).
+func (jt *JunctionTree) message(src *Clique, belief *model.Function, dest *Clique, divide bool) (float64, error) {
+	msg, err := belief.SumTo(sharedVars(src, dest))
+	if err != nil {
+		return math.NaN(), err
+	}
+
+	if divide {
+		msg, err = msg.Divide(jt.sepsets[[2]int{dest.ID, src.ID}])
+		if err != nil {
+			return math.NaN(), err
+		}
+	}
+
+	ls, err := scale(msg)
+	if err != nil {
+		return math.NaN(), errors.Wrapf(err, "Message from clique %d to %d", src.ID, dest.ID)
+	}
+
+	jt.sepsets[[2]int{src.ID, dest.ID}] = msg
+	return ls, nil
+}
+
+// Calibrate runs a full collect/distribute pass using the current evidence
+// in the model. Afterwards every clique has a normalized Belief.
+func (jt *JunctionTree) Calibrate() error {
+	jt.calibrated = false
+	jt.sepsets = make(map[[2]int]*model.Function)
+
+	// We track every scale factor removed during the collect pass: that's
+	// enough to recover Z from the (unnormalized) root beliefs
+	logScale := 0.0
+
+	beliefs := make([]*model.Function, len(jt.Cliques))
+	for _, c := range jt.Cliques {
+		pot, err := c.potential.Mask(jt.pgm.Vars)
+		if err != nil {
+			return errors.Wrapf(err, "Clique %d potential", c.ID)
+		}
+		ls, err := scale(pot)
+		if err != nil {
+			return errors.Wrapf(err, "Clique %d potential", c.ID)
+		}
+		logScale += ls
+		beliefs[c.ID] = pot
+	}
+
+	// Find a post-order traversal for each tree in our forest
+	visited := make([]bool, len(jt.Cliques))
+	parents := make([]int, len(jt.Cliques))
+	order := make([]int, 0, len(jt.Cliques))
+	for _, root := range jt.Cliques {
+		if visited[root.ID] {
+			continue
+		}
+		visited[root.ID] = true
+		parents[root.ID] = -1
+
+		start := len(order)
+		order = append(order, root.ID)
+		for i := start; i < len(order); i++ {
+			for _, n := range jt.Cliques[order[i]].Neighbors {
+				if !visited[n] {
+					visited[n] = true
+					parents[n] = order[i]
+					order = append(order, n)
+				}
+			}
+		}
+	}
+
+	// Collect: children before parents (reverse BFS order). When a clique
+	// sends its message, all of its children's messages have been absorbed.
+	for i := len(order) - 1; i >= 0; i-- {
+		c := jt.Cliques[order[i]]
+		p := parents[c.ID]
+		if p < 0 {
+			continue
+		}
+		ls, err := jt.message(c, beliefs[c.ID], jt.Cliques[p], false)
+		if err != nil {
+			return err
+		}
+		logScale += ls
+		beliefs[p], err = beliefs[p].Multiply(jt.sepsets[[2]int{c.ID, p}])
+		if err != nil {
+			return err
+		}
+		ls, err = scale(beliefs[p])
+		if err != nil {
+			return errors.Wrapf(err, "Clique %d belief", p)
+		}
+		logScale += ls
+	}
+
+	// After collect, each root has absorbed its entire tree
+	for _, id := range order {
+		if parents[id] >= 0 {
+			continue
+		}
+		sum := 0.0
+		for _, v := range beliefs[id].Table {
+			sum += v
+		}
+		if sum <= 0.0 || math.IsNaN(sum) {
+			return errors.Errorf("Clique %d has zero belief: evidence has zero probability", id)
+		}
+		logScale += math.Log(sum)
+	}
+
+	// Distribute: parents before children (BFS order). The parent's belief
+	// is final, so dividing out the child's message gives the correct
+	// message to the child.
+	for _, id := range order {
+		p := parents[id]
+		if p < 0 {
+			continue
+		}
+		_, err := jt.message(jt.Cliques[p], beliefs[p], jt.Cliques[id], true)
+		if err != nil {
+			return err
+		}
+		beliefs[id], err = beliefs[id].Multiply(jt.sepsets[[2]int{p, id}])
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, c := range jt.Cliques {
+		b := beliefs[c.ID]
+		sum := 0.0
+		for _, v := range b.Table {
+			sum += v
+		}
+		if sum <= 0.0 || math.IsNaN(sum) {
+			return errors.Errorf("Clique %d has zero belief: evidence has zero probability", c.ID)
+		}
+		for i, v := range b.Table {
+			b.Table[i] = v / sum
+		}
+		c.Belief = b
+	}
+
+	jt.logZ = logScale
+	jt.calibrated = true
+	return nil
+}
+
+// LogZ returns the natural log of the partition function (including the
+// current evidence) found during the last calibration.
+func (jt *JunctionTree) LogZ() (float64, error) {
+	if !jt.calibrated {
+		return math.NaN(), errors.New("Junction tree has not been calibrated")
+	}
+	return jt.logZ, nil
+}
+
+// Marginal returns a copy of the variable at varIdx with its exact marginal
+// from the calibrated tree.
+func (jt *JunctionTree) Marginal(varIdx int) (*model.Variable, error) {
+	if !jt.calibrated {
+		return nil, errors.New("Junction tree has not been calibrated")
+	}
+	if varIdx < 0 || varIdx >= len(jt.pgm.Vars) {
+		return nil, errors.Errorf("Invalid variable index %d", varIdx)
+	}
+
+	v := jt.pgm.Vars[varIdx].Clone()
+	c := jt.Cliques[jt.varClique[varIdx]]
+
+	marg, err := c.Belief.SumTo([]*model.Variable{jt.pgm.Vars[varIdx]})
+	if err != nil {
+		return nil, err
+	}
+	copy(v.Marginal, marg.Table)
+
+	err = v.NormMarginal()
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Marginals returns copies of all the model's variables with exact marginals
+// from the calibrated tree.
+func (jt *JunctionTree) Marginals() ([]*model.Variable, error) {
+	vars := make([]*model.Variable, len(jt.pgm.Vars))
+	for i := range jt.pgm.Vars {
+		v, err := jt.Marginal(i)
+		if err != nil {
+			return nil, err
+		}
+		vars[i] = v
+	}
+	return vars, nil
+}
+
+// MaxCliqueSize returns the number of variables in the largest clique (which
+// is the induced width plus one).
+func (jt *JunctionTree) MaxCliqueSize() int {
+	max := 0
+	for _, c := range jt.Cliques {
+		if len(c.Vars) > max {
+			max = len(c.Vars)
+		}
+	}
+	return max
+}
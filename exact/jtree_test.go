@@ -0,0 +1,135 @@
+package exact
+
+import (
+	"testing"
+
+	"github.com/CraigKelly/grample/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func checkTreeMarginals(t *testing.T, jt *JunctionTree, m *model.Model) {
+	assert := assert.New(t)
+
+	assert.NoError(jt.Calibrate())
+	vars, err := jt.Marginals()
+	assert.NoError(err)
+
+	expected, expectedLogZ := bruteForce(m)
+	assert.Equal(len(expected), len(vars))
+	for i, v := range vars {
+		assert.InDeltaSlice(expected[i].Marginal, v.Marginal, 1e-10)
+	}
+
+	logZ, err := jt.LogZ()
+	assert.NoError(err)
+	assert.InDelta(expectedLogZ, logZ, 1e-10)
+
+	// Every clique belief is a distribution that agrees with the marginals
+	for _, c := range jt.Cliques {
+		sum := 0.0
+		for _, p := range c.Belief.Table {
+			sum += p
+		}
+		assert.InDelta(1.0, sum, 1e-10)
+
+		for _, v := range c.Vars {
+			marg, err := c.Belief.SumTo([]*model.Variable{v})
+			assert.NoError(err)
+			assert.InDeltaSlice(expected[v.ID].Marginal, marg.Table, 1e-10)
+		}
+	}
+}
+
+func TestJunctionTreeSample(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := model.UAIReader{}
+	mod, err := model.NewModelFromFile(reader, "../res/sample.uai", false)
+	assert.NoError(err)
+
+	jt, err := NewJunctionTree(mod)
+	assert.NoError(err)
+	assert.Equal(2, len(jt.Cliques))
+	assert.Equal(2, jt.MaxCliqueSize())
+
+	_, err = jt.Marginal(0)
+	assert.Error(err) // Not calibrated yet
+	_, err = jt.LogZ()
+	assert.Error(err)
+
+	checkTreeMarginals(t, jt, mod)
+
+	// New evidence, same tree
+	mod.Vars[0].FixedVal = 1
+	checkTreeMarginals(t, jt, mod)
+
+	mod.Vars[0].FixedVal = -1
+	mod.Vars[2].FixedVal = 2
+	checkTreeMarginals(t, jt, mod)
+}
+
+func TestJunctionTreeLoop(t *testing.T) {
+	assert := assert.New(t)
+
+	// Grid-like model with a disconnected variable to make a forest
+	cards := []int{2, 3, 2, 4, 2, 3, 2}
+	vars := make([]*model.Variable, len(cards))
+	for i, c := range cards {
+		v, err := model.NewVariable(i, c)
+		assert.NoError(err)
+		vars[i] = v
+	}
+
+	scopes := [][]int{{0, 1}, {1, 2}, {3, 4}, {0, 3}, {1, 4}, {2, 5}, {4, 5}, {5}, {6}}
+	funcs := make([]*model.Function, len(scopes))
+	for i, sc := range scopes {
+		fv := make([]*model.Variable, len(sc))
+		for j, idx := range sc {
+			fv[j] = vars[idx]
+		}
+		f, err := model.NewFunction(i, fv)
+		assert.NoError(err)
+		for k := range f.Table {
+			f.Table[k] = 0.5 + float64((k*5+i*3)%7)
+		}
+		funcs[i] = f
+	}
+
+	mod := &model.Model{Type: model.MARKOV, Name: "grid", Vars: vars, Funcs: funcs}
+	assert.NoError(mod.Check())
+
+	jt, err := NewJunctionTree(mod)
+	assert.NoError(err)
+	checkTreeMarginals(t, jt, mod)
+
+	vars[4].FixedVal = 1
+	vars[6].FixedVal = 0
+	checkTreeMarginals(t, jt, mod)
+
+	// Impossible evidence is an error
+	funcs[8].Table[0] = 0.0
+	jt, err = NewJunctionTree(mod)
+	assert.NoError(err)
+	assert.Error(jt.Calibrate())
+}
+
+func TestJunctionTreeSolution(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := model.UAIReader{}
+	mod, err := model.NewModelFromFile(reader, "../res/deterministic.uai", true)
+	assert.NoError(err)
+	sol, err := model.NewSolutionFromFile(reader, "../res/deterministic.uai.MAR")
+	assert.NoError(err)
+
+	jt, err := NewJunctionTree(mod)
+	assert.NoError(err)
+	assert.NoError(jt.Calibrate())
+	vars, err := jt.Marginals()
+	assert.NoError(err)
+
+	score, err := sol.Error(vars)
+	assert.NoError(err)
+	assert.InDelta(0.0, score.MaxMaxAbsError, 1e-8)
+}
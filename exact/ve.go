@@ -0,0 +1,216 @@
+package exact
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/pkg/errors"
+
+	"github.com/CraigKelly/grample/elim"
+	"github.com/CraigKelly/grample/model"
+)
+
+// VarElim performs exact inference with sum-product variable elimination.
+// Evidence is taken from the FixedVal of the model's variables when a query is
+// run, so evidence may be changed between queries. Note that this is
+// exponential in the induced width of the elimination order: model.Function
+// refuses to create tables that are too large, so an intractable model will
+// return an error instead of running forever.
+type VarElim struct {
+	pgm       *model.Model
+	order     []int
+	orderEvid []int // FixedVal for each var when order was calculated
+}
+
+// NewVarElim creates a variable elimination engine for the given model
+func NewVarElim(m *model.Model) (*VarElim, error) {
+	err := checkModel(m)
+	if err != nil {
+		return nil, err
+	}
+
+	ve := &VarElim{
+		pgm:       m,
+		order:     nil,
+		orderEvid: nil,
+	}
+
+	return ve, nil
+}
+
+// eliminate sums out every unfixed variable except keep (which may be -1 to
+// sum out everything). The remaining functions (which are all over keep) are
+// returned along with the log of the scale factors removed along the way.
+func (ve *VarElim) eliminate(keep int) ([]*model.Function, float64, error) {
+	// Our elimination order depends on the current evidence
+	if ve.evidenceChanged() {
+		o, err := elim.NewOrder(elim.NewGraph(ve.pgm, true), elim.MinFill, nil)
+		if err != nil {
+			return nil, math.NaN(), errors.Wrap(err, "Could not find elimination order")
+		}
+		ve.order = o.Vars
+		ve.orderEvid = make([]int, len(ve.pgm.Vars))
+		for i, v := range ve.pgm.Vars {
+			ve.orderEvid[i] = v.FixedVal
+		}
+	}
+
+	logScale := 0.0
+
+	funcs := make([]*model.Function, 0, len(ve.pgm.Funcs))
+	for _, f := range ve.pgm.Funcs {
+		r, c, err := reduce(f, ve.pgm.Vars)
+		if err != nil {
+			return nil, math.NaN(), err
+		}
+		if r == nil {
+			if c <= 0.0 {
+				return nil, math.NaN(), errors.Errorf("Function %s is zero for the given evidence", f.Name)
+			}
+			logScale += math.Log(c)
+			continue
+		}
+		funcs = append(funcs, r)
+	}
+
+	for _, id := range ve.order {
+		if id == keep {
+			continue
+		}
+
+		// Split the functions into those over our variable and the rest
+		bucket := make([]*model.Function, 0, 8)
+		rest := make([]*model.Function, 0, len(funcs))
+		for _, f := range funcs {
+			if varIndex(f.Vars, id) >= 0 {
+				bucket = append(bucket, f)
+			} else {
+				rest = append(rest, f)
+			}
+		}
+		funcs = rest
+
+		if len(bucket) < 1 {
+			// Variable is in no functions, so it just multiplies Z by the
+			// number of values it can take
+			logScale += math.Log(float64(len(ve.pgm.Vars[id].AllowedValues())))
+			continue
+		}
+
+		prod, err := model.Product(bucket...)
+		if err != nil {
+			return nil, math.NaN(), errors.Wrapf(err, "Could not eliminate var %s", ve.pgm.Vars[id].Name)
+		}
+
+		msg, c, err := prod.SumOut(id)
+		if err != nil {
+			return nil, math.NaN(), err
+		}
+		if msg == nil {
+			if c <= 0.0 {
+				return nil, math.NaN(), errors.Errorf("Eliminating var %s gives zero: evidence has zero probability", ve.pgm.Vars[id].Name)
+			}
+			logScale += math.Log(c)
+			continue
+		}
+
+		msg.Name = fmt.Sprintf("VE-%s", ve.pgm.Vars[id].Name)
+		ls, err := scale(msg)
+		if err != nil {
+			return nil, math.NaN(), err
+		}
+		logScale += ls
+
+		funcs = append(funcs, msg)
+	}
+
+	return funcs, logScale, nil
+}
+
+// evidenceChanged returns true if our elimination order needs to be
+// recalculated because the evidence has changed
+func (ve *VarElim) evidenceChanged() bool {
+	if ve.order == nil || len(ve.orderEvid) != len(ve.pgm.Vars) {
+		return true
+	}
+	for i, v := range ve.pgm.Vars {
+		if (v.FixedVal < 0) != (ve.orderEvid[i] < 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// LogZ returns the natural log of the partition function given the current
+// evidence (the log probability of evidence for a Bayesian network).
+func (ve *VarElim) LogZ() (float64, error) {
+	funcs, logZ, err := ve.eliminate(-1)
+	if err != nil {
+		return math.NaN(), errors.Wrap(err, "Could not calculate log Z")
+	}
+	if len(funcs) > 0 {
+		return math.NaN(), errors.Errorf("Function %s left over after eliminating every var", funcs[0].Name)
+	}
+	return logZ, nil
+}
+
+// Marginal returns a copy of the variable at varIdx with its exact marginal
+// given the current evidence. A variable with evidence gets a one-hot
+// marginal.
+func (ve *VarElim) Marginal(varIdx int) (*model.Variable, error) {
+	if varIdx < 0 || varIdx >= len(ve.pgm.Vars) {
+		return nil, errors.Errorf("Invalid variable index %d", varIdx)
+	}
+
+	v := ve.pgm.Vars[varIdx].Clone()
+	if v.FixedVal >= 0 {
+		for i := range v.Marginal {
+			v.Marginal[i] = 0.0
+		}
+		v.Marginal[v.FixedVal] = 1.0
+		return v, nil
+	}
+
+	funcs, _, err := ve.eliminate(varIdx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not calculate marginal for var %s", v.Name)
+	}
+
+	for i := range v.Marginal {
+		v.Marginal[i] = 1.0
+	}
+	for _, f := range funcs {
+		if len(f.Vars) != 1 || f.Vars[0].ID != v.ID {
+			return nil, errors.Errorf("Function %s left over after elimination for var %s", f.Name, v.Name)
+		}
+		for i, p := range f.Table {
+			v.Marginal[i] *= p
+		}
+	}
+
+	sum := 0.0
+	for _, p := range v.Marginal {
+		sum += p
+	}
+	if sum <= 0.0 {
+		return nil, errors.Errorf("Marginal for var %s is zero: evidence has zero probability", v.Name)
+	}
+	for i, p := range v.Marginal {
+		v.Marginal[i] = p / sum
+	}
+
+	return v, nil
+}
+
+// Marginals returns copies of all the model's variables with exact marginals
+func (ve *VarElim) Marginals() ([]*model.Variable, error) {
+	vars := make([]*model.Variable, len(ve.pgm.Vars))
+	for i := range ve.pgm.Vars {
+		v, err := ve.Marginal(i)
+		if err != nil {
+			return nil, err
+		}
+		vars[i] = v
+	}
+	return vars, nil
+}
@@ -0,0 +1,321 @@
+package exact
+
+import (
+	"math"
+	"testing"
+
+	"github.com/CraigKelly/grample/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bruteMarginals enumerates the full joint to find marginals (honoring evidence)
+func bruteMarginals(m *model.Model) []*model.Variable {
+	vars, _ := bruteForce(m)
+	return vars
+}
+
+// bruteLogZ enumerates the full joint to find log Z (honoring evidence)
+func bruteLogZ(m *model.Model) float64 {
+	_, logZ := bruteForce(m)
+	return logZ
+}
+
+// bruteForce enumerates the joint to find the marginals and log Z
+func bruteForce(m *model.Model) ([]*model.Variable, float64) {
+	vars := make([]*model.Variable, len(m.Vars))
+	for i, v := range m.Vars {
+		vars[i] = v.Clone()
+		for c := range vars[i].Marginal {
+			vars[i].Marginal[c] = 0.0
+		}
+	}
+
+	vi, err := model.NewVariableIter(m.Vars, true)
+	if err != nil {
+		panic(err)
+	}
+	z := 0.0
+	state := make([]int, len(m.Vars))
+	for {
+		if err := vi.Val(state); err != nil {
+			panic(err)
+		}
+
+		p := 1.0
+		for _, f := range m.Funcs {
+			vals := make([]int, len(f.Vars))
+			for i, v := range f.Vars {
+				vals[i] = state[v.ID]
+			}
+			r, err := f.Eval(vals)
+			if err != nil {
+				panic(err)
+			}
+			if f.IsLog {
+				r = math.Exp(r)
+			}
+			p *= r
+		}
+		z += p
+		for i, v := range vars {
+			v.Marginal[state[i]] += p
+		}
+
+		if !vi.Next() {
+			break
+		}
+	}
+
+	for _, v := range vars {
+		if err := v.NormMarginal(); err != nil {
+			panic(err)
+		}
+	}
+	return vars, math.Log(z)
+}
+
+func checkMarginals(t *testing.T, m *model.Model) {
+	assert := assert.New(t)
+
+	ve, err := NewVarElim(m)
+	assert.NoError(err)
+	vars, err := ve.Marginals()
+	assert.NoError(err)
+
+	expected := bruteMarginals(m)
+	assert.Equal(len(expected), len(vars))
+	for i, v := range vars {
+		assert.InDeltaSlice(expected[i].Marginal, v.Marginal, 1e-10)
+	}
+
+	logZ, err := ve.LogZ()
+	assert.NoError(err)
+	assert.InDelta(bruteLogZ(m), logZ, 1e-10)
+}
+
+func TestVarElimSample(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := model.UAIReader{}
+	mod, err := model.NewModelFromFile(reader, "../res/sample.uai", false)
+	assert.NoError(err)
+	checkMarginals(t, mod)
+
+	// Evidence changes between queries
+	mod.Vars[1].FixedVal = 1
+	checkMarginals(t, mod)
+
+	mod.Vars[1].FixedVal = -1
+	mod.Vars[2].FixedVal = 0
+	checkMarginals(t, mod)
+
+	// Log space functions are fine too
+	for _, f := range mod.Funcs {
+		assert.NoError(f.UseLogSpace())
+	}
+	ve, err := NewVarElim(mod)
+	assert.NoError(err)
+	v, err := ve.Marginal(2)
+	assert.NoError(err)
+	assert.InDeltaSlice([]float64{1.0, 0.0, 0.0}, v.Marginal, 1e-10)
+}
+
+func TestVarElimSolution(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := model.UAIReader{}
+	mod, err := model.NewModelFromFile(reader, "../res/deterministic.uai", true)
+	assert.NoError(err)
+	sol, err := model.NewSolutionFromFile(reader, "../res/deterministic.uai.MAR")
+	assert.NoError(err)
+
+	ve, err := NewVarElim(mod)
+	assert.NoError(err)
+	vars, err := ve.Marginals()
+	assert.NoError(err)
+
+	score, err := sol.Error(vars)
+	assert.NoError(err)
+	assert.InDelta(0.0, score.MaxMaxAbsError, 1e-8)
+}
+
+func TestVarElimLoop(t *testing.T) {
+	// A small loopy model with non-binary vars so that factor ordering matters
+	cards := []int{2, 3, 2, 4, 2}
+	vars := make([]*model.Variable, len(cards))
+	for i, c := range cards {
+		v, err := model.NewVariable(i, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vars[i] = v
+	}
+
+	scopes := [][]int{{0, 1}, {2, 1}, {2, 3}, {3, 0, 4}, {4}, {1, 4}}
+	funcs := make([]*model.Function, len(scopes))
+	for i, sc := range scopes {
+		fv := make([]*model.Variable, len(sc))
+		for j, idx := range sc {
+			fv[j] = vars[idx]
+		}
+		f, err := model.NewFunction(i, fv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k := range f.Table {
+			f.Table[k] = 0.1 + math.Mod(float64(k*7+i*3), 5.0)
+		}
+		funcs[i] = f
+	}
+
+	mod := &model.Model{Type: model.MARKOV, Name: "loop", Vars: vars, Funcs: funcs}
+	assert.NoError(t, mod.Check())
+	checkMarginals(t, mod)
+
+	vars[3].FixedVal = 2
+	checkMarginals(t, mod)
+}
+
+func TestVarElimBIF(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := model.NewModelFromFile(model.BIFReader{}, "../res/asia.bif", false)
+	assert.NoError(err)
+
+	ve, err := NewVarElim(m)
+	assert.NoError(err)
+
+	// A Bayes net with no evidence is already normalized
+	logZ, err := ve.LogZ()
+	assert.NoError(err)
+	assert.InDelta(0.0, logZ, 1e-9)
+
+	lung, err := ve.Marginal(3)
+	assert.NoError(err)
+	assert.InDelta(0.055, lung.Marginal[0], 1e-9)
+
+	either, err := ve.Marginal(5)
+	assert.NoError(err)
+	assert.InDelta(1.0-(1.0-0.055)*(1.0-0.0104), either.Marginal[0], 1e-9)
+}
+
+func TestVarElimCNF(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := model.NewModelFromFile(model.DIMACSReader{}, "../res/sat.cnf", false)
+	assert.NoError(err)
+
+	ve, err := NewVarElim(m)
+	assert.NoError(err)
+
+	// Z is the solution count, and marginals are over the solutions
+	logZ, err := ve.LogZ()
+	assert.NoError(err)
+	assert.InDelta(math.Log(3.0), logZ, 1e-9)
+
+	marg, err := ve.Marginals()
+	assert.NoError(err)
+	for i, p := range []float64{2.0 / 3.0, 1.0 / 3.0, 2.0 / 3.0, 2.0 / 3.0} {
+		assert.InDelta(p, marg[i].Marginal[1], 1e-9)
+	}
+}
+
+func TestVarElimSoftEvidence(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := model.NewModelFromFile(model.UAIReader{}, "../res/one.uai", false)
+	assert.NoError(err)
+	assert.NoError(m.AddSoftEvidence(model.SoftEvidence{0: {0.9, 0.1}}))
+
+	ve, err := NewVarElim(m)
+	assert.NoError(err)
+
+	// P(X) is (0.25, 0.75) so P(X | soft) is proportional to (0.225, 0.075)
+	x, err := ve.Marginal(0)
+	assert.NoError(err)
+	assert.InDelta(0.75, x.Marginal[0], 1e-9)
+	assert.InDelta(0.25, x.Marginal[1], 1e-9)
+
+	logZ, err := ve.LogZ()
+	assert.NoError(err)
+	assert.InDelta(math.Log(0.3), logZ, 1e-9)
+}
+
+func TestVarElimReduction(t *testing.T) {
+	assert := assert.New(t)
+
+	mod, err := model.NewModelFromFile(model.UAIReader{}, "../res/deterministic.uai", false)
+	assert.NoError(err)
+	assert.NoError(mod.SetEvidence(model.Evidence{1: 1}))
+	red, err := model.NewReduction(mod)
+	assert.NoError(err)
+
+	ve, err := NewVarElim(mod)
+	assert.NoError(err)
+	redVE, err := NewVarElim(red.Model)
+	assert.NoError(err)
+
+	// Absorbing evidence doesn't change the probability of evidence
+	logZ, err := ve.LogZ()
+	assert.NoError(err)
+	redLogZ, err := redVE.LogZ()
+	assert.NoError(err)
+	assert.InDelta(logZ, redLogZ+red.LogConst, 1e-8)
+
+	// ... or the marginals
+	vars, err := ve.Marginals()
+	assert.NoError(err)
+	redVars, err := redVE.Marginals()
+	assert.NoError(err)
+	expVars, err := red.ExpandVars(redVars)
+	assert.NoError(err)
+	for i, v := range vars {
+		for c := range v.Marginal {
+			assert.InDelta(v.Marginal[c], expVars[i].Marginal[c], 1e-8)
+		}
+	}
+}
+
+func TestVarElimPruning(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := model.NewModelFromFile(model.BIFReader{}, "../res/asia.bif", false)
+	assert.NoError(err)
+	assert.NoError(m.AddSoftEvidence(model.SoftEvidence{4: {0.3, 0.6}}))
+
+	cases := []struct {
+		query []int
+		evid  model.Evidence
+	}{
+		{[]int{3}, model.Evidence{}},
+		{[]int{3, 0}, model.Evidence{6: 0}},
+		{[]int{1}, model.Evidence{5: 0, 7: 1}},
+		{[]int{7}, model.Evidence{2: 1, 0: 0}},
+	}
+
+	for _, c := range cases {
+		assert.NoError(m.SetEvidence(c.evid))
+		ve, err := NewVarElim(m)
+		assert.NoError(err)
+
+		p, err := model.NewPruning(m, c.query)
+		assert.NoError(err)
+		assert.True(len(p.Model.Vars) < len(m.Vars))
+		pve, err := NewVarElim(p.Model)
+		assert.NoError(err)
+		pvars, err := pve.Marginals()
+		assert.NoError(err)
+		qvars, err := p.QueryVars(pvars)
+		assert.NoError(err)
+
+		// Pruning doesn't change the query marginals
+		for i, q := range c.query {
+			v, err := ve.Marginal(q)
+			assert.NoError(err)
+			for j := range v.Marginal {
+				assert.InDelta(v.Marginal[j], qvars[i].Marginal[j], 1e-9)
+			}
+		}
+	}
+}
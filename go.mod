@@ -7,6 +7,7 @@ require (
 	github.com/seehuhn/mt19937 v1.0.0
 	github.com/spf13/cobra v1.3.0
 	github.com/stretchr/testify v1.7.0
+	github.com/ulikunitz/xz v0.5.12
 )
 
 require (
@@ -0,0 +1,230 @@
+package model
+
+import (
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// CPTTolerance is how far the sum of a CPT row (the child's distribution for
+// one parent configuration) may be from 1 before a BAYES model is invalid.
+// Model files often print probabilities with limited precision, so this is
+// loose enough for rounding but catches hand editing mistakes.
+const CPTTolerance = 1e-3
+
+// CPTs returns the CPT for each variable (indexed by variable ID). The child
+// of a CPT is its last variable, and every variable must be the child of
+// exactly one CPT. The exception is a fixed variable, which may have no CPT
+// since it's only conditioned on (as in a pruned model), so its entry is
+// nil. Soft evidence functions are not CPT's.
+func (m *Model) CPTs() ([]*Function, error) {
+	cpts := make([]*Function, len(m.Vars))
+	for _, f := range m.Funcs {
+		if len(f.Vars) < 1 || isSoftEvidence(f) {
+			continue
+		}
+		child := f.Vars[len(f.Vars)-1]
+		if child.ID < 0 || child.ID >= len(m.Vars) {
+			return nil, errors.Errorf("Function %s has var %s with invalid ID %d", f.Name, child.Name, child.ID)
+		}
+		if prev := cpts[child.ID]; prev != nil {
+			return nil, errors.Errorf("Variable %s is the child of both %s and %s", child.Name, prev.Name, f.Name)
+		}
+		cpts[child.ID] = f
+	}
+
+	for i, f := range cpts {
+		if f == nil && m.Vars[i].FixedVal < 0 {
+			return nil, errors.Errorf("Variable %s is not the child of any CPT", m.Vars[i].Name)
+		}
+	}
+
+	return cpts, nil
+}
+
+// TopoOrder returns the variable IDs of a BAYES model in topological order
+// (every parent before its children). An error is returned if the parent
+// graph has a cycle.
+func (m *Model) TopoOrder() ([]int, error) {
+	parents, err := m.Parents()
+	if err != nil {
+		return nil, err
+	}
+
+	// Kahn's algorithm: roots start in ID order and ready variables are
+	// queued, so the order is always the same for a model
+	kids := children(parents)
+	waiting := make([]int, len(parents))
+	ready := make([]int, 0, len(parents))
+	for i, ps := range parents {
+		waiting[i] = len(ps)
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	order := make([]int, 0, len(parents))
+	for len(ready) > 0 {
+		id := ready[0]
+		ready = ready[1:]
+		order = append(order, id)
+		for _, c := range kids[id] {
+			waiting[c]--
+			if waiting[c] == 0 {
+				ready = append(ready, c)
+			}
+		}
+	}
+
+	if len(order) < len(parents) {
+		for i, w := range waiting {
+			if w > 0 {
+				return nil, errors.Errorf("Model %s has a cycle through variable %s", m.Name, m.Vars[i].Name)
+			}
+		}
+	}
+
+	return order, nil
+}
+
+// cptRowSum returns the sum of a CPT row (in linear space)
+func cptRowSum(f *Function, row []float64) float64 {
+	sum := 0.0
+	for _, p := range row {
+		if f.IsLog {
+			p = math.Exp(p)
+		}
+		sum += p
+	}
+	return sum
+}
+
+// CheckBayes returns an error if the model isn't a valid Bayesian network:
+// the child of each CPT is its last variable, every unfixed variable has
+// exactly one CPT, the parent graph is acyclic, and the child's values sum to
+// 1 (within tol) for every parent configuration. Soft evidence functions are
+// ignored. Note that Check calls this for BAYES models.
+func (m *Model) CheckBayes(tol float64) error {
+	if m.Type != BAYES {
+		return errors.Errorf("Model %s is %s, not %s", m.Name, m.Type, BAYES)
+	}
+
+	cpts, err := m.CPTs()
+	if err != nil {
+		return err
+	}
+	if _, err = m.TopoOrder(); err != nil {
+		return err
+	}
+
+	for _, f := range cpts {
+		if f == nil {
+			continue
+		}
+		card := f.Vars[len(f.Vars)-1].Card
+		for r := 0; r < len(f.Table); r += card {
+			sum := cptRowSum(f, f.Table[r:r+card])
+			if math.IsNaN(sum) || math.Abs(sum-1.0) > tol {
+				return errors.Errorf("CPT %s row %d sums to %v", f.Name, r/card, sum)
+			}
+		}
+	}
+
+	return nil
+}
+
+// NormalizeCPTs renormalizes every CPT row in a BAYES model so that the
+// child's values sum to 1 for each parent configuration, and returns the
+// number of rows that were off by more than CPTTolerance. A row that sums to
+// zero can't be repaired and is an error. This is the opt-in repair for
+// models that fail CheckBayes because of their CPT values.
+func (m *Model) NormalizeCPTs() (int, error) {
+	if m.Type != BAYES {
+		return 0, errors.Errorf("Model %s is %s, not %s", m.Name, m.Type, BAYES)
+	}
+
+	cpts, err := m.CPTs()
+	if err != nil {
+		return 0, err
+	}
+
+	repaired := 0
+	for _, f := range cpts {
+		if f == nil {
+			continue
+		}
+		card := f.Vars[len(f.Vars)-1].Card
+		for r := 0; r < len(f.Table); r += card {
+			row := f.Table[r : r+card]
+			sum := cptRowSum(f, row)
+			if !(sum > 0.0) || math.IsInf(sum, 0) {
+				return repaired, errors.Errorf("CPT %s row %d sums to %v and can not be normalized", f.Name, r/card, sum)
+			}
+			if math.Abs(sum-1.0) > CPTTolerance {
+				repaired++
+			}
+
+			for i, p := range row {
+				if f.IsLog {
+					row[i] = p - math.Log(sum)
+				} else {
+					row[i] = p / sum
+				}
+			}
+		}
+	}
+
+	return repaired, nil
+}
+
+// CPTRepairReader wraps a model reader so that the CPT's of BAYES models are
+// renormalized (see NormalizeCPTs) before the model is checked. Repaired is
+// the number of rows that needed repair in the last model read.
+type CPTRepairReader struct {
+	Reader
+	Repaired int
+}
+
+// ReadModel implements Reader
+func (r *CPTRepairReader) ReadModel(data []byte) (*Model, error) {
+	m, err := r.Reader.ReadModel(data)
+	if err != nil {
+		return nil, err
+	}
+	return r.repair(m)
+}
+
+// ReadModelFrom implements StreamReader: the wrapped reader streams if it can
+func (r *CPTRepairReader) ReadModelFrom(in io.Reader) (*Model, error) {
+	sr, ok := r.Reader.(StreamReader)
+	if !ok {
+		data, err := ioutil.ReadAll(in)
+		if err != nil {
+			return nil, err
+		}
+		return r.ReadModel(data)
+	}
+
+	m, err := sr.ReadModelFrom(in)
+	if err != nil {
+		return nil, err
+	}
+	return r.repair(m)
+}
+
+// repair normalizes the CPT's of a BAYES model
+func (r *CPTRepairReader) repair(m *Model) (*Model, error) {
+	r.Repaired = 0
+	if m.Type != BAYES {
+		return m, nil
+	}
+
+	n, err := m.NormalizeCPTs()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not repair CPT's")
+	}
+	r.Repaired = n
+	return m, nil
+}
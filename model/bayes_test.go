@@ -0,0 +1,141 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// cptFor returns the index in m.Funcs of the CPT for the variable
+func cptFor(m *Model, id int) int {
+	for i, f := range m.Funcs {
+		if f.Vars[len(f.Vars)-1].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestCheckBayes(t *testing.T) {
+	assert := assert.New(t)
+
+	m := asiaModel(t)
+	assert.NoError(m.CheckBayes(CPTTolerance))
+
+	order, err := m.TopoOrder()
+	assert.NoError(err)
+	assert.Equal([]int{0, 2, 1, 3, 4, 5, 6, 7}, order)
+
+	// Log space is fine
+	lung := cptFor(m, 3)
+	m.Funcs[lung] = m.Funcs[lung].ToLog()
+	assert.NoError(m.Check())
+
+	// A bad row (smoke=no is 0.1 + 0.99)
+	m = asiaModel(t)
+	f := m.Funcs[lung]
+	f.Table[2], f.Table[3] = 0.1, 0.99
+	assert.Error(m.CheckBayes(CPTTolerance))
+	assert.Error(m.Check())
+	assert.NoError(m.CheckBayes(0.1))
+
+	// The child must be the last variable
+	m = asiaModel(t)
+	swapped, err := m.Funcs[lung].Reorder([]*Variable{m.Vars[3], m.Vars[2]})
+	assert.NoError(err)
+	m.Funcs[lung] = swapped
+	assert.Error(m.CheckBayes(CPTTolerance))
+
+	// A missing CPT is only allowed for evidence
+	m = asiaModel(t)
+	m.Funcs = append(m.Funcs[:0], m.Funcs[1:]...)
+	assert.Equal(-1, cptFor(m, 0))
+	assert.Error(m.Check())
+	m.Vars[0].FixedVal = 1
+	assert.NoError(m.Check())
+
+	// Cycle: asia depends on dysp
+	m = asiaModel(t)
+	cyc, err := NewFunction(0, []*Variable{m.Vars[7], m.Vars[0]})
+	assert.NoError(err)
+	cyc.Name = "P(asia | dysp)"
+	copy(cyc.Table, []float64{0.5, 0.5, 0.5, 0.5})
+	m.Funcs[cptFor(m, 0)] = cyc
+	assert.Error(m.CheckBayes(CPTTolerance))
+	_, err = m.TopoOrder()
+	assert.Error(err)
+
+	// Soft evidence isn't a CPT
+	m = asiaModel(t)
+	assert.NoError(m.AddSoftEvidence(SoftEvidence{3: {0.2, 0.7}}))
+	assert.NoError(m.Check())
+
+	m.Type = MARKOV
+	assert.Error(m.CheckBayes(CPTTolerance))
+}
+
+func TestNormalizeCPTs(t *testing.T) {
+	assert := assert.New(t)
+
+	m := asiaModel(t)
+	lung := cptFor(m, 3)
+	bronc := cptFor(m, 4)
+	m.Funcs[lung].Table[2], m.Funcs[lung].Table[3] = 0.1, 0.3
+	m.Funcs[bronc] = m.Funcs[bronc].ToLog()
+	m.Funcs[bronc].Table[0] += 1.0
+
+	n, err := m.NormalizeCPTs()
+	assert.NoError(err)
+	assert.Equal(2, n)
+	assert.NoError(m.Check())
+	assert.InDeltaSlice([]float64{0.25, 0.75}, m.Funcs[lung].Table[2:4], 1e-9)
+
+	// Nothing to do the second time
+	n, err = m.NormalizeCPTs()
+	assert.NoError(err)
+	assert.Equal(0, n)
+
+	// A zero row can't be fixed
+	m.Funcs[lung].Table[0], m.Funcs[lung].Table[1] = 0.0, 0.0
+	_, err = m.NormalizeCPTs()
+	assert.Error(err)
+}
+
+func TestCPTRepairReader(t *testing.T) {
+	assert := assert.New(t)
+
+	// B's first row sums to 0.8
+	data := `BAYES
+2
+2 2
+2
+1 0
+2 0 1
+
+2
+ 0.3 0.7
+4
+ 0.2 0.6
+ 0.5 0.5
+`
+	_, err := NewModelFromBuffer(UAIReader{}, []byte(data))
+	assert.Error(err)
+
+	r := &CPTRepairReader{Reader: UAIReader{}}
+	m, err := NewModelFromBuffer(r, []byte(data))
+	assert.NoError(err)
+	assert.Equal(1, r.Repaired)
+	assert.InDeltaSlice([]float64{0.25, 0.75, 0.5, 0.5}, m.Funcs[1].Table, 1e-9)
+
+	m, err = r.ReadModelFrom(strings.NewReader(data))
+	assert.NoError(err)
+	assert.Equal(1, r.Repaired)
+	assert.NoError(m.Check())
+
+	// MARKOV models are left alone
+	m, err = r.ReadModel([]byte(strings.Replace(data, "BAYES", "MARKOV", 1)))
+	assert.NoError(err)
+	assert.Equal(0, r.Repaired)
+	assert.Equal([]float64{0.2, 0.6, 0.5, 0.5}, m.Funcs[1].Table)
+}
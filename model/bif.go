@@ -0,0 +1,314 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// BIFReader reads models in the Bayesian Interchange Format (BIF) used by
+// JavaBayes, bnlearn, pgmpy, etc. Variable and state names are kept (see
+// Variable.Labels). Each probability block becomes a function with the parents
+// first (in the order given) and the child last, which matches the UAI
+// convention for BAYES models.
+//
+// CPT rows may be given per parent configuration, e.g. "(yes, no) 0.2, 0.8;",
+// or as a single "table" where the child varies fastest and the parents are
+// enumerated in order (the first parent changing slowest). That is simply the
+// rows in order, so both map directly to our most to least significant table
+// order. A "default" entry supplies any parent configurations not listed.
+type BIFReader struct {
+}
+
+// bifProb is a parsed probability block
+type bifProb struct {
+	child   string
+	parents []string
+	table   []float64            // Full table (if given)
+	deflt   []float64            // Default row (if given)
+	rows    map[string][]float64 // Rows by parent config (names joined by a space)
+}
+
+// ReadModel implements the model.Reader interface
+func (r BIFReader) ReadModel(data []byte) (*Model, error) {
+	p := &bnParser{tokens: bnTokens(string(data), "//", true)}
+	if p.done() {
+		return nil, errors.New("No BIF data found")
+	}
+
+	m := &Model{Type: BAYES}
+	byName := make(map[string]*Variable)
+	probs := []*bifProb{}
+
+	for !p.done() {
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t {
+		case "network":
+			name, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			m.Name = name
+			if err := p.expect("{"); err != nil {
+				return nil, err
+			}
+			if err := p.skipBlock(); err != nil {
+				return nil, err
+			}
+
+		case "variable":
+			v, err := p.variable(len(m.Vars))
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := byName[v.Name]; dup {
+				return nil, errors.Errorf("Variable %s is declared twice", v.Name)
+			}
+			byName[v.Name] = v
+			m.Vars = append(m.Vars, v)
+
+		case "probability":
+			prob, err := p.probability()
+			if err != nil {
+				return nil, err
+			}
+			probs = append(probs, prob)
+
+		default:
+			return nil, errors.Errorf("Unknown BIF block %s (token %d)", t, p.pos-1)
+		}
+	}
+
+	if len(m.Vars) < 1 {
+		return nil, errors.New("No variables found in BIF data")
+	}
+
+	// Now that we have all the variables, we can create our functions
+	seen := make(map[string]bool)
+	for i, prob := range probs {
+		if seen[prob.child] {
+			return nil, errors.Errorf("Variable %s has more than one probability block", prob.child)
+		}
+		seen[prob.child] = true
+
+		f, err := prob.function(i, byName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid probability block for %s", prob.child)
+		}
+		m.Funcs = append(m.Funcs, f)
+	}
+
+	return m, nil
+}
+
+// variable parses a variable block (after the keyword)
+func (p *bnParser) variable(id int) (*Variable, error) {
+	name, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, errors.Wrapf(err, "Variable %s", name)
+	}
+
+	var labels []string
+	for {
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if t == "}" {
+			break
+		}
+		if t != "type" {
+			if err := p.skipStatement(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// type discrete [ N ] { s1, s2, ... };
+		if err := p.expect("discrete"); err != nil {
+			return nil, errors.Wrapf(err, "Variable %s (only discrete vars are supported)", name)
+		}
+		if err := p.expect("["); err != nil {
+			return nil, errors.Wrapf(err, "Variable %s", name)
+		}
+		cardTok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		card, err := strconv.Atoi(cardTok)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Variable %s has invalid cardinality %s", name, cardTok)
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, errors.Wrapf(err, "Variable %s", name)
+		}
+		if err := p.expect("{"); err != nil {
+			return nil, errors.Wrapf(err, "Variable %s", name)
+		}
+		labels, err = p.nameList("}")
+		if err != nil {
+			return nil, errors.Wrapf(err, "Variable %s states", name)
+		}
+		if len(labels) != card {
+			return nil, errors.Errorf("Variable %s has card %d but %d states", name, card, len(labels))
+		}
+		if p.peek() == ";" {
+			p.pos++
+		}
+	}
+
+	if labels == nil {
+		return nil, errors.Errorf("Variable %s has no type", name)
+	}
+
+	v, err := NewVariable(id, len(labels))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not create variable %s", name)
+	}
+	v.Name = name
+	v.Labels = labels
+	return v, nil
+}
+
+// probability parses a probability block (after the keyword)
+func (p *bnParser) probability() (*bifProb, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	child, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(child, "{}()[];,|") {
+		return nil, errors.Errorf("Probability block with no child variable (found %s)", child)
+	}
+
+	prob := &bifProb{child: child, rows: make(map[string][]float64)}
+	if p.peek() == "|" {
+		p.pos++
+	}
+	prob.parents, err = p.nameList(")")
+	if err != nil {
+		return nil, errors.Wrapf(err, "Parents for %s", child)
+	}
+
+	if err := p.expect("{"); err != nil {
+		return nil, errors.Wrapf(err, "Probability for %s", prob.child)
+	}
+	for {
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t {
+		case "}":
+			return prob, nil
+		case "table":
+			prob.table, err = p.floatList()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Probability table for %s", prob.child)
+			}
+		case "default":
+			prob.deflt, err = p.floatList()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Default probabilities for %s", prob.child)
+			}
+		case "(":
+			config, err := p.nameList(")")
+			if err != nil {
+				return nil, errors.Wrapf(err, "Parent config for %s", prob.child)
+			}
+			ky := strings.Join(config, " ")
+			if _, dup := prob.rows[ky]; dup {
+				return nil, errors.Errorf("Parent config (%s) for %s is given twice", ky, prob.child)
+			}
+			prob.rows[ky], err = p.floatList()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Probabilities for %s given (%s)", prob.child, ky)
+			}
+		default:
+			if err := p.skipStatement(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// function creates the function for the probability block
+func (prob *bifProb) function(index int, byName map[string]*Variable) (*Function, error) {
+	f, err := cptFunction(index, prob.child, prob.parents, byName)
+	if err != nil {
+		return nil, err
+	}
+	vars := f.Vars
+	child := vars[len(vars)-1]
+
+	if prob.table != nil {
+		if len(prob.table) != len(f.Table) {
+			return nil, errors.Errorf("Table has %d entries but expected %d", len(prob.table), len(f.Table))
+		}
+		copy(f.Table, prob.table)
+		return f, nil
+	}
+
+	if len(prob.parents) < 1 {
+		return nil, errors.New("No table given")
+	}
+
+	// Fill row by row: our rows are in the same order as a VariableIter over
+	// the parents (first parent most significant)
+	parents := vars[:len(vars)-1]
+	found := 0
+	vi, err := NewVariableIter(parents, false)
+	if err != nil {
+		return nil, err
+	}
+	config := make([]int, len(parents))
+	labels := make([]string, len(parents))
+	for row := 0; ; row++ {
+		if err := vi.Val(config); err != nil {
+			return nil, err
+		}
+		for i, val := range config {
+			labels[i] = parents[i].Labels[val]
+		}
+
+		vals, ok := prob.rows[strings.Join(labels, " ")]
+		if ok {
+			found++
+		} else if prob.deflt != nil {
+			vals = prob.deflt
+		} else {
+			return nil, errors.Errorf("No probabilities given for (%s)", strings.Join(labels, ", "))
+		}
+		if len(vals) != child.Card {
+			return nil, errors.Errorf("Row (%s) has %d entries but expected %d", strings.Join(labels, ", "), len(vals), child.Card)
+		}
+		copy(f.Table[row*child.Card:], vals)
+
+		if !vi.Next() {
+			break
+		}
+	}
+
+	if found != len(prob.rows) {
+		return nil, errors.Errorf("Found %d rows but only %d match parent states", len(prob.rows), found)
+	}
+
+	return f, nil
+}
+
+// ApplyEvidence is part of the reader interface. BIF doesn't define an
+// evidence format, so we use named evidence (see applyNamedEvidence).
+func (r BIFReader) ApplyEvidence(data []byte, m *Model) error {
+	return applyNamedEvidence(data, m)
+}
@@ -0,0 +1,145 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBIFAsia(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := NewModelFromFile(BIFReader{}, "../res/asia.bif", false)
+	assert.NoError(err)
+	assert.NoError(m.Check())
+	assert.Equal(BAYES, m.Type)
+	assert.Equal("../res/asia", m.Name)
+
+	names := []string{"asia", "tub", "smoke", "lung", "bronc", "either", "xray", "dysp"}
+	assert.Equal(len(names), len(m.Vars))
+	for i, v := range m.Vars {
+		assert.Equal(i, v.ID)
+		assert.Equal(names[i], v.Name)
+		assert.Equal(2, v.Card)
+		assert.Equal([]string{"yes", "no"}, v.Labels)
+		assert.Equal(-1, v.FixedVal)
+	}
+
+	assert.Equal(len(names), len(m.Funcs))
+	assert.Equal("P(asia)", m.Funcs[0].Name)
+	assert.Equal([]*Variable{m.Vars[0]}, m.Funcs[0].Vars)
+	assert.Equal([]float64{0.01, 0.99}, m.Funcs[0].Table)
+
+	// Parents first, child last
+	either := m.Funcs[5]
+	assert.Equal("P(either | lung, tub)", either.Name)
+	assert.Equal([]*Variable{m.Vars[3], m.Vars[1], m.Vars[5]}, either.Vars)
+	assert.Equal([]float64{1, 0, 1, 0, 1, 0, 0, 1}, either.Table)
+
+	dysp := m.Funcs[7]
+	assert.Equal([]*Variable{m.Vars[4], m.Vars[5], m.Vars[7]}, dysp.Vars)
+	assert.Equal([]float64{0.9, 0.1, 0.8, 0.2, 0.7, 0.3, 0.1, 0.9}, dysp.Table)
+}
+
+const bifTableExample = `
+// Line comment
+network "test" { property "source me"; }
+/* block
+   comment */
+variable A { type discrete [2] { a0, a1 }; property "x = 1"; }
+variable B { type discrete [3] { b0, b1, b2 }; }
+probability ( A ) { table 0.3 0.7; }
+probability ( B | A ) { table 0.1, 0.2, 0.7, 0.5, 0.25, 0.25; }
+`
+
+const bifRowExample = `
+network test {}
+variable A { type discrete [2] { a0, a1 }; }
+variable B { type discrete [3] { b0, b1, b2 }; }
+probability ( A ) { table 0.3 0.7; }
+probability ( B | A ) {
+  (a1) 0.5, 0.25, 0.25;
+  default 0.1, 0.2, 0.7;
+}
+`
+
+func TestBIFTableRows(t *testing.T) {
+	assert := assert.New(t)
+
+	tm, err := NewModelFromBuffer(BIFReader{}, []byte(bifTableExample))
+	assert.NoError(err)
+	rm, err := NewModelFromBuffer(BIFReader{}, []byte(bifRowExample))
+	assert.NoError(err)
+
+	assert.Equal("test", tm.Name)
+	for _, m := range []*Model{tm, rm} {
+		assert.Equal(2, len(m.Funcs))
+		assert.Equal([]float64{0.3, 0.7}, m.Funcs[0].Table)
+		assert.Equal([]float64{0.1, 0.2, 0.7, 0.5, 0.25, 0.25}, m.Funcs[1].Table)
+		assert.Equal([]string{"b0", "b1", "b2"}, m.Vars[1].Labels)
+	}
+}
+
+func TestBIFEvidence(t *testing.T) {
+	assert := assert.New(t)
+
+	r := BIFReader{}
+	m, err := NewModelFromFile(r, "../res/asia.bif", false)
+	assert.NoError(err)
+
+	assert.NoError(r.ApplyEvidence([]byte("// evid\nasia = yes\nxray no;\n"), m))
+	assert.Equal(0, m.Vars[0].FixedVal)
+	assert.Equal(1, m.Vars[6].FixedVal)
+	assert.Equal(-1, m.Vars[1].FixedVal)
+	assert.NoError(m.Check())
+
+	// Already fixed
+	assert.Error(r.ApplyEvidence([]byte("asia = no"), m))
+
+	m, err = NewModelFromFile(r, "../res/asia.bif", false)
+	assert.NoError(err)
+	assert.Error(r.ApplyEvidence([]byte("nope = yes"), m))
+	assert.Error(r.ApplyEvidence([]byte("asia = maybe"), m))
+	assert.Error(r.ApplyEvidence([]byte("asia = yes asia = no"), m))
+	assert.Error(r.ApplyEvidence([]byte("asia ="), m))
+	for _, v := range m.Vars {
+		assert.Equal(-1, v.FixedVal)
+	}
+
+	e, err := m.NamedEvidence(map[string]string{"smoke": "no", "dysp": "yes"})
+	assert.NoError(err)
+	assert.Equal(Evidence{2: 1, 7: 0}, e)
+}
+
+func TestBIFBad(t *testing.T) {
+	assert := assert.New(t)
+
+	bad := []string{
+		"",
+		"// nothing here",
+		"network x {}",
+		"variable A { type discrete [2] { a0, a1 }; } bogus",
+		"variable A { type discrete [3] { a0, a1 }; }",
+		"variable A { type discrete [x] { a0, a1 }; }",
+		"variable A { type continuous; }",
+		"variable A { property p; }",
+		"variable A { type discrete [2] { a0, a1 }; } variable A { type discrete [2] { a0, a1 }; }",
+		"variable A { type discrete [2] { a0, a1 }; } probability ( B ) { table 0.5 0.5; }",
+		"variable A { type discrete [2] { a0, a1 }; } probability ( A ) { table 0.5 0.5 0.0; }",
+		"variable A { type discrete [2] { a0, a1 }; } probability ( A ) { table 0.5 x; }",
+		"variable A { type discrete [2] { a0, a1 }; } probability ( A ) { }",
+		"variable A { type discrete [2] { a0, a1 }; } probability ( A ) { table 0.5 0.5; } probability ( A ) { table 0.5 0.5; }",
+		"variable A { type discrete [2] { a0, a1 }; } variable B { type discrete [2] { b0, b1 }; } " +
+			"probability ( A ) { table 0.5 0.5; } probability ( B | A ) { (a0) 0.5 0.5; }",
+		"variable A { type discrete [2] { a0, a1 }; } variable B { type discrete [2] { b0, b1 }; } " +
+			"probability ( A ) { table 0.5 0.5; } probability ( B | A ) { (a0) 0.5 0.5; (a2) 0.5 0.5; default 0.5 0.5; }",
+		"variable A { type discrete [2] { a0, a1 }; } variable B { type discrete [2] { b0, b1 }; } " +
+			"probability ( A ) { table 0.5 0.5; } probability ( B | A ) { (a0) 0.5 0.5; (a1) 0.5; }",
+		"variable A { type discrete [2] { a0, a1 }; } probability ( A ) { table 0.5 0.5;",
+	}
+
+	for _, b := range bad {
+		_, err := NewModelFromBuffer(BIFReader{}, []byte(b))
+		assert.Error(err, b)
+	}
+}
@@ -0,0 +1,268 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// bnTokens splits Bayesian network text (BIF or Hugin) into tokens:
+// punctuation is a token by itself, quoted strings are kept whole (without the
+// quotes), and comments are removed. Comments run from lineComment to the end
+// of the line, and C-style block comments are removed if blockComments is set.
+func bnTokens(text string, lineComment string, blockComments bool) []string {
+	tokens := make([]string, 0, len(text)/4)
+	runes := []rune(text)
+	n := len(runes)
+	lc := []rune(lineComment)
+
+	startsWith := func(i int, prefix []rune) bool {
+		if len(prefix) < 1 || i+len(prefix) > n {
+			return false
+		}
+		for k, c := range prefix {
+			if runes[i+k] != c {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i := 0; i < n; {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case startsWith(i, lc):
+			for i < n && runes[i] != '\n' {
+				i++
+			}
+		case blockComments && c == '/' && i+1 < n && runes[i+1] == '*':
+			i += 2
+			for i+1 < n && !(runes[i] == '*' && runes[i+1] == '/') {
+				i++
+			}
+			i += 2
+		case c == '"':
+			j := i + 1
+			for j < n && runes[j] != '"' {
+				j++
+			}
+			tokens = append(tokens, string(runes[i+1:minInt(j, n)]))
+			i = j + 1
+		case strings.ContainsRune("{}()[];,|=", c):
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			j := i
+			for j < n && !unicode.IsSpace(runes[j]) && !strings.ContainsRune("{}()[];,|=\"", runes[j]) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		}
+	}
+
+	return tokens
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// bnParser is a simple recursive descent parser over bnTokens
+type bnParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *bnParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *bnParser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *bnParser) next() (string, error) {
+	if p.done() {
+		return "", errors.New("Unexpected end of data")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *bnParser) expect(want string) error {
+	t, err := p.next()
+	if err != nil {
+		return errors.Wrapf(err, "Expected %s", want)
+	}
+	if t != want {
+		return errors.Errorf("Expected %s but found %s (token %d)", want, t, p.pos-1)
+	}
+	return nil
+}
+
+// skipStatement skips to the end of the current statement (a semicolon) or
+// block, whichever comes first
+func (p *bnParser) skipStatement() error {
+	for {
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+		if t == ";" {
+			return nil
+		}
+		if t == "{" {
+			return p.skipBlock()
+		}
+	}
+}
+
+// skipBlock skips to the end of a block (we've already read the open brace)
+func (p *bnParser) skipBlock() error {
+	depth := 1
+	for depth > 0 {
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+		if t == "{" {
+			depth++
+		} else if t == "}" {
+			depth--
+		}
+	}
+	return nil
+}
+
+// nameList reads names (separated by optional commas) until the stop token,
+// which is consumed
+func (p *bnParser) nameList(stop string) ([]string, error) {
+	names := []string{}
+	for {
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if t == stop {
+			return names, nil
+		}
+		if t == "," {
+			continue
+		}
+		if strings.ContainsAny(t, "{}()[];|") {
+			return nil, errors.Errorf("Unexpected %s in name list", t)
+		}
+		names = append(names, t)
+	}
+}
+
+// floatList reads floats (separated by optional commas) through the end of
+// the statement
+func (p *bnParser) floatList() ([]float64, error) {
+	vals := []float64{}
+	for {
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if t == ";" {
+			return vals, nil
+		}
+		if t == "," {
+			continue
+		}
+		f, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid probability %s", t)
+		}
+		vals = append(vals, f)
+	}
+}
+
+// cptFunction creates an (empty) function for the CPT of child given parents.
+// The parents are first in the given order and the child is last, which
+// matches the UAI convention for BAYES models.
+func cptFunction(index int, child string, parents []string, byName map[string]*Variable) (*Function, error) {
+	cv, ok := byName[child]
+	if !ok {
+		return nil, errors.Errorf("Unknown variable %s", child)
+	}
+
+	vars := make([]*Variable, 0, len(parents)+1)
+	for _, name := range parents {
+		v, ok := byName[name]
+		if !ok {
+			return nil, errors.Errorf("Unknown parent %s", name)
+		}
+		vars = append(vars, v)
+	}
+	vars = append(vars, cv)
+
+	f, err := NewFunction(index, vars)
+	if err != nil {
+		return nil, err
+	}
+	if len(parents) > 0 {
+		f.Name = "P(" + child + " | " + strings.Join(parents, ", ") + ")"
+	} else {
+		f.Name = "P(" + child + ")"
+	}
+
+	return f, nil
+}
+
+// applyNamedEvidence handles evidence for the Bayesian network formats (BIF,
+// XMLBIF, and Hugin) which don't define an evidence format. We accept
+// "name = state" (or just "name state") pairs using the variable and state
+// names from the model. Pairs may be separated by semicolons or commas, and
+// comments are allowed as in BIF.
+func applyNamedEvidence(data []byte, m *Model) error {
+	tokens := bnTokens(string(data), "//", true)
+	named := make(map[string]string)
+	for i := 0; i < len(tokens); {
+		name := tokens[i]
+		i++
+		for i < len(tokens) && (tokens[i] == "=" || tokens[i] == ",") {
+			i++
+		}
+		if i >= len(tokens) {
+			return errors.Errorf("No state given for evidence variable %s", name)
+		}
+		if _, dup := named[name]; dup {
+			return errors.Errorf("Evidence variable %s is given twice", name)
+		}
+		named[name] = tokens[i]
+		i++
+		for i < len(tokens) && (tokens[i] == ";" || tokens[i] == ",") {
+			i++
+		}
+	}
+
+	e, err := m.NamedEvidence(named)
+	if err != nil {
+		return err
+	}
+	for _, idx := range e.VarIDs() {
+		v := m.Vars[idx]
+		if v.FixedVal != -1 {
+			return errors.Errorf("variable[%d]:%v had previous fixedval %d", idx, v.Name, v.FixedVal)
+		}
+	}
+	for idx, val := range e {
+		m.Vars[idx].FixedVal = val
+	}
+
+	return nil
+}
@@ -0,0 +1,263 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DIMACSReader reads SAT instances in DIMACS CNF and (weighted) MaxSAT WCNF
+// formats. Each DIMACS variable becomes a binary variable (0 is false and 1 is
+// true) named with its DIMACS number, and each clause becomes a function over
+// the clause's variables.
+//
+// Hard clauses are 0/1 tables: 0 for the single assignment violating the
+// clause and 1 everywhere else. A soft clause with weight w is a table in log
+// space with -w for the violating assignment and 0 everywhere else (so
+// violating the clause multiplies the probability by exp(-w)). Using log space
+// means large weights aren't lost.
+//
+// CNF files need a "p cnf VARS CLAUSES" line. WCNF files with a "p wcnf VARS
+// CLAUSES [TOP]" line have a weight at the start of each clause, and clauses
+// with a weight of at least TOP are hard. Files without a p line are read in
+// the newer WCNF format where hard clauses start with "h" and soft clauses
+// start with their weight. Comment lines start with "c", and anything after a
+// line starting with "%" is ignored (as in SATLIB).
+//
+// Evidence is a list of literals (optionally 0 terminated) - e.g. "3 -5" sets
+// variable 3 to true and variable 5 to false.
+type DIMACSReader struct {
+}
+
+// dimacsPreprocess returns the fields of the header (p) line, if there is
+// one, and the tokens of the rest of the file with comments removed
+func dimacsPreprocess(data []byte) ([]string, []string) {
+	var header []string
+	lines := strings.Split(string(data), "\n")
+	keep := make([]string, 0, len(lines))
+	for _, ln := range lines {
+		ln = strings.TrimSpace(ln)
+		if strings.HasPrefix(ln, "%") {
+			break
+		}
+		if len(ln) < 1 || ln[0] == 'c' {
+			continue
+		}
+		if ln[0] == 'p' && header == nil && len(keep) < 1 {
+			header = strings.Fields(ln)
+			continue
+		}
+		keep = append(keep, ln)
+	}
+	return header, strings.Fields(strings.Join(keep, "\n"))
+}
+
+// dimacsClause is a clause as read from the file
+type dimacsClause struct {
+	hard   bool
+	weight float64
+	lits   []int
+}
+
+// ReadModel implements the model.Reader interface
+func (r DIMACSReader) ReadModel(data []byte) (*Model, error) {
+	header, fields := dimacsPreprocess(data)
+	fr := &FieldReader{Fields: fields}
+	if header == nil && len(fields) < 1 {
+		return nil, errors.New("No DIMACS data found")
+	}
+
+	// Header (if there is one)
+	varCount, clauseCount := -1, -1
+	weighted := true
+	top := -1.0
+	if header != nil {
+		if len(header) < 4 || len(header) > 5 {
+			return nil, errors.Errorf("Invalid DIMACS header %s", strings.Join(header, " "))
+		}
+		switch header[1] {
+		case "cnf":
+			weighted = false
+		case "wcnf":
+		default:
+			return nil, errors.Errorf("Unknown DIMACS format %s", header[1])
+		}
+
+		var err error
+		if varCount, err = strconv.Atoi(header[2]); err != nil {
+			return nil, errors.Wrap(err, "Could not read DIMACS var count")
+		}
+		if clauseCount, err = strconv.Atoi(header[3]); err != nil {
+			return nil, errors.Wrap(err, "Could not read DIMACS clause count")
+		}
+		if varCount < 1 || clauseCount < 0 {
+			return nil, errors.Errorf("Invalid DIMACS header with %d vars and %d clauses", varCount, clauseCount)
+		}
+
+		// Optional top weight (hard clause weight) for WCNF
+		if len(header) == 5 {
+			if !weighted {
+				return nil, errors.New("Top weight is only valid for WCNF")
+			}
+			if top, err = strconv.ParseFloat(header[4], 64); err != nil {
+				return nil, errors.Wrapf(err, "Invalid WCNF top weight %s", header[4])
+			}
+		}
+	}
+
+	// Clauses
+	clauses := []*dimacsClause{}
+	maxVar := 0
+	for fr.Pos < len(fr.Fields) {
+		c := &dimacsClause{}
+		idx := len(clauses) + 1
+
+		if weighted {
+			tok, _ := fr.Read()
+			if tok == "h" && header == nil {
+				c.hard = true
+			} else {
+				w, err := strconv.ParseFloat(tok, 64)
+				if err != nil || w < 0 {
+					return nil, errors.Errorf("Invalid weight %s for clause %d", tok, idx)
+				}
+				c.weight = w
+				c.hard = top > 0 && w >= top
+			}
+		} else {
+			c.hard = true
+		}
+
+		for {
+			lit, err := fr.ReadInt()
+			if err != nil {
+				return nil, errors.Errorf("Clause %d is invalid or not 0 terminated", idx)
+			}
+			if lit == 0 {
+				break
+			}
+			c.lits = append(c.lits, lit)
+			if lit < 0 {
+				lit = -lit
+			}
+			if lit > maxVar {
+				maxVar = lit
+			}
+		}
+		if len(c.lits) < 1 {
+			return nil, errors.Errorf("Clause %d is empty", idx)
+		}
+
+		clauses = append(clauses, c)
+	}
+
+	if varCount < 0 {
+		varCount = maxVar
+	} else if maxVar > varCount {
+		return nil, errors.Errorf("Found variable %d but header has %d vars", maxVar, varCount)
+	}
+	if clauseCount >= 0 && clauseCount != len(clauses) {
+		return nil, errors.Errorf("Found %d clauses but header has %d", len(clauses), clauseCount)
+	}
+	if len(clauses) < 1 {
+		return nil, errors.New("No clauses found")
+	}
+
+	m := &Model{Type: MARKOV}
+	for i := 0; i < varCount; i++ {
+		v, err := NewVariable(i, 2)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not create var %d", i+1)
+		}
+		v.Name = strconv.Itoa(i + 1)
+		m.Vars = append(m.Vars, v)
+	}
+
+	for i, c := range clauses {
+		f, err := c.function(i, m)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not create function for clause %d", i+1)
+		}
+		m.Funcs = append(m.Funcs, f)
+	}
+
+	return m, nil
+}
+
+// function creates the clause's function. Repeated literals are ignored, and
+// a clause with a literal and its negation is always satisfied.
+func (c *dimacsClause) function(index int, m *Model) (*Function, error) {
+	vars := make([]*Variable, 0, len(c.lits))
+	violate := make([]int, 0, len(c.lits))
+	seen := make(map[int]int)
+	tautology := false
+	for _, lit := range c.lits {
+		id, val := lit-1, 0 // val is the value that violates the literal
+		if lit < 0 {
+			id, val = -lit-1, 1
+		}
+		if prev, ok := seen[id]; ok {
+			if prev != val {
+				tautology = true
+			}
+			continue
+		}
+		seen[id] = val
+		vars = append(vars, m.Vars[id])
+		violate = append(violate, val)
+	}
+
+	f, err := NewFunction(index, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	sat, unsat := 1.0, 0.0
+	if !c.hard {
+		f.IsLog = true
+		sat, unsat = 0.0, -c.weight
+	}
+	for i := range f.Table {
+		f.Table[i] = sat
+	}
+	if !tautology {
+		idx := 0
+		for _, val := range violate {
+			idx = idx*2 + val
+		}
+		f.Table[idx] = unsat
+	}
+
+	return f, nil
+}
+
+// ApplyEvidence is part of the reader interface: evidence is a list of
+// literals
+func (r DIMACSReader) ApplyEvidence(data []byte, m *Model) error {
+	_, fields := dimacsPreprocess(data)
+	for _, tok := range fields {
+		lit, err := strconv.Atoi(tok)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid evidence literal %s", tok)
+		}
+		if lit == 0 {
+			continue
+		}
+
+		id, val := lit-1, 1
+		if lit < 0 {
+			id, val = -lit-1, 0
+		}
+		if id >= len(m.Vars) {
+			return errors.Errorf("Invalid evidence variable %d", id+1)
+		}
+		v := m.Vars[id]
+		if v.FixedVal != -1 {
+			return errors.Errorf("variable[%d]:%v had previous fixedval %d", id, v.Name, v.FixedVal)
+		}
+		v.FixedVal = val
+	}
+
+	return nil
+}
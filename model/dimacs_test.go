@@ -0,0 +1,156 @@
+package model
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// satCount returns the sum of exp(LogProb) over all states
+func satCount(assert *assert.Assertions, m *Model) float64 {
+	vi, err := NewVariableIter(m.Vars, true)
+	assert.NoError(err)
+	state := make([]int, len(m.Vars))
+	total := 0.0
+	for {
+		assert.NoError(vi.Val(state))
+		lp, err := m.LogProb(state)
+		assert.NoError(err)
+		total += math.Exp(lp)
+		if !vi.Next() {
+			break
+		}
+	}
+	return total
+}
+
+func TestDIMACSCNF(t *testing.T) {
+	assert := assert.New(t)
+
+	r := DIMACSReader{}
+	m, err := NewModelFromFile(r, "../res/sat.cnf", false)
+	assert.NoError(err)
+	assert.NoError(m.Check())
+	assert.Equal(MARKOV, m.Type)
+
+	assert.Equal(4, len(m.Vars))
+	for i, v := range m.Vars {
+		assert.Equal(2, v.Card)
+		assert.Equal(string(rune('1'+i)), v.Name)
+	}
+
+	// Hard clauses are 0 for the one violating assignment
+	assert.Equal(4, len(m.Funcs))
+	f := m.Funcs[1]
+	assert.Equal([]*Variable{m.Vars[0], m.Vars[2]}, f.Vars)
+	assert.False(f.IsLog)
+	assert.Equal([]float64{1, 1, 0, 1}, f.Table)
+	assert.Equal([]float64{1, 1, 1, 0}, m.Funcs[2].Table)
+
+	// The model's partition function is the solution count
+	assert.InDelta(3.0, satCount(assert, m), 1e-12)
+	for _, sol := range [][]int{{0, 1, 0, 1}, {1, 0, 1, 0}, {1, 0, 1, 1}} {
+		lp, err := m.LogProb(sol)
+		assert.NoError(err)
+		assert.Equal(0.0, lp)
+	}
+
+	// Evidence is a list of literals
+	assert.NoError(r.ApplyEvidence([]byte("-1 4 0\n"), m))
+	assert.Equal([]int{0, -1, -1, 1}, []int{m.Vars[0].FixedVal, m.Vars[1].FixedVal, m.Vars[2].FixedVal, m.Vars[3].FixedVal})
+	assert.InDelta(1.0, satCount(assert, m), 1e-12)
+	assert.NoError(m.Check())
+
+	assert.Error(r.ApplyEvidence([]byte("1"), m))
+	assert.Error(r.ApplyEvidence([]byte("5"), m))
+	assert.Error(r.ApplyEvidence([]byte("x"), m))
+}
+
+func TestDIMACSClauses(t *testing.T) {
+	assert := assert.New(t)
+
+	// Repeated literals and tautologies
+	m, err := NewModelFromBuffer(DIMACSReader{}, []byte("p cnf 2 2\n1 -2 1 0\n2 -2 1 0\n"))
+	assert.NoError(err)
+	assert.Equal([]*Variable{m.Vars[0], m.Vars[1]}, m.Funcs[0].Vars)
+	assert.Equal([]float64{1, 0, 1, 1}, m.Funcs[0].Table)
+	assert.Equal([]*Variable{m.Vars[1], m.Vars[0]}, m.Funcs[1].Vars)
+	assert.Equal([]float64{1, 1, 1, 1}, m.Funcs[1].Table)
+
+	// Clauses can span lines and vars don't need to be used
+	m, err = NewModelFromBuffer(DIMACSReader{}, []byte("c comment\np cnf 3 1\n1\n-2 0"))
+	assert.NoError(err)
+	assert.Equal(3, len(m.Vars))
+	assert.Equal([]float64{1, 0, 1, 1}, m.Funcs[0].Table)
+	assert.InDelta(6.0, satCount(assert, m), 1e-12)
+}
+
+func TestDIMACSWCNF(t *testing.T) {
+	assert := assert.New(t)
+
+	check := func(data string) {
+		m, err := NewModelFromBuffer(DIMACSReader{}, []byte(data))
+		assert.NoError(err)
+		assert.NoError(m.Check())
+
+		assert.Equal(2, len(m.Vars))
+		assert.Equal(3, len(m.Funcs))
+
+		// Hard
+		assert.False(m.Funcs[0].IsLog)
+		assert.Equal([]float64{0, 1, 1, 1}, m.Funcs[0].Table)
+
+		// Soft clauses are log space with large weights kept
+		assert.True(m.Funcs[1].IsLog)
+		assert.Equal([]float64{-2.5, 0}, m.Funcs[1].Table)
+		assert.True(m.Funcs[2].IsLog)
+		assert.Equal([]float64{0, -1000}, m.Funcs[2].Table)
+
+		lp, err := m.LogProb([]int{1, 1})
+		assert.NoError(err)
+		assert.Equal(-1000.0, lp)
+		lp, err = m.LogProb([]int{0, 0})
+		assert.True(math.IsInf(lp, -1))
+		assert.NoError(err)
+	}
+
+	check("c old format\np wcnf 2 3 2000\n2000 1 2 0\n2.5 1 0\n1000 -2 0\n")
+	check("c new format\nh 1 2 0\n2.5 1 0\n1000 -2 0\n")
+
+	// Without top everything is soft
+	m, err := NewModelFromBuffer(DIMACSReader{}, []byte("p wcnf 1 1\n5 1 0\n"))
+	assert.NoError(err)
+	assert.True(m.Funcs[0].IsLog)
+	assert.Equal([]float64{-5, 0}, m.Funcs[0].Table)
+}
+
+func TestDIMACSBad(t *testing.T) {
+	assert := assert.New(t)
+
+	bad := []string{
+		"",
+		"c nothing",
+		"p cnf 2 0\n",
+		"p cnf 2\n1 0\n",
+		"p dnf 2 1\n1 0\n",
+		"p cnf x 1\n1 0\n",
+		"p cnf 2 x\n1 0\n",
+		"p cnf 0 1\n1 0\n",
+		"p cnf 2 1 5\n1 0\n",
+		"p cnf 2 1\n1 3 0\n",
+		"p cnf 2 2\n1 0\n",
+		"p cnf 2 1\n1 2\n",
+		"p cnf 2 1\n0\n",
+		"p cnf 2 1\n1 x 0\n",
+		"p wcnf 2 1 x\n1 1 0\n",
+		"p wcnf 2 1\nh 1 0\n",
+		"p wcnf 2 1\n-1 1 0\n",
+		"h 0\n",
+	}
+
+	for _, b := range bad {
+		_, err := NewModelFromBuffer(DIMACSReader{}, []byte(b))
+		assert.Error(err, b)
+	}
+}
@@ -0,0 +1,373 @@
+package model
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Parents returns the parents of each variable (indexed by variable ID) in a
+// BAYES model. The child of a CPT is its last variable and the parents are
+// the rest. Soft evidence functions are not CPT's, so they are skipped.
+func (m *Model) Parents() ([][]int, error) {
+	if m.Type != BAYES {
+		return nil, errors.Errorf("Model %s is %s, not %s", m.Name, m.Type, BAYES)
+	}
+
+	parents := make([][]int, len(m.Vars))
+	seen := make([]map[int]bool, len(m.Vars))
+	for _, f := range m.Funcs {
+		if len(f.Vars) < 1 || isSoftEvidence(f) {
+			continue
+		}
+		for _, v := range f.Vars {
+			if v.ID < 0 || v.ID >= len(m.Vars) {
+				return nil, errors.Errorf("Function %s has var %s with invalid ID %d", f.Name, v.Name, v.ID)
+			}
+		}
+
+		child := f.Vars[len(f.Vars)-1].ID
+		if seen[child] == nil {
+			seen[child] = make(map[int]bool)
+		}
+		for _, v := range f.Vars[:len(f.Vars)-1] {
+			if !seen[child][v.ID] {
+				seen[child][v.ID] = true
+				parents[child] = append(parents[child], v.ID)
+			}
+		}
+	}
+
+	return parents, nil
+}
+
+// children inverts the parent lists from Parents
+func children(parents [][]int) [][]int {
+	kids := make([][]int, len(parents))
+	for child, ps := range parents {
+		for _, p := range ps {
+			kids[p] = append(kids[p], child)
+		}
+	}
+	return kids
+}
+
+// bayesBall is Shachter's Bayes-Ball algorithm: a ball starts at each query
+// variable (as if from a child) and bounces through the network, where
+// observed variables pass the ball back to their parents and unobserved
+// variables pass it on. Variables with virtual evidence (soft or domain
+// evidence) have an observed child that bounces the ball back to them. Top
+// is marked for variables whose CPT is needed for the query, and bottom is
+// marked for unobserved variables that are d-connected to the query.
+// Visited observed variables are the evidence needed for the query.
+func bayesBall(parents [][]int, query []int, observed []bool, virtual []bool) (top, bottom, visited []bool) {
+	kids := children(parents)
+	top = make([]bool, len(parents))
+	bottom = make([]bool, len(parents))
+	visited = make([]bool, len(parents))
+
+	type visit struct {
+		id        int
+		fromChild bool
+	}
+	schedule := make([]visit, 0, len(query))
+	for _, q := range query {
+		schedule = append(schedule, visit{q, true})
+	}
+
+	toParents := func(j int) {
+		for _, p := range parents[j] {
+			schedule = append(schedule, visit{p, true})
+		}
+	}
+	toChildren := func(j int) {
+		for _, c := range kids[j] {
+			schedule = append(schedule, visit{c, false})
+		}
+	}
+
+	for len(schedule) > 0 {
+		vis := schedule[len(schedule)-1]
+		schedule = schedule[:len(schedule)-1]
+		j := vis.id
+		visited[j] = true
+
+		if observed[j] {
+			// Only a ball from a parent bounces back up
+			if !vis.fromChild && !top[j] {
+				top[j] = true
+				toParents(j)
+			}
+			continue
+		}
+
+		if vis.fromChild {
+			if !top[j] {
+				top[j] = true
+				toParents(j)
+			}
+			if !bottom[j] {
+				bottom[j] = true
+				toChildren(j)
+			}
+		} else {
+			if !bottom[j] {
+				bottom[j] = true
+				toChildren(j)
+			}
+			if virtual != nil && virtual[j] && !top[j] {
+				top[j] = true
+				toParents(j)
+			}
+		}
+	}
+
+	return top, bottom, visited
+}
+
+// checkVarSet returns a membership slice for the variable IDs
+func (m *Model) checkVarSet(ids []int, what string) ([]bool, error) {
+	member := make([]bool, len(m.Vars))
+	for _, id := range ids {
+		if id < 0 || id >= len(m.Vars) {
+			return nil, errors.Errorf("Invalid %s variable ID %d", what, id)
+		}
+		member[id] = true
+	}
+	return member, nil
+}
+
+// DConnected returns a flag for each variable (indexed by ID) that is true
+// if the variable is d-connected to a variable in x given the variables in z.
+// The variables in x are d-connected to themselves, and the variables in z
+// are never d-connected. The model must be BAYES, and x and z must not
+// overlap.
+func (m *Model) DConnected(x []int, z []int) ([]bool, error) {
+	parents, err := m.Parents()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = m.checkVarSet(x, "query"); err != nil {
+		return nil, err
+	}
+	observed, err := m.checkVarSet(z, "evidence")
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range x {
+		if observed[id] {
+			return nil, errors.Errorf("Variable %s is in both x and z", m.Vars[id].Name)
+		}
+	}
+
+	_, bottom, _ := bayesBall(parents, x, observed, nil)
+	return bottom, nil
+}
+
+// DSeparated returns true if every variable in x is d-separated from every
+// variable in y given the variables in z. See DConnected: y must not overlap
+// x or z.
+func (m *Model) DSeparated(x []int, y []int, z []int) (bool, error) {
+	conn, err := m.DConnected(x, z)
+	if err != nil {
+		return false, err
+	}
+	inY, err := m.checkVarSet(y, "target")
+	if err != nil {
+		return false, err
+	}
+
+	for id := range m.Vars {
+		if !inY[id] {
+			continue
+		}
+		if conn[id] {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// LookupVars returns the variable IDs for a list of variable names. Anything
+// that isn't a name is tried as a variable ID.
+func (m *Model) LookupVars(names []string) ([]int, error) {
+	byName := make(map[string]int, len(m.Vars))
+	for i, v := range m.Vars {
+		byName[v.Name] = i
+	}
+
+	ids := make([]int, len(names))
+	for i, name := range names {
+		id, ok := byName[name]
+		if !ok {
+			var err error
+			id, err = strconv.Atoi(name)
+			if err != nil || id < 0 || id >= len(m.Vars) {
+				return nil, errors.Errorf("Unknown variable %s in model %s", name, m.Name)
+			}
+		}
+		ids[i] = id
+	}
+
+	return ids, nil
+}
+
+// Pruning is a BAYES model pruned for a query given the current evidence.
+// Barren variables (that aren't ancestors of the query or evidence) are
+// removed, as are variables and evidence that are d-separated from the
+// query. Only the CPT's needed for the query are kept, so the query
+// marginals of the pruned model are the same as the original's (but other
+// marginals may not be).
+//
+// Pruned variables have new IDs (0 to N-1 in the original order) and keep
+// their evidence. VarMap maps back to the original variables.
+type Pruning struct {
+	Model    *Model // The pruned model
+	Original *Model // The model that was pruned
+	Query    []int  // Original ID of each query variable
+	VarMap   []int  // Original variable ID for each variable in Model
+}
+
+// NewPruning prunes the model for the query variables (given as original
+// variable IDs) using Bayes-Ball. Soft and domain evidence count as evidence
+// for the variable they are on. The query variables can not be fixed. The
+// original model is not changed.
+func NewPruning(m *Model, query []int) (*Pruning, error) {
+	parents, err := m.Parents()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Only %s models can be pruned", BAYES)
+	}
+	if len(query) < 1 {
+		return nil, errors.New("No query variables to prune for")
+	}
+	isQuery, err := m.checkVarSet(query, "query")
+	if err != nil {
+		return nil, err
+	}
+
+	observed := make([]bool, len(m.Vars))
+	virtual := make([]bool, len(m.Vars))
+	for i, v := range m.Vars {
+		if v.ID != i {
+			return nil, errors.Errorf("Invalid ID for var %s: expected %d but was %d", v.Name, i, v.ID)
+		}
+		observed[i] = v.FixedVal >= 0
+		virtual[i] = v.Allowed != nil
+		if isQuery[i] && observed[i] {
+			return nil, errors.Errorf("Query variable %s has evidence", v.Name)
+		}
+	}
+	for _, f := range m.Funcs {
+		if isSoftEvidence(f) {
+			virtual[f.Vars[0].ID] = true
+		}
+	}
+
+	top, bottom, _ := bayesBall(parents, query, observed, virtual)
+
+	// Keep the CPT's for top marked variables (and soft evidence for
+	// variables that the ball reached), along with every variable they use
+	keep := make([]bool, len(m.Vars))
+	copy(keep, isQuery)
+	funcs := []*Function{}
+	for _, f := range m.Funcs {
+		id := f.Vars[len(f.Vars)-1].ID
+		if isSoftEvidence(f) {
+			if !bottom[id] {
+				continue
+			}
+		} else if !top[id] {
+			continue
+		}
+
+		funcs = append(funcs, f)
+		for _, v := range f.Vars {
+			keep[v.ID] = true
+		}
+	}
+
+	p := &Pruning{
+		Model:    &Model{Type: BAYES, Name: m.Name},
+		Original: m,
+		Query:    append([]int{}, query...),
+	}
+
+	pruned := make([]*Variable, len(m.Vars))
+	for i, v := range m.Vars {
+		if !keep[i] {
+			continue
+		}
+		cp := v.Clone()
+		cp.ID = len(p.Model.Vars)
+		pruned[i] = cp
+		p.Model.Vars = append(p.Model.Vars, cp)
+		p.VarMap = append(p.VarMap, i)
+	}
+
+	for _, f := range funcs {
+		pf := &Function{
+			Name:  f.Name,
+			Vars:  make([]*Variable, len(f.Vars)),
+			Table: make([]float64, len(f.Table)),
+			IsLog: f.IsLog,
+
+			SoftEvidence: f.SoftEvidence,
+		}
+		for i, v := range f.Vars {
+			pf.Vars[i] = pruned[v.ID]
+		}
+		copy(pf.Table, f.Table)
+		p.Model.Funcs = append(p.Model.Funcs, pf)
+	}
+
+	err = p.Model.Check()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Pruned model is not valid")
+	}
+
+	return p, nil
+}
+
+// ReduceVars returns clones of the variables in the pruned model, where vars
+// are indexed by original variable ID (e.g. the variables of a solution).
+func (p *Pruning) ReduceVars(vars []*Variable) ([]*Variable, error) {
+	return selectVars(vars, len(p.Original.Vars), p.VarMap)
+}
+
+// QueryVars returns clones of the query variables (in query order, with
+// their original IDs) from estimates for the variables in the pruned model.
+func (p *Pruning) QueryVars(vars []*Variable) ([]*Variable, error) {
+	if len(vars) != len(p.VarMap) {
+		return nil, errors.Errorf("Expected %d pruned variables but found %d", len(p.VarMap), len(vars))
+	}
+
+	index := make(map[int]int, len(p.VarMap))
+	for i, orig := range p.VarMap {
+		index[orig] = i
+	}
+
+	qv := make([]*Variable, len(p.Query))
+	for i, orig := range p.Query {
+		qv[i] = vars[index[orig]].Clone()
+		qv[i].ID = orig
+	}
+
+	return qv, nil
+}
+
+// selectVars returns clones of the selected variables (with new IDs in
+// order), where vars must have count variables
+func selectVars(vars []*Variable, count int, selected []int) ([]*Variable, error) {
+	if len(vars) != count {
+		return nil, errors.Errorf("Expected %d variables but found %d", count, len(vars))
+	}
+
+	sel := make([]*Variable, len(selected))
+	for i, orig := range selected {
+		sel[i] = vars[orig].Clone()
+		sel[i].ID = i
+	}
+
+	return sel, nil
+}
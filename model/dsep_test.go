@@ -0,0 +1,171 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Asia IDs: asia=0 tub=1 smoke=2 lung=3 bronc=4 either=5 xray=6 dysp=7
+func asiaModel(t *testing.T) *Model {
+	m, err := NewModelFromFile(BIFReader{}, "../res/asia.bif", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestParents(t *testing.T) {
+	assert := assert.New(t)
+
+	m := asiaModel(t)
+	parents, err := m.Parents()
+	assert.NoError(err)
+	assert.Equal([][]int{nil, {0}, nil, {2}, {2}, {3, 1}, {5}, {4, 5}}, parents)
+
+	// Soft evidence isn't a CPT
+	assert.NoError(m.AddSoftEvidence(SoftEvidence{2: {0.5, 1.0}}))
+	again, err := m.Parents()
+	assert.NoError(err)
+	assert.Equal(parents, again)
+
+	m.Type = MARKOV
+	_, err = m.Parents()
+	assert.Error(err)
+}
+
+func TestDSeparated(t *testing.T) {
+	assert := assert.New(t)
+
+	m := asiaModel(t)
+
+	cases := []struct {
+		x, y, z []int
+		sep     bool
+	}{
+		{[]int{0}, []int{2}, nil, true},       // either and dysp are colliders
+		{[]int{0}, []int{2}, []int{5}, false}, // observed collider
+		{[]int{0}, []int{2}, []int{6}, false}, // observed collider descendant
+		{[]int{1}, []int{6}, nil, false},      // chain
+		{[]int{1}, []int{6}, []int{5}, true},  // blocked chain
+		{[]int{3}, []int{4}, nil, false},      // common cause
+		{[]int{3}, []int{4}, []int{2}, true},  // blocked common cause
+		{[]int{4}, []int{5}, []int{2}, true},  // dysp is an unobserved collider
+		{[]int{4}, []int{5}, []int{2, 7}, false},
+		{[]int{0, 4}, []int{6, 3}, []int{2}, false},
+	}
+
+	for _, c := range cases {
+		sep, err := m.DSeparated(c.x, c.y, c.z)
+		assert.NoError(err)
+		assert.Equal(c.sep, sep, "%v _|_ %v | %v", c.x, c.y, c.z)
+
+		// d-separation is symmetric
+		sep, err = m.DSeparated(c.y, c.x, c.z)
+		assert.NoError(err)
+		assert.Equal(c.sep, sep, "%v _|_ %v | %v", c.y, c.x, c.z)
+	}
+
+	// Observing either opens the path from tub through lung
+	conn, err := m.DConnected([]int{1}, []int{5})
+	assert.NoError(err)
+	assert.Equal([]bool{true, true, true, true, true, false, false, true}, conn)
+
+	_, err = m.DSeparated([]int{0}, []int{1}, []int{0})
+	assert.Error(err)
+	_, err = m.DSeparated([]int{8}, []int{1}, nil)
+	assert.Error(err)
+	_, err = m.DSeparated([]int{0}, []int{-1}, nil)
+	assert.Error(err)
+}
+
+func TestLookupVars(t *testing.T) {
+	assert := assert.New(t)
+
+	m := asiaModel(t)
+	ids, err := m.LookupVars([]string{"lung", "7", "asia"})
+	assert.NoError(err)
+	assert.Equal([]int{3, 7, 0}, ids)
+
+	_, err = m.LookupVars([]string{"nope"})
+	assert.Error(err)
+	_, err = m.LookupVars([]string{"8"})
+	assert.Error(err)
+}
+
+// prunedNames returns the names of the pruned model's variables
+func prunedNames(p *Pruning) []string {
+	names := make([]string, len(p.Model.Vars))
+	for i, v := range p.Model.Vars {
+		names[i] = v.Name
+	}
+	return names
+}
+
+func TestPruning(t *testing.T) {
+	assert := assert.New(t)
+
+	// Without evidence, we only need the query's ancestors
+	m := asiaModel(t)
+	p, err := NewPruning(m, []int{3})
+	assert.NoError(err)
+	assert.Equal(BAYES, p.Model.Type)
+	assert.Equal([]string{"smoke", "lung"}, prunedNames(p))
+	assert.Equal([]int{2, 3}, p.VarMap)
+	assert.Len(p.Model.Funcs, 2)
+	assert.Equal(p.Model.Vars[0], p.Model.Funcs[1].Vars[0])
+
+	// Evidence ancestors are needed too, but bronc and dysp are barren
+	assert.NoError(m.SetEvidence(Evidence{6: 0}))
+	p, err = NewPruning(m, []int{3})
+	assert.NoError(err)
+	assert.Equal([]string{"asia", "tub", "smoke", "lung", "either", "xray"}, prunedNames(p))
+	assert.Equal(0, p.Model.Vars[5].FixedVal)
+
+	// Observed parents block everything above them
+	assert.NoError(m.SetEvidence(Evidence{2: 1}))
+	p, err = NewPruning(m, []int{4})
+	assert.NoError(err)
+	assert.Equal([]string{"smoke", "bronc"}, prunedNames(p))
+	assert.Len(p.Model.Funcs, 1)
+	assert.Equal("P(bronc | smoke)", p.Model.Funcs[0].Name)
+
+	// Observing either needs its CPT, but the evidence below it is barren
+	assert.NoError(m.SetEvidence(Evidence{5: 0}))
+	p, err = NewPruning(m, []int{1})
+	assert.NoError(err)
+	assert.Equal([]string{"asia", "tub", "smoke", "lung", "either"}, prunedNames(p))
+
+	// Soft evidence acts like an observed child
+	assert.NoError(m.SetEvidence(Evidence{}))
+	assert.NoError(m.AddSoftEvidence(SoftEvidence{7: {0.9, 0.2}}))
+	p, err = NewPruning(m, []int{3})
+	assert.NoError(err)
+	assert.Equal([]string{"asia", "tub", "smoke", "lung", "bronc", "either", "dysp"}, prunedNames(p))
+	assert.Equal("L(dysp)", p.Model.Funcs[len(p.Model.Funcs)-1].Name)
+
+	// Query results are mapped back
+	est := make([]*Variable, len(p.Model.Vars))
+	for i, v := range p.Model.Vars {
+		est[i] = v.Clone()
+	}
+	est[3].Marginal = []float64{0.1, 0.9}
+	qv, err := p.QueryVars(est)
+	assert.NoError(err)
+	assert.Len(qv, 1)
+	assert.Equal(3, qv[0].ID)
+	assert.Equal([]float64{0.1, 0.9}, qv[0].Marginal)
+	red, err := p.ReduceVars(m.Vars)
+	assert.NoError(err)
+	assert.Equal(prunedNames(p), []string{red[0].Name, red[1].Name, red[2].Name, red[3].Name, red[4].Name, red[5].Name, red[6].Name})
+
+	// Errors
+	_, err = NewPruning(m, nil)
+	assert.Error(err)
+	assert.NoError(m.SetEvidence(Evidence{3: 0}))
+	_, err = NewPruning(m, []int{3})
+	assert.Error(err)
+	m.Type = MARKOV
+	_, err = NewPruning(m, []int{1})
+	assert.Error(err)
+}
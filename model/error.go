@@ -77,6 +77,69 @@ func NewErrorSuite(vars1 []*Variable, vars2 []*Variable) (*ErrorSuite, error) {
 	return &es, nil
 }
 
+// LogZError is the error for an estimate of the log partition function. Since
+// log Z can be huge for large models, we report relative error as well.
+type LogZError struct {
+	Expected float64 // The log Z from the solution
+	Estimate float64 // Our estimate of log Z
+	AbsError float64 // |Expected - Estimate|
+	RelError float64 // AbsError / |Expected| (or AbsError if Expected is 0)
+}
+
+// NewLogZError compares an estimate of (natural) log Z with the expected value
+func NewLogZError(expected float64, estimate float64) *LogZError {
+	e := &LogZError{
+		Expected: expected,
+		Estimate: estimate,
+		AbsError: math.Abs(expected - estimate),
+	}
+
+	if expected != 0.0 {
+		e.RelError = e.AbsError / math.Abs(expected)
+	} else {
+		e.RelError = e.AbsError
+	}
+
+	return e
+}
+
+// MPEError compares an MPE estimate with an expected solution.
+type MPEError struct {
+	ExpectedLogProb float64 // Unnormalized log prob of the solution state
+	EstimateLogProb float64 // Unnormalized log prob of our state
+	LogProbGap      float64 // ExpectedLogProb - EstimateLogProb (negative if we beat the solution)
+	Hamming         int     // Number of unfixed variables with a different value
+}
+
+// NewMPEError scores the estimate state vs the expected state on the given
+// model. Both states are indexed by variable ID.
+func NewMPEError(m *Model, expected []int, estimate []int) (*MPEError, error) {
+	if len(expected) != len(m.Vars) || len(estimate) != len(m.Vars) {
+		return nil, errors.Errorf("State size mismatch: %d and %d for %d vars", len(expected), len(estimate), len(m.Vars))
+	}
+
+	var err error
+	e := &MPEError{}
+
+	e.ExpectedLogProb, err = m.LogProb(expected)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not score expected MPE state")
+	}
+	e.EstimateLogProb, err = m.LogProb(estimate)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not score estimated MPE state")
+	}
+	e.LogProbGap = e.ExpectedLogProb - e.EstimateLogProb
+
+	for i, v := range m.Vars {
+		if v.FixedVal < 0 && expected[i] != estimate[i] {
+			e.Hamming++
+		}
+	}
+
+	return e, nil
+}
+
 // MaxAbsDiff returns the maximum difference found between the two prob dists
 func MaxAbsDiff(v1 *Variable, v2 *Variable) float64 {
 	if v1.FixedVal >= 0 || v2.FixedVal >= 0 {
@@ -12,12 +12,12 @@ func TestErrorSuiteNormed(t *testing.T) {
 	assert := assert.New(t)
 
 	vars1 := []*Variable{
-		{0, "V1", 2, -1, []float64{250.0, 750.0}, nil, false},
-		{0, "V2", 2, -1, []float64{25.1, 75.3}, nil, false},
+		{0, "V1", 2, -1, []float64{250.0, 750.0}, nil, false, nil, nil},
+		{0, "V2", 2, -1, []float64{25.1, 75.3}, nil, false, nil, nil},
 	}
 	vars2 := []*Variable{
-		{0, "V1", 2, -1, []float64{42.0, 42.0}, nil, false},
-		{0, "V2", 2, -1, []float64{3.1, 3.1}, nil, false},
+		{0, "V1", 2, -1, []float64{42.0, 42.0}, nil, false, nil, nil},
+		{0, "V2", 2, -1, []float64{3.1, 3.1}, nil, false, nil, nil},
 	}
 
 	// Calculate mean hellinger
@@ -94,12 +94,12 @@ func TestErrorSuiteMaxMean(t *testing.T) {
 	// We manually calculated our expected values for these variables
 
 	vars1 := []*Variable{
-		{0, "V1", 3, -1, []float64{30.0, 40.0, 30.0}, nil, false},
-		{0, "V2", 3, -1, []float64{30.0, 40.0, 30.0}, nil, false},
+		{0, "V1", 3, -1, []float64{30.0, 40.0, 30.0}, nil, false, nil, nil},
+		{0, "V2", 3, -1, []float64{30.0, 40.0, 30.0}, nil, false, nil, nil},
 	}
 	vars2 := []*Variable{
-		{0, "V1", 3, -1, []float64{90.0, 5.0, 5.0}, nil, false},
-		{0, "V2", 3, -1, []float64{60.0, 30.0, 10.0}, nil, false},
+		{0, "V1", 3, -1, []float64{90.0, 5.0, 5.0}, nil, false, nil, nil},
+		{0, "V2", 3, -1, []float64{60.0, 30.0, 10.0}, nil, false, nil, nil},
 	}
 
 	var suite *ErrorSuite
@@ -117,3 +117,55 @@ func TestErrorSuiteMaxMean(t *testing.T) {
 	assert.InEpsilon(.18806933, suite.MeanJSDiverge, eps)
 	assert.InEpsilon(.29645726, suite.MaxJSDiverge, eps)
 }
+
+func TestLogZError(t *testing.T) {
+	assert := assert.New(t)
+
+	e := NewLogZError(-10.0, -12.5)
+	assert.Equal(-10.0, e.Expected)
+	assert.Equal(-12.5, e.Estimate)
+	assert.InDelta(2.5, e.AbsError, 1e-12)
+	assert.InDelta(0.25, e.RelError, 1e-12)
+
+	e = NewLogZError(0.0, 0.5)
+	assert.InDelta(0.5, e.AbsError, 1e-12)
+	assert.InDelta(0.5, e.RelError, 1e-12)
+
+	sol := &PRSolution{LogZ: 4.0}
+	assert.InDelta(0.25, sol.Error(5.0).RelError, 1e-12)
+}
+
+func TestMPEError(t *testing.T) {
+	assert := assert.New(t)
+
+	r := UAIReader{}
+	m, err := NewModelFromFile(r, "../res/sample.uai", false)
+	assert.NoError(err)
+
+	// MPE for sample.uai is A=0, B=1, C=0
+	sol := &MPESolution{Values: []int{0, 1, 0}}
+	assert.NoError(sol.Check(m))
+
+	e, err := sol.Error(m, []int{0, 1, 0})
+	assert.NoError(err)
+	assert.InDelta(math.Log(0.436*0.872*0.811), e.ExpectedLogProb, 1e-12)
+	assert.Equal(0.0, e.LogProbGap)
+	assert.Equal(0, e.Hamming)
+
+	e, err = sol.Error(m, []int{1, 0, 2})
+	assert.NoError(err)
+	assert.InDelta(math.Log(0.436*0.872*0.811)-math.Log(0.564*0.920*0.457), e.LogProbGap, 1e-12)
+	assert.Equal(3, e.Hamming)
+
+	// Fixed vars don't count for Hamming distance
+	m.Vars[0].FixedVal = 1
+	e, err = sol.Error(m, []int{1, 0, 2})
+	assert.NoError(err)
+	assert.Equal(2, e.Hamming)
+
+	_, err = sol.Error(m, []int{0, 1})
+	assert.Error(err)
+
+	assert.Error((&MPESolution{Values: []int{1, 0}}).Check(m))
+	assert.Error((&MPESolution{Values: []int{1, 0, 3}}).Check(m))
+}
@@ -0,0 +1,325 @@
+package model
+
+import (
+	"io/ioutil"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Evidence is a single evidence instance: observed values keyed by variable
+// ID. An evidence file may contain many instances for the same model.
+type Evidence map[int]int
+
+// EvidenceReader implementors read one or more evidence instances
+type EvidenceReader interface {
+	ReadEvidence(data []byte) ([]Evidence, error)
+}
+
+// NewEvidenceFromFile reads all evidence instances in an evidence file
+func NewEvidenceFromFile(r EvidenceReader, filename string) ([]Evidence, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not READ evidence from %s", filename)
+	}
+
+	evid, err := NewEvidenceFromBuffer(r, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return evid, nil
+}
+
+// NewEvidenceFromBuffer reads all evidence instances from the specified buffer
+func NewEvidenceFromBuffer(r EvidenceReader, data []byte) ([]Evidence, error) {
+	evid, err := r.ReadEvidence(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not PARSE evidence")
+	}
+
+	return evid, nil
+}
+
+// Check returns an error if the evidence isn't valid for the model
+func (e Evidence) Check(m *Model) error {
+	for idx, val := range e {
+		if idx < 0 || idx >= len(m.Vars) {
+			return errors.Errorf("Invalid evidence variable index %d", idx)
+		}
+		v := m.Vars[idx]
+		if val < 0 || val >= v.Card {
+			return errors.Errorf("Invalid evidence value %d for variable[%d]:%v with card %d", val, idx, v.Name, v.Card)
+		}
+		if v.Allowed != nil && !v.Allowed[val] {
+			return errors.Errorf("Evidence value %d for variable[%d]:%v is not allowed by its domain evidence", val, idx, v.Name)
+		}
+	}
+	return nil
+}
+
+// VarIDs returns the evidence variable IDs in sorted order
+func (e Evidence) VarIDs() []int {
+	ids := make([]int, 0, len(e))
+	for idx := range e {
+		ids = append(ids, idx)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// SetEvidence replaces any current evidence in the model with the given
+// evidence instance. The model is unchanged if the evidence is invalid. Soft
+// and domain evidence are NOT changed.
+func (m *Model) SetEvidence(e Evidence) error {
+	if err := e.Check(m); err != nil {
+		return errors.Wrapf(err, "Could not set evidence on model %s", m.Name)
+	}
+
+	for _, v := range m.Vars {
+		v.FixedVal = -1
+	}
+	for idx, val := range e {
+		m.Vars[idx].FixedVal = val
+	}
+
+	return nil
+}
+
+// NamedEvidence creates an evidence instance for the model from variable names
+// and value labels (see Variable.LabelIndex)
+func (m *Model) NamedEvidence(named map[string]string) (Evidence, error) {
+	byName := make(map[string]*Variable, len(m.Vars))
+	for _, v := range m.Vars {
+		byName[v.Name] = v
+	}
+
+	e := make(Evidence, len(named))
+	for name, label := range named {
+		v, ok := byName[name]
+		if !ok {
+			return nil, errors.Errorf("Unknown evidence variable %s in model %s", name, m.Name)
+		}
+		val, err := v.LabelIndex(label)
+		if err != nil {
+			return nil, err
+		}
+		e[v.ID] = val
+	}
+
+	return e, nil
+}
+
+// SoftEvidence is likelihood (virtual) evidence: for each variable ID, the
+// likelihood of the observation given each of the variable's values. It is
+// added to a model as a unary function for each variable.
+type SoftEvidence map[int][]float64
+
+// SoftEvidenceReader implementors read soft evidence
+type SoftEvidenceReader interface {
+	ReadSoftEvidence(data []byte) (SoftEvidence, error)
+}
+
+// NewSoftEvidenceFromFile reads the soft evidence in an evidence file
+func NewSoftEvidenceFromFile(r SoftEvidenceReader, filename string) (SoftEvidence, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not READ soft evidence from %s", filename)
+	}
+
+	se, err := r.ReadSoftEvidence(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not PARSE soft evidence")
+	}
+
+	return se, nil
+}
+
+// Check returns an error if the soft evidence isn't valid for the model: each
+// variable needs a likelihood per value, and the likelihoods must be
+// non-negative and not all zero.
+func (se SoftEvidence) Check(m *Model) error {
+	for idx, lik := range se {
+		if idx < 0 || idx >= len(m.Vars) {
+			return errors.Errorf("Invalid soft evidence variable index %d", idx)
+		}
+		v := m.Vars[idx]
+		if len(lik) != v.Card {
+			return errors.Errorf("Soft evidence for variable[%d]:%v has %d values but card is %d", idx, v.Name, len(lik), v.Card)
+		}
+
+		sum := 0.0
+		for _, l := range lik {
+			if l < 0.0 || math.IsNaN(l) || math.IsInf(l, 0) {
+				return errors.Errorf("Invalid soft evidence likelihood %v for variable[%d]:%v", l, idx, v.Name)
+			}
+			sum += l
+		}
+		if sum <= 0.0 {
+			return errors.Errorf("Soft evidence for variable[%d]:%v is all zero", idx, v.Name)
+		}
+	}
+	return nil
+}
+
+// VarIDs returns the soft evidence variable IDs in sorted order
+func (se SoftEvidence) VarIDs() []int {
+	ids := make([]int, 0, len(se))
+	for idx := range se {
+		ids = append(ids, idx)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// softEvidenceName is the name of the function holding a variable's soft
+// evidence
+func softEvidenceName(v *Variable) string {
+	return "L(" + v.Name + ")"
+}
+
+// isSoftEvidence is true if the function holds a variable's soft evidence
+// (and isn't a CPT). We go by the SoftEvidence flag and never by name, since
+// a model's own functions may have any name.
+func isSoftEvidence(f *Function) bool {
+	return f.SoftEvidence && len(f.Vars) == 1
+}
+
+// softEvidenceFunc returns the function holding the variable's soft evidence
+// (or nil if there isn't one). Variables are matched by ID since a cloned
+// model has its own copy of every variable.
+func (m *Model) softEvidenceFunc(v *Variable) *Function {
+	for _, f := range m.Funcs {
+		if isSoftEvidence(f) && f.Vars[0].ID == v.ID {
+			return f
+		}
+	}
+	return nil
+}
+
+// AddSoftEvidence adds the soft evidence to the model as unary functions. A
+// variable that already has soft evidence has its likelihoods multiplied by
+// the new ones (as for independent observations). The model is unchanged if
+// the evidence is invalid.
+func (m *Model) AddSoftEvidence(se SoftEvidence) error {
+	if err := se.Check(m); err != nil {
+		return errors.Wrapf(err, "Could not add soft evidence to model %s", m.Name)
+	}
+
+	for _, idx := range se.VarIDs() {
+		v := m.Vars[idx]
+		f := m.softEvidenceFunc(v)
+		if f == nil {
+			var err error
+			f, err = NewFunction(len(m.Funcs), []*Variable{v})
+			if err != nil {
+				return errors.Wrapf(err, "Could not create soft evidence function for %s", v.Name)
+			}
+			f.Name = softEvidenceName(v)
+			f.SoftEvidence = true
+			for i := range f.Table {
+				f.Table[i] = 1.0
+			}
+			m.Funcs = append(m.Funcs, f)
+		}
+
+		for i, l := range se[idx] {
+			if f.IsLog {
+				f.Table[i] += math.Log(l)
+			} else {
+				f.Table[i] *= l
+			}
+		}
+	}
+
+	return nil
+}
+
+// ClearSoftEvidence removes all soft evidence functions from the model
+func (m *Model) ClearSoftEvidence() {
+	keep := m.Funcs[:0]
+	for _, f := range m.Funcs {
+		if isSoftEvidence(f) {
+			continue
+		}
+		keep = append(keep, f)
+	}
+	for i := len(keep); i < len(m.Funcs); i++ {
+		m.Funcs[i] = nil
+	}
+	m.Funcs = keep
+}
+
+// DomainEvidence is negative and set-valued evidence: for each variable ID,
+// the values that the variable may take (so X != 3 for a variable with card 4
+// is the values 0, 1, and 2). See Variable.Restrict.
+type DomainEvidence map[int][]int
+
+// DomainEvidenceReader implementors read domain evidence
+type DomainEvidenceReader interface {
+	ReadDomainEvidence(data []byte) (DomainEvidence, error)
+}
+
+// NewDomainEvidenceFromFile reads the domain evidence in an evidence file
+func NewDomainEvidenceFromFile(r DomainEvidenceReader, filename string) (DomainEvidence, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not READ domain evidence from %s", filename)
+	}
+
+	de, err := r.ReadDomainEvidence(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not PARSE domain evidence")
+	}
+
+	return de, nil
+}
+
+// VarIDs returns the domain evidence variable IDs in sorted order
+func (de DomainEvidence) VarIDs() []int {
+	ids := make([]int, 0, len(de))
+	for idx := range de {
+		ids = append(ids, idx)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Check returns an error if the domain evidence can't be applied to the
+// model (see RestrictDomains)
+func (de DomainEvidence) Check(m *Model) error {
+	for _, idx := range de.VarIDs() {
+		if idx < 0 || idx >= len(m.Vars) {
+			return errors.Errorf("Invalid domain evidence variable index %d", idx)
+		}
+		if err := m.Vars[idx].Clone().Restrict(de[idx]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestrictDomains limits each variable in the domain evidence to the given
+// values (on top of any current restriction). The model is unchanged if the
+// evidence is invalid.
+func (m *Model) RestrictDomains(de DomainEvidence) error {
+	if err := de.Check(m); err != nil {
+		return errors.Wrapf(err, "Could not restrict domains on model %s", m.Name)
+	}
+
+	for _, idx := range de.VarIDs() {
+		if err := m.Vars[idx].Restrict(de[idx]); err != nil {
+			return errors.Wrapf(err, "Could not restrict domains on model %s", m.Name)
+		}
+	}
+
+	return nil
+}
+
+// ClearDomains removes all domain evidence from the model
+func (m *Model) ClearDomains() {
+	for _, v := range m.Vars {
+		v.Allowed = nil
+	}
+}
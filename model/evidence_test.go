@@ -0,0 +1,111 @@
+package model
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetEvidence(t *testing.T) {
+	assert := assert.New(t)
+
+	r := UAIReader{}
+	m, err := NewModelFromFile(r, "../res/sample.uai", false)
+	assert.NoError(err)
+
+	assert.NoError(m.SetEvidence(Evidence{0: 1, 2: 2}))
+	assert.Equal([]int{1, -1, 2}, []int{m.Vars[0].FixedVal, m.Vars[1].FixedVal, m.Vars[2].FixedVal})
+
+	// Replaces previous evidence
+	assert.NoError(m.SetEvidence(Evidence{1: 0}))
+	assert.Equal([]int{-1, 0, -1}, []int{m.Vars[0].FixedVal, m.Vars[1].FixedVal, m.Vars[2].FixedVal})
+
+	// Invalid evidence leaves the model alone
+	assert.Error(m.SetEvidence(Evidence{0: 1, 2: 3}))
+	assert.Error(m.SetEvidence(Evidence{3: 0}))
+	assert.Error(m.SetEvidence(Evidence{-1: 0}))
+	assert.Equal([]int{-1, 0, -1}, []int{m.Vars[0].FixedVal, m.Vars[1].FixedVal, m.Vars[2].FixedVal})
+
+	assert.NoError(m.SetEvidence(Evidence{}))
+	assert.Equal([]int{-1, -1, -1}, []int{m.Vars[0].FixedVal, m.Vars[1].FixedVal, m.Vars[2].FixedVal})
+}
+
+func TestSoftEvidence(t *testing.T) {
+	assert := assert.New(t)
+
+	r := UAIReader{}
+	m, err := NewModelFromFile(r, "../res/sample.uai", false)
+	assert.NoError(err)
+	funcCount := len(m.Funcs)
+
+	state := []int{1, 0, 2}
+	before, err := m.LogProb(state)
+	assert.NoError(err)
+
+	// Invalid soft evidence leaves the model alone
+	assert.Error(m.AddSoftEvidence(SoftEvidence{3: {0.5, 0.5}}))
+	assert.Error(m.AddSoftEvidence(SoftEvidence{0: {0.5, 0.5, 0.5}}))
+	assert.Error(m.AddSoftEvidence(SoftEvidence{0: {-0.5, 0.5}}))
+	assert.Error(m.AddSoftEvidence(SoftEvidence{0: {0.0, 0.0}}))
+	assert.Equal(funcCount, len(m.Funcs))
+
+	// Each variable gets a unary function
+	assert.NoError(m.AddSoftEvidence(SoftEvidence{0: {0.9, 0.1}, 2: {0.2, 0.3, 0.5}}))
+	assert.NoError(m.Check())
+	assert.Equal(funcCount+2, len(m.Funcs))
+	f := m.Funcs[funcCount]
+	assert.Equal([]*Variable{m.Vars[0]}, f.Vars)
+	assert.Equal([]float64{0.9, 0.1}, f.Table)
+
+	after, err := m.LogProb(state)
+	assert.NoError(err)
+	assert.InDelta(before+math.Log(0.1*0.5), after, 1e-9)
+
+	// More evidence for the same variable is multiplied in
+	assert.NoError(m.AddSoftEvidence(SoftEvidence{0: {0.5, 1.0}}))
+	assert.Equal(funcCount+2, len(m.Funcs))
+	assert.Equal([]float64{0.45, 0.1}, f.Table)
+
+	m.ClearSoftEvidence()
+	assert.Equal(funcCount, len(m.Funcs))
+	after, err = m.LogProb(state)
+	assert.NoError(err)
+	assert.InDelta(before, after, 1e-9)
+}
+
+// Soft evidence functions are found by flag and variable ID: clones keep
+// them, and a model function that happens to share the name is left alone
+func TestSoftEvidenceIdentity(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := NewModelFromFile(UAIReader{}, "../res/sample.uai", false)
+	assert.NoError(err)
+	funcCount := len(m.Funcs)
+	assert.NoError(m.AddSoftEvidence(SoftEvidence{0: {0.9, 0.1}}))
+
+	cp := m.Clone()
+	assert.NoError(cp.AddSoftEvidence(SoftEvidence{0: {0.5, 1.0}}))
+	assert.NoError(cp.AddSoftEvidence(SoftEvidence{0: {0.5, 1.0}}))
+	assert.Equal(funcCount+1, len(cp.Funcs))
+	assert.True(cp.Funcs[funcCount].SoftEvidence)
+	assert.InDeltaSlice([]float64{0.225, 0.1}, cp.Funcs[funcCount].Table, 1e-9)
+	assert.Equal([]float64{0.9, 0.1}, m.Funcs[funcCount].Table)
+
+	// A user function named like soft evidence is just a function
+	m.ClearSoftEvidence()
+	m.Funcs[0].Name = softEvidenceName(m.Funcs[0].Vars[0])
+	m.ClearSoftEvidence()
+	assert.Equal(funcCount, len(m.Funcs))
+
+	// Even a root CPT in a BAYES model
+	bn := asiaModel(t)
+	for _, f := range bn.Funcs {
+		if len(f.Vars) == 1 {
+			f.Name = softEvidenceName(f.Vars[0])
+		}
+	}
+	assert.NoError(bn.CheckBayes(CPTTolerance))
+	bn.ClearSoftEvidence()
+	assert.NoError(bn.CheckBayes(CPTTolerance))
+}
@@ -0,0 +1,521 @@
+package model
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// The factor operations in this file treat a Function as a factor in either
+// linear or log space: each result is in the same space as its input(s).
+// Operations return NEW functions and never modify their inputs. Variables
+// are matched by ID (so functions over cloned variables work together), and
+// result tables are in our usual order (the last variable is fastest).
+//
+// A Function can not have an empty scope, so an operation that would give a
+// factor over zero variables returns a nil function and the scalar value
+// instead.
+
+// varIndex returns the position of the variable with the given ID in vars
+// (or -1 if it isn't there)
+func varIndex(vars []*Variable, id int) int {
+	for i, v := range vars {
+		if v.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// strides returns the table stride for each variable in f when the variables
+// are laid out in the order given by scope. Variables in scope but NOT in f
+// get a stride of 0.
+func strides(f *Function, scope []*Variable) []int {
+	st := make([]int, len(scope))
+	s := 1
+	for j := len(f.Vars) - 1; j >= 0; j-- {
+		if i := varIndex(scope, f.Vars[j].ID); i >= 0 {
+			st[i] = s
+		}
+		s *= f.Vars[j].Card
+	}
+	return st
+}
+
+// eachAssign calls visit for every assignment to vars in table order, where
+// t is the table index of the assignment
+func eachAssign(vars []*Variable, visit func(t int, assign []int)) {
+	size := calcTabSize(vars)
+	assign := make([]int, len(vars))
+	for t := 0; t < size; t++ {
+		visit(t, assign)
+
+		for i := len(vars) - 1; i >= 0; i-- {
+			assign[i]++
+			if assign[i] < vars[i].Card {
+				break
+			}
+			assign[i] = 0
+		}
+	}
+}
+
+// eachIndex calls visit for every assignment to vars in table order, where t
+// is the table index of the assignment and idx is the index of the same
+// assignment in a table with the given strides (one per var).
+func eachIndex(vars []*Variable, st []int, visit func(t int, idx int)) {
+	size := calcTabSize(vars)
+	assign := make([]int, len(vars))
+	idx := 0
+	for t := 0; t < size; t++ {
+		visit(t, idx)
+
+		for i := len(vars) - 1; i >= 0; i-- {
+			assign[i]++
+			if assign[i] < vars[i].Card {
+				idx += st[i]
+				break
+			}
+			assign[i] = 0
+			idx -= st[i] * (vars[i].Card - 1)
+		}
+	}
+}
+
+// checkScope returns an error if scope is empty, has a repeated variable, or
+// has a variable that isn't in f
+func checkScope(f *Function, scope []*Variable) error {
+	if len(scope) < 1 {
+		return errors.Errorf("Empty scope for function %s", f.Name)
+	}
+	for i, v := range scope {
+		if varIndex(f.Vars, v.ID) < 0 {
+			return errors.Errorf("Variable %s is not in function %s", v.Name, f.Name)
+		}
+		if varIndex(scope[:i], v.ID) >= 0 {
+			return errors.Errorf("Variable %s is repeated in scope for function %s", v.Name, f.Name)
+		}
+	}
+	return nil
+}
+
+// logSumExp returns log(sum(exp(vals))) without overflow
+func logSumExp(vals []float64) float64 {
+	max := math.Inf(-1)
+	for _, v := range vals {
+		if v > max {
+			max = v
+		}
+	}
+	if math.IsInf(max, 0) {
+		return max
+	}
+
+	sum := 0.0
+	for _, v := range vals {
+		sum += math.Exp(v - max)
+	}
+	return max + math.Log(sum)
+}
+
+// ToLinear returns a copy of the function that is NOT in log space
+func (f *Function) ToLinear() *Function {
+	cp := f.Clone()
+	if cp.IsLog {
+		for i, v := range cp.Table {
+			cp.Table[i] = math.Exp(v)
+		}
+		cp.IsLog = false
+	}
+	return cp
+}
+
+// ToLog returns a copy of the function in log space. Unlike UseLogSpace, a
+// zero becomes -Inf.
+func (f *Function) ToLog() *Function {
+	cp := f.Clone()
+	if !cp.IsLog {
+		for i, v := range cp.Table {
+			cp.Table[i] = math.Log(v)
+		}
+		cp.IsLog = true
+	}
+	return cp
+}
+
+// Product returns the product of the functions. The scope of the result is
+// the union of the input scopes in the order the variables are first seen.
+// The result is in log space if any of the inputs are (and the others are
+// converted), and it is named for the first function.
+func Product(funcs ...*Function) (*Function, error) {
+	if len(funcs) < 1 {
+		return nil, errors.New("Can not take the product of zero functions")
+	}
+
+	isLog := false
+	scope := make([]*Variable, 0, len(funcs[0].Vars))
+	for _, f := range funcs {
+		isLog = isLog || f.IsLog
+		for _, v := range f.Vars {
+			if varIndex(scope, v.ID) < 0 {
+				scope = append(scope, v)
+			}
+		}
+	}
+
+	dest, err := NewFunction(0, scope)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not create product of %d functions", len(funcs))
+	}
+	dest.Name = funcs[0].Name
+	dest.IsLog = isLog
+	if !isLog {
+		for t := range dest.Table {
+			dest.Table[t] = 1.0
+		}
+	}
+
+	for _, f := range funcs {
+		src := f
+		if isLog {
+			src = f.ToLog()
+		}
+		eachIndex(scope, strides(src, scope), func(t int, idx int) {
+			if isLog {
+				dest.Table[t] += src.Table[idx]
+			} else {
+				dest.Table[t] *= src.Table[idx]
+			}
+		})
+	}
+
+	return dest, nil
+}
+
+// Multiply returns the product of f and g (see Product)
+func (f *Function) Multiply(g *Function) (*Function, error) {
+	return Product(f, g)
+}
+
+// Divide returns f divided by g, where the variables in g must be a subset of
+// the variables in f. The result has the scope of f and is in the space of f.
+// As is standard in message passing, 0/0 is defined to be 0.
+func (f *Function) Divide(g *Function) (*Function, error) {
+	for _, v := range g.Vars {
+		if varIndex(f.Vars, v.ID) < 0 {
+			return nil, errors.Errorf("Can not divide %s by %s: variable %s is not in %s", f.Name, g.Name, v.Name, f.Name)
+		}
+	}
+
+	dest := f.Clone()
+	den := g.ToLinear()
+	if f.IsLog {
+		den = g.ToLog()
+	}
+
+	eachIndex(dest.Vars, strides(den, dest.Vars), func(t int, idx int) {
+		d := den.Table[idx]
+		switch {
+		case dest.IsLog && math.IsInf(d, -1):
+			dest.Table[t] = math.Inf(-1)
+		case dest.IsLog:
+			dest.Table[t] -= d
+		case d == 0.0:
+			dest.Table[t] = 0.0
+		default:
+			dest.Table[t] /= d
+		}
+	})
+
+	return dest, nil
+}
+
+// MaxTo returns a function over scope (a non-empty subset of the variables
+// in f, in any order) where every other variable is maximized out
+func (f *Function) MaxTo(scope []*Variable) (*Function, error) {
+	if err := checkScope(f, scope); err != nil {
+		return nil, err
+	}
+
+	dest, err := NewFunction(0, scope)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not maximize function %s", f.Name)
+	}
+	dest.Name = f.Name
+	dest.IsLog = f.IsLog
+	for t := range dest.Table {
+		dest.Table[t] = math.Inf(-1)
+	}
+
+	eachIndex(f.Vars, strides(dest, f.Vars), func(t int, idx int) {
+		if v := f.Table[t]; v > dest.Table[idx] {
+			dest.Table[idx] = v
+		}
+	})
+
+	return dest, nil
+}
+
+// SumTo returns a function over scope (a non-empty subset of the variables
+// in f, in any order) where every other variable is summed out. In log space
+// the sums are done without leaving log space.
+func (f *Function) SumTo(scope []*Variable) (*Function, error) {
+	if !f.IsLog {
+		if err := checkScope(f, scope); err != nil {
+			return nil, err
+		}
+
+		dest, err := NewFunction(0, scope)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not sum function %s", f.Name)
+		}
+		dest.Name = f.Name
+
+		eachIndex(f.Vars, strides(dest, f.Vars), func(t int, idx int) {
+			dest.Table[idx] += f.Table[t]
+		})
+		return dest, nil
+	}
+
+	// Log space: shift by the max for each result entry before summing
+	max, err := f.MaxTo(scope)
+	if err != nil {
+		return nil, err
+	}
+	dest := max.Clone()
+	for t := range dest.Table {
+		dest.Table[t] = 0.0
+	}
+
+	eachIndex(f.Vars, strides(dest, f.Vars), func(t int, idx int) {
+		if m := max.Table[idx]; !math.IsInf(m, -1) {
+			dest.Table[idx] += math.Exp(f.Table[t] - m)
+		}
+	})
+	for t, s := range dest.Table {
+		if math.IsInf(max.Table[t], -1) {
+			dest.Table[t] = math.Inf(-1)
+		} else {
+			dest.Table[t] = max.Table[t] + math.Log(s)
+		}
+	}
+
+	return dest, nil
+}
+
+// eliminate returns the variables in f without the given IDs (which must all
+// be in f)
+func (f *Function) eliminate(ids []int) ([]*Variable, error) {
+	for _, id := range ids {
+		if varIndex(f.Vars, id) < 0 {
+			return nil, errors.Errorf("Variable %d is not in function %s", id, f.Name)
+		}
+	}
+
+	keep := make([]*Variable, 0, len(f.Vars))
+	for _, v := range f.Vars {
+		drop := false
+		for _, id := range ids {
+			drop = drop || v.ID == id
+		}
+		if !drop {
+			keep = append(keep, v)
+		}
+	}
+	return keep, nil
+}
+
+// SumOut returns f with the variables given by ID summed out. If that leaves
+// no variables, the result is nil and the sum is returned (in the space of
+// f). Otherwise the returned value is 1 (or 0 in log space).
+func (f *Function) SumOut(ids ...int) (*Function, float64, error) {
+	keep, err := f.eliminate(ids)
+	if err != nil {
+		return nil, math.NaN(), err
+	}
+
+	if len(keep) < 1 {
+		if f.IsLog {
+			return nil, logSumExp(f.Table), nil
+		}
+		sum := 0.0
+		for _, v := range f.Table {
+			sum += v
+		}
+		return nil, sum, nil
+	}
+
+	dest, err := f.SumTo(keep)
+	if err != nil {
+		return nil, math.NaN(), err
+	}
+	return dest, f.identity(), nil
+}
+
+// MaxOut returns f with the variables given by ID maximized out. If that
+// leaves no variables, the result is nil and the max is returned. Otherwise
+// the returned value is 1 (or 0 in log space).
+func (f *Function) MaxOut(ids ...int) (*Function, float64, error) {
+	keep, err := f.eliminate(ids)
+	if err != nil {
+		return nil, math.NaN(), err
+	}
+
+	if len(keep) < 1 {
+		max := math.Inf(-1)
+		for _, v := range f.Table {
+			max = math.Max(max, v)
+		}
+		return nil, max, nil
+	}
+
+	dest, err := f.MaxTo(keep)
+	if err != nil {
+		return nil, math.NaN(), err
+	}
+	return dest, f.identity(), nil
+}
+
+// identity is 1 in f's space
+func (f *Function) identity() float64 {
+	if f.IsLog {
+		return 0.0
+	}
+	return 1.0
+}
+
+// Reduce conditions f on the evidence and drops the evidence variables from
+// the scope. Evidence for variables that aren't in f is ignored. If every
+// variable in f is fixed, the result is nil and the matching table value is
+// returned. Otherwise the returned value is 1 (or 0 in log space).
+func (f *Function) Reduce(e Evidence) (*Function, float64, error) {
+	// Starting offset in the table comes from the fixed values
+	st := strides(f, f.Vars)
+	offset := 0
+	keep := make([]*Variable, 0, len(f.Vars))
+	for j, v := range f.Vars {
+		val, ok := e[v.ID]
+		if !ok {
+			keep = append(keep, v)
+			continue
+		}
+		if val < 0 || val >= v.Card {
+			return nil, math.NaN(), errors.Errorf("Invalid evidence value %d for variable %s with card %d", val, v.Name, v.Card)
+		}
+		offset += val * st[j]
+	}
+
+	if len(keep) < 1 {
+		return nil, f.Table[offset], nil
+	}
+	if len(keep) == len(f.Vars) {
+		return f.Clone(), f.identity(), nil
+	}
+
+	dest, err := NewFunction(0, keep)
+	if err != nil {
+		return nil, math.NaN(), errors.Wrapf(err, "Could not reduce function %s", f.Name)
+	}
+	dest.Name = f.Name
+	dest.IsLog = f.IsLog
+
+	eachIndex(keep, strides(f, keep), func(t int, idx int) {
+		dest.Table[t] = f.Table[offset+idx]
+	})
+
+	return dest, f.identity(), nil
+}
+
+// Mask returns a copy of f where every entry that is inconsistent with the
+// evidence in vars (looked up by ID) is zero. This includes domain evidence.
+// Unlike Reduce, the scope does not change.
+func (f *Function) Mask(vars []*Variable) (*Function, error) {
+	dest := f.Clone()
+
+	var restricted []int
+	for i, v := range f.Vars {
+		if v.ID < 0 || v.ID >= len(vars) {
+			return nil, errors.Errorf("Function %s has var %s with invalid ID %d", f.Name, v.Name, v.ID)
+		}
+		if ev := vars[v.ID]; ev.FixedVal >= 0 || ev.Allowed != nil {
+			restricted = append(restricted, i)
+		}
+	}
+	if len(restricted) < 1 {
+		return dest, nil
+	}
+
+	zero := 0.0
+	if f.IsLog {
+		zero = math.Inf(-1)
+	}
+
+	eachAssign(f.Vars, func(t int, assign []int) {
+		for _, i := range restricted {
+			if !vars[f.Vars[i].ID].Allows(assign[i]) {
+				dest.Table[t] = zero
+				return
+			}
+		}
+	})
+
+	return dest, nil
+}
+
+// Normalize returns a copy of f scaled so that its entries sum to 1 (in the
+// space of f), along with the log of the sum that was divided out. It is an
+// error for every entry to be zero.
+func (f *Function) Normalize() (*Function, float64, error) {
+	dest := f.Clone()
+
+	sum := 0.0
+	if f.IsLog {
+		sum = logSumExp(f.Table)
+	} else {
+		for _, v := range f.Table {
+			sum += v
+		}
+	}
+
+	logSum := sum
+	if !f.IsLog {
+		logSum = math.Log(sum)
+	}
+	if math.IsInf(logSum, 0) || math.IsNaN(logSum) {
+		return nil, math.NaN(), errors.Errorf("Can not normalize function %s: sum is exp(%v)", f.Name, logSum)
+	}
+
+	for i, v := range dest.Table {
+		if f.IsLog {
+			dest.Table[i] = v - sum
+		} else {
+			dest.Table[i] = v / sum
+		}
+	}
+
+	return dest, logSum, nil
+}
+
+// Reorder returns a copy of f over the same variables in the order given by
+// scope
+func (f *Function) Reorder(scope []*Variable) (*Function, error) {
+	if len(scope) != len(f.Vars) {
+		return nil, errors.Errorf("Scope has %d variables but function %s has %d", len(scope), f.Name, len(f.Vars))
+	}
+	if err := checkScope(f, scope); err != nil {
+		return nil, err
+	}
+
+	dest, err := NewFunction(0, scope)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not reorder function %s", f.Name)
+	}
+	dest.Name = f.Name
+	dest.IsLog = f.IsLog
+
+	eachIndex(scope, strides(f, scope), func(t int, idx int) {
+		dest.Table[t] = f.Table[idx]
+	})
+
+	return dest, nil
+}
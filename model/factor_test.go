@@ -0,0 +1,271 @@
+package model
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// factorVars returns A(2), B(3), C(2) with IDs 0, 1, 2
+func factorVars() (a, b, c *Variable) {
+	a = &Variable{0, "A", 2, -1, []float64{0.5, 0.5}, nil, false, nil, nil}
+	b = &Variable{1, "B", 3, -1, []float64{0.4, 0.3, 0.3}, nil, false, nil, nil}
+	c = &Variable{2, "C", 2, -1, []float64{0.5, 0.5}, nil, false, nil, nil}
+	return
+}
+
+// evalAt evaluates f with values taken from a full assignment (by var ID)
+func evalAt(t *testing.T, f *Function, full []int) float64 {
+	vals := make([]int, len(f.Vars))
+	for i, v := range f.Vars {
+		vals[i] = full[v.ID]
+	}
+	r, err := f.Eval(vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// factorIDs returns the variable IDs for f
+func factorIDs(f *Function) []int {
+	ids := make([]int, len(f.Vars))
+	for i, v := range f.Vars {
+		ids[i] = v.ID
+	}
+	return ids
+}
+
+func TestFactorProduct(t *testing.T) {
+	assert := assert.New(t)
+	a, b, c := factorVars()
+
+	ab := &Function{"AB", []*Variable{a, b}, []float64{1, 2, 3, 4, 5, 6}, false, false}
+	cb := &Function{"CB", []*Variable{c, b}, []float64{1, 10, 100, 2, 20, 200}, false, false}
+
+	p, err := Product(ab, cb)
+	assert.NoError(err)
+	assert.Equal("AB", p.Name)
+	assert.False(p.IsLog)
+	assert.Equal([]*Variable{a, b, c}, p.Vars)
+	for ai := 0; ai < 2; ai++ {
+		for bi := 0; bi < 3; bi++ {
+			for ci := 0; ci < 2; ci++ {
+				full := []int{ai, bi, ci}
+				assert.Equal(evalAt(t, ab, full)*evalAt(t, cb, full), evalAt(t, p, full))
+			}
+		}
+	}
+
+	// Inputs are unchanged
+	assert.Equal([]float64{1, 2, 3, 4, 5, 6}, ab.Table)
+	assert.Len(ab.Vars, 2)
+
+	// Mixing spaces gives log space
+	lcb := cb.ToLog()
+	lp, err := ab.Multiply(lcb)
+	assert.NoError(err)
+	assert.True(lp.IsLog)
+	assert.Equal(factorIDs(p), factorIDs(lp))
+	for i := range p.Table {
+		assert.InDelta(math.Log(p.Table[i]), lp.Table[i], 1e-9)
+	}
+	assert.InDeltaSlice(p.Table, lp.ToLinear().Table, 1e-9)
+
+	// Cloned variables are matched by ID
+	cp, err := Product(ab, cb.Clone())
+	assert.NoError(err)
+	assert.Equal(p.Table, cp.Table)
+
+	_, err = Product()
+	assert.Error(err)
+}
+
+func TestFactorSumMax(t *testing.T) {
+	assert := assert.New(t)
+	a, b, c := factorVars()
+
+	f := &Function{"ABC", []*Variable{a, b, c}, make([]float64, 12), false, false}
+	for i := range f.Table {
+		f.Table[i] = float64(i + 1)
+	}
+	lf := f.ToLog()
+
+	// Sum to C, A (in that order)
+	s, err := f.SumTo([]*Variable{c, a})
+	assert.NoError(err)
+	assert.Equal([]*Variable{c, a}, s.Vars)
+	assert.Equal([]float64{1 + 3 + 5, 7 + 9 + 11, 2 + 4 + 6, 8 + 10 + 12}, s.Table)
+	ls, err := lf.SumTo([]*Variable{c, a})
+	assert.NoError(err)
+	assert.True(ls.IsLog)
+	assert.InDeltaSlice(s.Table, ls.ToLinear().Table, 1e-9)
+
+	// Sum out B is the same as sum to A, C
+	s, one, err := f.SumOut(1)
+	assert.NoError(err)
+	assert.Equal(1.0, one)
+	assert.Equal([]*Variable{a, c}, s.Vars)
+	assert.Equal([]float64{9, 12, 27, 30}, s.Table)
+
+	// Summing out everything gives a scalar
+	s, sum, err := f.SumOut(0, 1, 2)
+	assert.NoError(err)
+	assert.Nil(s)
+	assert.Equal(78.0, sum)
+	s, lsum, err := lf.SumOut(2, 0, 1)
+	assert.NoError(err)
+	assert.Nil(s)
+	assert.InDelta(math.Log(78.0), lsum, 1e-9)
+
+	// Max
+	m, _, err := f.MaxOut(0, 2)
+	assert.NoError(err)
+	assert.Equal([]*Variable{b}, m.Vars)
+	assert.Equal([]float64{8, 10, 12}, m.Table)
+	lm, zero, err := lf.MaxOut(0, 2)
+	assert.NoError(err)
+	assert.Equal(0.0, zero)
+	assert.InDeltaSlice(m.Table, lm.ToLinear().Table, 1e-9)
+	m, max, err := f.MaxOut(0, 1, 2)
+	assert.NoError(err)
+	assert.Nil(m)
+	assert.Equal(12.0, max)
+
+	// Log space zeros stay zero
+	lf.Table[1] = math.Inf(-1)
+	lf.Table[3] = math.Inf(-1)
+	lf.Table[5] = math.Inf(-1)
+	ls, err = lf.SumTo([]*Variable{a, c})
+	assert.NoError(err)
+	assert.True(math.IsInf(ls.Table[1], -1))
+	assert.InDelta(math.Log(9.0), ls.Table[0], 1e-9)
+
+	// Errors
+	_, err = f.SumTo(nil)
+	assert.Error(err)
+	_, err = f.SumTo([]*Variable{a, a})
+	assert.Error(err)
+	_, err = lm.MaxTo([]*Variable{a})
+	assert.Error(err)
+	_, _, err = f.SumOut(7)
+	assert.Error(err)
+}
+
+func TestFactorReduceMask(t *testing.T) {
+	assert := assert.New(t)
+	a, b, c := factorVars()
+
+	f := &Function{"ABC", []*Variable{a, b, c}, make([]float64, 12), false, false}
+	for i := range f.Table {
+		f.Table[i] = float64(i + 1)
+	}
+
+	// B=2 leaves A, C
+	r, one, err := f.Reduce(Evidence{1: 2, 9: 0})
+	assert.NoError(err)
+	assert.Equal(1.0, one)
+	assert.Equal([]*Variable{a, c}, r.Vars)
+	assert.Equal([]float64{5, 6, 11, 12}, r.Table)
+
+	// Everything fixed
+	r, val, err := f.ToLog().Reduce(Evidence{0: 1, 1: 0, 2: 1})
+	assert.NoError(err)
+	assert.Nil(r)
+	assert.InDelta(math.Log(8.0), val, 1e-9)
+
+	// No evidence
+	r, _, err = f.Reduce(Evidence{})
+	assert.NoError(err)
+	assert.Equal(f.Table, r.Table)
+
+	_, _, err = f.Reduce(Evidence{2: 2})
+	assert.Error(err)
+
+	// Mask keeps the scope, with evidence looked up by ID
+	ev := []*Variable{a.Clone(), b.Clone(), c.Clone()}
+	ev[1].FixedVal = 2
+	ev[2].Allowed = []bool{false, true}
+	mk, err := f.Mask(ev)
+	assert.NoError(err)
+	assert.Equal(factorIDs(f), factorIDs(mk))
+	assert.Equal([]float64{0, 0, 0, 0, 0, 6, 0, 0, 0, 0, 0, 12}, mk.Table)
+	lmk, err := f.ToLog().Mask(ev)
+	assert.NoError(err)
+	assert.True(math.IsInf(lmk.Table[0], -1))
+	assert.InDelta(math.Log(6.0), lmk.Table[5], 1e-9)
+
+	_, err = f.Mask(ev[:2])
+	assert.Error(err)
+}
+
+func TestFactorDivideNormalize(t *testing.T) {
+	assert := assert.New(t)
+	a, b, _ := factorVars()
+
+	ab := &Function{"AB", []*Variable{a, b}, []float64{0, 2, 3, 4, 5, 6}, false, false}
+	bf := &Function{"B", []*Variable{b}, []float64{0, 2, 4}, false, false}
+
+	d, err := ab.Divide(bf)
+	assert.NoError(err)
+	assert.Equal([]float64{0, 1, 0.75, 0, 2.5, 1.5}, d.Table)
+	ld, err := ab.ToLog().Divide(bf)
+	assert.NoError(err)
+	assert.True(ld.IsLog)
+	assert.InDeltaSlice(d.Table, ld.ToLinear().Table, 1e-9)
+
+	// Dividing by a product gives back the original
+	p, err := ab.Multiply(bf)
+	assert.NoError(err)
+	d, err = p.Divide(bf)
+	assert.NoError(err)
+	assert.Equal([]float64{0, 2, 3, 0, 5, 6}, d.Table)
+
+	_, err = bf.Divide(ab)
+	assert.Error(err)
+
+	n, logSum, err := ab.Normalize()
+	assert.NoError(err)
+	assert.InDelta(math.Log(20.0), logSum, 1e-9)
+	assert.InDeltaSlice([]float64{0, 0.1, 0.15, 0.2, 0.25, 0.3}, n.Table, 1e-9)
+	ln, lLogSum, err := ab.ToLog().Normalize()
+	assert.NoError(err)
+	assert.InDelta(logSum, lLogSum, 1e-9)
+	assert.InDeltaSlice(n.Table, ln.ToLinear().Table, 1e-9)
+
+	_, _, err = (&Function{"Z", []*Variable{a}, []float64{0, 0}, false, false}).Normalize()
+	assert.Error(err)
+}
+
+func TestFactorReorder(t *testing.T) {
+	assert := assert.New(t)
+	a, b, c := factorVars()
+
+	f := &Function{"ABC", []*Variable{a, b, c}, make([]float64, 12), true, false}
+	for i := range f.Table {
+		f.Table[i] = float64(i)
+	}
+
+	r, err := f.Reorder([]*Variable{c, a, b})
+	assert.NoError(err)
+	assert.True(r.IsLog)
+	assert.Equal([]*Variable{c, a, b}, r.Vars)
+	for ai := 0; ai < 2; ai++ {
+		for bi := 0; bi < 3; bi++ {
+			for ci := 0; ci < 2; ci++ {
+				full := []int{ai, bi, ci}
+				assert.Equal(evalAt(t, f, full), evalAt(t, r, full))
+			}
+		}
+	}
+
+	back, err := r.Reorder(f.Vars)
+	assert.NoError(err)
+	assert.Equal(f.Table, back.Table)
+
+	_, err = f.Reorder([]*Variable{a, b})
+	assert.Error(err)
+	_, err = f.Reorder([]*Variable{a, b, b})
+	assert.Error(err)
+}
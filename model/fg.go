@@ -0,0 +1,209 @@
+package model
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FGReader reads the libDAI factor graph (.fg) format. A factor lists its
+// variable labels, their cardinalities, and then the sparse nonzero table
+// entries. Labels are arbitrary non-negative integers: our variables are the
+// labels in sorted order and each variable is named with its label. libDAI
+// tables have the FIRST variable changing fastest, so entries are reordered
+// into our most to least significant order (with the variables kept in the
+// listed order). Lines starting with # are comments.
+//
+// libDAI has no evidence file format, so evidence is in the UAI format (with
+// variable indexes in our order).
+type FGReader struct {
+}
+
+// fgPreprocess removes comment lines
+func fgPreprocess(data []byte) string {
+	lines := strings.Split(string(data), "\n")
+	for i, ln := range lines {
+		if strings.HasPrefix(strings.TrimSpace(ln), "#") {
+			lines[i] = ""
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// fgFactor is a factor as read from the file (before we know all variables)
+type fgFactor struct {
+	labels []int
+	cards  []int
+	index  []int
+	vals   []float64
+}
+
+// ReadModel implements the model.Reader interface
+func (r FGReader) ReadModel(data []byte) (*Model, error) {
+	fr := NewFieldReader(fgPreprocess(data))
+
+	funcCount, err := fr.ReadInt()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not read factor count")
+	}
+	if funcCount < 1 {
+		return nil, errors.Errorf("Invalid factor count %d", funcCount)
+	}
+
+	factors := make([]*fgFactor, funcCount)
+	cards := make(map[int]int)
+	for i := range factors {
+		fac := &fgFactor{}
+		factors[i] = fac
+
+		varCount, err := fr.ReadInt()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not read var count for factor %d", i)
+		}
+		if varCount < 1 {
+			return nil, errors.Errorf("Invalid var count %d for factor %d", varCount, i)
+		}
+
+		fac.labels = make([]int, varCount)
+		for j := range fac.labels {
+			fac.labels[j], err = fr.ReadInt()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Could not read var label %d for factor %d", j, i)
+			}
+			if fac.labels[j] < 0 {
+				return nil, errors.Errorf("Invalid var label %d for factor %d", fac.labels[j], i)
+			}
+		}
+
+		fac.cards = make([]int, varCount)
+		for j := range fac.cards {
+			fac.cards[j], err = fr.ReadInt()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Could not read var card %d for factor %d", j, i)
+			}
+			lbl := fac.labels[j]
+			if prev, ok := cards[lbl]; ok && prev != fac.cards[j] {
+				return nil, errors.Errorf("Var %d has card %d in factor %d but was %d", lbl, fac.cards[j], i, prev)
+			}
+			cards[lbl] = fac.cards[j]
+		}
+
+		entries, err := fr.ReadInt()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not read entry count for factor %d", i)
+		}
+		if entries < 0 {
+			return nil, errors.Errorf("Invalid entry count %d for factor %d", entries, i)
+		}
+		fac.index = make([]int, entries)
+		fac.vals = make([]float64, entries)
+		for j := 0; j < entries; j++ {
+			fac.index[j], err = fr.ReadInt()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Could not read entry %d index for factor %d", j, i)
+			}
+			fac.vals[j], err = fr.ReadFloat()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Could not read entry %d value for factor %d", j, i)
+			}
+		}
+	}
+
+	if _, err := fr.Read(); err == nil {
+		return nil, errors.Errorf("Extra data found after %d factors", funcCount)
+	}
+
+	// Variables are the labels in sorted order
+	labels := make([]int, 0, len(cards))
+	for lbl := range cards {
+		labels = append(labels, lbl)
+	}
+	sort.Ints(labels)
+
+	m := &Model{Type: MARKOV}
+	byLabel := make(map[int]*Variable)
+	for i, lbl := range labels {
+		v, err := NewVariable(i, cards[lbl])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not create var %d", lbl)
+		}
+		v.Name = strconv.Itoa(lbl)
+		byLabel[lbl] = v
+		m.Vars = append(m.Vars, v)
+	}
+
+	for i, fac := range factors {
+		vars := make([]*Variable, len(fac.labels))
+		for j, lbl := range fac.labels {
+			vars[j] = byLabel[lbl]
+		}
+
+		f, err := NewFunction(i, vars)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not create function for factor %d", i)
+		}
+
+		for j, idx := range fac.index {
+			if idx < 0 || idx >= len(f.Table) {
+				return nil, errors.Errorf("Invalid index %d for factor %d with table size %d", idx, i, len(f.Table))
+			}
+			f.Table[fgToIndex(idx, vars)] = fac.vals[j]
+		}
+
+		m.Funcs = append(m.Funcs, f)
+	}
+
+	return m, nil
+}
+
+// fgToIndex converts a libDAI table index (first var fastest) to our table
+// index (last var fastest)
+func fgToIndex(fgIdx int, vars []*Variable) int {
+	idx := 0
+	stride := calcTabSize(vars)
+	for _, v := range vars {
+		stride /= v.Card
+		idx += (fgIdx % v.Card) * stride
+		fgIdx /= v.Card
+	}
+	return idx
+}
+
+// fgFromIndex converts our table index (last var fastest) to a libDAI table
+// index (first var fastest)
+func fgFromIndex(idx int, vars []*Variable) int {
+	fgIdx := 0
+	stride := calcTabSize(vars)
+	for i := len(vars) - 1; i >= 0; i-- {
+		card := vars[i].Card
+		stride /= card
+		fgIdx += (idx % card) * stride
+		idx /= card
+	}
+	return fgIdx
+}
+
+// ApplyEvidence is part of the reader interface: evidence is in the UAI format
+func (r FGReader) ApplyEvidence(data []byte, m *Model) error {
+	return UAIReader{}.ApplyEvidence(data, m)
+}
+
+// ReadEvidence implements the model.EvidenceReader interface: evidence is in
+// the UAI format
+func (r FGReader) ReadEvidence(data []byte) ([]Evidence, error) {
+	return UAIReader{}.ReadEvidence(data)
+}
+
+// ReadSoftEvidence implements the model.SoftEvidenceReader interface: soft
+// evidence is in the UAI format
+func (r FGReader) ReadSoftEvidence(data []byte) (SoftEvidence, error) {
+	return UAIReader{}.ReadSoftEvidence(data)
+}
+
+// ReadDomainEvidence implements the model.DomainEvidenceReader interface:
+// domain evidence is in the UAI format
+func (r FGReader) ReadDomainEvidence(data []byte) (DomainEvidence, error) {
+	return UAIReader{}.ReadDomainEvidence(data)
+}
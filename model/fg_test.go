@@ -0,0 +1,174 @@
+package model
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const FGExample = `# Labels don't need to be contiguous
+2
+
+1
+3
+3
+3
+0 0.2
+1 0.3
+2 0.5
+
+2
+5 3
+2 3
+5
+0 1
+2 3
+4 5
+3 4
+5 6
+`
+
+func TestFGRead(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := NewModelFromBuffer(FGReader{}, []byte(FGExample))
+	assert.NoError(err)
+	assert.Equal(MARKOV, m.Type)
+
+	assert.Equal(2, len(m.Vars))
+	assert.Equal("3", m.Vars[0].Name)
+	assert.Equal(3, m.Vars[0].Card)
+	assert.Equal("5", m.Vars[1].Name)
+	assert.Equal(2, m.Vars[1].Card)
+
+	assert.Equal(2, len(m.Funcs))
+	assert.Equal([]*Variable{m.Vars[0]}, m.Funcs[0].Vars)
+	assert.Equal([]float64{0.2, 0.3, 0.5}, m.Funcs[0].Table)
+
+	// Listed var order is kept, but the first var changes fastest in libDAI
+	// and index 1 is missing (so zero)
+	assert.Equal([]*Variable{m.Vars[1], m.Vars[0]}, m.Funcs[1].Vars)
+	assert.Equal([]float64{1, 3, 5, 0, 4, 6}, m.Funcs[1].Table)
+
+	// Evidence is UAI
+	r := FGReader{}
+	assert.NoError(r.ApplyEvidence([]byte("1 1 1"), m))
+	assert.Equal(-1, m.Vars[0].FixedVal)
+	assert.Equal(1, m.Vars[1].FixedVal)
+	evid, err := r.ReadEvidence([]byte("2\n1 0 2\n0\n"))
+	assert.NoError(err)
+	assert.Equal([]Evidence{{0: 2}, {}}, evid)
+}
+
+func TestFGIndex(t *testing.T) {
+	assert := assert.New(t)
+
+	vars := make([]*Variable, 3)
+	for i, card := range []int{2, 3, 4} {
+		v, err := NewVariable(i, card)
+		assert.NoError(err)
+		vars[i] = v
+	}
+
+	// First var fastest in libDAI, last var fastest for us
+	assert.Equal(0, fgToIndex(0, vars))
+	assert.Equal(12, fgToIndex(1, vars))
+	assert.Equal(4, fgToIndex(2, vars))
+	assert.Equal(1, fgToIndex(6, vars))
+
+	seen := make(map[int]bool)
+	for i := 0; i < 24; i++ {
+		idx := fgToIndex(i, vars)
+		assert.False(seen[idx])
+		seen[idx] = true
+		assert.Equal(i, fgFromIndex(idx, vars))
+	}
+}
+
+func TestFGBad(t *testing.T) {
+	assert := assert.New(t)
+
+	bad := []string{
+		"",
+		"0",
+		"1",
+		"1\n0\n",
+		"1\n1\n-1\n2\n0\n",
+		"1\n1\n0\n",
+		"1\n1\n0\n2\n",
+		"1\n1\n0\n2\n1\n",
+		"1\n1\n0\n2\n1\n2 0.5\n",
+		"1\n1\n0\n2\n1\n0 x\n",
+		"1\n1\n0\n2\n1\n0 0.5\nextra",
+		"2\n1\n0\n2\n1\n0 0.5\n1\n0\n3\n1\n0 0.5\n",
+	}
+
+	for _, b := range bad {
+		_, err := NewModelFromBuffer(FGReader{}, []byte(b))
+		assert.Error(err, b)
+	}
+}
+
+func TestFGWriteModel(t *testing.T) {
+	assert := assert.New(t)
+
+	w := FGWriter{}
+	r := FGReader{}
+
+	buf := &bytes.Buffer{}
+	assert.Error(w.WriteModel(buf, nil))
+	assert.Error(w.WriteModel(buf, &Model{Type: MARKOV}))
+
+	for _, fn := range []string{"one.uai", "sample.uai", "deterministic.uai", "Grids_11.uai", "Promedus_11.uai"} {
+		m, err := NewModelFromFile(UAIReader{}, "../res/"+fn, false)
+		assert.NoError(err)
+
+		buf.Reset()
+		assert.NoError(w.WriteModel(buf, m))
+		m2, err := NewModelFromBuffer(r, buf.Bytes())
+		assert.NoError(err)
+		checkSameModel(assert, m, m2, 0.0)
+	}
+
+	// The zero in the sample model isn't written, and the tables are reordered
+	m, err := NewModelFromFile(UAIReader{}, "../res/sample.uai", false)
+	assert.NoError(err)
+	buf.Reset()
+	assert.NoError(w.WriteModel(buf, m))
+	assert.Equal(
+		"# ../res/sample\n3\n"+
+			"\n1\n0\n2\n2\n0 0.436\n1 0.564\n"+
+			"\n2\n0 1\n2 2\n4\n0 0.128\n1 0.92\n2 0.872\n3 0.08\n"+
+			"\n2\n1 2\n2 3\n5\n0 0.21\n1 0.811\n2 0.333\n4 0.457\n5 0.189\n",
+		buf.String(),
+	)
+
+	// Our FG reader works the same as our UAI reader
+	m2, err := NewModelFromBuffer(r, buf.Bytes())
+	assert.NoError(err)
+	for _, state := range [][]int{{0, 0, 0}, {0, 1, 0}, {1, 0, 2}, {1, 1, 1}} {
+		lp1, err := m.LogProb(state)
+		assert.NoError(err)
+		lp2, err := m2.LogProb(state)
+		assert.NoError(err)
+		assert.Equal(lp1, lp2)
+	}
+
+	// Log space is converted back
+	for _, f := range m.Funcs {
+		assert.NoError(f.UseLogSpace())
+	}
+	buf.Reset()
+	assert.NoError(w.WriteModel(buf, m))
+	m2, err = NewModelFromBuffer(r, buf.Bytes())
+	assert.NoError(err)
+	checkSameModel(assert, m, m2, 1e-12)
+
+	// Evidence is UAI
+	buf.Reset()
+	assert.NoError(w.WriteEvidence(buf, []Evidence{{1: 1}}))
+	evid, err := r.ReadEvidence(buf.Bytes())
+	assert.NoError(err)
+	assert.Equal([]Evidence{{1: 1}}, evid)
+}
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FGWriter writes the libDAI factor graph format read by FGReader. Variable
+// labels are the variable IDs, each function's variables are written in
+// order, and only the nonzero table entries are written (in libDAI order,
+// with the first variable changing fastest). Functions in log space are
+// converted back. Evidence is written in the UAI format.
+type FGWriter struct {
+}
+
+// WriteModel implements the model.Writer interface
+func (w FGWriter) WriteModel(out io.Writer, m *Model) error {
+	if m == nil || len(m.Vars) < 1 || len(m.Funcs) < 1 {
+		return errors.New("Can not write an empty model")
+	}
+	for i, v := range m.Vars {
+		if i != v.ID {
+			return errors.Errorf("Invalid ID for var %s: expected %d but was %d", v.Name, i, v.ID)
+		}
+	}
+
+	bw := bufio.NewWriter(out)
+
+	if len(m.Name) > 0 {
+		bw.WriteString("# " + strings.Join(strings.Fields(m.Name), " ") + "\n")
+	}
+	bw.WriteString(strconv.Itoa(len(m.Funcs)))
+	bw.WriteByte('\n')
+
+	fgTable := []float64{}
+	for _, f := range m.Funcs {
+		if len(f.Vars) < 1 {
+			return errors.Errorf("Function %s has no variables", f.Name)
+		}
+		if len(f.Table) != calcTabSize(f.Vars) {
+			return errors.Errorf("Function %s has table size %d but expected %d", f.Name, len(f.Table), calcTabSize(f.Vars))
+		}
+
+		bw.WriteByte('\n')
+		bw.WriteString(strconv.Itoa(len(f.Vars)))
+		bw.WriteByte('\n')
+		for i, v := range f.Vars {
+			if v.ID < 0 || v.ID >= len(m.Vars) || m.Vars[v.ID].Card != v.Card {
+				return errors.Errorf("Function %s has var %s which does not match the model", f.Name, v.Name)
+			}
+			if i > 0 {
+				bw.WriteByte(' ')
+			}
+			bw.WriteString(strconv.Itoa(v.ID))
+		}
+		bw.WriteByte('\n')
+		for i, v := range f.Vars {
+			if i > 0 {
+				bw.WriteByte(' ')
+			}
+			bw.WriteString(strconv.Itoa(v.Card))
+		}
+		bw.WriteByte('\n')
+
+		// Reorder to libDAI order and find the nonzero entries
+		if cap(fgTable) < len(f.Table) {
+			fgTable = make([]float64, len(f.Table))
+		}
+		fgTable = fgTable[:len(f.Table)]
+		nonzero := 0
+		for i, val := range f.Table {
+			if f.IsLog {
+				val = math.Exp(val)
+			}
+			fgTable[fgFromIndex(i, f.Vars)] = val
+			if val != 0.0 {
+				nonzero++
+			}
+		}
+
+		bw.WriteString(strconv.Itoa(nonzero))
+		bw.WriteByte('\n')
+		for i, val := range fgTable {
+			if val == 0.0 {
+				continue
+			}
+			bw.WriteString(strconv.Itoa(i))
+			bw.WriteByte(' ')
+			writeFloat(bw, val)
+			bw.WriteByte('\n')
+		}
+	}
+
+	return bw.Flush()
+}
+
+// WriteEvidence implements the model.Writer interface (using the UAI format)
+func (w FGWriter) WriteEvidence(out io.Writer, evid []Evidence) error {
+	return UAIWriter{}.WriteEvidence(out, evid)
+}
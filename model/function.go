@@ -39,6 +39,8 @@ type Function struct {
 	Vars  []*Variable // Vars in function
 	Table []float64   // CPT - len is product of variables' Card
 	IsLog bool        // True if values are log(v) - default is false
+
+	SoftEvidence bool // True if this holds a variable's soft evidence (see AddSoftEvidence)
 }
 
 // calcTabSize return the correct size for the function's table. If the
@@ -95,6 +97,8 @@ func (f *Function) Clone() *Function {
 		Vars:  make([]*Variable, len(f.Vars)),
 		Table: make([]float64, len(f.Table)),
 		IsLog: f.IsLog,
+
+		SoftEvidence: f.SoftEvidence,
 	}
 
 	for i, v := range f.Vars {
@@ -156,6 +160,29 @@ func (f *Function) Eval(values []int) (float64, error) {
 	return f.Table[i], nil
 }
 
+// EvalState is like Eval, but the values are taken from a full model state
+// (indexed by variable ID) instead of being in the same order as f.Vars.
+func (f *Function) EvalState(state []int) (float64, error) {
+	digit := 1
+	location := 0
+
+	for i := len(f.Vars) - 1; i >= 0; i-- {
+		v := f.Vars[i]
+		if v.ID < 0 || v.ID >= len(state) {
+			return math.NaN(), errors.Errorf("Var %s (ID %d) not in state of size %d", v.Name, v.ID, len(state))
+		}
+		val := state[v.ID]
+		if val < 0 || val >= v.Card {
+			return math.NaN(), errors.Errorf("Value %d invalid for cardinality %d for var %s", val, v.Card, v.Name)
+		}
+
+		location += digit * val
+		digit *= v.Card
+	}
+
+	return f.Table[location], nil
+}
+
 // AddValue allows value adding based on the current input setting in values.
 // This is used for building new functions (i.e see collapsed-gibbs)
 func (f *Function) AddValue(values []int, inc float64) error {
@@ -8,10 +8,10 @@ import (
 )
 
 func testVars() (v0, v1, v2, v3 *Variable) {
-	v0 = &Variable{0, "V0", 0, -1, []float64{}, nil, false}
-	v1 = &Variable{1, "V1", 1, -1, []float64{1.0}, nil, false}
-	v2 = &Variable{2, "V2", 2, -1, []float64{0.25, 0.75}, nil, false}
-	v3 = &Variable{3, "V2", 3, -1, []float64{0.25, 0.70, 0.05}, nil, false}
+	v0 = &Variable{0, "V0", 0, -1, []float64{}, nil, false, nil, nil}
+	v1 = &Variable{1, "V1", 1, -1, []float64{1.0}, nil, false, nil, nil}
+	v2 = &Variable{2, "V2", 2, -1, []float64{0.25, 0.75}, nil, false, nil, nil}
+	v3 = &Variable{3, "V2", 3, -1, []float64{0.25, 0.70, 0.05}, nil, false, nil, nil}
 	return
 }
 
@@ -29,18 +29,18 @@ func TestFuncBadCheck(t *testing.T) {
 	assert.NoError(v3.Check())
 
 	cases := []*Function{
-		{"Bad-NoVarHaveTable", []*Variable{}, []float64{0.5, 0.5}, false},
-		{"Bad-0Var", []*Variable{v0}, []float64{}, false},
+		{"Bad-NoVarHaveTable", []*Variable{}, []float64{0.5, 0.5}, false, false},
+		{"Bad-0Var", []*Variable{v0}, []float64{}, false, false},
 
-		{"Bad-1Var1BadTable", []*Variable{v1}, []float64{0.5, 0.5}, false},
-		{"Bad-1Var2BadTable", []*Variable{v2}, []float64{0.5, 0.5, 0.5}, false},
-		{"Bad-1Var3BadTable", []*Variable{v3}, []float64{0.5}, false},
+		{"Bad-1Var1BadTable", []*Variable{v1}, []float64{0.5, 0.5}, false, false},
+		{"Bad-1Var2BadTable", []*Variable{v2}, []float64{0.5, 0.5, 0.5}, false, false},
+		{"Bad-1Var3BadTable", []*Variable{v3}, []float64{0.5}, false, false},
 
-		{"Bad-2VarBadVar", []*Variable{v2, v0}, []float64{0.5, 0.5}, false},
-		{"Bad-2VarBadTableHi", []*Variable{v2, v2}, []float64{0.5, 0.5, 0.5, 0.5, 0.5}, false},
-		{"Bad-2VarBadTableLo", []*Variable{v2, v2}, []float64{0.5, 0.5}, false},
+		{"Bad-2VarBadVar", []*Variable{v2, v0}, []float64{0.5, 0.5}, false, false},
+		{"Bad-2VarBadTableHi", []*Variable{v2, v2}, []float64{0.5, 0.5, 0.5, 0.5, 0.5}, false, false},
+		{"Bad-2VarBadTableLo", []*Variable{v2, v2}, []float64{0.5, 0.5}, false, false},
 
-		{"Bad-3VarBadTable", []*Variable{v1, v2, v3}, []float64{0.5, 0.5, 0.5, 0.5, 0.5}, false},
+		{"Bad-3VarBadTable", []*Variable{v1, v2, v3}, []float64{0.5, 0.5, 0.5, 0.5, 0.5}, false, false},
 	}
 
 	for _, f := range cases {
@@ -61,15 +61,15 @@ func TestFuncGoodCheck(t *testing.T) {
 	assert.NoError(v3.Check())
 
 	cases := []*Function{
-		{"Good-1Var1", []*Variable{v1}, []float64{0.5}, false},
-		{"Good-1Var2", []*Variable{v2}, []float64{0.5, 0.5}, false},
-		{"Good-1Var3", []*Variable{v3}, []float64{0.5, 0.5, 0.5}, false},
+		{"Good-1Var1", []*Variable{v1}, []float64{0.5}, false, false},
+		{"Good-1Var2", []*Variable{v2}, []float64{0.5, 0.5}, false, false},
+		{"Good-1Var3", []*Variable{v3}, []float64{0.5, 0.5, 0.5}, false, false},
 
-		{"Good-2VarBin", []*Variable{v2, v2}, []float64{0.5, 0.5, 0.5, 0.5}, false},
-		{"Good-2VarMad", []*Variable{v2, v3}, []float64{0.5, 0.5, 0.5, 0.5, 0.5, 0.5}, false},
+		{"Good-2VarBin", []*Variable{v2, v2}, []float64{0.5, 0.5, 0.5, 0.5}, false, false},
+		{"Good-2VarMad", []*Variable{v2, v3}, []float64{0.5, 0.5, 0.5, 0.5, 0.5, 0.5}, false, false},
 
-		{"Good-3VarAll", []*Variable{v1, v2, v3}, []float64{0.5, 0.5, 0.5, 0.5, 0.5, 0.5}, false},
-		{"Good-3VarNo1", []*Variable{v3, v2, v2}, []float64{0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5}, false},
+		{"Good-3VarAll", []*Variable{v1, v2, v3}, []float64{0.5, 0.5, 0.5, 0.5, 0.5, 0.5}, false, false},
+		{"Good-3VarNo1", []*Variable{v3, v2, v2}, []float64{0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5}, false, false},
 	}
 
 	for _, f := range cases {
@@ -100,6 +100,7 @@ func TestFuncTestEval(t *testing.T) {
 			5.06, // 1 2
 		},
 		false,
+		false,
 	}
 
 	passCases := []struct {
@@ -181,6 +182,7 @@ func TestFuncClone(t *testing.T) {
 			5.06, // 1 2
 		},
 		false,
+		false,
 	}
 
 	f2 := f1.Clone()
@@ -0,0 +1,264 @@
+package model
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// HuginReader reads discrete Bayesian networks in the Hugin .net format.
+// Variable (node) names and state names are kept (see Variable.Labels). Each
+// potential becomes a function with the parents first and the child last. The
+// data for a potential is nested by parent with the child innermost, so the
+// flattened values are already in our most to least significant table order.
+// A potential without data gets a uniform CPT (as in Hugin).
+type HuginReader struct {
+}
+
+// ReadModel implements the model.Reader interface
+func (r HuginReader) ReadModel(data []byte) (*Model, error) {
+	p := &bnParser{tokens: bnTokens(string(data), "%", false)}
+	if p.done() {
+		return nil, errors.New("No Hugin data found")
+	}
+
+	m := &Model{Type: BAYES}
+	byName := make(map[string]*Variable)
+
+	for !p.done() {
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t {
+		case "net":
+			if err := p.expect("{"); err != nil {
+				return nil, err
+			}
+			if err := p.skipBlock(); err != nil {
+				return nil, err
+			}
+
+		case "discrete", "node":
+			if t == "discrete" {
+				if err := p.expect("node"); err != nil {
+					return nil, err
+				}
+			}
+			v, err := p.huginNode(len(m.Vars))
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := byName[v.Name]; dup {
+				return nil, errors.Errorf("Node %s is declared twice", v.Name)
+			}
+			byName[v.Name] = v
+			m.Vars = append(m.Vars, v)
+
+		case "potential":
+			f, err := p.huginPotential(len(m.Funcs), byName)
+			if err != nil {
+				return nil, err
+			}
+			m.Funcs = append(m.Funcs, f)
+
+		case "continuous", "decision", "utility", "class":
+			return nil, errors.Errorf("Hugin %s nodes are not supported", t)
+
+		default:
+			return nil, errors.Errorf("Unknown Hugin block %s (token %d)", t, p.pos-1)
+		}
+	}
+
+	if len(m.Vars) < 1 {
+		return nil, errors.New("No nodes found in Hugin data")
+	}
+
+	seen := make(map[string]bool)
+	for _, f := range m.Funcs {
+		child := f.Vars[len(f.Vars)-1].Name
+		if seen[child] {
+			return nil, errors.Errorf("Node %s has more than one potential", child)
+		}
+		seen[child] = true
+	}
+
+	return m, nil
+}
+
+// huginNode parses a node block (after the keyword)
+func (p *bnParser) huginNode(id int) (*Variable, error) {
+	name, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, errors.Wrapf(err, "Node %s", name)
+	}
+
+	var labels []string
+	for {
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if t == "}" {
+			break
+		}
+		if t != "states" {
+			if err := p.skipStatement(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// states = ("s1" "s2" ...);
+		if err := p.expect("="); err != nil {
+			return nil, errors.Wrapf(err, "Node %s states", name)
+		}
+		if err := p.expect("("); err != nil {
+			return nil, errors.Wrapf(err, "Node %s states", name)
+		}
+		labels, err = p.nameList(")")
+		if err != nil {
+			return nil, errors.Wrapf(err, "Node %s states", name)
+		}
+		if err := p.expect(";"); err != nil {
+			return nil, errors.Wrapf(err, "Node %s states", name)
+		}
+	}
+
+	if len(labels) < 1 {
+		return nil, errors.Errorf("Node %s has no states", name)
+	}
+
+	v, err := NewVariable(id, len(labels))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not create node %s", name)
+	}
+	v.Name = name
+	v.Labels = labels
+	return v, nil
+}
+
+// huginPotential parses a potential block (after the keyword)
+func (p *bnParser) huginPotential(index int, byName map[string]*Variable) (*Function, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	// ( child | parent1 parent2 ... )
+	children := []string{}
+	for p.peek() != "|" && p.peek() != ")" && !p.done() {
+		t, _ := p.next()
+		children = append(children, t)
+	}
+	if len(children) != 1 {
+		return nil, errors.Errorf("Potentials must have exactly one child node, found %d", len(children))
+	}
+	child := children[0]
+
+	var parents []string
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if t == "|" {
+		parents, err = p.nameList(")")
+		if err != nil {
+			return nil, errors.Wrapf(err, "Potential for %s", child)
+		}
+	}
+
+	f, err := cptFunction(index, child, parents, byName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid potential for %s", child)
+	}
+
+	if err := p.expect("{"); err != nil {
+		return nil, errors.Wrapf(err, "Potential for %s", child)
+	}
+	var vals []float64
+	for {
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if t == "}" {
+			break
+		}
+		if t == "model_nodes" || t == "model_data" {
+			return nil, errors.Errorf("Potential for %s uses expressions which are not supported", child)
+		}
+		if t != "data" {
+			if err := p.skipStatement(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if err := p.expect("="); err != nil {
+			return nil, errors.Wrapf(err, "Potential data for %s", child)
+		}
+		vals, err = p.huginData()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Potential data for %s", child)
+		}
+	}
+
+	if vals == nil {
+		card := f.Vars[len(f.Vars)-1].Card
+		for i := range f.Table {
+			f.Table[i] = 1.0 / float64(card)
+		}
+		return f, nil
+	}
+
+	if len(vals) != len(f.Table) {
+		return nil, errors.Errorf("Potential for %s has %d entries but expected %d", child, len(vals), len(f.Table))
+	}
+	copy(f.Table, vals)
+
+	return f, nil
+}
+
+// huginData reads the (possibly nested) lists of numbers in a potential's
+// data through the end of the statement
+func (p *bnParser) huginData() ([]float64, error) {
+	vals := []float64{}
+	depth := 0
+	for {
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth < 0 {
+				return nil, errors.New("Unbalanced parentheses")
+			}
+		case ";":
+			if depth != 0 {
+				return nil, errors.New("Unbalanced parentheses")
+			}
+			return vals, nil
+		default:
+			f, err := strconv.ParseFloat(t, 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid probability %s", t)
+			}
+			vals = append(vals, f)
+		}
+	}
+}
+
+// ApplyEvidence is part of the reader interface. Like BIF we use named
+// evidence (see applyNamedEvidence).
+func (r HuginReader) ApplyEvidence(data []byte, m *Model) error {
+	return applyNamedEvidence(data, m)
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHuginAsia(t *testing.T) {
+	assert := assert.New(t)
+
+	bif, err := NewModelFromFile(BIFReader{}, "../res/asia.bif", false)
+	assert.NoError(err)
+
+	r := HuginReader{}
+	m, err := NewModelFromFile(r, "../res/asia.net", false)
+	assert.NoError(err)
+	assertSameModel(assert, bif, m)
+
+	assert.NoError(r.ApplyEvidence([]byte("smoke = yes; dysp = no"), m))
+	assert.Equal(0, m.Vars[2].FixedVal)
+	assert.Equal(1, m.Vars[7].FixedVal)
+}
+
+func TestHuginUniform(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := NewModelFromBuffer(HuginReader{}, []byte(`
+		net {}
+		discrete node A { states = ("a0" "a1" "a2"); }
+		potential (A) { }
+	`))
+	assert.NoError(err)
+	assert.Equal([]string{"a0", "a1", "a2"}, m.Vars[0].Labels)
+	assert.InDeltaSlice([]float64{1.0 / 3.0, 1.0 / 3.0, 1.0 / 3.0}, m.Funcs[0].Table, 1e-12)
+}
+
+func TestHuginBad(t *testing.T) {
+	assert := assert.New(t)
+
+	node := `node A { states = ("a0" "a1"); } `
+	bad := []string{
+		"",
+		"% just a comment",
+		"net {}",
+		"continuous node A {}",
+		"utility node A {}",
+		"node A { label = \"A\"; }",
+		"node A { states = \"a0\"; }",
+		node + node,
+		node + "bogus",
+		node + "potential (B) { data = (0.5 0.5); }",
+		node + "potential (A | B) { data = (0.5 0.5); }",
+		node + "potential (A A) { data = (0.5 0.5); }",
+		node + "potential (A) { data = (0.5); }",
+		node + "potential (A) { data = (0.5 x); }",
+		node + "potential (A) { data = ((0.5 0.5); }",
+		node + "potential (A) { data = (0.5 0.5)); }",
+		node + "potential (A) { model_nodes = (); }",
+		node + "potential (A) { data = (0.5 0.5); } potential (A) { data = (0.5 0.5); }",
+		node + "potential (A) { data = (0.5 0.5);",
+	}
+
+	for _, b := range bad {
+		_, err := NewModelFromBuffer(HuginReader{}, []byte(b))
+		assert.Error(err, b)
+	}
+}
@@ -0,0 +1,298 @@
+package model
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// JSONReader reads our native JSON model format, which keeps variable names
+// and state labels. An example:
+//
+//	{
+//	  "type": "BAYES",
+//	  "name": "rain",
+//	  "variables": [
+//	    {"name": "Rain", "states": ["yes", "no"]},
+//	    {"name": "Count", "card": 3}
+//	  ],
+//	  "factors": [
+//	    {"name": "P(Rain)", "vars": ["Rain"], "table": [0.2, 0.8]},
+//	    {"vars": ["Rain", "Count"], "table": [0.1, 0.3, 0.6, 0.5, 0.3, 0.2]}
+//	  ],
+//	  "evidence": {"Rain": "yes"}
+//	}
+//
+// Variables are in ID order and need a card, states, or both. Factors list
+// their variables by name and their table is in our usual order (the last
+// variable changes fastest). A factor with "log": true has a table in log
+// space. Evidence in the model file is applied when the model is read.
+//
+// Evidence files are an object (or an array with a single object) mapping
+// variable names (or IDs) to state labels (strings) or values (numbers).
+type JSONReader struct {
+}
+
+// jsonModel is the top level of the JSON model format
+type jsonModel struct {
+	Type      string                 `json:"type"`
+	Name      string                 `json:"name,omitempty"`
+	Variables []jsonVariable         `json:"variables"`
+	Factors   []jsonFactor           `json:"factors"`
+	Evidence  map[string]interface{} `json:"evidence,omitempty"`
+}
+
+type jsonVariable struct {
+	Name   string   `json:"name"`
+	Card   int      `json:"card,omitempty"`
+	States []string `json:"states,omitempty"`
+}
+
+type jsonFactor struct {
+	Name  string    `json:"name,omitempty"`
+	Vars  []string  `json:"vars"`
+	Table []float64 `json:"table"`
+	Log   bool      `json:"log,omitempty"`
+}
+
+// ReadModel implements the model.Reader interface
+func (r JSONReader) ReadModel(data []byte) (*Model, error) {
+	var jm jsonModel
+	if err := json.Unmarshal(data, &jm); err != nil {
+		return nil, errors.Wrap(err, "Invalid JSON model")
+	}
+
+	if jm.Type != BAYES && jm.Type != MARKOV {
+		return nil, errors.Errorf("Unknown model type %s", jm.Type)
+	}
+	if len(jm.Variables) < 1 {
+		return nil, errors.New("No variables found in JSON model")
+	}
+
+	m := &Model{Type: jm.Type, Name: jm.Name}
+	byName := make(map[string]*Variable)
+	for i, jv := range jm.Variables {
+		if len(jv.Name) < 1 {
+			return nil, errors.Errorf("Variable %d has no name", i)
+		}
+		if _, dup := byName[jv.Name]; dup {
+			return nil, errors.Errorf("Variable %s is declared twice", jv.Name)
+		}
+
+		card := jv.Card
+		if card == 0 {
+			card = len(jv.States)
+		} else if jv.States != nil && card != len(jv.States) {
+			return nil, errors.Errorf("Variable %s has card %d but %d states", jv.Name, card, len(jv.States))
+		}
+
+		v, err := NewVariable(i, card)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not create variable %s", jv.Name)
+		}
+		v.Name = jv.Name
+		if jv.States != nil {
+			v.Labels = make([]string, len(jv.States))
+			copy(v.Labels, jv.States)
+		}
+
+		byName[v.Name] = v
+		m.Vars = append(m.Vars, v)
+	}
+
+	for i, jf := range jm.Factors {
+		vars := make([]*Variable, len(jf.Vars))
+		seen := make(map[string]bool)
+		for j, name := range jf.Vars {
+			v, ok := byName[name]
+			if !ok {
+				return nil, errors.Errorf("Unknown variable %s in factor %d", name, i)
+			}
+			if seen[name] {
+				return nil, errors.Errorf("Variable %s is repeated in factor %d", name, i)
+			}
+			seen[name] = true
+			vars[j] = v
+		}
+
+		f, err := NewFunction(i, vars)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not create factor %d", i)
+		}
+		if len(jf.Name) > 0 {
+			f.Name = jf.Name
+		}
+		if len(jf.Table) != len(f.Table) {
+			return nil, errors.Errorf("Factor %s has %d table entries but expected %d", f.Name, len(jf.Table), len(f.Table))
+		}
+		copy(f.Table, jf.Table)
+		f.IsLog = jf.Log
+
+		m.Funcs = append(m.Funcs, f)
+	}
+
+	if len(jm.Evidence) > 0 {
+		e, err := jsonEvidence(jm.Evidence, m)
+		if err != nil {
+			return nil, err
+		}
+		for idx, val := range e {
+			m.Vars[idx].FixedVal = val
+		}
+	}
+
+	return m, nil
+}
+
+// jsonEvidence converts a JSON evidence object to an evidence instance
+func jsonEvidence(obj map[string]interface{}, m *Model) (Evidence, error) {
+	byName := make(map[string]*Variable, len(m.Vars))
+	for _, v := range m.Vars {
+		byName[v.Name] = v
+	}
+
+	e := make(Evidence, len(obj))
+	for key, state := range obj {
+		v, ok := byName[key]
+		if !ok {
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(m.Vars) {
+				return nil, errors.Errorf("Unknown evidence variable %s", key)
+			}
+			v = m.Vars[idx]
+		}
+
+		var val int
+		switch s := state.(type) {
+		case string:
+			var err error
+			if val, err = v.LabelIndex(s); err != nil {
+				return nil, err
+			}
+		case float64:
+			val = int(s)
+			if float64(val) != s || val < 0 || val >= v.Card {
+				return nil, errors.Errorf("Invalid value %v for variable %s with card %d", s, v.Name, v.Card)
+			}
+		default:
+			return nil, errors.Errorf("Invalid evidence value %v for variable %s", state, v.Name)
+		}
+
+		e[v.ID] = val
+	}
+
+	return e, nil
+}
+
+// ApplyEvidence is part of the reader interface
+func (r JSONReader) ApplyEvidence(data []byte, m *Model) error {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		var list []map[string]interface{}
+		if lerr := json.Unmarshal(data, &list); lerr != nil {
+			return errors.Wrap(err, "Invalid JSON evidence")
+		}
+		if len(list) != 1 {
+			return errors.Errorf("Expected one evidence instance but found %d", len(list))
+		}
+		obj = list[0]
+	}
+
+	e, err := jsonEvidence(obj, m)
+	if err != nil {
+		return err
+	}
+	for _, idx := range e.VarIDs() {
+		v := m.Vars[idx]
+		if v.FixedVal != -1 {
+			return errors.Errorf("variable[%d]:%v had previous fixedval %d", idx, v.Name, v.FixedVal)
+		}
+	}
+	for idx, val := range e {
+		m.Vars[idx].FixedVal = val
+	}
+
+	return nil
+}
+
+// JSONWriter writes our native JSON model format (see JSONReader). Current
+// evidence is written with the model (using state labels if there are any).
+type JSONWriter struct {
+}
+
+// WriteModel implements the model.Writer interface
+func (w JSONWriter) WriteModel(out io.Writer, m *Model) error {
+	if m == nil || len(m.Vars) < 1 {
+		return errors.New("Can not write an empty model")
+	}
+
+	jm := jsonModel{
+		Type:      m.Type,
+		Name:      m.Name,
+		Variables: make([]jsonVariable, len(m.Vars)),
+		Factors:   make([]jsonFactor, len(m.Funcs)),
+	}
+
+	names := make(map[string]bool)
+	for i, v := range m.Vars {
+		if i != v.ID {
+			return errors.Errorf("Invalid ID for var %s: expected %d but was %d", v.Name, i, v.ID)
+		}
+		if len(v.Name) < 1 || names[v.Name] {
+			return errors.Errorf("Variable %d needs a unique name but has %q", i, v.Name)
+		}
+		names[v.Name] = true
+
+		jm.Variables[i] = jsonVariable{Name: v.Name, Card: v.Card, States: v.Labels}
+
+		if v.FixedVal >= 0 {
+			if jm.Evidence == nil {
+				jm.Evidence = make(map[string]interface{})
+			}
+			if v.Labels != nil {
+				jm.Evidence[v.Name] = v.Labels[v.FixedVal]
+			} else {
+				jm.Evidence[v.Name] = v.FixedVal
+			}
+		}
+	}
+
+	for i, f := range m.Funcs {
+		if len(f.Table) != calcTabSize(f.Vars) {
+			return errors.Errorf("Function %s has table size %d but expected %d", f.Name, len(f.Table), calcTabSize(f.Vars))
+		}
+		jf := jsonFactor{Name: f.Name, Vars: make([]string, len(f.Vars)), Table: f.Table, Log: f.IsLog}
+		for j, v := range f.Vars {
+			if v.ID < 0 || v.ID >= len(m.Vars) || m.Vars[v.ID].Card != v.Card {
+				return errors.Errorf("Function %s has var %s which does not match the model", f.Name, v.Name)
+			}
+			jf.Vars[j] = m.Vars[v.ID].Name
+		}
+		jm.Factors[i] = jf
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jm)
+}
+
+// WriteEvidence implements the model.Writer interface. We don't have the
+// model, so instances map variable IDs to values.
+func (w JSONWriter) WriteEvidence(out io.Writer, evid []Evidence) error {
+	list := make([]map[string]int, len(evid))
+	for i, e := range evid {
+		list[i] = make(map[string]int, len(e))
+		for idx, val := range e {
+			if idx < 0 || val < 0 {
+				return errors.Errorf("Invalid evidence %d=%d", idx, val)
+			}
+			list[i][strconv.Itoa(idx)] = val
+		}
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(list)
+}
@@ -0,0 +1,132 @@
+package model
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONRead(t *testing.T) {
+	assert := assert.New(t)
+
+	r := JSONReader{}
+	m, err := NewModelFromFile(r, "../res/rain.json", false)
+	assert.NoError(err)
+	assert.NoError(m.Check())
+	assert.Equal(BAYES, m.Type)
+
+	assert.Equal(3, len(m.Vars))
+	assert.Equal("Wet Grass", m.Vars[2].Name)
+	assert.Equal([]string{"on", "off"}, m.Vars[1].Labels)
+
+	assert.Equal(3, len(m.Funcs))
+	f := m.Funcs[2]
+	assert.Equal("P(Wet Grass | Rain, Sprinkler)", f.Name)
+	assert.Equal([]*Variable{m.Vars[0], m.Vars[1], m.Vars[2]}, f.Vars)
+	assert.Equal([]float64{0.99, 0.01, 0.8, 0.2, 0.9, 0.1, 0.0, 1.0}, f.Table)
+	assert.False(f.IsLog)
+
+	// Evidence in the model file is applied
+	assert.Equal([]int{-1, -1, 0}, []int{m.Vars[0].FixedVal, m.Vars[1].FixedVal, m.Vars[2].FixedVal})
+
+	// An evidence file replaces it (names or IDs, labels or values)
+	for _, evid := range []string{`{"Rain": "no"}`, `[{"Rain": 1}]`, `{"0": "no"}`} {
+		assert.NoError(m.SetEvidence(Evidence{}))
+		assert.NoError(r.ApplyEvidence([]byte(evid), m))
+		assert.Equal([]int{1, -1, -1}, []int{m.Vars[0].FixedVal, m.Vars[1].FixedVal, m.Vars[2].FixedVal})
+	}
+
+	assert.Error(r.ApplyEvidence([]byte(`{"Rain": "yes"}`), m))
+	for _, evid := range []string{"", "[]", `[{}, {}]`, `{"Snow": "yes"}`, `{"7": 0}`, `{"Sprinkler": "maybe"}`,
+		`{"Sprinkler": 2}`, `{"Sprinkler": 0.5}`, `{"Sprinkler": true}`} {
+		assert.Error(r.ApplyEvidence([]byte(evid), m), evid)
+	}
+	assert.Equal(-1, m.Vars[1].FixedVal)
+
+	// Card without states and log tables
+	m, err = NewModelFromBuffer(r, []byte(`{"type": "MARKOV", "variables": [{"name": "x", "card": 3}, {"name": "y", "card": 2}],
+		"factors": [{"vars": ["x"], "table": [0, -1, -2], "log": true}], "evidence": {"x": 2}}`))
+	assert.NoError(err)
+	assert.Nil(m.Vars[0].Labels)
+	assert.Equal(2, m.Vars[0].FixedVal)
+	assert.Equal("func-0", m.Funcs[0].Name)
+	assert.True(m.Funcs[0].IsLog)
+}
+
+func TestJSONBad(t *testing.T) {
+	assert := assert.New(t)
+
+	v := `"variables": [{"name": "x", "card": 2}]`
+	bad := []string{
+		"",
+		"[]",
+		`{"type": "BAYES"}`,
+		`{"type": "NOPE", ` + v + `}`,
+		`{"type": "BAYES", "variables": [{"name": "x"}]}`,
+		`{"type": "BAYES", "variables": [{"card": 2}]}`,
+		`{"type": "BAYES", "variables": [{"name": "x", "card": 3, "states": ["a", "b"]}]}`,
+		`{"type": "BAYES", "variables": [{"name": "x", "card": 2}, {"name": "x", "card": 2}]}`,
+		`{"type": "BAYES", ` + v + `, "factors": [{"vars": ["y"], "table": [1, 1]}]}`,
+		`{"type": "BAYES", ` + v + `, "factors": [{"vars": ["x", "x"], "table": [1, 1, 1, 1]}]}`,
+		`{"type": "BAYES", ` + v + `, "factors": [{"vars": [], "table": []}]}`,
+		`{"type": "BAYES", ` + v + `, "factors": [{"vars": ["x"], "table": [1]}]}`,
+		`{"type": "BAYES", ` + v + `, "factors": [{"vars": ["x"], "table": [1, 1]}], "evidence": {"x": 2}}`,
+	}
+
+	for _, b := range bad {
+		_, err := NewModelFromBuffer(JSONReader{}, []byte(b))
+		assert.Error(err, b)
+	}
+}
+
+func TestJSONWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	w := JSONWriter{}
+	r := JSONReader{}
+
+	buf := &bytes.Buffer{}
+	assert.Error(w.WriteModel(buf, nil))
+	assert.Error(w.WriteModel(buf, &Model{Type: MARKOV}))
+
+	// Round trip: names, labels, and evidence are kept
+	m, err := NewModelFromFile(BIFReader{}, "../res/asia.bif", false)
+	assert.NoError(err)
+	assert.NoError(m.SetEvidence(Evidence{6: 0}))
+	assert.NoError(w.WriteModel(buf, m))
+	m2, err := NewModelFromBuffer(r, buf.Bytes())
+	assert.NoError(err)
+	assertSameModel(assert, m, m2)
+	assert.Equal(m.Evidence(), m2.Evidence())
+
+	// Models without labels (or in log space)
+	for _, fn := range []string{"sample.uai", "Grids_11.uai"} {
+		m, err := NewModelFromFile(UAIReader{}, "../res/"+fn, false)
+		assert.NoError(err)
+		assert.NoError(m.SetEvidence(Evidence{1: 1}))
+		assert.NoError(m.Funcs[0].UseLogSpace())
+
+		buf.Reset()
+		assert.NoError(w.WriteModel(buf, m))
+		m2, err := NewModelFromBuffer(r, buf.Bytes())
+		assert.NoError(err)
+		assertSameModel(assert, m, m2)
+		assert.True(m2.Funcs[0].IsLog)
+		assert.Equal(Evidence{1: 1}, m2.Evidence())
+	}
+
+	// Names must be unique
+	m.Vars[1].Name = m.Vars[0].Name
+	assert.Error(w.WriteModel(buf, m))
+
+	// Evidence uses IDs
+	buf.Reset()
+	assert.NoError(w.WriteEvidence(buf, []Evidence{{0: 1, 2: 0}}))
+	m, err = NewModelFromFile(r, "../res/rain.json", false)
+	assert.NoError(err)
+	assert.NoError(m.SetEvidence(Evidence{}))
+	assert.NoError(r.ApplyEvidence(buf.Bytes(), m))
+	assert.Equal(Evidence{0: 1, 2: 0}, m.Evidence())
+	assert.Error(w.WriteEvidence(buf, []Evidence{{-1: 0}}))
+}
@@ -1,10 +1,17 @@
 package model
 
 import (
+	"bufio"
+	"compress/gzip"
+	"io"
 	"io/ioutil"
+	"math"
+	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
 )
 
 // Model type constant string - matches UAI formats
@@ -20,6 +27,46 @@ type Reader interface {
 	ApplyEvidence(data []byte, m *Model) error
 }
 
+// StreamReader is implemented by Readers that can parse a model directly from
+// a stream (so that large models aren't read in to memory first).
+type StreamReader interface {
+	ReadModelFrom(in io.Reader) (*Model, error)
+}
+
+// ReaderForFile returns the model reader for the file's extension: .bif is
+// BIF, .xml and .xmlbif are XMLBIF, .net is Hugin, .fg is libDAI, .cnf and
+// .wcnf are DIMACS, .wcsp is toulbar2 WCSP, .json is our JSON model format,
+// and everything else is UAI. A .gz or .xz extension is ignored (see
+// NewModelFromFile).
+func ReaderForFile(filename string) Reader {
+	filename = UncompressedName(filename)
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".bif":
+		return BIFReader{}
+	case ".xml", ".xmlbif":
+		return XMLBIFReader{}
+	case ".net":
+		return HuginReader{}
+	case ".fg":
+		return FGReader{}
+	case ".cnf", ".wcnf":
+		return DIMACSReader{}
+	case ".wcsp":
+		return WCSPReader{}
+	case ".json":
+		return JSONReader{}
+	default:
+		return UAIReader{}
+	}
+}
+
+// Writer implementors serialize a model and evidence in a format that the
+// matching Reader can parse.
+type Writer interface {
+	WriteModel(w io.Writer, m *Model) error
+	WriteEvidence(w io.Writer, evid []Evidence) error
+}
+
 // Model represent a PGM
 type Model struct {
 	Type  string      // PGM type - should match a constant
@@ -48,25 +95,93 @@ func (m *Model) Clone() *Model {
 	return cp
 }
 
-// NewModelFromFile initializes and creates a model from the specified source.
+// compressedExt returns the file's compression extension (.gz or .xz) or ""
+// if the file isn't compressed
+func compressedExt(filename string) string {
+	ext := filepath.Ext(filename)
+	switch strings.ToLower(ext) {
+	case ".gz", ".xz":
+		return ext
+	default:
+		return ""
+	}
+}
+
+// UncompressedName returns the file name without any compression extension
+// (.gz or .xz). Side files for a model (evidence, MAR and PR solutions) are
+// named from the uncompressed name, so foo.uai.gz uses foo.uai.evid.
+func UncompressedName(filename string) string {
+	return filename[:len(filename)-len(compressedExt(filename))]
+}
+
+// readCloser pairs a (decompressing) reader with the file to close
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// openModelFile opens the file for reading, decompressing .gz and .xz files
+func openModelFile(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(compressedExt(filename)) {
+	case ".gz":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "Invalid gzip file %s", filename)
+		}
+		return readCloser{gz, f}, nil
+	case ".xz":
+		xzr, err := xz.NewReader(bufio.NewReader(f))
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "Invalid xz file %s", filename)
+		}
+		return readCloser{xzr, f}, nil
+	default:
+		return f, nil
+	}
+}
+
+// NewModelFromFile reads the model in the file with the given reader. Files
+// ending in .gz or .xz are decompressed as they are read, and a reader that
+// is also a StreamReader parses the file without reading it all first. The
+// model is named from the file name (without any extensions). If
+// useEvidence is true, evidence is read from the uncompressed file name plus
+// ".evid".
 func NewModelFromFile(r Reader, filename string, useEvidence bool) (*Model, error) {
-	data, err := ioutil.ReadFile(filename)
+	in, err := openModelFile(filename)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Could not READ model from %s", filename)
 	}
+	defer in.Close()
 
-	model, err := NewModelFromBuffer(r, data)
+	var model *Model
+	if sr, ok := r.(StreamReader); ok {
+		model, err = newModelFromStream(sr, in)
+	} else {
+		var data []byte
+		data, err = ioutil.ReadAll(in)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not READ model from %s", filename)
+		}
+		model, err = NewModelFromBuffer(r, data)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	// Name the model from the file
-	var ext = filepath.Ext(filename)
-	model.Name = filename[0 : len(filename)-len(ext)]
+	name := UncompressedName(filename)
+	model.Name = name[:len(name)-len(filepath.Ext(name))]
 
 	// Apply evidence if necessary
 	if useEvidence {
-		err = model.ApplyEvidenceFromFile(r, filename+".evid")
+		err = model.ApplyEvidenceFromFile(r, UncompressedName(filename)+".evid")
 		if err != nil {
 			return nil, err
 		}
@@ -75,6 +190,21 @@ func NewModelFromFile(r Reader, filename string, useEvidence bool) (*Model, erro
 	return model, nil
 }
 
+// newModelFromStream is NewModelFromBuffer for a StreamReader
+func newModelFromStream(sr StreamReader, in io.Reader) (*Model, error) {
+	m, err := sr.ReadModelFrom(in)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not PARSE model")
+	}
+
+	err = m.Check()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Parsed model is not valid")
+	}
+
+	return m, nil
+}
+
 // NewModelFromBuffer creates a model from the given pre-read data
 func NewModelFromBuffer(r Reader, data []byte) (*Model, error) {
 	m, err := r.ReadModel(data)
@@ -97,6 +227,8 @@ func (m *Model) ApplyEvidenceFromFile(r Reader, eviFilename string) error {
 	for _, v := range m.Vars {
 		v.FixedVal = -1
 	}
+	m.ClearSoftEvidence()
+	m.ClearDomains()
 
 	data, err := ioutil.ReadFile(eviFilename)
 	if err != nil {
@@ -111,6 +243,75 @@ func (m *Model) ApplyEvidenceFromFile(r Reader, eviFilename string) error {
 	return nil
 }
 
+// WriteToFile writes the model to the given file (which is overwritten)
+func (m *Model) WriteToFile(w Writer, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "Could not CREATE model file %s", filename)
+	}
+
+	err = w.WriteModel(f, m)
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "Could not WRITE model to %s", filename)
+	}
+
+	return f.Close()
+}
+
+// WriteEvidenceToFile writes the evidence instances to the given file (which
+// is overwritten)
+func WriteEvidenceToFile(w Writer, filename string, evid []Evidence) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "Could not CREATE evidence file %s", filename)
+	}
+
+	err = w.WriteEvidence(f, evid)
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "Could not WRITE evidence to %s", filename)
+	}
+
+	return f.Close()
+}
+
+// Evidence returns the model's current evidence (from the FixedVal of the
+// variables) as an evidence instance
+func (m *Model) Evidence() Evidence {
+	e := make(Evidence)
+	for i, v := range m.Vars {
+		if v.FixedVal >= 0 {
+			e[i] = v.FixedVal
+		}
+	}
+	return e
+}
+
+// LogProb returns the natural log of the unnormalized probability of the
+// given full state (indexed by variable ID). Functions may be in log space or
+// not. A state with zero probability returns -Inf. Evidence is NOT checked.
+func (m *Model) LogProb(state []int) (float64, error) {
+	if len(state) != len(m.Vars) {
+		return math.NaN(), errors.Errorf("State size %d != var count %d", len(state), len(m.Vars))
+	}
+
+	total := 0.0
+	for _, f := range m.Funcs {
+		val, err := f.EvalState(state)
+		if err != nil {
+			return math.NaN(), errors.Wrapf(err, "Could not evaluate function %s", f.Name)
+		}
+		if f.IsLog {
+			total += val
+		} else {
+			total += math.Log(val)
+		}
+	}
+
+	return total, nil
+}
+
 // Check returns an error if there is a problem with the model
 func (m *Model) Check() error {
 	if m.Type != BAYES && m.Type != MARKOV {
@@ -153,5 +354,12 @@ func (m *Model) Check() error {
 		return errors.Errorf("There are %v funcs, but %v names", len(m.Funcs), len(funcNames))
 	}
 
+	if m.Type == BAYES {
+		e := m.CheckBayes(CPTTolerance)
+		if e != nil {
+			return errors.Wrapf(e, "Model %s is not a valid Bayesian network", m.Name)
+		}
+	}
+
 	return nil
 }
@@ -1,17 +1,18 @@
 package model
 
 import (
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func vanillaModel() *Model {
-	v1 := &Variable{0, "V1", 2, -1, []float64{0.5, 0.5}, nil, false}
-	v2 := &Variable{1, "V2", 2, -1, []float64{0.5, 0.5}, nil, false}
+	v1 := &Variable{0, "V1", 2, -1, []float64{0.5, 0.5}, nil, false, nil, nil}
+	v2 := &Variable{1, "V2", 2, -1, []float64{0.5, 0.5}, nil, false, nil, nil}
 
-	f1 := &Function{"F1", []*Variable{v1, v2}, []float64{1.1, 2.2, 3.3, 4.4}, false}
-	f2 := &Function{"F2", []*Variable{v1, v2}, []float64{0.1, 0.2, 0.3, 0.4}, false}
+	f1 := &Function{"F1", []*Variable{v1, v2}, []float64{1.1, 2.2, 3.3, 4.4}, false, false}
+	f2 := &Function{"F2", []*Variable{v1, v2}, []float64{0.1, 0.2, 0.3, 0.4}, false, false}
 
 	return &Model{
 		Type:  "MARKOV",
@@ -60,3 +61,47 @@ func TestModelCreation(t *testing.T) {
 	m.Funcs[0].Table = []float64{0.0}
 	assert.Error(m.Check())
 }
+
+func TestModelLogProb(t *testing.T) {
+	assert := assert.New(t)
+
+	m := vanillaModel()
+
+	lp, err := m.LogProb([]int{1, 0})
+	assert.NoError(err)
+	assert.InDelta(math.Log(3.3*0.3), lp, 1e-12)
+
+	// Log space gives the same answer
+	assert.NoError(m.Funcs[0].UseLogSpace())
+	lp, err = m.LogProb([]int{1, 0})
+	assert.NoError(err)
+	assert.InDelta(math.Log(3.3*0.3), lp, 1e-12)
+
+	// Zero probability
+	m.Funcs[1].Table[3] = 0.0
+	lp, err = m.LogProb([]int{1, 1})
+	assert.NoError(err)
+	assert.True(math.IsInf(lp, -1))
+
+	_, err = m.LogProb([]int{1})
+	assert.Error(err)
+	_, err = m.LogProb([]int{1, 2})
+	assert.Error(err)
+}
+
+func TestReaderForFile(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(UAIReader{}, ReaderForFile("../res/sample.uai"))
+	assert.Equal(UAIReader{}, ReaderForFile("no-extension"))
+	assert.Equal(BIFReader{}, ReaderForFile("../res/asia.bif"))
+	assert.Equal(BIFReader{}, ReaderForFile("ASIA.BIF"))
+	assert.Equal(XMLBIFReader{}, ReaderForFile("../res/asia.xml"))
+	assert.Equal(XMLBIFReader{}, ReaderForFile("asia.xmlbif"))
+	assert.Equal(HuginReader{}, ReaderForFile("../res/asia.net"))
+	assert.Equal(FGReader{}, ReaderForFile("model.fg"))
+	assert.Equal(DIMACSReader{}, ReaderForFile("../res/sat.cnf"))
+	assert.Equal(DIMACSReader{}, ReaderForFile("maxsat.wcnf"))
+	assert.Equal(WCSPReader{}, ReaderForFile("../res/example.wcsp"))
+	assert.Equal(JSONReader{}, ReaderForFile("../res/rain.json"))
+}
@@ -1,9 +1,13 @@
 package model
 
 import (
+	"bufio"
+	"fmt"
 	"io"
 	"strconv"
 	"strings"
+
+	"github.com/pkg/errors"
 )
 
 // FieldReader is just a simple reader for basic file formats.
@@ -47,3 +51,230 @@ func (fr *FieldReader) ReadFloat() (float64, error) {
 
 	return strconv.ParseFloat(s, 64)
 }
+
+// PosError is an error at a position (line and column, both starting at 1)
+// in a file read with a TokenReader.
+type PosError struct {
+	Line int
+	Col  int
+	Err  error
+}
+
+// Error implements the error interface
+func (e *PosError) Error() string {
+	return fmt.Sprintf("line %d, col %d: %v", e.Line, e.Col, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *PosError) Unwrap() error {
+	return e.Err
+}
+
+// token is a single token read by a TokenReader
+type token struct {
+	text  string
+	line  int
+	col   int
+	first bool // first token on its line
+}
+
+// TokenReader reads space-delimited tokens from a stream without reading the
+// whole stream into memory (unlike FieldReader). Lines starting with the
+// comment character (after any leading space) are skipped. The line and
+// column of every token are tracked so that errors can say where they are.
+type TokenReader struct {
+	in        *bufio.Reader
+	comment   byte
+	line      int // Position of the next byte
+	col       int
+	lineStart bool // Only space has been read on the current line
+	lines     int  // Count of lines with a token
+	tokLine   int  // Line of the last token scanned
+	buf       []byte
+	cur       token  // Last token returned by Read
+	next      *token // Token returned by Peek (but not yet by Read)
+	nextErr   error
+}
+
+// NewTokenReader constructs a new token reader around the given stream. If
+// comment is 0 there are no comment lines.
+func NewTokenReader(in io.Reader, comment byte) *TokenReader {
+	return &TokenReader{
+		in:        bufio.NewReaderSize(in, 64*1024),
+		comment:   comment,
+		line:      1,
+		col:       1,
+		lineStart: true,
+	}
+}
+
+// isSpace is true for the ASCII space characters
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\t' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// scan reads the next token from the stream
+func (tr *TokenReader) scan() (token, error) {
+	tok := token{}
+	tr.buf = tr.buf[:0]
+
+	for {
+		c, err := tr.in.ReadByte()
+		if err == io.EOF && len(tr.buf) > 0 {
+			break
+		}
+		if err == io.EOF {
+			return tok, io.EOF
+		}
+		if err != nil {
+			return tok, &PosError{tr.line, tr.col, err}
+		}
+
+		if c == '\n' {
+			tr.line++
+			tr.col = 1
+			tr.lineStart = true
+			if len(tr.buf) > 0 {
+				break
+			}
+			continue
+		}
+
+		col := tr.col
+		tr.col++
+		if isSpace(c) {
+			if len(tr.buf) > 0 {
+				break
+			}
+			continue
+		}
+
+		if len(tr.buf) < 1 {
+			if tr.lineStart && tr.comment != 0 && c == tr.comment {
+				// Comment line: skip to the next line (which may be EOF)
+				for c != '\n' && err == nil {
+					c, err = tr.in.ReadByte()
+				}
+				if err == nil {
+					tr.in.UnreadByte()
+				}
+				continue
+			}
+			tok.line, tok.col, tok.first = tr.line, col, tr.lineStart
+		}
+
+		tr.lineStart = false
+		tr.buf = append(tr.buf, c)
+	}
+
+	if tok.line != tr.tokLine {
+		tr.lines++
+		tr.tokLine = tok.line
+	}
+	tok.text = string(tr.buf)
+	return tok, nil
+}
+
+// Read returns the next token (or io.EOF at the end of the stream)
+func (tr *TokenReader) Read() (string, error) {
+	if tr.next != nil || tr.nextErr != nil {
+		tok, err := tr.next, tr.nextErr
+		tr.next, tr.nextErr = nil, nil
+		if err != nil {
+			return "", err
+		}
+		tr.cur = *tok
+		return tok.text, nil
+	}
+
+	tok, err := tr.scan()
+	if err != nil {
+		return "", err
+	}
+	tr.cur = tok
+	return tok.text, nil
+}
+
+// Peek returns the next token without consuming it
+func (tr *TokenReader) Peek() (string, error) {
+	if tr.next == nil && tr.nextErr == nil {
+		tok, err := tr.scan()
+		if err != nil {
+			tr.nextErr = err
+		} else {
+			tr.next = &tok
+		}
+	}
+	if tr.nextErr != nil {
+		return "", tr.nextErr
+	}
+	return tr.next.text, nil
+}
+
+// Pos returns the line and column of the last token read
+func (tr *TokenReader) Pos() (int, int) {
+	return tr.cur.line, tr.cur.col
+}
+
+// Lines returns the number of lines with at least one token so far
+// (including any token returned by Peek)
+func (tr *TokenReader) Lines() int {
+	return tr.lines
+}
+
+// Errorf returns an error at the position of the last token read
+func (tr *TokenReader) Errorf(format string, args ...interface{}) error {
+	return &PosError{tr.cur.line, tr.cur.col, errors.Errorf(format, args...)}
+}
+
+// read returns the next token, but the end of the stream is an error (with
+// the position where more data was expected)
+func (tr *TokenReader) read() (string, error) {
+	s, err := tr.Read()
+	if err == io.EOF {
+		return "", &PosError{tr.line, tr.col, io.ErrUnexpectedEOF}
+	}
+	return s, err
+}
+
+// ReadInt reads the next token as an int
+func (tr *TokenReader) ReadInt() (int, error) {
+	s, err := tr.read()
+	if err != nil {
+		return 0, err
+	}
+
+	i, err := strconv.ParseInt(s, 10, 0)
+	if err != nil {
+		return 0, tr.Errorf("Invalid integer %q", s)
+	}
+	return int(i), nil
+}
+
+// ReadFloat reads the next token as a float
+func (tr *TokenReader) ReadFloat() (float64, error) {
+	s, err := tr.read()
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, tr.Errorf("Invalid number %q", s)
+	}
+	return f, nil
+}
+
+// SkipTo skips tokens until a line starting with prefix: the first token of
+// that line is the next token read. Returns io.EOF if there is no such line.
+func (tr *TokenReader) SkipTo(prefix string) error {
+	for {
+		if _, err := tr.Peek(); err != nil {
+			return err
+		}
+		if tr.next.first && strings.HasPrefix(tr.next.text, prefix) {
+			return nil
+		}
+		tr.Read()
+	}
+}
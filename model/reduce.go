@@ -0,0 +1,200 @@
+package model
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Reduction is a model with its evidence absorbed: every function is
+// conditioned on the fixed variables, which are then dropped from the model.
+// Functions left without any variables are constant and are removed (their
+// product is kept as LogConst). Samplers never evaluate tables over evidence
+// variables in the reduced model, so heavily-evidenced models are much
+// smaller and faster to sample.
+//
+// The reduced variables have new IDs (0 to N-1 in the original order) and
+// keep their names, labels, domain restrictions, and marginals. VarMap maps
+// back to the original variables so results can be expanded for output.
+type Reduction struct {
+	Model    *Model  // The reduced model (with no evidence)
+	Original *Model  // The model that was reduced
+	VarMap   []int   // Original variable ID for each variable in Model
+	LogConst float64 // Natural log of the product of the removed constant functions
+}
+
+// NewReduction absorbs the current evidence in the model. The original model
+// is not changed. It is an error for the evidence to have zero probability.
+// The reduced model is MARKOV since conditioned CPT's are no longer
+// normalized.
+func NewReduction(m *Model) (*Reduction, error) {
+	r := &Reduction{
+		Model:    &Model{Type: MARKOV, Name: m.Name},
+		Original: m,
+	}
+
+	// Keep the unfixed variables (in order) with new ID's
+	reduced := make([]*Variable, len(m.Vars))
+	for i, v := range m.Vars {
+		if v.ID != i {
+			return nil, errors.Errorf("Invalid ID for var %s: expected %d but was %d", v.Name, i, v.ID)
+		}
+		if v.FixedVal >= 0 {
+			continue
+		}
+
+		cp := v.Clone()
+		cp.ID = len(r.Model.Vars)
+		reduced[i] = cp
+		r.Model.Vars = append(r.Model.Vars, cp)
+		r.VarMap = append(r.VarMap, i)
+	}
+	if len(r.Model.Vars) < 1 {
+		return nil, errors.Errorf("All %d variables in model %s are fixed", len(m.Vars), m.Name)
+	}
+
+	for _, f := range m.Funcs {
+		rf, err := reduceFunction(f, m.Vars, reduced)
+		if err != nil {
+			return nil, err
+		}
+		if len(rf.Vars) > 0 {
+			r.Model.Funcs = append(r.Model.Funcs, rf)
+			continue
+		}
+
+		// Constant function
+		val := rf.Table[0]
+		if !rf.IsLog {
+			val = math.Log(val)
+		}
+		if math.IsInf(val, -1) || math.IsNaN(val) {
+			return nil, errors.Errorf("Evidence has zero probability in function %s", f.Name)
+		}
+		r.LogConst += val
+	}
+
+	err := r.Model.Check()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Reduced model is not valid")
+	}
+
+	return r, nil
+}
+
+// reduceFunction slices the function's table at the fixed values of its
+// variables. Fixed values are taken from the model's variables (a cloned
+// function has its own copies), and the result uses the reduced variables
+// (both indexed by original ID). The result has no variables if every
+// variable was fixed.
+func reduceFunction(f *Function, vars []*Variable, reduced []*Variable) (*Function, error) {
+	// Stride of each variable in the original table (last is fastest)
+	strides := make([]int, len(f.Vars))
+	stride := 1
+	for i := len(f.Vars) - 1; i >= 0; i-- {
+		strides[i] = stride
+		stride *= f.Vars[i].Card
+	}
+	if stride != len(f.Table) {
+		return nil, errors.Errorf("Function %s expected table size %d, found %d", f.Name, stride, len(f.Table))
+	}
+
+	// Offset for the fixed values and the positions of the vars we keep
+	base := 0
+	kept := []int{}
+	rf := &Function{Name: f.Name, IsLog: f.IsLog, SoftEvidence: f.SoftEvidence}
+	for i, v := range f.Vars {
+		if v.ID < 0 || v.ID >= len(vars) {
+			return nil, errors.Errorf("Function %s has var %s with invalid ID %d", f.Name, v.Name, v.ID)
+		}
+		if reduced[v.ID] == nil {
+			base += vars[v.ID].FixedVal * strides[i]
+			continue
+		}
+		kept = append(kept, i)
+		rf.Vars = append(rf.Vars, reduced[v.ID])
+	}
+
+	if len(rf.Vars) < 1 {
+		rf.Table = []float64{f.Table[base]}
+		return rf, nil
+	}
+	rf.Table = make([]float64, calcTabSize(rf.Vars))
+
+	// Walk the kept values in table order
+	vals := make([]int, len(kept))
+	for t := range rf.Table {
+		idx := base
+		for j, k := range kept {
+			idx += vals[j] * strides[k]
+		}
+		rf.Table[t] = f.Table[idx]
+
+		for j := len(vals) - 1; j >= 0; j-- {
+			vals[j]++
+			if vals[j] < rf.Vars[j].Card {
+				break
+			}
+			vals[j] = 0
+		}
+	}
+
+	return rf, nil
+}
+
+// ReduceVars returns clones of the variables in the reduced model, where vars
+// are indexed by original variable ID (e.g. the variables of a solution).
+func (r *Reduction) ReduceVars(vars []*Variable) ([]*Variable, error) {
+	return selectVars(vars, len(r.Original.Vars), r.VarMap)
+}
+
+// ExpandVars maps estimates for the reduced variables back to the original
+// model. The result has a clone of every original variable: unfixed
+// variables get the estimate's marginal and state, and evidence variables get
+// a one-hot marginal for their fixed value.
+func (r *Reduction) ExpandVars(vars []*Variable) ([]*Variable, error) {
+	if len(vars) != len(r.VarMap) {
+		return nil, errors.Errorf("Expected %d reduced variables but found %d", len(r.VarMap), len(vars))
+	}
+
+	exp := make([]*Variable, len(r.Original.Vars))
+	for i, orig := range r.VarMap {
+		v := vars[i].Clone()
+		v.ID = orig
+		v.FixedVal = r.Original.Vars[orig].FixedVal
+		exp[orig] = v
+	}
+	for i, v := range r.Original.Vars {
+		if exp[i] != nil {
+			continue
+		}
+		cp := v.Clone()
+		for c := range cp.Marginal {
+			cp.Marginal[c] = 0.0
+		}
+		cp.Marginal[cp.FixedVal] = 1.0
+		exp[i] = cp
+	}
+
+	return exp, nil
+}
+
+// ExpandState maps a full state of the reduced model to a full state of the
+// original model (evidence variables get their fixed value). The log
+// probability of the result in the original model is the reduced log
+// probability plus LogConst.
+func (r *Reduction) ExpandState(state []int) ([]int, error) {
+	if len(state) != len(r.VarMap) {
+		return nil, errors.Errorf("State size %d != reduced var count %d", len(state), len(r.VarMap))
+	}
+
+	exp := make([]int, len(r.Original.Vars))
+	for i, v := range r.Original.Vars {
+		exp[i] = v.FixedVal
+	}
+	for i, orig := range r.VarMap {
+		exp[orig] = state[i]
+	}
+
+	return exp, nil
+}
@@ -0,0 +1,141 @@
+package model
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// reduceModel has A(2), B(3), C(2) with a mix of linear and log functions
+// (which aren't normalized, so it's not a BAYES model)
+func reduceModel() *Model {
+	a := &Variable{0, "A", 2, -1, []float64{0.5, 0.5}, nil, false, nil, nil}
+	b := &Variable{1, "B", 3, -1, []float64{0.4, 0.3, 0.3}, nil, false, []string{"x", "y", "z"}, nil}
+	c := &Variable{2, "C", 2, -1, []float64{0.5, 0.5}, nil, false, nil, nil}
+
+	abc := &Function{"ABC", []*Variable{a, b, c}, make([]float64, 12), false, false}
+	for i := range abc.Table {
+		abc.Table[i] = float64(i + 1)
+	}
+	lb := &Function{"LB", []*Variable{b}, []float64{-1.0, -2.0, -3.0}, true, false}
+	ba := &Function{"BA", []*Variable{b, a}, []float64{1, 2, 3, 4, 5, 6}, false, false}
+
+	return &Model{
+		Type:  MARKOV,
+		Name:  "ReduceModel",
+		Vars:  []*Variable{a, b, c},
+		Funcs: []*Function{abc, lb, ba},
+	}
+}
+
+func TestReduction(t *testing.T) {
+	assert := assert.New(t)
+
+	m := reduceModel()
+	assert.NoError(m.Check())
+	assert.NoError(m.SetEvidence(Evidence{1: 2}))
+
+	r, err := NewReduction(m)
+	assert.NoError(err)
+	assert.Equal(MARKOV, r.Model.Type)
+	assert.Equal([]int{0, 2}, r.VarMap)
+	assert.Equal(-3.0, r.LogConst)
+	assert.Equal(2, m.Vars[2].ID) // Original is unchanged
+
+	// A and C keep their names with new ID's
+	assert.Len(r.Model.Vars, 2)
+	assert.Equal("A", r.Model.Vars[0].Name)
+	assert.Equal("C", r.Model.Vars[1].Name)
+	assert.Equal(1, r.Model.Vars[1].ID)
+
+	// LB is constant, ABC and BA are sliced at B=2
+	assert.Len(r.Model.Funcs, 2)
+	abc, ba := r.Model.Funcs[0], r.Model.Funcs[1]
+	assert.Equal("ABC", abc.Name)
+	assert.Equal([]*Variable{r.Model.Vars[0], r.Model.Vars[1]}, abc.Vars)
+	assert.Equal([]float64{5, 6, 11, 12}, abc.Table)
+	assert.Equal("BA", ba.Name)
+	assert.Equal([]*Variable{r.Model.Vars[0]}, ba.Vars)
+	assert.Equal([]float64{5, 6}, ba.Table)
+
+	// Log probabilities match for every reduced state
+	for a := 0; a < 2; a++ {
+		for c := 0; c < 2; c++ {
+			lp, err := r.Model.LogProb([]int{a, c})
+			assert.NoError(err)
+			full, err := r.ExpandState([]int{a, c})
+			assert.NoError(err)
+			assert.Equal([]int{a, 2, c}, full)
+			orig, err := m.LogProb(full)
+			assert.NoError(err)
+			assert.InDelta(orig, lp+r.LogConst, 1e-9)
+		}
+	}
+	_, err = r.ExpandState([]int{0})
+	assert.Error(err)
+
+	// Expanded marginals are in the original order with a one-hot for B
+	est := []*Variable{r.Model.Vars[0].Clone(), r.Model.Vars[1].Clone()}
+	est[0].Marginal = []float64{0.2, 0.8}
+	exp, err := r.ExpandVars(est)
+	assert.NoError(err)
+	assert.Len(exp, 3)
+	assert.Equal([]float64{0.2, 0.8}, exp[0].Marginal)
+	assert.Equal(0, exp[0].ID)
+	assert.Equal([]float64{0, 0, 1}, exp[1].Marginal)
+	assert.Equal(2, exp[1].FixedVal)
+	assert.Equal("C", exp[2].Name)
+	assert.Equal(2, exp[2].ID)
+	_, err = r.ExpandVars(exp)
+	assert.Error(err)
+
+	// Solution variables can be reduced
+	red, err := r.ReduceVars(exp)
+	assert.NoError(err)
+	assert.Len(red, 2)
+	assert.Equal("C", red[1].Name)
+	assert.Equal(1, red[1].ID)
+}
+
+func TestReductionStaleFunctionVars(t *testing.T) {
+	assert := assert.New(t)
+
+	// Evidence set after a clone is only on the model's variables
+	m := reduceModel().Clone()
+	assert.NoError(m.SetEvidence(Evidence{0: 1, 2: 0}))
+
+	r, err := NewReduction(m)
+	assert.NoError(err)
+	assert.Equal([]int{1}, r.VarMap)
+	assert.Len(r.Model.Funcs, 3)
+	assert.Equal([]float64{7, 9, 11}, r.Model.Funcs[0].Table)
+	assert.Equal([]float64{2, 4, 6}, r.Model.Funcs[2].Table)
+	assert.Equal(0.0, r.LogConst)
+}
+
+func TestReductionErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	// Evidence with zero probability
+	m := reduceModel()
+	m.Funcs[2].Table[5] = 0.0
+	assert.NoError(m.SetEvidence(Evidence{0: 1, 1: 2}))
+	_, err := NewReduction(m)
+	assert.Error(err)
+
+	// A log space zero is caught too
+	m = reduceModel()
+	m.Funcs[1].Table[0] = math.Inf(-1)
+	assert.NoError(m.SetEvidence(Evidence{1: 0}))
+	_, err = NewReduction(m)
+	assert.Error(err)
+
+	// Everything fixed
+	m = reduceModel()
+	for _, v := range m.Vars {
+		v.FixedVal = 0
+	}
+	_, err = NewReduction(m)
+	assert.Error(err)
+}
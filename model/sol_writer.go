@@ -0,0 +1,165 @@
+package model
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// JSONSolWriter writes marginal solutions as JSON. A single solution is an
+// object with a Vars array (each variable is encoded just like the variables
+// in our trace files), and multiple solutions are an array of those objects.
+type JSONSolWriter struct {
+}
+
+// WriteMargSolution implements the model.SolWriter interface
+func (w JSONSolWriter) WriteMargSolution(out io.Writer, s *Solution) error {
+	if s == nil || len(s.Vars) < 1 {
+		return errors.New("Can not write an empty solution")
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// WriteMargSolutions implements the model.MultiSolWriter interface
+func (w JSONSolWriter) WriteMargSolutions(out io.Writer, sols []*Solution) error {
+	if len(sols) < 1 {
+		return errors.New("Can not write an empty solution list")
+	}
+	for _, s := range sols {
+		if s == nil || len(s.Vars) < 1 {
+			return errors.New("Can not write an empty solution")
+		}
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sols)
+}
+
+// CSVSolWriter writes marginal solutions as CSV with a row per variable. The
+// columns are the variable fields, the variable state (every key found in any
+// variable, sorted), and then the marginal probabilities M0, M1, ... which
+// are blank past a variable's cardinality. If any variable has state labels,
+// the labels follow as L0, L1, ... Multiple solutions get an additional first
+// column with the (one-based) instance number.
+type CSVSolWriter struct {
+}
+
+// WriteMargSolution implements the model.SolWriter interface
+func (w CSVSolWriter) WriteMargSolution(out io.Writer, s *Solution) error {
+	return w.write(out, []*Solution{s}, false)
+}
+
+// WriteMargSolutions implements the model.MultiSolWriter interface
+func (w CSVSolWriter) WriteMargSolutions(out io.Writer, sols []*Solution) error {
+	if len(sols) < 1 {
+		return errors.New("Can not write an empty solution list")
+	}
+	return w.write(out, sols, true)
+}
+
+func (w CSVSolWriter) write(out io.Writer, sols []*Solution, withInstance bool) error {
+	// Find our columns
+	maxCard := 0
+	labeled := false
+	stateKeys := make(map[string]bool)
+	for _, s := range sols {
+		if s == nil || len(s.Vars) < 1 {
+			return errors.New("Can not write an empty solution")
+		}
+		for _, v := range s.Vars {
+			if v.Card != len(v.Marginal) {
+				return errors.Errorf("Variable %s Card %d != len(M) %d", v.Name, v.Card, len(v.Marginal))
+			}
+			if v.Card > maxCard {
+				maxCard = v.Card
+			}
+			if v.Labels != nil {
+				labeled = true
+			}
+			for ky := range v.State {
+				stateKeys[ky] = true
+			}
+		}
+	}
+
+	states := make([]string, 0, len(stateKeys))
+	for ky := range stateKeys {
+		states = append(states, ky)
+	}
+	sort.Strings(states)
+
+	header := []string{}
+	if withInstance {
+		header = append(header, "Instance")
+	}
+	header = append(header, "ID", "Name", "Card", "FixedVal", "Collapsed")
+	header = append(header, states...)
+	for c := 0; c < maxCard; c++ {
+		header = append(header, "M"+strconv.Itoa(c))
+	}
+	if labeled {
+		for c := 0; c < maxCard; c++ {
+			header = append(header, "L"+strconv.Itoa(c))
+		}
+	}
+
+	cw := csv.NewWriter(out)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	row := make([]string, 0, len(header))
+	for i, s := range sols {
+		for _, v := range s.Vars {
+			row = row[:0]
+			if withInstance {
+				row = append(row, strconv.Itoa(i+1))
+			}
+			row = append(row,
+				strconv.Itoa(v.ID),
+				v.Name,
+				strconv.Itoa(v.Card),
+				strconv.Itoa(v.FixedVal),
+				strconv.FormatBool(v.Collapsed),
+			)
+			for _, ky := range states {
+				val, ok := v.State[ky]
+				if ok {
+					row = append(row, strconv.FormatFloat(val, 'g', -1, 64))
+				} else {
+					row = append(row, "")
+				}
+			}
+			for c := 0; c < maxCard; c++ {
+				if c < v.Card {
+					row = append(row, strconv.FormatFloat(v.Marginal[c], 'g', -1, 64))
+				} else {
+					row = append(row, "")
+				}
+			}
+			if labeled {
+				for c := 0; c < maxCard; c++ {
+					if c < len(v.Labels) {
+						row = append(row, v.Labels[c])
+					} else {
+						row = append(row, "")
+					}
+				}
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
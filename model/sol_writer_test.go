@@ -0,0 +1,112 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSolution(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewSolution(nil)
+	assert.Error(err)
+
+	r := UAIReader{}
+	m, err := NewModelFromFile(r, "../res/sample.uai", false)
+	assert.NoError(err)
+
+	m.Vars[0].Marginal = []float64{1.0, 3.0}
+	m.Vars[2].FixedVal = 1
+	m.Vars[2].Marginal = []float64{0.2, 0.2, 0.6}
+
+	sol, err := NewSolution(m.Vars)
+	assert.NoError(err)
+	assert.Equal([]float64{0.25, 0.75}, sol.Vars[0].Marginal)
+	assert.Equal([]float64{0.5, 0.5}, sol.Vars[1].Marginal)
+	assert.Equal([]float64{0.0, 1.0, 0.0}, sol.Vars[2].Marginal)
+
+	// We didn't change the source vars
+	assert.Equal([]float64{1.0, 3.0}, m.Vars[0].Marginal)
+	assert.Equal([]float64{0.2, 0.2, 0.6}, m.Vars[2].Marginal)
+}
+
+func TestJSONSolWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	w := JSONSolWriter{}
+	r := UAIReader{}
+
+	sol, err := NewSolutionFromFile(r, "../res/deterministic.uai.MAR")
+	assert.NoError(err)
+	sol.Vars[1].State["Hell-Error"] = 0.125
+
+	buf := &bytes.Buffer{}
+	assert.Error(w.WriteMargSolution(buf, &Solution{}))
+	assert.NoError(w.WriteMargSolution(buf, sol))
+
+	var sol2 Solution
+	assert.NoError(json.Unmarshal(buf.Bytes(), &sol2))
+	assert.Equal(len(sol.Vars), len(sol2.Vars))
+	for i, v := range sol.Vars {
+		assert.Equal(v.Name, sol2.Vars[i].Name)
+		assert.Equal(v.Marginal, sol2.Vars[i].Marginal)
+	}
+	assert.Equal(0.125, sol2.Vars[1].State["Hell-Error"])
+
+	buf.Reset()
+	assert.Error(w.WriteMargSolutions(buf, nil))
+	assert.NoError(w.WriteMargSolutions(buf, []*Solution{sol, sol}))
+	var sols []*Solution
+	assert.NoError(json.Unmarshal(buf.Bytes(), &sols))
+	assert.Equal(2, len(sols))
+	assert.Equal(sol.Vars[2].Marginal, sols[1].Vars[2].Marginal)
+}
+
+func TestCSVSolWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	w := CSVSolWriter{}
+
+	v1, err := NewVariable(0, 2)
+	assert.NoError(err)
+	v1.FixedVal = 1
+	v1.Marginal = []float64{0.0, 1.0}
+	v2, err := NewVariable(1, 3)
+	assert.NoError(err)
+	v2.State["Hell-Error"] = 0.5
+	sol := &Solution{Vars: []*Variable{v1, v2}}
+
+	buf := &bytes.Buffer{}
+	assert.Error(w.WriteMargSolution(buf, &Solution{}))
+	assert.NoError(w.WriteMargSolution(buf, sol))
+	assert.Equal(
+		"ID,Name,Card,FixedVal,Collapsed,Hell-Error,M0,M1,M2\n"+
+			"0,A,2,1,false,,0,1,\n"+
+			"1,B,3,-1,false,0.5,0.3333333333333333,0.3333333333333333,0.3333333333333333\n",
+		buf.String(),
+	)
+
+	buf.Reset()
+	assert.Error(w.WriteMargSolutions(buf, nil))
+	assert.NoError(w.WriteMargSolutions(buf, []*Solution{{Vars: []*Variable{v1}}, {Vars: []*Variable{v1}}}))
+	assert.Equal(
+		"Instance,ID,Name,Card,FixedVal,Collapsed,M0,M1\n"+
+			"1,0,A,2,1,false,0,1\n"+
+			"2,0,A,2,1,false,0,1\n",
+		buf.String(),
+	)
+
+	// State labels are included if any variable has them
+	v2.Labels = []string{"lo", "mid", "hi"}
+	buf.Reset()
+	assert.NoError(w.WriteMargSolution(buf, sol))
+	assert.Equal(
+		"ID,Name,Card,FixedVal,Collapsed,Hell-Error,M0,M1,M2,L0,L1,L2\n"+
+			"0,A,2,1,false,,0,1,,,,\n"+
+			"1,B,3,-1,false,0.5,0.3333333333333333,0.3333333333333333,0.3333333333333333,lo,mid,hi\n",
+		buf.String(),
+	)
+}
@@ -1,22 +1,89 @@
 package model
 
 import (
+	"io"
 	"io/ioutil"
+	"os"
 
 	"github.com/pkg/errors"
 )
 
-// SolReader implementors read a solution (currently we only support marginal solutions)
+// SolReader implementors read a marginal (MAR) solution
 type SolReader interface {
 	ReadMargSolution(data []byte) (*Solution, error)
 }
 
+// SolWriter implementors write a marginal (MAR) solution
+type SolWriter interface {
+	WriteMargSolution(w io.Writer, s *Solution) error
+}
+
+// MultiSolReader implementors read a marginal (MAR) solution per evidence
+// instance
+type MultiSolReader interface {
+	ReadMargSolutions(data []byte) ([]*Solution, error)
+}
+
+// MultiSolWriter implementors write a marginal (MAR) solution per evidence
+// instance
+type MultiSolWriter interface {
+	WriteMargSolutions(w io.Writer, sols []*Solution) error
+}
+
+// PRReader implementors read a partition function (PR) solution
+type PRReader interface {
+	ReadPRSolution(data []byte) (*PRSolution, error)
+}
+
+// PRWriter implementors write a partition function (PR) solution
+type PRWriter interface {
+	WritePRSolution(w io.Writer, s *PRSolution) error
+}
+
+// MPEReader implementors read a most probable explanation (MPE) solution
+type MPEReader interface {
+	ReadMPESolution(data []byte) (*MPESolution, error)
+}
+
+// MPEWriter implementors write a most probable explanation (MPE) solution
+type MPEWriter interface {
+	WriteMPESolution(w io.Writer, s *MPESolution) error
+}
+
 // Solution to a marginal estimation problem specified on a Model. It also
 // provides evaluation metrics to evaluate vs the solution.
 type Solution struct {
 	Vars []*Variable // Variables with their marginals
 }
 
+// NewSolution creates a solution from estimated variables. The variables are
+// cloned and their marginals normalized. Evidence variables get a one-hot
+// marginal for their fixed value (whatever our estimate was).
+func NewSolution(vars []*Variable) (*Solution, error) {
+	if len(vars) < 1 {
+		return nil, errors.New("Can not create a solution with no variables")
+	}
+
+	sol := &Solution{Vars: make([]*Variable, len(vars))}
+	for i, src := range vars {
+		v := src.Clone()
+		if v.FixedVal >= v.Card {
+			return nil, errors.Errorf("Variable %s has FixedVal %d but Card %d", v.Name, v.FixedVal, v.Card)
+		}
+		if v.FixedVal >= 0 {
+			for c := range v.Marginal {
+				v.Marginal[c] = 0.0
+			}
+			v.Marginal[v.FixedVal] = 1.0
+		} else if err := v.NormMarginal(); err != nil {
+			return nil, errors.Wrapf(err, "Invalid marginal for variable %s", v.Name)
+		}
+		sol.Vars[i] = v
+	}
+
+	return sol, nil
+}
+
 // NewSolutionFromFile reads a UAI MAR solution file
 func NewSolutionFromFile(r SolReader, filename string) (*Solution, error) {
 	data, err := ioutil.ReadFile(filename)
@@ -42,6 +109,66 @@ func NewSolutionFromBuffer(r SolReader, data []byte) (*Solution, error) {
 	return s, nil
 }
 
+// NewSolutionsFromFile reads a UAI MAR solution file with a solution per
+// evidence instance
+func NewSolutionsFromFile(r MultiSolReader, filename string) ([]*Solution, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not READ solutions from %s", filename)
+	}
+
+	sols, err := NewSolutionsFromBuffer(r, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return sols, nil
+}
+
+// NewSolutionsFromBuffer reads a UAI MAR solution file with a solution per
+// evidence instance from the specified buffer
+func NewSolutionsFromBuffer(r MultiSolReader, data []byte) ([]*Solution, error) {
+	sols, err := r.ReadMargSolutions(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not PARSE solutions")
+	}
+
+	return sols, nil
+}
+
+// WriteSolutionsToFile writes a solution per evidence instance to the given
+// file (which is overwritten)
+func WriteSolutionsToFile(w MultiSolWriter, filename string, sols []*Solution) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "Could not CREATE solution file %s", filename)
+	}
+
+	err = w.WriteMargSolutions(f, sols)
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "Could not WRITE solutions to %s", filename)
+	}
+
+	return f.Close()
+}
+
+// WriteToFile writes the solution to the given file (which is overwritten)
+func (s *Solution) WriteToFile(w SolWriter, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "Could not CREATE solution file %s", filename)
+	}
+
+	err = w.WriteMargSolution(f, s)
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "Could not WRITE solution to %s", filename)
+	}
+
+	return f.Close()
+}
+
 // Check insures that the solution is as correct as can be checked given a model
 func (s *Solution) Check(m *Model) error {
 	for _, v := range s.Vars {
@@ -63,3 +190,122 @@ func (s *Solution) Check(m *Model) error {
 func (s *Solution) Error(vars []*Variable) (*ErrorSuite, error) {
 	return NewErrorSuite(s.Vars, vars)
 }
+
+// PRSolution is the solution to a partition function estimation problem
+// specified on a Model. With evidence, this is the probability of evidence.
+type PRSolution struct {
+	LogZ float64 // Natural log of the partition function
+}
+
+// NewPRSolutionFromFile reads a PR solution file
+func NewPRSolutionFromFile(r PRReader, filename string) (*PRSolution, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not READ PR solution from %s", filename)
+	}
+
+	sol, err := NewPRSolutionFromBuffer(r, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return sol, nil
+}
+
+// NewPRSolutionFromBuffer reads a PR solution from the specified buffer
+func NewPRSolutionFromBuffer(r PRReader, data []byte) (*PRSolution, error) {
+	s, err := r.ReadPRSolution(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not PARSE PR solution")
+	}
+
+	return s, nil
+}
+
+// WriteToFile writes the PR solution to the given file (which is overwritten)
+func (s *PRSolution) WriteToFile(w PRWriter, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "Could not CREATE PR solution file %s", filename)
+	}
+
+	err = w.WritePRSolution(f, s)
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "Could not WRITE PR solution to %s", filename)
+	}
+
+	return f.Close()
+}
+
+// Error returns our log Z error metrics for the given estimate of log Z
+func (s *PRSolution) Error(logZ float64) *LogZError {
+	return NewLogZError(s.LogZ, logZ)
+}
+
+// MPESolution is the solution to a most probable explanation problem
+// specified on a Model: a value for every variable.
+type MPESolution struct {
+	Values []int // Value for each variable (indexed by variable ID)
+}
+
+// NewMPESolutionFromFile reads an MPE solution file
+func NewMPESolutionFromFile(r MPEReader, filename string) (*MPESolution, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not READ MPE solution from %s", filename)
+	}
+
+	sol, err := NewMPESolutionFromBuffer(r, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return sol, nil
+}
+
+// NewMPESolutionFromBuffer reads an MPE solution from the specified buffer
+func NewMPESolutionFromBuffer(r MPEReader, data []byte) (*MPESolution, error) {
+	s, err := r.ReadMPESolution(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not PARSE MPE solution")
+	}
+
+	return s, nil
+}
+
+// WriteToFile writes the MPE solution to the given file (which is overwritten)
+func (s *MPESolution) WriteToFile(w MPEWriter, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "Could not CREATE MPE solution file %s", filename)
+	}
+
+	err = w.WriteMPESolution(f, s)
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "Could not WRITE MPE solution to %s", filename)
+	}
+
+	return f.Close()
+}
+
+// Check insures that the solution is a valid state for the given model
+func (s *MPESolution) Check(m *Model) error {
+	if len(s.Values) != len(m.Vars) {
+		return errors.Errorf("MPE solution var count %d != model var count %d", len(s.Values), len(m.Vars))
+	}
+
+	for i, val := range s.Values {
+		if val < 0 || val >= m.Vars[i].Card {
+			return errors.Errorf("MPE value %d is invalid for var %s with card %d", val, m.Vars[i].Name, m.Vars[i].Card)
+		}
+	}
+
+	return nil
+}
+
+// Error returns our MPE error metrics for the given state on the model
+func (s *MPESolution) Error(m *Model, state []int) (*MPEError, error) {
+	return NewMPEError(m, s.Values, state)
+}
@@ -0,0 +1,162 @@
+package model
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// SupportTries is the default number of values SupportState will try before
+// giving up
+const SupportTries = 100000
+
+// HasZeros is true if any function in the model has a zero entry (so some
+// states are impossible)
+func (m *Model) HasZeros() bool {
+	for _, f := range m.Funcs {
+		for _, v := range f.Table {
+			if isZeroEntry(f, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isZeroEntry is true if the table value v of function f is zero
+func isZeroEntry(f *Function, v float64) bool {
+	if f.IsLog {
+		return math.IsInf(v, -1)
+	}
+	return v == 0.0
+}
+
+// SupportState returns a full state with non-zero probability that respects
+// the model's evidence (fixed values and domain restrictions). We use a depth
+// first search over the unfixed vars, trying at most maxTries values. A value
+// is only consistent if every function of the var still has a non-zero entry
+// that matches the vars assigned so far, and we always branch on the var with
+// the fewest consistent values. An error is returned if the evidence is
+// impossible, if there is no such state, or if we give up.
+func (m *Model) SupportState(maxTries int) ([]int, error) {
+	varFuncs := make([][]*Function, len(m.Vars))
+	for _, f := range m.Funcs {
+		for _, v := range f.Vars {
+			if v.ID < 0 || v.ID >= len(m.Vars) {
+				return nil, errors.Errorf("Function %s has invalid var ID %d", f.Name, v.ID)
+			}
+			varFuncs[v.ID] = append(varFuncs[v.ID], f)
+		}
+	}
+
+	state := make([]int, len(m.Vars))
+	assigned := make([]bool, len(m.Vars))
+	for i, v := range m.Vars {
+		if v.ID != i {
+			return nil, errors.Errorf("Invalid ID for var %s: expected %d but was %d", v.Name, i, v.ID)
+		}
+		if v.FixedVal >= 0 {
+			state[i] = v.FixedVal
+			assigned[i] = true
+		}
+	}
+
+	// consistent is true if every function of var i has a non-zero entry
+	// that matches the assigned vars in state
+	consistent := func(i int) bool {
+		for _, f := range varFuncs[i] {
+			assign := make([]int, len(f.Vars))
+			ok := false
+			for _, tv := range f.Table {
+				if !isZeroEntry(f, tv) {
+					ok = true
+					for k, a := range assign {
+						if id := f.Vars[k].ID; assigned[id] && state[id] != a {
+							ok = false
+							break
+						}
+					}
+					if ok {
+						break
+					}
+				}
+
+				for k := len(assign) - 1; k >= 0; k-- {
+					assign[k]++
+					if assign[k] < f.Vars[k].Card {
+						break
+					}
+					assign[k] = 0
+				}
+			}
+			if !ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Our evidence alone may be impossible
+	for i, v := range m.Vars {
+		if v.FixedVal >= 0 && !consistent(i) {
+			return nil, errors.Errorf("Evidence for variable %s is impossible", v.Name)
+		}
+	}
+
+	// Each level of the search is a var and the values we have left to try
+	type choice struct {
+		v    int
+		vals []int
+	}
+	stack := []choice{}
+	tries := 0
+
+	for {
+		// Pick the unassigned var with the fewest consistent values
+		next := choice{v: -1}
+		for i, v := range m.Vars {
+			if assigned[i] {
+				continue
+			}
+			vals := []int{}
+			assigned[i] = true
+			for _, c := range v.AllowedValues() {
+				state[i] = c
+				if consistent(i) {
+					vals = append(vals, c)
+				}
+			}
+			assigned[i] = false
+			if next.v < 0 || len(vals) < len(next.vals) {
+				next = choice{v: i, vals: vals}
+			}
+			if len(vals) == 0 {
+				break
+			}
+		}
+		if next.v < 0 {
+			return state, nil // Everything is assigned
+		}
+		stack = append(stack, next)
+
+		// Assign the next value, backtracking as needed
+		for {
+			top := &stack[len(stack)-1]
+			if len(top.vals) > 0 {
+				tries++
+				if tries > maxTries {
+					return nil, errors.Errorf("No state with non-zero probability found after %d tries", maxTries)
+				}
+				state[top.v] = top.vals[0]
+				assigned[top.v] = true
+				top.vals = top.vals[1:]
+				break
+			}
+			assigned[top.v] = false
+			stack = stack[:len(stack)-1]
+			if len(stack) < 1 {
+				return nil, errors.New("Model has no state with non-zero probability")
+			}
+		}
+	}
+}
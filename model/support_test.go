@@ -0,0 +1,50 @@
+package model
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Support states respect evidence and have non-zero probability
+func TestSupportState(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := NewModelFromFile(UAIReader{}, "../res/one.uai", false)
+	assert.NoError(err)
+	assert.False(m.HasZeros())
+
+	m, err = NewModelFromFile(UAIReader{}, "../res/deterministic.uai", false)
+	assert.NoError(err)
+	assert.True(m.HasZeros())
+
+	state, err := m.SupportState(SupportTries)
+	assert.NoError(err)
+	lp, err := m.LogProb(state)
+	assert.NoError(err)
+	assert.False(math.IsInf(lp, -1))
+
+	// Evidence and domain restrictions are honored (x0 and x1 must match)
+	assert.NoError(m.SetEvidence(Evidence{0: 1}))
+	assert.NoError(m.RestrictDomains(DomainEvidence{2: {1}}))
+	state, err = m.SupportState(SupportTries)
+	assert.NoError(err)
+	assert.Equal([]int{1, 1, 1}, state)
+
+	// Impossible evidence
+	assert.NoError(m.SetEvidence(Evidence{0: 1, 1: 0}))
+	_, err = m.SupportState(SupportTries)
+	assert.Error(err)
+
+	// A harder model, and giving up
+	m, err = NewModelFromFile(UAIReader{}, "../res/Pedigree_11.uai", true)
+	assert.NoError(err)
+	state, err = m.SupportState(SupportTries)
+	assert.NoError(err)
+	lp, err = m.LogProb(state)
+	assert.NoError(err)
+	assert.False(math.IsInf(lp, -1))
+	_, err = m.SupportState(1)
+	assert.Error(err)
+}
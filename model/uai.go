@@ -1,7 +1,10 @@
 package model
 
 import (
-	"strings"
+	"bytes"
+	"io"
+	"math"
+	"strconv"
 
 	"github.com/pkg/errors"
 )
@@ -13,42 +16,6 @@ import (
 type UAIReader struct {
 }
 
-// Preprocessor for UAI files: remove lines that are blank or comments. Return
-// the new buffer and the count of "real" lines found. If reqPrefix is
-// specified, then a line starting with reqPrefix must be present AND all text
-// before the first occurrence of reqPrefix will be dropped.
-func uaiPreprocess(data []byte, reqPrefix string) (string, int) {
-	lines := strings.Split(string(data), "\n")
-
-	startFound := false
-	if len(reqPrefix) < 1 {
-		startFound = true // No req prefix specified
-	}
-
-	newPos := 0
-	for i, ln := range lines {
-		ln = strings.TrimSpace(ln)
-		if len(ln) < 1 || ln[0] == 'c' {
-			lines[i] = "" // Empty or comment: skip
-			continue
-		}
-
-		if !startFound {
-			if strings.HasPrefix(ln, reqPrefix) {
-				startFound = true
-			} else {
-				continue // still looking...
-			}
-		}
-
-		// Rewrite update line and update insert point
-		lines[newPos] = ln
-		newPos++
-	}
-
-	return strings.Join(lines[:newPos], "\n"), newPos
-}
-
 // ReadModel implements the model.Reader interface
 func (r UAIReader) ReadModel(data []byte) (*Model, error) {
 	// We counted: bayes net with single var with card=1 with minimal spacing
@@ -57,15 +24,16 @@ func (r UAIReader) ReadModel(data []byte) (*Model, error) {
 		return nil, errors.Errorf("Invalid data buffer: len=%d (<15)", len(data))
 	}
 
-	// A minimal model will have 6 fields
-	text, lineCount := uaiPreprocess(data, "")
-	if lineCount < 1 {
+	return r.ReadModelFrom(bytes.NewReader(data))
+}
+
+// ReadModelFrom implements the model.StreamReader interface. The model is
+// read a token at a time, so we never hold the file text in memory.
+func (r UAIReader) ReadModelFrom(in io.Reader) (*Model, error) {
+	fr := NewTokenReader(in, 'c')
+	if _, err := fr.Peek(); err == io.EOF {
 		return nil, errors.Errorf("No lines found in file")
 	}
-	fr := NewFieldReader(text)
-	if len(fr.Fields) < 6 {
-		return nil, errors.Errorf("Invalid data: only %d fields found (<6)", len(fr.Fields))
-	}
 
 	// Network type
 	m := &Model{}
@@ -76,7 +44,7 @@ func (r UAIReader) ReadModel(data []byte) (*Model, error) {
 		return nil, errors.Wrap(err, "Error reading UAI file on Type")
 	}
 	if m.Type != BAYES && m.Type != MARKOV {
-		return nil, errors.Errorf("Unknown model type %v", m.Type)
+		return nil, fr.Errorf("Unknown model type %v", m.Type)
 	}
 
 	// Network variables: count followed by cardinality.  For example, 3 boolean
@@ -87,7 +55,7 @@ func (r UAIReader) ReadModel(data []byte) (*Model, error) {
 		return nil, errors.Wrap(err, "Error reading UAI file on Variable count")
 	}
 	if varCount < 1 {
-		return nil, errors.Errorf("Invalid variable count: %d", varCount)
+		return nil, fr.Errorf("Invalid variable count: %d", varCount)
 	}
 
 	m.Vars = make([]*Variable, varCount)
@@ -98,7 +66,7 @@ func (r UAIReader) ReadModel(data []byte) (*Model, error) {
 			return nil, errors.Wrapf(err, "Error reading Card for var %d", i)
 		}
 		if card < 1 {
-			return nil, errors.Errorf("Invalid card %d for var %d", card, i)
+			return nil, fr.Errorf("Invalid card %d for var %d", card, i)
 		}
 
 		m.Vars[i], err = NewVariable(i, card)
@@ -115,7 +83,7 @@ func (r UAIReader) ReadModel(data []byte) (*Model, error) {
 		return nil, errors.Wrap(err, "Error reading UAI file on Clique count")
 	}
 	if funcCount < 1 {
-		return nil, errors.Errorf("Invalid Clique count count: %d", funcCount)
+		return nil, fr.Errorf("Invalid Clique count count: %d", funcCount)
 	}
 
 	// Then we read the variables (domain) for the functions - they are count
@@ -129,7 +97,7 @@ func (r UAIReader) ReadModel(data []byte) (*Model, error) {
 			return nil, errors.Wrapf(err, "Error reading Clique size for Clique %d", i)
 		}
 		if varCount < 1 {
-			return nil, errors.Errorf("Invalid variable count (<1) for Clique %d", i)
+			return nil, fr.Errorf("Invalid variable count (<1) for Clique %d", i)
 		}
 
 		fvars := make([]*Variable, varCount)
@@ -139,7 +107,7 @@ func (r UAIReader) ReadModel(data []byte) (*Model, error) {
 				return nil, errors.Wrapf(err, "Error reading var idx for Clique %d Variable %d", i, j)
 			}
 			if varIdx < 0 || varIdx >= len(m.Vars) {
-				return nil, errors.Errorf("Invalid var idx %d for Clique %d Variable %d", varIdx, i, j)
+				return nil, fr.Errorf("Invalid var idx %d for Clique %d Variable %d", varIdx, i, j)
 			}
 
 			fvars[j] = m.Vars[varIdx]
@@ -162,13 +130,13 @@ func (r UAIReader) ReadModel(data []byte) (*Model, error) {
 			return nil, errors.Wrapf(err, "Error reading table size on function %s", fun.Name)
 		}
 		if tabSize != len(fun.Table) {
-			return nil, errors.Errorf("Read table size %d != previous Clique size %d on function %s", tabSize, len(fun.Table), fun.Name)
+			return nil, fr.Errorf("Read table size %d != previous Clique size %d on function %s", tabSize, len(fun.Table), fun.Name)
 		}
 
 		for t := 0; t < tabSize; t++ {
 			entry, err = fr.ReadFloat()
 			if err != nil {
-				return nil, errors.Errorf("Error reading entry %d on function %s", t, fun.Name)
+				return nil, errors.Wrapf(err, "Error reading entry %d on function %s", t, fun.Name)
 			}
 			fun.Table[t] = entry
 		}
@@ -179,73 +147,326 @@ func (r UAIReader) ReadModel(data []byte) (*Model, error) {
 }
 
 // ApplyEvidence is part of the reader interface - read the evidence file and
-// apply to the model.
+// apply to the model. Only a single evidence instance can be applied: use
+// ReadEvidence for files with multiple instances. Any soft or domain evidence
+// in the file is added to the model as well (see ReadSoftEvidence and
+// ReadDomainEvidence).
 func (r UAIReader) ApplyEvidence(data []byte, m *Model) error {
-	text, lineCount := uaiPreprocess(data, "")
-	if lineCount < 1 {
-		return errors.Errorf("Invalid data buffer: there is no data")
-	} else if lineCount > 2 {
-		return errors.Errorf("Found %d lines: only understand evidence files with 1 or 2 lines", lineCount)
+	evid, err := r.ReadEvidence(data)
+	if err != nil {
+		return err
+	}
+	if len(evid) > 1 {
+		return errors.Errorf("Sample count is %d - only single sample evidence can be applied", len(evid))
 	}
 
-	fr := NewFieldReader(text)
-	if len(fr.Fields) < 1 {
-		return errors.Errorf("Invalid data: found no fields")
+	soft, err := r.ReadSoftEvidence(data)
+	if err != nil {
+		return err
+	}
+	if err := soft.Check(m); err != nil {
+		return err
 	}
 
-	var err error
+	domains, err := r.ReadDomainEvidence(data)
+	if err != nil {
+		return err
+	}
+	if err := domains.Check(m); err != nil {
+		return err
+	}
+
+	if len(evid) > 0 {
+		e := evid[0]
+		if err := e.Check(m); err != nil {
+			return err
+		}
+		for _, idx := range e.VarIDs() {
+			v := m.Vars[idx]
+			if v.FixedVal != -1 {
+				return errors.Errorf("variable[%d]:%v had previous fixedval %d", idx, v.Name, v.FixedVal)
+			}
+			if vals, ok := domains[idx]; ok && !containsInt(vals, e[idx]) {
+				return errors.Errorf("variable[%d]:%v has evidence %d which is not in its domain evidence", idx, v.Name, e[idx])
+			}
+		}
+		for idx, val := range e {
+			m.Vars[idx].FixedVal = val
+		}
+	}
+
+	if err := m.RestrictDomains(domains); err != nil {
+		return err
+	}
+	return m.AddSoftEvidence(soft)
+}
+
+// containsInt returns true if val is in vals
+func containsInt(vals []int, val int) bool {
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
 
-	if lineCount == 2 {
-		sampleCount, err := fr.ReadInt()
+// uaiSections are the optional sections at the end of a UAI evidence file
+var uaiSections = []string{"SOFT", "DOMAIN"}
+
+// uaiSplitSections splits an evidence file into the usual (hard) evidence and
+// the optional sections, which each start with a line starting with the
+// section name. Sections missing from the file are missing from the map.
+func uaiSplitSections(data []byte) ([]byte, map[string][]byte) {
+	sections := make(map[string][]byte)
+	hard := data
+	name, start := "", 0
+
+	lineStart := 0
+	for lineStart <= len(data) {
+		lineEnd := bytes.IndexByte(data[lineStart:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(data)
+		} else {
+			lineEnd += lineStart
+		}
+
+		ln := bytes.TrimSpace(data[lineStart:lineEnd])
+		for _, sec := range uaiSections {
+			if !bytes.HasPrefix(ln, []byte(sec)) {
+				continue
+			}
+			if len(name) > 0 {
+				sections[name] = data[start:lineStart]
+			} else {
+				hard = data[:lineStart]
+			}
+			name, start = sec, lineStart
+			break
+		}
+
+		lineStart = lineEnd + 1
+	}
+	if len(name) > 0 {
+		sections[name] = data[start:]
+	}
+
+	return hard, sections
+}
+
+// ReadSoftEvidence implements the model.SoftEvidenceReader interface. Soft
+// evidence is in an optional section at the end of a UAI evidence file: a
+// SOFT line, the number of variables, and then for each variable its index,
+// its card, and a likelihood for each value. For example:
+//
+//	1
+//	1 0 1
+//	SOFT
+//	2
+//	1 2 0.9 0.1
+//	3 3 0.2 0.3 0.5
+//
+// Files without a SOFT section have no soft evidence.
+func (r UAIReader) ReadSoftEvidence(data []byte) (SoftEvidence, error) {
+	se := SoftEvidence{}
+
+	_, sections := uaiSplitSections(data)
+	soft, ok := sections["SOFT"]
+	if !ok {
+		return se, nil
+	}
+	fr, err := uaiSection(soft, "SOFT")
+	if err != nil {
+		return nil, err
+	}
+
+	varCount, err := fr.ReadInt()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading soft evidence variable count")
+	}
+	if varCount < 0 {
+		return nil, fr.Errorf("Invalid soft evidence variable count %d", varCount)
+	}
+
+	for i := 0; i < varCount; i++ {
+		idx, err := fr.ReadInt()
 		if err != nil {
-			return errors.Wrapf(err, "Error reading UAI evid file sample count")
+			return nil, errors.Wrapf(err, "Could not read soft evidence var on iteration %d", i)
 		}
-		if sampleCount == 0 {
-			return nil // Allowed
+		if _, dup := se[idx]; dup {
+			return nil, fr.Errorf("Variable index %d appears twice in soft evidence", idx)
 		}
-		if sampleCount > 1 {
-			return errors.Errorf("Sample count is %d - only single sample evidence currently supported", sampleCount)
+
+		card, err := fr.ReadInt()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not read soft evidence card for var %d", idx)
+		}
+		if card < 1 {
+			return nil, fr.Errorf("Invalid soft evidence card %d for var %d", card, idx)
 		}
+
+		lik := make([]float64, card)
+		for j := range lik {
+			lik[j], err = fr.ReadFloat()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Could not read soft evidence likelihood %d for var %d", j, idx)
+			}
+		}
+		se[idx] = lik
 	}
 
-	// Read variable count
-	var varCount int
-	varCount, err = fr.ReadInt()
+	if _, err := fr.Read(); err == nil {
+		return nil, fr.Errorf("Found extra data after soft evidence for %d variables", varCount)
+	}
+
+	return se, nil
+}
+
+// ReadDomainEvidence implements the model.DomainEvidenceReader interface.
+// Domain evidence is in an optional section at the end of a UAI evidence file
+// (before or after any SOFT section): a DOMAIN line, the number of variables,
+// and then for each variable its index, the number of allowed values, and the
+// allowed values. For example, X1 != 2 and X3 in {0, 2} (for vars with card 3
+// and 4) would be:
+//
+//	DOMAIN
+//	2
+//	1 2 0 1
+//	3 2 0 2
+//
+// Files without a DOMAIN section have no domain evidence.
+func (r UAIReader) ReadDomainEvidence(data []byte) (DomainEvidence, error) {
+	de := DomainEvidence{}
+
+	_, sections := uaiSplitSections(data)
+	domains, ok := sections["DOMAIN"]
+	if !ok {
+		return de, nil
+	}
+	fr, err := uaiSection(domains, "DOMAIN")
 	if err != nil {
-		return errors.Wrap(err, "Error reading UAI evid Variable Count")
+		return nil, err
 	}
-	if varCount < 1 {
-		return nil // Allowed
+
+	varCount, err := fr.ReadInt()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading domain evidence variable count")
+	}
+	if varCount < 0 {
+		return nil, fr.Errorf("Invalid domain evidence variable count %d", varCount)
 	}
 
-	var idx int
-	var val int
 	for i := 0; i < varCount; i++ {
-		idx, err = fr.ReadInt()
+		idx, err := fr.ReadInt()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not read domain evidence var on iteration %d", i)
+		}
+		if _, dup := de[idx]; dup {
+			return nil, fr.Errorf("Variable index %d appears twice in domain evidence", idx)
+		}
+
+		count, err := fr.ReadInt()
 		if err != nil {
-			return errors.Wrapf(err, "Could not read evid var on iteration %d", i)
+			return nil, errors.Wrapf(err, "Could not read domain evidence value count for var %d", idx)
+		}
+		if count < 1 {
+			return nil, fr.Errorf("Invalid domain evidence value count %d for var %d", count, idx)
 		}
-		if idx < 0 || idx >= len(m.Vars) {
-			return errors.Errorf("Read incorrect variable index %d", idx)
+
+		vals := make([]int, count)
+		for j := range vals {
+			vals[j], err = fr.ReadInt()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Could not read domain evidence value %d for var %d", j, idx)
+			}
 		}
+		de[idx] = vals
+	}
+
+	if _, err := fr.Read(); err == nil {
+		return nil, fr.Errorf("Found extra data after domain evidence for %d variables", varCount)
+	}
+
+	return de, nil
+}
+
+// ReadEvidence implements the model.EvidenceReader interface. We support the
+// older format (a single instance on one line) and the newer format where the
+// first line is the number of instances and each instance follows. Any soft
+// or domain evidence sections are ignored.
+func (r UAIReader) ReadEvidence(data []byte) ([]Evidence, error) {
+	data, sections := uaiSplitSections(data)
+	fr := NewTokenReader(bytes.NewReader(data), 'c')
+	first, err := fr.Read()
+	if err == io.EOF && len(sections) > 0 {
+		return []Evidence{}, nil // Only soft or domain evidence
+	}
+	if err == io.EOF {
+		return nil, errors.Errorf("Invalid data buffer: there is no data")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading UAI evid file")
+	}
 
-		v := m.Vars[idx]
-		if v.FixedVal != -1 {
-			return errors.Errorf("variable[%d]:%v had previous fixedval %d", idx, v.Name, v.FixedVal)
+	// A lone zero is no evidence in either format
+	_, err = fr.Peek()
+	if err == io.EOF && first == "0" {
+		return []Evidence{}, nil
+	}
+
+	// The newer format has the sample count on its own line
+	sampleCount := 1
+	if err == nil && fr.Lines() > 1 {
+		sampleCount, err = strconv.Atoi(first)
+		if err != nil {
+			return nil, fr.Errorf("Error reading UAI evid file sample count %q", first)
+		}
+		if sampleCount < 0 {
+			return nil, fr.Errorf("Invalid sample count %d", sampleCount)
+		}
+		if sampleCount == 0 {
+			return []Evidence{}, nil // Allowed (and we ignore anything else)
 		}
+	} else {
+		// Older format: start over since the first token is the var count
+		fr = NewTokenReader(bytes.NewReader(data), 'c')
+	}
 
-		val, err = fr.ReadInt()
+	evid := make([]Evidence, sampleCount)
+	for s := range evid {
+		varCount, err := fr.ReadInt()
 		if err != nil {
-			return errors.Wrapf(err, "Could not read evid var value on iteration %d, index %d", i, idx)
+			return nil, errors.Wrapf(err, "Error reading UAI evid Variable Count for sample %d", s)
+		}
+		if varCount < 0 {
+			return nil, fr.Errorf("Invalid variable count %d for sample %d", varCount, s)
 		}
-		if val < 0 || val >= v.Card {
-			return errors.Errorf("Read invalid value %d for variable[%d]:%v with card %d", val, idx, v.Name, v.Card)
+
+		e := make(Evidence, varCount)
+		for i := 0; i < varCount; i++ {
+			idx, err := fr.ReadInt()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Could not read evid var on iteration %d, sample %d", i, s)
+			}
+			if _, dup := e[idx]; dup {
+				return nil, fr.Errorf("Variable index %d appears twice in sample %d", idx, s)
+			}
+
+			val, err := fr.ReadInt()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Could not read evid var value on iteration %d, index %d, sample %d", i, idx, s)
+			}
+
+			e[idx] = val
 		}
+		evid[s] = e
+	}
 
-		v.FixedVal = val
+	if _, err := fr.Read(); err == nil {
+		return nil, fr.Errorf("Found extra data after %d evidence samples", sampleCount)
 	}
 
-	return nil
+	return evid, nil
 }
 
 // ReadMargSolution implements the model.SolReader interface
@@ -255,30 +476,80 @@ func (r UAIReader) ReadMargSolution(data []byte) (*Solution, error) {
 		return nil, errors.Errorf("Invalid data buffer: len=%d (<11)", len(data))
 	}
 
-	// A minimal solution will have 3 fields
 	// Note that we only read one MAR solution, *BUT* we'll skip anything
 	// before it. This is mainly useful for Merlin MAR files because Merlin
 	// includes a PR solution section before the MAR section.
-	text, lineCount := uaiPreprocess(data, "MAR")
-	if lineCount < 1 {
-		return nil, errors.Errorf("No lines in file")
+	sols, err := r.ReadMargSolutions(data)
+	if err != nil {
+		return nil, err
 	}
-	fr := NewFieldReader(text)
-	if len(fr.Fields) < 4 {
-		return nil, errors.Errorf("Invalid data: only %d fields found (<4)", len(fr.Fields))
+	if len(sols) > 1 {
+		return nil, errors.Errorf("Found %d solutions: only a single solution can be read", len(sols))
 	}
 
-	var err error
+	return sols[0], nil
+}
+
+// ReadMargSolutions implements the model.MultiSolReader interface. Files with
+// a solution per evidence instance have the instance count after the MAR
+// header. Files with a single solution (and no count) are read as well.
+func (r UAIReader) ReadMargSolutions(data []byte) ([]*Solution, error) {
+	fr, err := uaiSection(data, "MAR")
+	if err != nil {
+		return nil, err
+	}
+
+	// The count is ambiguous (it could be a var count), so we only accept
+	// the multi-instance format if it accounts for all the data
+	if count, err := fr.ReadInt(); err == nil && count > 0 {
+		sols := make([]*Solution, count)
+		for i := range sols {
+			sols[i], err = readMargVars(fr)
+			if err != nil {
+				break
+			}
+		}
+		if _, extra := fr.Read(); err == nil && extra == io.EOF {
+			return sols, nil
+		}
+	}
+
+	fr, err = uaiSection(data, "MAR")
+	if err != nil {
+		return nil, err
+	}
+	sol, err := readMargVars(fr)
+	if err != nil {
+		return nil, err
+	}
+	return []*Solution{sol}, nil
+}
+
+// uaiSection returns a token reader for a UAI solution file positioned after
+// the header of the section. Anything before the section is skipped.
+func uaiSection(data []byte, header string) (*TokenReader, error) {
+	fr := NewTokenReader(bytes.NewReader(data), 'c')
+	if err := fr.SkipTo(header); err == io.EOF {
+		return nil, errors.Errorf("No %s section found", header)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "Could not understand file")
+	}
 
-	// Check solution type
 	solType, err := fr.Read()
 	if err != nil {
 		return nil, errors.Wrap(err, "Could not understand file")
 	}
-	if solType != "MAR" {
-		return nil, errors.Errorf("Unknown solution file type %s", solType)
+	if solType != header {
+		return nil, fr.Errorf("Unknown solution file type %s", solType)
 	}
 
+	return fr, nil
+}
+
+// readMargVars reads a single MAR solution (starting with the var count)
+func readMargVars(fr *TokenReader) (*Solution, error) {
+	var err error
+
 	// Read variable count
 	var varCount int
 	varCount, err = fr.ReadInt()
@@ -286,7 +557,7 @@ func (r UAIReader) ReadMargSolution(data []byte) (*Solution, error) {
 		return nil, errors.Wrap(err, "Error reading UAI MAR Solution Variable Count")
 	}
 	if varCount < 1 {
-		return nil, errors.Errorf("Invalid variable count: %d", varCount)
+		return nil, fr.Errorf("Invalid variable count: %d", varCount)
 	}
 
 	// Read variables and their marginals
@@ -301,7 +572,7 @@ func (r UAIReader) ReadMargSolution(data []byte) (*Solution, error) {
 			return nil, errors.Wrapf(err, "Error reading Card for var %d", i)
 		}
 		if card < 1 {
-			return nil, errors.Errorf("Invalid card %d for var %d", card, i)
+			return nil, fr.Errorf("Invalid card %d for var %d", card, i)
 		}
 
 		sol.Vars[i], err = NewVariable(i, card)
@@ -316,7 +587,7 @@ func (r UAIReader) ReadMargSolution(data []byte) (*Solution, error) {
 				return nil, errors.Wrapf(err, "Could not read marg prob %d on var %d (%s)", m, i, sol.Vars[i].Name)
 			}
 			if p < 0.0 || p > 1.0 {
-				return nil, errors.Wrapf(err, "Invalid p=%f marg prob %d on var %d (%s)", p, m, i, sol.Vars[i].Name)
+				return nil, fr.Errorf("Invalid p=%f marg prob %d on var %d (%s)", p, m, i, sol.Vars[i].Name)
 			}
 			sol.Vars[i].Marginal[m] = p
 		}
@@ -327,6 +598,79 @@ func (r UAIReader) ReadMargSolution(data []byte) (*Solution, error) {
 		}
 	}
 
-	// Finally all done - we leave it to our caller to perform final checking
+	return sol, nil
+}
+
+// ReadPRSolution implements the model.PRReader interface. The value is the
+// natural log of Z (which is what Merlin writes). Merlin also writes Z itself
+// in parentheses after the log value: it is ignored. Any MAR section that
+// follows (also from Merlin) is ignored as well.
+func (r UAIReader) ReadPRSolution(data []byte) (*PRSolution, error) {
+	fr, err := uaiSection(data, "PR")
+	if err != nil {
+		return nil, err
+	}
+
+	logZ, err := fr.ReadFloat()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading UAI PR Solution log Z")
+	}
+	if math.IsNaN(logZ) {
+		return nil, fr.Errorf("Invalid PR solution: log Z is NaN")
+	}
+
+	return &PRSolution{LogZ: logZ}, nil
+}
+
+// ReadMPESolution implements the model.MPEReader interface. We accept both
+// the UAI 2014 format (MPE, var count, values) and the older format that has
+// an evidence sample count (which must be 1) before the var count.
+func (r UAIReader) ReadMPESolution(data []byte) (*MPESolution, error) {
+	fr, err := uaiSection(data, "MPE")
+	if err != nil {
+		return nil, err
+	}
+
+	varCount, err := fr.ReadInt()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading UAI MPE Solution Variable Count")
+	}
+	if varCount < 1 {
+		return nil, fr.Errorf("Invalid variable count: %d", varCount)
+	}
+
+	// Older format: the first number is the sample count (which must be 1),
+	// so with a "var count" of 1 we need to know how many values follow
+	values := []int{}
+	for {
+		if _, err := fr.Peek(); err == io.EOF {
+			break
+		}
+		val, err := fr.ReadInt()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not read MPE value for var %d", len(values))
+		}
+		values = append(values, val)
+	}
+	if varCount == 1 && len(values) > 1 {
+		varCount, values = values[0], values[1:]
+		if varCount < 1 {
+			return nil, errors.Errorf("Invalid variable count: %d", varCount)
+		}
+	}
+	if len(values) < varCount {
+		return nil, fr.Errorf("Found %d MPE values but expected %d", len(values), varCount)
+	}
+
+	sol := &MPESolution{
+		Values: values[:varCount],
+	}
+	for i, val := range sol.Values {
+		if val < 0 {
+			return nil, errors.Errorf("Invalid MPE value %d for var %d", val, i)
+		}
+	}
+
+	// We leave it to our caller to check vs the model
 	return sol, nil
 }
@@ -1,9 +1,14 @@
 package model
 
 import (
+	"fmt"
+	"io"
 	"math"
+	"strings"
 	"testing"
 
+	"github.com/pkg/errors"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -27,15 +32,33 @@ const PASCALExample = `MARKOV
  0.811 0.000 0.189
 `
 
-// Test preprocessing
+// Test skipping blank and comment lines
 func TestUAIPreproc(t *testing.T) {
 	assert := assert.New(t)
 
 	pre := ""
 	assertPreproc := func(lineCount int, correct string, buf string) {
-		s, c := uaiPreprocess([]byte(buf), pre)
-		assert.Equal(lineCount, c)
-		assert.Equal(correct, s)
+		tr := NewTokenReader(strings.NewReader(buf), 'c')
+		if len(pre) > 0 {
+			if err := tr.SkipTo(pre); err != nil {
+				assert.Equal(io.EOF, err)
+			}
+		}
+
+		toks := []string{}
+		for {
+			tok, err := tr.Read()
+			if err != nil {
+				assert.Equal(io.EOF, err)
+				break
+			}
+			toks = append(toks, tok)
+		}
+
+		assert.Equal(correct, strings.Join(toks, "\n"))
+		if len(pre) < 1 {
+			assert.Equal(lineCount, tr.Lines())
+		}
 	}
 
 	assertPreproc(0, "", "")
@@ -65,11 +88,78 @@ func TestUAIPreproc(t *testing.T) {
 	pre = "wor"
 
 	assertPreproc(2, "world\nabc", "hello\nworld\nabc")
-	assertPreproc(2, "world\nabc", "hello\nworld\nabc")
+	assertPreproc(2, "world\nabc", "hello world\nworld\nabc")
 	assertPreproc(2, "world\nabc", "\nhello\n\nworld\nabc")
 	assertPreproc(2, "world\nabc", "c comment\n\nhello\nc again\nworld\nabc\nc last\n\n")
 }
 
+// Errors should tell us where the problem is
+func TestUAIErrorPosition(t *testing.T) {
+	assert := assert.New(t)
+
+	r := UAIReader{}
+	assertErrAt := func(line int, col int, buf string) {
+		_, err := r.ReadModel([]byte(buf))
+		assert.Error(err)
+		pe, ok := errors.Cause(err).(*PosError)
+		if assert.True(ok, "Not a PosError: %v", err) {
+			assert.Equal(line, pe.Line)
+			assert.Equal(col, pe.Col)
+		}
+		assert.Contains(err.Error(), fmt.Sprintf("line %d, col %d", line, col))
+	}
+
+	assertErrAt(1, 1, "BAYESIAN\n1\n2\n1\n1 0\n2\n0.5 0.5\n")
+	assertErrAt(3, 3, "MARKOV\n2\n2 x\n1\n1 0\n2\n0.5 0.5\n")
+	assertErrAt(6, 5, "MARKOV\nc comment\n1\n2\n1\n  1 3\n2\n0.5 0.5\n")
+	assertErrAt(7, 5, "MARKOV\n1\n2\n1\n1 0\n2\n0.5 oops\n")
+	assertErrAt(7, 4, "MARKOV\n1\n2\n1\n1 0\n2\n0.5")
+
+	_, err := r.ReadEvidence([]byte("1\n2 0 1 0 1\n"))
+	assert.Error(err)
+	assert.Contains(err.Error(), "line 2, col 7")
+}
+
+// Compressed models are read transparently
+func TestUAICompressed(t *testing.T) {
+	assert := assert.New(t)
+
+	plain, err := NewModelFromFile(UAIReader{}, "../res/one.uai", false)
+	assert.NoError(err)
+
+	for _, ext := range []string{".gz", ".xz"} {
+		fn := "../res/one.uai" + ext
+		assert.IsType(UAIReader{}, ReaderForFile(fn))
+
+		m, err := NewModelFromFile(ReaderForFile(fn), fn, false)
+		assert.NoError(err)
+		assert.Equal("../res/one", m.Name)
+		assert.Equal(len(plain.Vars), len(m.Vars))
+		assert.Equal(plain.Funcs[0].Table, m.Funcs[0].Table)
+	}
+}
+
+// Evidence and solutions for a compressed model are found next to the
+// uncompressed name
+func TestUAICompressedSideFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	fn := "../res/one.uai.gz"
+	assert.Equal("../res/one.uai", UncompressedName(fn))
+	assert.Equal("../res/one.uai", UncompressedName("../res/one.uai"))
+
+	m, err := NewModelFromFile(ReaderForFile(fn), fn, true)
+	assert.NoError(err)
+	assert.Equal(-1, m.Vars[0].FixedVal)
+
+	sol, err := NewSolutionFromFile(UAIReader{}, UncompressedName(fn)+".MAR")
+	assert.NoError(err)
+	assert.NoError(m.Vars[0].NormMarginal())
+	score, err := sol.Error(m.Vars)
+	assert.NoError(err)
+	assert.NotNil(score)
+}
+
 // Test reading the example file at http://www.cs.huji.ac.il/project/PASCAL/fileFormat.php#model
 func TestUAIDoc(t *testing.T) {
 	assert := assert.New(t)
@@ -182,7 +272,7 @@ func TestUAIMariEvidFile(t *testing.T) {
 	// Remember that the default evid file has no evidence
 	assert.Equal(-1, m.Vars[0].FixedVal)
 
-	// Check that we can't support multi-sample evidence
+	// Check that we can't apply multi-sample evidence
 	err = r.ApplyEvidence([]byte("2\n1 0 0\n1 0 1"), m)
 	assert.Error(err)
 	assert.Equal(-1, m.Vars[0].FixedVal)
@@ -206,3 +296,136 @@ func TestUAIMariEvidFile(t *testing.T) {
 	checkOneVarSet("1 0 0", 0)
 	checkOneVarSet("1\n1 0 1", 1)
 }
+
+// Test reading multi-sample evidence
+func TestUAIMultiEvidence(t *testing.T) {
+	assert := assert.New(t)
+
+	r := UAIReader{}
+
+	evid, err := NewEvidenceFromBuffer(r, []byte("3\n1 0 0\n0\n2 2 1 0 1\n"))
+	assert.NoError(err)
+	assert.Equal([]Evidence{{0: 0}, {}, {0: 1, 2: 1}}, evid)
+	assert.Equal([]int{0, 2}, evid[2].VarIDs())
+
+	// Older single line format and no evidence
+	evid, err = NewEvidenceFromBuffer(r, []byte("2 1 0 0 1"))
+	assert.NoError(err)
+	assert.Equal([]Evidence{{1: 0, 0: 1}}, evid)
+
+	evid, err = NewEvidenceFromFile(r, "../res/one.uai.evid")
+	assert.NoError(err)
+	assert.Equal(0, len(evid))
+
+	// Bad data
+	for _, data := range []string{
+		"",
+		"2\n1 0 0\n",        // Missing a sample
+		"1\n1 0 0\n1 0 1\n", // Extra sample
+		"1\n2 0 0 0 1\n",    // Repeated variable
+		"-1\n1 0 0\n",       // Bad count
+		"1\n1 0 x\n",        // Bad value
+	} {
+		_, err = NewEvidenceFromBuffer(r, []byte(data))
+		assert.Error(err, data)
+	}
+}
+
+func TestUAISoftEvidence(t *testing.T) {
+	assert := assert.New(t)
+
+	r := UAIReader{}
+
+	// No SOFT section is no soft evidence
+	se, err := r.ReadSoftEvidence([]byte("1\n1 0 1\n"))
+	assert.NoError(err)
+	assert.Equal(0, len(se))
+
+	data := []byte("1\n1 0 1\nc likelihoods follow\nSOFT\n2\n1 2 0.9 0.1\n2 3 0.2 0.3 0.5\n")
+	evid, err := r.ReadEvidence(data)
+	assert.NoError(err)
+	assert.Equal([]Evidence{{0: 1}}, evid)
+
+	se, err = r.ReadSoftEvidence(data)
+	assert.NoError(err)
+	assert.Equal(SoftEvidence{1: {0.9, 0.1}, 2: {0.2, 0.3, 0.5}}, se)
+
+	// Soft evidence only
+	evid, err = r.ReadEvidence([]byte("SOFT 1 0 2 0.5 0.5"))
+	assert.NoError(err)
+	assert.Equal(0, len(evid))
+
+	// Bad soft evidence
+	_, err = r.ReadSoftEvidence([]byte("SOFT\n1\n0 2 0.5\n"))
+	assert.Error(err)
+	_, err = r.ReadSoftEvidence([]byte("SOFT\n2\n0 2 0.5 0.5\n0 2 0.5 0.5\n"))
+	assert.Error(err)
+	_, err = r.ReadSoftEvidence([]byte("SOFT\n1\n0 2 0.5 0.5 0.5\n"))
+	assert.Error(err)
+
+	// Apply both kinds
+	m, err := NewModelFromBuffer(r, []byte(PASCALExample))
+	assert.NoError(err)
+	funcCount := len(m.Funcs)
+	assert.NoError(r.ApplyEvidence(data, m))
+	assert.Equal(1, m.Vars[0].FixedVal)
+	assert.Equal(funcCount+2, len(m.Funcs))
+	assert.NoError(m.Check())
+
+	// Soft evidence has to match the model
+	m, err = NewModelFromBuffer(r, []byte(PASCALExample))
+	assert.NoError(err)
+	assert.Error(r.ApplyEvidence([]byte("SOFT\n1\n0 3 0.2 0.3 0.5\n"), m))
+	assert.Equal(funcCount, len(m.Funcs))
+}
+
+func TestUAIDomainEvidence(t *testing.T) {
+	assert := assert.New(t)
+
+	r := UAIReader{}
+
+	// Sections can be in either order
+	data := []byte("1\n1 0 1\nDOMAIN\n1\n2 2 0 2\nSOFT\n1\n1 2 0.9 0.1\n")
+	evid, err := r.ReadEvidence(data)
+	assert.NoError(err)
+	assert.Equal([]Evidence{{0: 1}}, evid)
+
+	de, err := r.ReadDomainEvidence(data)
+	assert.NoError(err)
+	assert.Equal(DomainEvidence{2: {0, 2}}, de)
+
+	se, err := r.ReadSoftEvidence(data)
+	assert.NoError(err)
+	assert.Equal(SoftEvidence{1: {0.9, 0.1}}, se)
+
+	de, err = r.ReadDomainEvidence([]byte("1\n1 0 1\n"))
+	assert.NoError(err)
+	assert.Equal(0, len(de))
+
+	_, err = r.ReadDomainEvidence([]byte("DOMAIN\n1\n2 0\n"))
+	assert.Error(err)
+	_, err = r.ReadDomainEvidence([]byte("DOMAIN\n1\n2 2 0\n"))
+	assert.Error(err)
+
+	m, err := NewModelFromBuffer(r, []byte(PASCALExample))
+	assert.NoError(err)
+	assert.NoError(r.ApplyEvidence(data, m))
+	assert.Equal(1, m.Vars[0].FixedVal)
+	assert.Equal([]int{0, 2}, m.Vars[2].AllowedValues())
+	assert.NoError(m.Check())
+
+	// Domain evidence has to agree with the model and the other evidence
+	m, err = NewModelFromBuffer(r, []byte(PASCALExample))
+	assert.NoError(err)
+	assert.Error(r.ApplyEvidence([]byte("DOMAIN\n1\n1 1 2\n"), m))
+	assert.Error(r.ApplyEvidence([]byte("1 0 1\nDOMAIN\n1\n0 1 0\n"), m))
+	assert.Equal(-1, m.Vars[0].FixedVal)
+	assert.Nil(m.Vars[0].Allowed)
+
+	// Hard evidence has to agree with current domains
+	assert.NoError(m.RestrictDomains(DomainEvidence{1: {0}}))
+	assert.Error(m.SetEvidence(Evidence{1: 1}))
+	assert.NoError(m.SetEvidence(Evidence{1: 0}))
+	m.ClearDomains()
+	assert.Nil(m.Vars[1].Allowed)
+}
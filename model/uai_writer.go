@@ -0,0 +1,249 @@
+package model
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// UAIWriter writes the UAI inference data set formats read by UAIReader.
+type UAIWriter struct {
+}
+
+// writeFloat writes a float with the shortest representation that will be
+// read back as the same value.
+func writeFloat(bw *bufio.Writer, f float64) {
+	bw.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+}
+
+// WriteModel implements the model.Writer interface. Functions in log space are
+// converted back (so any zeros smoothed by UseLogSpace are written as small
+// positive values). Variable and function names are not part of the format,
+// so the model name is only written as a comment.
+func (w UAIWriter) WriteModel(out io.Writer, m *Model) error {
+	if m == nil || len(m.Vars) < 1 || len(m.Funcs) < 1 {
+		return errors.New("Can not write an empty model")
+	}
+	if m.Type != BAYES && m.Type != MARKOV {
+		return errors.Errorf("Unknown model type %s", m.Type)
+	}
+	for i, v := range m.Vars {
+		if i != v.ID {
+			return errors.Errorf("Invalid ID for var %s: expected %d but was %d", v.Name, i, v.ID)
+		}
+	}
+
+	bw := bufio.NewWriter(out)
+
+	if len(m.Name) > 0 {
+		bw.WriteString("c " + strings.Join(strings.Fields(m.Name), " ") + "\n")
+	}
+	bw.WriteString(m.Type)
+	bw.WriteByte('\n')
+
+	// Variables
+	bw.WriteString(strconv.Itoa(len(m.Vars)))
+	bw.WriteByte('\n')
+	for i, v := range m.Vars {
+		if i > 0 {
+			bw.WriteByte(' ')
+		}
+		bw.WriteString(strconv.Itoa(v.Card))
+	}
+	bw.WriteByte('\n')
+
+	// Function scopes
+	bw.WriteString(strconv.Itoa(len(m.Funcs)))
+	bw.WriteByte('\n')
+	for _, f := range m.Funcs {
+		bw.WriteString(strconv.Itoa(len(f.Vars)))
+		for _, v := range f.Vars {
+			if v.ID < 0 || v.ID >= len(m.Vars) || m.Vars[v.ID].Card != v.Card {
+				return errors.Errorf("Function %s has var %s which does not match the model", f.Name, v.Name)
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(strconv.Itoa(v.ID))
+		}
+		bw.WriteByte('\n')
+	}
+
+	// Function tables
+	for _, f := range m.Funcs {
+		if len(f.Table) != calcTabSize(f.Vars) {
+			return errors.Errorf("Function %s has table size %d but expected %d", f.Name, len(f.Table), calcTabSize(f.Vars))
+		}
+
+		bw.WriteByte('\n')
+		bw.WriteString(strconv.Itoa(len(f.Table)))
+		bw.WriteByte('\n')
+		for i, val := range f.Table {
+			if f.IsLog {
+				val = math.Exp(val)
+			}
+			if i > 0 {
+				bw.WriteByte(' ')
+			}
+			writeFloat(bw, val)
+		}
+		bw.WriteByte('\n')
+	}
+
+	return bw.Flush()
+}
+
+// WriteEvidence implements the model.Writer interface. We always write the
+// instance count first (with each instance on its own line) so that any
+// number of instances can be read back by ReadEvidence.
+func (w UAIWriter) WriteEvidence(out io.Writer, evid []Evidence) error {
+	bw := bufio.NewWriter(out)
+
+	bw.WriteString(strconv.Itoa(len(evid)))
+	bw.WriteByte('\n')
+	for _, e := range evid {
+		bw.WriteString(strconv.Itoa(len(e)))
+		for _, idx := range e.VarIDs() {
+			if idx < 0 || e[idx] < 0 {
+				return errors.Errorf("Invalid evidence %d=%d", idx, e[idx])
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(strconv.Itoa(idx))
+			bw.WriteByte(' ')
+			bw.WriteString(strconv.Itoa(e[idx]))
+		}
+		bw.WriteByte('\n')
+	}
+
+	return bw.Flush()
+}
+
+// WriteMargSolution implements the model.SolWriter interface. The marginals
+// are written as-is, so they should already be normalized. If the variables
+// have state labels, the variable names and labels are written first as
+// comments.
+func (w UAIWriter) WriteMargSolution(out io.Writer, s *Solution) error {
+	bw := bufio.NewWriter(out)
+
+	if s != nil {
+		writeMargLabels(bw, s)
+	}
+	bw.WriteString("MAR\n")
+	if err := writeMargVars(bw, s); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// WriteMargSolutions implements the model.MultiSolWriter interface. We write
+// the instance count after the MAR header and then one solution per line.
+func (w UAIWriter) WriteMargSolutions(out io.Writer, sols []*Solution) error {
+	if len(sols) < 1 {
+		return errors.New("Can not write an empty solution list")
+	}
+
+	bw := bufio.NewWriter(out)
+
+	if sols[0] != nil {
+		writeMargLabels(bw, sols[0])
+	}
+	bw.WriteString("MAR\n")
+	bw.WriteString(strconv.Itoa(len(sols)))
+	bw.WriteByte('\n')
+	for _, s := range sols {
+		if err := writeMargVars(bw, s); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// writeMargLabels writes a comment line for each variable with its ID, name,
+// and state labels, but only if there are state labels
+func writeMargLabels(bw *bufio.Writer, s *Solution) {
+	labeled := false
+	for _, v := range s.Vars {
+		if v.Labels != nil {
+			labeled = true
+			break
+		}
+	}
+	if !labeled {
+		return
+	}
+
+	for _, v := range s.Vars {
+		bw.WriteString("c " + strconv.Itoa(v.ID) + " " + v.Name + ":")
+		for _, lbl := range v.Labels {
+			bw.WriteString(" " + lbl)
+		}
+		bw.WriteByte('\n')
+	}
+}
+
+// writeMargVars writes a single MAR solution line (starting with var count)
+func writeMargVars(bw *bufio.Writer, s *Solution) error {
+	if s == nil || len(s.Vars) < 1 {
+		return errors.New("Can not write an empty solution")
+	}
+
+	bw.WriteString(strconv.Itoa(len(s.Vars)))
+	for _, v := range s.Vars {
+		if v.Card != len(v.Marginal) {
+			return errors.Errorf("Variable %s Card %d != len(M) %d", v.Name, v.Card, len(v.Marginal))
+		}
+
+		bw.WriteByte(' ')
+		bw.WriteString(strconv.Itoa(v.Card))
+		for _, p := range v.Marginal {
+			bw.WriteByte(' ')
+			writeFloat(bw, p)
+		}
+	}
+	bw.WriteByte('\n')
+
+	return nil
+}
+
+// WritePRSolution implements the model.PRWriter interface. We write the
+// natural log of Z, which is what ReadPRSolution expects.
+func (w UAIWriter) WritePRSolution(out io.Writer, s *PRSolution) error {
+	if s == nil {
+		return errors.New("Can not write an empty PR solution")
+	}
+	if math.IsNaN(s.LogZ) {
+		return errors.New("Can not write a PR solution with log Z = NaN")
+	}
+
+	bw := bufio.NewWriter(out)
+
+	bw.WriteString("PR\n")
+	writeFloat(bw, s.LogZ)
+	bw.WriteByte('\n')
+
+	return bw.Flush()
+}
+
+// WriteMPESolution implements the model.MPEWriter interface. We use the UAI
+// 2014 format (no evidence sample count line).
+func (w UAIWriter) WriteMPESolution(out io.Writer, s *MPESolution) error {
+	if s == nil || len(s.Values) < 1 {
+		return errors.New("Can not write an empty MPE solution")
+	}
+
+	bw := bufio.NewWriter(out)
+
+	bw.WriteString("MPE\n")
+	bw.WriteString(strconv.Itoa(len(s.Values)))
+	for _, val := range s.Values {
+		bw.WriteByte(' ')
+		bw.WriteString(strconv.Itoa(val))
+	}
+	bw.WriteByte('\n')
+
+	return bw.Flush()
+}
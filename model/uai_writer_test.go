@@ -0,0 +1,292 @@
+package model
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUAIWriteMarg(t *testing.T) {
+	assert := assert.New(t)
+
+	w := UAIWriter{}
+	r := UAIReader{}
+
+	buf := &bytes.Buffer{}
+	assert.Error(w.WriteMargSolution(buf, &Solution{}))
+
+	sol, err := NewSolutionFromFile(r, "../res/Grids_11.uai.MAR")
+	assert.NoError(err)
+
+	buf.Reset()
+	assert.NoError(w.WriteMargSolution(buf, sol))
+
+	sol2, err := NewSolutionFromBuffer(r, buf.Bytes())
+	assert.NoError(err)
+	assert.Equal(len(sol.Vars), len(sol2.Vars))
+	for i, v := range sol.Vars {
+		assert.Equal(v.Card, sol2.Vars[i].Card)
+		assert.InDeltaSlice(v.Marginal, sol2.Vars[i].Marginal, 1e-12)
+	}
+
+	// Names and state labels are written as comments
+	m, err := NewModelFromFile(BIFReader{}, "../res/asia.bif", false)
+	assert.NoError(err)
+	sol, err = NewSolution(m.Vars[:2])
+	assert.NoError(err)
+	buf.Reset()
+	assert.NoError(w.WriteMargSolution(buf, sol))
+	assert.Equal("c 0 asia: yes no\nc 1 tub: yes no\nMAR\n2 2 0.5 0.5 2 0.5 0.5\n", buf.String())
+	sol2, err = NewSolutionFromBuffer(r, buf.Bytes())
+	assert.NoError(err)
+	assert.Equal(2, len(sol2.Vars))
+}
+
+func TestUAIWriteMargMulti(t *testing.T) {
+	assert := assert.New(t)
+
+	w := UAIWriter{}
+	r := UAIReader{}
+
+	buf := &bytes.Buffer{}
+	assert.Error(w.WriteMargSolutions(buf, nil))
+
+	one, err := NewSolutionFromFile(r, "../res/one.uai.MAR")
+	assert.NoError(err)
+	det, err := NewSolutionFromFile(r, "../res/deterministic.uai.MAR")
+	assert.NoError(err)
+
+	buf.Reset()
+	assert.NoError(w.WriteMargSolutions(buf, []*Solution{one, det, one}))
+	assert.Equal("MAR\n3\n1 2 0.25 0.75\n3 2 0.5 0.5 2 0.5 0.5 2 0.5 0.5\n1 2 0.25 0.75\n", buf.String())
+
+	sols, err := NewSolutionsFromBuffer(r, buf.Bytes())
+	assert.NoError(err)
+	assert.Equal(3, len(sols))
+	assert.Equal(one.Vars[0].Marginal, sols[0].Vars[0].Marginal)
+	assert.Equal(3, len(sols[1].Vars))
+	assert.Equal(one.Vars[0].Marginal, sols[2].Vars[0].Marginal)
+
+	// Can't read multiple solutions as a single solution
+	_, err = NewSolutionFromBuffer(r, buf.Bytes())
+	assert.Error(err)
+
+	// Single solutions (with or without an instance count) are fine
+	for _, data := range []string{"MAR 1 2 0.25 0.75", "MAR\n1\n1 2 0.25 0.75\n"} {
+		sols, err = NewSolutionsFromBuffer(r, []byte(data))
+		assert.NoError(err)
+		assert.Equal(1, len(sols))
+		assert.Equal([]float64{0.25, 0.75}, sols[0].Vars[0].Marginal)
+
+		sol, err := NewSolutionFromBuffer(r, []byte(data))
+		assert.NoError(err)
+		assert.Equal([]float64{0.25, 0.75}, sol.Vars[0].Marginal)
+	}
+
+	// Merlin files have a PR section first
+	sols, err = NewSolutionsFromFile(r, "../res/Grids_11.uai.merlin.MAR")
+	assert.NoError(err)
+	assert.Equal(1, len(sols))
+	assert.Equal(100, len(sols[0].Vars))
+}
+
+func TestUAIWritePR(t *testing.T) {
+	assert := assert.New(t)
+
+	w := UAIWriter{}
+	r := UAIReader{}
+
+	buf := &bytes.Buffer{}
+	assert.Error(w.WritePRSolution(buf, nil))
+
+	// Merlin writes a PR section before the MAR section
+	sol, err := NewPRSolutionFromFile(r, "../res/Grids_11.uai.merlin.MAR")
+	assert.NoError(err)
+	assert.InDelta(365.581530, sol.LogZ, 1e-12)
+
+	buf.Reset()
+	assert.NoError(w.WritePRSolution(buf, sol))
+	assert.Equal("PR\n365.58153\n", buf.String())
+
+	sol2, err := NewPRSolutionFromBuffer(r, buf.Bytes())
+	assert.NoError(err)
+	assert.Equal(sol.LogZ, sol2.LogZ)
+
+	// Missing or bad PR data
+	_, err = NewPRSolutionFromBuffer(r, []byte("MAR\n1 2 0.5 0.5\n"))
+	assert.Error(err)
+	_, err = NewPRSolutionFromBuffer(r, []byte("PR\nnope\n"))
+	assert.Error(err)
+	_, err = NewPRSolutionFromFile(r, "../res/does-not-exist.PR")
+	assert.Error(err)
+}
+
+func TestUAIWriteMPE(t *testing.T) {
+	assert := assert.New(t)
+
+	w := UAIWriter{}
+	r := UAIReader{}
+
+	buf := &bytes.Buffer{}
+	assert.Error(w.WriteMPESolution(buf, &MPESolution{}))
+
+	sol := &MPESolution{Values: []int{1, 0, 2}}
+	assert.NoError(w.WriteMPESolution(buf, sol))
+	assert.Equal("MPE\n3 1 0 2\n", buf.String())
+
+	sol2, err := NewMPESolutionFromBuffer(r, buf.Bytes())
+	assert.NoError(err)
+	assert.Equal(sol.Values, sol2.Values)
+
+	// Older format with evidence sample count
+	sol2, err = NewMPESolutionFromBuffer(r, []byte("MPE\n1\n3 1 0 2\n"))
+	assert.NoError(err)
+	assert.Equal(sol.Values, sol2.Values)
+
+	// Single var in both formats
+	sol2, err = NewMPESolutionFromBuffer(r, []byte("MPE\n1 1\n"))
+	assert.NoError(err)
+	assert.Equal([]int{1}, sol2.Values)
+	sol2, err = NewMPESolutionFromBuffer(r, []byte("MPE\n1\n1 1\n"))
+	assert.NoError(err)
+	assert.Equal([]int{1}, sol2.Values)
+
+	// Bad data
+	_, err = NewMPESolutionFromBuffer(r, []byte("MAR\n1 2 0.5 0.5\n"))
+	assert.Error(err)
+	_, err = NewMPESolutionFromBuffer(r, []byte("MPE\n3 1 0\n"))
+	assert.Error(err)
+	_, err = NewMPESolutionFromBuffer(r, []byte("MPE\n2 1 -1\n"))
+	assert.Error(err)
+	_, err = NewMPESolutionFromFile(r, "../res/does-not-exist.MPE")
+	assert.Error(err)
+}
+
+// checkSameModel insures that the models match in everything that the UAI
+// format stores (so names are NOT checked)
+func checkSameModel(assert *assert.Assertions, m1 *Model, m2 *Model, delta float64) {
+	assert.Equal(m1.Type, m2.Type)
+	assert.Equal(len(m1.Vars), len(m2.Vars))
+	for i, v := range m1.Vars {
+		assert.Equal(v.Card, m2.Vars[i].Card)
+	}
+
+	assert.Equal(len(m1.Funcs), len(m2.Funcs))
+	for i, f := range m1.Funcs {
+		f2 := m2.Funcs[i]
+		assert.Equal(len(f.Vars), len(f2.Vars))
+		for j, v := range f.Vars {
+			assert.Equal(v.ID, f2.Vars[j].ID)
+		}
+		assert.False(f2.IsLog)
+		if f.IsLog {
+			for j, val := range f.Table {
+				assert.InDelta(math.Exp(val), f2.Table[j], delta)
+			}
+		} else {
+			assert.InDeltaSlice(f.Table, f2.Table, delta)
+		}
+	}
+}
+
+func TestUAIWriteModel(t *testing.T) {
+	assert := assert.New(t)
+
+	w := UAIWriter{}
+	r := UAIReader{}
+
+	buf := &bytes.Buffer{}
+	assert.Error(w.WriteModel(buf, nil))
+	assert.Error(w.WriteModel(buf, &Model{Type: MARKOV}))
+
+	for _, fn := range []string{"one.uai", "sample.uai", "deterministic.uai", "Grids_11.uai", "Promedus_11.uai"} {
+		m, err := NewModelFromFile(r, "../res/"+fn, false)
+		assert.NoError(err)
+
+		buf.Reset()
+		assert.NoError(w.WriteModel(buf, m))
+		m2, err := NewModelFromBuffer(r, buf.Bytes())
+		assert.NoError(err)
+		checkSameModel(assert, m, m2, 0.0)
+	}
+
+	// Sample model is the documentation example: we should match it exactly
+	// (except for comments)
+	m, err := NewModelFromFile(r, "../res/sample.uai", false)
+	assert.NoError(err)
+	buf.Reset()
+	assert.NoError(w.WriteModel(buf, m))
+	assert.Equal(
+		"c ../res/sample\nMARKOV\n3\n2 2 3\n3\n1 0\n2 0 1\n2 1 2\n\n2\n0.436 0.564\n\n4\n0.128 0.872 0.92 0.08\n\n6\n0.21 0.333 0.457 0.811 0 0.189\n",
+		buf.String(),
+	)
+
+	// Log space is converted back (with zeros smoothed)
+	for _, f := range m.Funcs {
+		assert.NoError(f.UseLogSpace())
+	}
+	buf.Reset()
+	assert.NoError(w.WriteModel(buf, m))
+	m2, err := NewModelFromBuffer(r, buf.Bytes())
+	assert.NoError(err)
+	checkSameModel(assert, m, m2, 1e-12)
+	assert.InDelta(1e-6, m2.Funcs[2].Table[4], 1e-12)
+
+	// Changed models (like a collapsed function) are fine, but vars must match
+	f, err := NewFunction(3, []*Variable{m.Vars[2], m.Vars[0]})
+	assert.NoError(err)
+	f.Name = "COLLAPSE-B"
+	m.Funcs = append(m.Funcs[1:], f)
+	buf.Reset()
+	assert.NoError(w.WriteModel(buf, m))
+	m2, err = NewModelFromBuffer(r, buf.Bytes())
+	assert.NoError(err)
+	checkSameModel(assert, m, m2, 1e-12)
+
+	bad, err := NewVariable(7, 2)
+	assert.NoError(err)
+	f.Vars[1] = bad
+	assert.Error(w.WriteModel(buf, m))
+}
+
+func TestUAIWriteEvidence(t *testing.T) {
+	assert := assert.New(t)
+
+	w := UAIWriter{}
+	r := UAIReader{}
+
+	buf := &bytes.Buffer{}
+	evid := []Evidence{{2: 1, 0: 0}, {}, {1: 1}}
+	assert.NoError(w.WriteEvidence(buf, evid))
+	assert.Equal("3\n2 0 0 2 1\n0\n1 1 1\n", buf.String())
+
+	evid2, err := NewEvidenceFromBuffer(r, buf.Bytes())
+	assert.NoError(err)
+	assert.Equal(evid, evid2)
+
+	// Single instance and no instances
+	for _, e := range [][]Evidence{{{0: 1}}, {{}}, {}} {
+		buf.Reset()
+		assert.NoError(w.WriteEvidence(buf, e))
+		evid2, err := NewEvidenceFromBuffer(r, buf.Bytes())
+		assert.NoError(err)
+		assert.Equal(e, evid2)
+	}
+
+	// From the evidence currently on a model
+	m, err := NewModelFromFile(r, "../res/Grids_11.uai", true)
+	assert.NoError(err)
+	buf.Reset()
+	assert.NoError(w.WriteEvidence(buf, []Evidence{m.Evidence()}))
+	m2, err := NewModelFromFile(r, "../res/Grids_11.uai", false)
+	assert.NoError(err)
+	assert.NoError(r.ApplyEvidence(buf.Bytes(), m2))
+	for i, v := range m.Vars {
+		assert.Equal(v.FixedVal, m2.Vars[i].FixedVal)
+	}
+
+	buf.Reset()
+	assert.Error(w.WriteEvidence(buf, []Evidence{{-1: 0}}))
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"math"
+	"strconv"
 
 	"github.com/pkg/errors"
 )
@@ -15,6 +16,8 @@ type Variable struct {
 	Marginal  []float64          // Current best estimate for marginal distribution: len should equal Card
 	State     map[string]float64 // State/stats a sampler can track - mainly for JSON tracking
 	Collapsed bool               // For Collapsed == True, you should just sample from Marginal (default is False)
+	Labels    []string           `json:",omitempty"` // Optional names for each value (state): len should equal Card
+	Allowed   []bool             `json:",omitempty"` // Values allowed by evidence (see Restrict): nil allows every value
 }
 
 // NewVariable is our standard way to create a variable from an index and a
@@ -69,6 +72,16 @@ func (v *Variable) Clone() *Variable {
 
 	copy(cp.Marginal, v.Marginal)
 
+	if v.Labels != nil {
+		cp.Labels = make([]string, len(v.Labels))
+		copy(cp.Labels, v.Labels)
+	}
+
+	if v.Allowed != nil {
+		cp.Allowed = make([]bool, len(v.Allowed))
+		copy(cp.Allowed, v.Allowed)
+	}
+
 	return cp
 }
 
@@ -78,6 +91,10 @@ func (v *Variable) Check() error {
 		return errors.Errorf("Variable %s Card %d != len(M) %d", v.Name, v.Card, len(v.Marginal))
 	}
 
+	if v.Labels != nil && len(v.Labels) != v.Card {
+		return errors.Errorf("Variable %s Card %d != len(Labels) %d", v.Name, v.Card, len(v.Labels))
+	}
+
 	// FixedVal should be -1 or correspond to card
 	// Note that this means you can never have a fixed value for a var with card 0.
 	if v.FixedVal != -1 {
@@ -86,6 +103,19 @@ func (v *Variable) Check() error {
 		}
 	}
 
+	// Allowed values (if any) should match card and allow something
+	if v.Allowed != nil {
+		if len(v.Allowed) != v.Card {
+			return errors.Errorf("Variable %s Card %d != len(Allowed) %d", v.Name, v.Card, len(v.Allowed))
+		}
+		if v.FixedVal >= 0 && !v.Allowed[v.FixedVal] {
+			return errors.Errorf("Variable %s has fixed val %d which is not allowed", v.Name, v.FixedVal)
+		}
+		if len(v.AllowedValues()) < 1 {
+			return errors.Errorf("Variable %s has no allowed values", v.Name)
+		}
+	}
+
 	// marginal should be a probability dist
 	if v.Card > 0 {
 		var sum float64
@@ -146,6 +176,95 @@ func (v *Variable) NormMarginal() error {
 	return nil
 }
 
+// Restrict limits the variable to the given values (negative and set-valued
+// evidence like X != 3 or X in {0, 2}). Any current restriction is kept, so
+// the allowed values are the intersection. The variable is unchanged if no
+// values would be left or the fixed value (if any) would not be allowed.
+func (v *Variable) Restrict(vals []int) error {
+	allowed := make([]bool, v.Card)
+	for _, val := range vals {
+		if val < 0 || val >= v.Card {
+			return errors.Errorf("Invalid value %d for variable %s with card %d", val, v.Name, v.Card)
+		}
+		allowed[val] = v.Allowed == nil || v.Allowed[val]
+	}
+
+	count := 0
+	for _, ok := range allowed {
+		if ok {
+			count++
+		}
+	}
+	if count < 1 {
+		return errors.Errorf("No values would be allowed for variable %s", v.Name)
+	}
+	if v.FixedVal >= 0 && !allowed[v.FixedVal] {
+		return errors.Errorf("Variable %s has fixed val %d which would not be allowed", v.Name, v.FixedVal)
+	}
+
+	v.Allowed = allowed
+	return nil
+}
+
+// Exclude removes the given values from the variable's allowed values (see
+// Restrict)
+func (v *Variable) Exclude(vals []int) error {
+	excluded := make([]bool, v.Card)
+	for _, val := range vals {
+		if val < 0 || val >= v.Card {
+			return errors.Errorf("Invalid value %d for variable %s with card %d", val, v.Name, v.Card)
+		}
+		excluded[val] = true
+	}
+
+	keep := make([]int, 0, v.Card)
+	for val, ex := range excluded {
+		if !ex {
+			keep = append(keep, val)
+		}
+	}
+	return v.Restrict(keep)
+}
+
+// Allows returns true if the evidence (the fixed value and any restriction)
+// allows the given value
+func (v *Variable) Allows(val int) bool {
+	if v.FixedVal >= 0 && val != v.FixedVal {
+		return false
+	}
+	return v.Allowed == nil || v.Allowed[val]
+}
+
+// AllowedValues returns the values allowed by the evidence in order
+func (v *Variable) AllowedValues() []int {
+	vals := make([]int, 0, v.Card)
+	for val := 0; val < v.Card; val++ {
+		if v.Allows(val) {
+			vals = append(vals, val)
+		}
+	}
+	return vals
+}
+
+// LabelIndex returns the value for the given label. If the variable has no
+// labels, the label must be the value's index.
+func (v *Variable) LabelIndex(label string) (int, error) {
+	if v.Labels == nil {
+		val, err := strconv.Atoi(label)
+		if err != nil || val < 0 || val >= v.Card {
+			return -1, errors.Errorf("Invalid value %s for variable %s with card %d", label, v.Name, v.Card)
+		}
+		return val, nil
+	}
+
+	for i, lbl := range v.Labels {
+		if lbl == label {
+			return i, nil
+		}
+	}
+	return -1, errors.Errorf("Unknown value %s for variable %s", label, v.Name)
+}
+
 // CreateName just gives a name to variable based on a numeric index
 func (v *Variable) CreateName(i int) error {
 	if i < 0 {
@@ -8,7 +8,7 @@ import (
 type VariableIter struct {
 	vars       []*Variable
 	lastVal    []int
-	honorFixed bool // If true, vars with a FixedVal always take that value
+	honorFixed bool // If true, vars only take values allowed by their evidence
 }
 
 // NewVariableIter returns a new iterator over the list of variables
@@ -25,12 +25,11 @@ func NewVariableIter(src []*Variable, honorFixed bool) (*VariableIter, error) {
 
 	copy(vi.vars, src) // Note: we don't clone
 
-	// Set initial value to include Fixed Vals if that's what they want
+	// Set initial value to include Fixed Vals (and restricted domains) if
+	// that's what they want
 	if vi.honorFixed {
 		for i, v := range vi.vars {
-			if v.FixedVal >= 0 {
-				vi.lastVal[i] = v.FixedVal
-			}
+			vi.lastVal[i] = vi.first(v)
 		}
 	}
 
@@ -59,6 +58,9 @@ func (vi *VariableIter) Next() bool {
 		}
 
 		prop := vi.lastVal[i] + 1
+		for vi.honorFixed && prop < v.Card && !v.Allows(prop) {
+			prop++ // Skip values not allowed by evidence
+		}
 
 		if prop < v.Card {
 			// All done
@@ -66,9 +68,22 @@ func (vi *VariableIter) Next() bool {
 			return true
 		}
 
-		vi.lastVal[i] = 0 // Overflow: continue to next
+		vi.lastVal[i] = vi.first(v) // Overflow: continue to next
 	}
 
 	// If we're still here then we set every digit to 0 and wrapped around
 	return false
 }
+
+// first returns the first value for the variable (honoring evidence if we
+// should)
+func (vi *VariableIter) first(v *Variable) int {
+	if vi.honorFixed {
+		for val := 0; val < v.Card; val++ {
+			if v.Allows(val) {
+				return val
+			}
+		}
+	}
+	return 0
+}
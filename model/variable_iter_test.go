@@ -24,13 +24,11 @@ func checkExpectSeq(assert *assert.Assertions, fixed bool, vars []*Variable, exp
 	assert.Equal(len(expected)-1, curr)
 
 	// Iterators wrap back around to the start value, which is different if
-	// there are fixed values
+	// there are fixed values (or restricted domains)
 	finalVal := make([]int, len(vars))
 	if fixed {
 		for i, v := range vars {
-			if v.FixedVal >= 0 {
-				finalVal[i] = v.FixedVal
-			}
+			finalVal[i] = v.AllowedValues()[0]
 		}
 	}
 
@@ -136,3 +134,37 @@ func TestVarIterFixedVals(t *testing.T) {
 		{1, 1, 1},
 	})
 }
+
+func TestVarIterRestricted(t *testing.T) {
+	assert := assert.New(t)
+
+	v1, e := NewVariable(0, 3)
+	assert.NoError(e)
+	v2, e := NewVariable(1, 4)
+	assert.NoError(e)
+	assert.NoError(v1.Exclude([]int{0}))
+	assert.NoError(v2.Restrict([]int{3, 1}))
+
+	checkExpectSeq(assert, true, []*Variable{v1, v2}, [][]int{
+		{1, 1},
+		{1, 3},
+		{2, 1},
+		{2, 3},
+	})
+
+	// Restrictions are ignored unless we honor evidence
+	vi, e := NewVariableIter([]*Variable{v1, v2}, false)
+	assert.NoError(e)
+	count := 1
+	for vi.Next() {
+		count++
+	}
+	assert.Equal(12, count)
+
+	// Fixed values still win
+	v2.FixedVal = 3
+	checkExpectSeq(assert, true, []*Variable{v1, v2}, [][]int{
+		{1, 3},
+		{2, 3},
+	})
+}
@@ -19,13 +19,13 @@ func TestVarBadCheck(t *testing.T) {
 
 	// bad cases
 	cases := []Variable{
-		{0, "BadVar-NoCardHaveMarg", 0, -1, []float64{0.5, 0.5}, nil, false},
-		{1, "BadVar-HaveCardNoMarg", 2, -1, []float64{}, nil, false},
-		{2, "BadVar-MismatchCardMarg", 2, -1, []float64{0.3, 0.3, 0.4}, nil, false},
-		{3, "BadVar-MargNotADist<1", 2, -1, []float64{0.5, 0.4999}, nil, false},
-		{4, "BadVar-MargNotADist>1", 2, -1, []float64{0.5, 0.5001}, nil, false},
-		{5, "BadVar-InvalidFixVal", 2, -2, []float64{0.5, 0.5001}, nil, false},
-		{5, "BadVar-FixVal>Card", 2, 3, []float64{0.5, 0.5001}, nil, false},
+		{0, "BadVar-NoCardHaveMarg", 0, -1, []float64{0.5, 0.5}, nil, false, nil, nil},
+		{1, "BadVar-HaveCardNoMarg", 2, -1, []float64{}, nil, false, nil, nil},
+		{2, "BadVar-MismatchCardMarg", 2, -1, []float64{0.3, 0.3, 0.4}, nil, false, nil, nil},
+		{3, "BadVar-MargNotADist<1", 2, -1, []float64{0.5, 0.4999}, nil, false, nil, nil},
+		{4, "BadVar-MargNotADist>1", 2, -1, []float64{0.5, 0.5001}, nil, false, nil, nil},
+		{5, "BadVar-InvalidFixVal", 2, -2, []float64{0.5, 0.5001}, nil, false, nil, nil},
+		{5, "BadVar-FixVal>Card", 2, 3, []float64{0.5, 0.5001}, nil, false, nil, nil},
 	}
 
 	for _, v := range cases {
@@ -48,12 +48,12 @@ func TestVarGoodCheck(t *testing.T) {
 
 	// good cases
 	cases := []Variable{
-		{0, "GoodVar-NoCard", 0, -1, []float64{}, nil, false},
-		{1, "GoodVar-Card1", 1, -1, []float64{1.0}, nil, false},
-		{2, "GoodVar-Card2", 2, -1, []float64{0.5, 0.5}, nil, false},
-		{3, "GoodVar-Card3", 3, -1, []float64{0.5, 0.4, 0.1}, nil, false},
-		{4, "GoodVar-Card3Fix", 3, 0, []float64{0.5, 0.4, 0.1}, nil, false},
-		{5, "GoodVar-Card3Fix", 3, 2, []float64{0.5, 0.4, 0.1}, nil, false},
+		{0, "GoodVar-NoCard", 0, -1, []float64{}, nil, false, nil, nil},
+		{1, "GoodVar-Card1", 1, -1, []float64{1.0}, nil, false, nil, nil},
+		{2, "GoodVar-Card2", 2, -1, []float64{0.5, 0.5}, nil, false, nil, nil},
+		{3, "GoodVar-Card3", 3, -1, []float64{0.5, 0.4, 0.1}, nil, false, nil, nil},
+		{4, "GoodVar-Card3Fix", 3, 0, []float64{0.5, 0.4, 0.1}, nil, false, nil, nil},
+		{5, "GoodVar-Card3Fix", 3, 2, []float64{0.5, 0.4, 0.1}, nil, false, nil, nil},
 	}
 
 	for _, v := range cases {
@@ -69,12 +69,12 @@ func TestVarNormProb(t *testing.T) {
 		Success bool
 		Var     *Variable
 	}{
-		{false, &Variable{0, "BadVar-NoCardHaveMarg", 0, -1, []float64{0.5, 0.5}, nil, false}},
-		{true, &Variable{1, "GoodVar-NoCard", 0, -1, []float64{}, nil, false}},
-		{true, &Variable{2, "GoodVar-Card1-OK", 1, -1, []float64{1.0}, nil, false}},
-		{true, &Variable{3, "GoodVar-Card1-SUB", 1, -1, []float64{0.1}, nil, false}},
-		{true, &Variable{4, "GoodVar-Card2-OK", 2, -1, []float64{0.5, 0.5}, nil, false}},
-		{true, &Variable{5, "GoodVar-Card2-SUB", 2, -1, []float64{120.0, 120.0}, nil, false}},
+		{false, &Variable{0, "BadVar-NoCardHaveMarg", 0, -1, []float64{0.5, 0.5}, nil, false, nil, nil}},
+		{true, &Variable{1, "GoodVar-NoCard", 0, -1, []float64{}, nil, false, nil, nil}},
+		{true, &Variable{2, "GoodVar-Card1-OK", 1, -1, []float64{1.0}, nil, false, nil, nil}},
+		{true, &Variable{3, "GoodVar-Card1-SUB", 1, -1, []float64{0.1}, nil, false, nil, nil}},
+		{true, &Variable{4, "GoodVar-Card2-OK", 2, -1, []float64{0.5, 0.5}, nil, false, nil, nil}},
+		{true, &Variable{5, "GoodVar-Card2-SUB", 2, -1, []float64{120.0, 120.0}, nil, false, nil, nil}},
 	}
 
 	for _, c := range cases {
@@ -92,7 +92,7 @@ func TestVarNormProb(t *testing.T) {
 func TestVarNaming(t *testing.T) {
 	assert := assert.New(t)
 
-	v := &Variable{0, "StartName", 0, -1, []float64{}, nil, false}
+	v := &Variable{0, "StartName", 0, -1, []float64{}, nil, false, nil, nil}
 
 	assert.Error(v.CreateName(-1)) // Quick error testing
 
@@ -120,7 +120,7 @@ func TestVarNaming(t *testing.T) {
 func TestVarClone(t *testing.T) {
 	assert := assert.New(t)
 
-	v1 := &Variable{1, "StartName", 2, -1, []float64{1.0, 2.1}, map[string]float64{"Abc": 42.42}, true}
+	v1 := &Variable{1, "StartName", 2, -1, []float64{1.0, 2.1}, map[string]float64{"Abc": 42.42}, true, nil, []bool{true, false}}
 	v2 := v1.Clone()
 	assert.True(v1 != v2) // point to different objects
 	assert.Equal(v1, v2)  // look exactly the same
@@ -129,3 +129,42 @@ func TestVarClone(t *testing.T) {
 	f2 := fmt.Sprintf("%+v", v2)
 	assert.Equal(f1, f2)
 }
+
+func TestVarRestrict(t *testing.T) {
+	assert := assert.New(t)
+
+	v, err := NewVariable(0, 4)
+	assert.NoError(err)
+	assert.Equal([]int{0, 1, 2, 3}, v.AllowedValues())
+
+	// X != 1
+	assert.NoError(v.Exclude([]int{1}))
+	assert.Equal([]int{0, 2, 3}, v.AllowedValues())
+	assert.False(v.Allows(1))
+	assert.True(v.Allows(2))
+	assert.NoError(v.Check())
+
+	// Restrictions are intersected
+	assert.NoError(v.Restrict([]int{1, 2, 3}))
+	assert.Equal([]int{2, 3}, v.AllowedValues())
+
+	// Invalid restrictions leave the variable alone
+	assert.Error(v.Restrict([]int{0, 1}))
+	assert.Error(v.Restrict([]int{4}))
+	assert.Error(v.Exclude([]int{2, 3}))
+	assert.Equal([]int{2, 3}, v.AllowedValues())
+
+	// The fixed value has to be allowed
+	v.FixedVal = 3
+	assert.NoError(v.Check())
+	assert.Equal([]int{3}, v.AllowedValues())
+	assert.Error(v.Exclude([]int{3}))
+	v.FixedVal = 0
+	assert.Error(v.Check())
+
+	v.FixedVal = -1
+	v.Allowed = []bool{false, false, false, false}
+	assert.Error(v.Check())
+	v.Allowed = []bool{true}
+	assert.Error(v.Check())
+}
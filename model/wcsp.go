@@ -0,0 +1,219 @@
+package model
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// WCSPReader reads cost function networks in the toulbar2 WCSP format. The
+// header is "NAME VARS MAXDOMAIN FUNCS TOP" followed by the domain size of
+// each variable. Each cost function is "ARITY VAR... DEFAULT TUPLES" followed
+// by TUPLES lines of "VAL... COST" (variables and values are 0-based).
+//
+// Costs become factor values with exp(-cost), and any cost of at least TOP is
+// a hard zero. The result is a MARKOV model. A zero-arity cost function is a
+// constant, so it is folded into the first function with variables (which
+// leaves the partition function unchanged).
+//
+// WCSP has no evidence format, so evidence is in the UAI format.
+type WCSPReader struct {
+}
+
+// ReadModel implements the model.Reader interface
+func (r WCSPReader) ReadModel(data []byte) (*Model, error) {
+	fr := NewFieldReader(string(data))
+
+	name, err := fr.Read()
+	if err != nil {
+		return nil, errors.New("No WCSP data found")
+	}
+
+	varCount, err := fr.ReadInt()
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not read var count")
+	}
+	if varCount < 1 {
+		return nil, errors.Errorf("Invalid var count %d", varCount)
+	}
+	maxDomain, err := fr.ReadInt()
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not read max domain size")
+	}
+	funcCount, err := fr.ReadInt()
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not read cost function count")
+	}
+	if funcCount < 1 {
+		return nil, errors.Errorf("Invalid cost function count %d", funcCount)
+	}
+	top, err := fr.ReadFloat()
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not read top (upper bound)")
+	}
+	if top <= 0 {
+		return nil, errors.Errorf("Invalid top %v", top)
+	}
+
+	// value converts a cost to a factor value
+	value := func(cost float64) (float64, error) {
+		if cost < 0 {
+			return 0.0, errors.Errorf("Invalid negative cost %v", cost)
+		}
+		if cost >= top {
+			return 0.0, nil
+		}
+		return math.Exp(-cost), nil
+	}
+
+	m := &Model{Type: MARKOV, Name: name}
+	for i := 0; i < varCount; i++ {
+		card, err := fr.ReadInt()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not read domain size for var %d", i)
+		}
+		if card > maxDomain {
+			return nil, errors.Errorf("Var %d has domain size %d but max is %d", i, card, maxDomain)
+		}
+		v, err := NewVariable(i, card)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not create var %d", i)
+		}
+		m.Vars = append(m.Vars, v)
+	}
+
+	constant := 1.0
+	for i := 0; i < funcCount; i++ {
+		arity, err := fr.ReadInt()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not read arity for cost function %d", i)
+		}
+		if arity < 0 {
+			return nil, errors.Errorf("Invalid arity %d for cost function %d", arity, i)
+		}
+
+		vars := make([]*Variable, arity)
+		seen := make(map[int]bool)
+		for j := range vars {
+			idx, err := fr.ReadInt()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Could not read var %d for cost function %d", j, i)
+			}
+			if idx < 0 || idx >= varCount {
+				return nil, errors.Errorf("Invalid var %d for cost function %d", idx, i)
+			}
+			if seen[idx] {
+				return nil, errors.Errorf("Var %d is repeated in cost function %d", idx, i)
+			}
+			seen[idx] = true
+			vars[j] = m.Vars[idx]
+		}
+
+		defCost, err := fr.ReadFloat()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not read default cost for cost function %d (global cost functions are not supported)", i)
+		}
+		defVal, err := value(defCost)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Cost function %d", i)
+		}
+		tuples, err := fr.ReadInt()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not read tuple count for cost function %d", i)
+		}
+		if tuples < 0 {
+			return nil, errors.Errorf("Invalid tuple count %d for cost function %d", tuples, i)
+		}
+
+		// Constant cost function
+		if arity == 0 {
+			if tuples > 1 {
+				return nil, errors.Errorf("Constant cost function %d has %d tuples", i, tuples)
+			}
+			if tuples == 1 {
+				cost, err := fr.ReadFloat()
+				if err != nil {
+					return nil, errors.Wrapf(err, "Could not read cost for constant cost function %d", i)
+				}
+				if defVal, err = value(cost); err != nil {
+					return nil, errors.Wrapf(err, "Cost function %d", i)
+				}
+			}
+			constant *= defVal
+			continue
+		}
+
+		f, err := NewFunction(len(m.Funcs), vars)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not create function for cost function %d", i)
+		}
+		for t := range f.Table {
+			f.Table[t] = defVal
+		}
+
+		tuple := make([]int, arity)
+		for t := 0; t < tuples; t++ {
+			for j, v := range vars {
+				tuple[j], err = fr.ReadInt()
+				if err != nil {
+					return nil, errors.Wrapf(err, "Could not read tuple %d for cost function %d", t, i)
+				}
+				if tuple[j] < 0 || tuple[j] >= v.Card {
+					return nil, errors.Errorf("Invalid value %d for var %d in cost function %d", tuple[j], v.ID, i)
+				}
+			}
+			cost, err := fr.ReadFloat()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Could not read tuple %d cost for cost function %d", t, i)
+			}
+
+			idx := 0
+			for j, v := range vars {
+				idx = idx*v.Card + tuple[j]
+			}
+			if f.Table[idx], err = value(cost); err != nil {
+				return nil, errors.Wrapf(err, "Cost function %d", i)
+			}
+		}
+
+		m.Funcs = append(m.Funcs, f)
+	}
+
+	if _, err := fr.Read(); err == nil {
+		return nil, errors.Errorf("Extra data found after %d cost functions", funcCount)
+	}
+	if len(m.Funcs) < 1 {
+		return nil, errors.New("No cost functions with variables found")
+	}
+	if constant == 0.0 {
+		return nil, errors.New("Constant cost function is at least top")
+	}
+	for i := range m.Funcs[0].Table {
+		m.Funcs[0].Table[i] *= constant
+	}
+
+	return m, nil
+}
+
+// ApplyEvidence is part of the reader interface: evidence is in the UAI format
+func (r WCSPReader) ApplyEvidence(data []byte, m *Model) error {
+	return UAIReader{}.ApplyEvidence(data, m)
+}
+
+// ReadEvidence implements the model.EvidenceReader interface: evidence is in
+// the UAI format
+func (r WCSPReader) ReadEvidence(data []byte) ([]Evidence, error) {
+	return UAIReader{}.ReadEvidence(data)
+}
+
+// ReadSoftEvidence implements the model.SoftEvidenceReader interface: soft
+// evidence is in the UAI format
+func (r WCSPReader) ReadSoftEvidence(data []byte) (SoftEvidence, error) {
+	return UAIReader{}.ReadSoftEvidence(data)
+}
+
+// ReadDomainEvidence implements the model.DomainEvidenceReader interface:
+// domain evidence is in the UAI format
+func (r WCSPReader) ReadDomainEvidence(data []byte) (DomainEvidence, error) {
+	return UAIReader{}.ReadDomainEvidence(data)
+}
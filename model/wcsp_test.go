@@ -0,0 +1,69 @@
+package model
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWCSPRead(t *testing.T) {
+	assert := assert.New(t)
+
+	r := WCSPReader{}
+	m, err := NewModelFromFile(r, "../res/example.wcsp", false)
+	assert.NoError(err)
+	assert.NoError(m.Check())
+	assert.Equal(MARKOV, m.Type)
+
+	assert.Equal(3, len(m.Vars))
+	assert.Equal([]int{2, 3, 2}, []int{m.Vars[0].Card, m.Vars[1].Card, m.Vars[2].Card})
+
+	// Costs are exp(-cost) with top (or more) a hard zero, and the constant
+	// is folded into the first function
+	assert.Equal(3, len(m.Funcs))
+	assert.InDeltaSlice([]float64{math.Exp(-1), math.Exp(-6)}, m.Funcs[0].Table, 1e-15)
+	assert.Equal([]*Variable{m.Vars[0], m.Vars[1]}, m.Funcs[1].Vars)
+	assert.InDeltaSlice([]float64{1, 0, 0, 0, 0, math.Exp(-3)}, m.Funcs[1].Table, 1e-15)
+	assert.Equal([]float64{1, 1, 1, 1, 1, 0}, m.Funcs[2].Table)
+
+	z := satCount(assert, m)
+	assert.InDelta(math.Exp(-1)*(2+math.Exp(-8)), z, 1e-12)
+
+	// Evidence is UAI
+	assert.NoError(r.ApplyEvidence([]byte("1 1 2"), m))
+	assert.Equal(2, m.Vars[1].FixedVal)
+	evid, err := r.ReadEvidence([]byte("1\n2 0 1 2 0\n"))
+	assert.NoError(err)
+	assert.Equal([]Evidence{{0: 1, 2: 0}}, evid)
+}
+
+func TestWCSPBad(t *testing.T) {
+	assert := assert.New(t)
+
+	bad := []string{
+		"",
+		"x",
+		"x 0 2 1 10\n",
+		"x 1 2 0 10\n2\n",
+		"x 1 2 1 0\n2\n1 0 0 0\n",
+		"x 1 2 1 10\n3\n1 0 0 0\n",
+		"x 1 2 1 10\n2\n1 1 0 0\n",
+		"x 2 2 1 10\n2 2\n2 0 0 0 0\n",
+		"x 1 2 1 10\n2\n1 0 -1 0\n",
+		"x 1 2 1 10\n2\n1 0 0 1\n2 1\n",
+		"x 1 2 1 10\n2\n1 0 0 1\n0\n",
+		"x 1 2 1 10\n2\n1 0 0 2\n0 1\n",
+		"x 1 2 1 10\n2\n1 0 0 1\n0 x\n",
+		"x 1 2 1 10\n2\n1 0 0 0\nextra\n",
+		"x 1 2 1 10\n2\n1 0 knapsack 1\n",
+		"x 1 2 1 10\n2\n0 1 0\n",
+		"x 1 2 2 10\n2\n1 0 0 0\n0 10 0\n",
+		"x 1 2 2 10\n2\n1 0 0 0\n0 0 2\n1\n1\n",
+	}
+
+	for _, b := range bad {
+		_, err := NewModelFromBuffer(WCSPReader{}, []byte(b))
+		assert.Error(err, b)
+	}
+}
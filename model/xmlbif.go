@@ -0,0 +1,146 @@
+package model
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// XMLBIFReader reads models in the XML version of the Bayesian Interchange
+// Format (XMLBIF 0.3, as written by JavaBayes, Weka, pgmpy, etc). Variable
+// names and outcomes are kept (see Variable.Labels). Each DEFINITION becomes a
+// function with the GIVEN variables first and the FOR variable last. The
+// TABLE has the FOR variable changing fastest and the GIVEN variables
+// enumerated in order (the first changing slowest), which is our most to least
+// significant table order.
+type XMLBIFReader struct {
+}
+
+type xmlBIF struct {
+	XMLName xml.Name `xml:"BIF"`
+	Network struct {
+		Name      string      `xml:"NAME"`
+		Variables []xmlBIFVar `xml:"VARIABLE"`
+		Defs      []xmlBIFDef `xml:"DEFINITION"`
+		Probs     []xmlBIFDef `xml:"PROBABILITY"` // Older name for DEFINITION
+	} `xml:"NETWORK"`
+}
+
+type xmlBIFVar struct {
+	Type     string   `xml:"TYPE,attr"`
+	Name     string   `xml:"NAME"`
+	Outcomes []string `xml:"OUTCOME"`
+}
+
+type xmlBIFDef struct {
+	For   string   `xml:"FOR"`
+	Given []string `xml:"GIVEN"`
+	Table string   `xml:"TABLE"`
+}
+
+// ReadModel implements the model.Reader interface
+func (r XMLBIFReader) ReadModel(data []byte) (*Model, error) {
+	var doc xmlBIF
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.CharsetReader = xmlCharsetReader
+	if err := dec.Decode(&doc); err != nil {
+		return nil, errors.Wrapf(err, "Invalid XMLBIF data")
+	}
+
+	net := doc.Network
+	if len(net.Variables) < 1 {
+		return nil, errors.New("No variables found in XMLBIF data")
+	}
+
+	m := &Model{Type: BAYES, Name: strings.TrimSpace(net.Name)}
+	byName := make(map[string]*Variable)
+
+	for i, xv := range net.Variables {
+		name := strings.TrimSpace(xv.Name)
+		typ := strings.TrimSpace(xv.Type)
+		if typ != "" && typ != "nature" {
+			return nil, errors.Errorf("Variable %s has type %s (only nature vars are supported)", name, typ)
+		}
+		if _, dup := byName[name]; dup {
+			return nil, errors.Errorf("Variable %s is declared twice", name)
+		}
+
+		v, err := NewVariable(i, len(xv.Outcomes))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not create variable %s", name)
+		}
+		v.Name = name
+		v.Labels = make([]string, len(xv.Outcomes))
+		for c, lbl := range xv.Outcomes {
+			v.Labels[c] = strings.TrimSpace(lbl)
+		}
+
+		byName[name] = v
+		m.Vars = append(m.Vars, v)
+	}
+
+	seen := make(map[string]bool)
+	for i, def := range append(net.Defs, net.Probs...) {
+		child := strings.TrimSpace(def.For)
+		if seen[child] {
+			return nil, errors.Errorf("Variable %s has more than one definition", child)
+		}
+		seen[child] = true
+
+		parents := make([]string, len(def.Given))
+		for p, name := range def.Given {
+			parents[p] = strings.TrimSpace(name)
+		}
+
+		f, err := cptFunction(i, child, parents, byName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid definition for %s", child)
+		}
+
+		fields := strings.Fields(def.Table)
+		if len(fields) != len(f.Table) {
+			return nil, errors.Errorf("Table for %s has %d entries but expected %d", child, len(fields), len(f.Table))
+		}
+		for t, fld := range fields {
+			f.Table[t], err = strconv.ParseFloat(fld, 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid probability %s for %s", fld, child)
+			}
+		}
+
+		m.Funcs = append(m.Funcs, f)
+	}
+
+	return m, nil
+}
+
+// xmlCharsetReader handles the single-byte encodings commonly declared in
+// XMLBIF files (US-ASCII and Latin-1) by converting to UTF-8
+func xmlCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "us-ascii", "ascii", "iso-8859-1", "latin1", "latin-1":
+	default:
+		return nil, errors.Errorf("Unsupported XML encoding %s", charset)
+	}
+
+	data, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return strings.NewReader(string(runes)), nil
+}
+
+// ApplyEvidence is part of the reader interface. Like BIF we use named
+// evidence (see applyNamedEvidence).
+func (r XMLBIFReader) ApplyEvidence(data []byte, m *Model) error {
+	return applyNamedEvidence(data, m)
+}
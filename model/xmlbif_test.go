@@ -0,0 +1,69 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertSameModel checks that two models have the same variables and CPTs
+func assertSameModel(assert *assert.Assertions, expect *Model, actual *Model) {
+	assert.Equal(expect.Type, actual.Type)
+	assert.Equal(len(expect.Vars), len(actual.Vars))
+	for i, v := range expect.Vars {
+		assert.Equal(v.Name, actual.Vars[i].Name)
+		assert.Equal(v.Card, actual.Vars[i].Card)
+		assert.Equal(v.Labels, actual.Vars[i].Labels)
+	}
+
+	assert.Equal(len(expect.Funcs), len(actual.Funcs))
+	for i, f := range expect.Funcs {
+		af := actual.Funcs[i]
+		assert.Equal(f.Name, af.Name)
+		assert.Equal(len(f.Vars), len(af.Vars))
+		for j, v := range f.Vars {
+			assert.Equal(v.ID, af.Vars[j].ID)
+		}
+		assert.Equal(f.Table, af.Table)
+	}
+}
+
+func TestXMLBIFAsia(t *testing.T) {
+	assert := assert.New(t)
+
+	bif, err := NewModelFromFile(BIFReader{}, "../res/asia.bif", false)
+	assert.NoError(err)
+
+	r := XMLBIFReader{}
+	m, err := NewModelFromFile(r, "../res/asia.xml", false)
+	assert.NoError(err)
+	assertSameModel(assert, bif, m)
+
+	assert.NoError(r.ApplyEvidence([]byte("either = no"), m))
+	assert.Equal(1, m.Vars[5].FixedVal)
+}
+
+func TestXMLBIFBad(t *testing.T) {
+	assert := assert.New(t)
+
+	vars := `<VARIABLE TYPE="nature"><NAME>A</NAME><OUTCOME>a0</OUTCOME><OUTCOME>a1</OUTCOME></VARIABLE>`
+	bad := []string{
+		"",
+		"<BIF>",
+		"<BIF><NETWORK><NAME>x</NAME></NETWORK></BIF>",
+		`<BIF><NETWORK><VARIABLE TYPE="decision"><NAME>A</NAME><OUTCOME>a0</OUTCOME></VARIABLE></NETWORK></BIF>`,
+		`<BIF><NETWORK><VARIABLE><NAME>A</NAME></VARIABLE></NETWORK></BIF>`,
+		"<BIF><NETWORK>" + vars + vars + "</NETWORK></BIF>",
+		"<BIF><NETWORK>" + vars + "<DEFINITION><FOR>B</FOR><TABLE>0.5 0.5</TABLE></DEFINITION></NETWORK></BIF>",
+		"<BIF><NETWORK>" + vars + "<DEFINITION><FOR>A</FOR><GIVEN>B</GIVEN><TABLE>0.5 0.5</TABLE></DEFINITION></NETWORK></BIF>",
+		"<BIF><NETWORK>" + vars + "<DEFINITION><FOR>A</FOR><TABLE>0.5</TABLE></DEFINITION></NETWORK></BIF>",
+		"<BIF><NETWORK>" + vars + "<DEFINITION><FOR>A</FOR><TABLE>0.5 x</TABLE></DEFINITION></NETWORK></BIF>",
+		"<BIF><NETWORK>" + vars + "<DEFINITION><FOR>A</FOR><TABLE>0.5 0.5</TABLE></DEFINITION>" +
+			"<PROBABILITY><FOR>A</FOR><TABLE>0.5 0.5</TABLE></PROBABILITY></NETWORK></BIF>",
+	}
+
+	for _, b := range bad {
+		_, err := NewModelFromBuffer(XMLBIFReader{}, []byte(b))
+		assert.Error(err, b)
+	}
+}
@@ -0,0 +1,274 @@
+package sampler
+
+import (
+	"math"
+
+	"github.com/CraigKelly/grample/model"
+	"github.com/CraigKelly/grample/rand"
+	"github.com/pkg/errors"
+)
+
+// AIS estimates the log partition function with annealed importance sampling
+// (Neal 2001). Each run starts with a uniform sample (which we can sample
+// exactly and which has a known Z) and moves through a sequence of tempered
+// distributions P~(X)^beta with beta going from 0 to 1. A single Gibbs sweep
+// at each temperature is the transition. Evidence is read from the model's
+// variables on each call to LogZ.
+//
+// Unlike our Gibbs samplers, zero entries in functions are NOT smoothed, so
+// the estimate is for the model as given. A uniform start is almost never in
+// the support of a model with deterministic functions, so for beta < 1 a zero
+// entry is tempered to exp(ZeroLog * beta / (1 - beta)) instead: it starts at
+// 1 (so the base is still uniform) and only becomes an exact zero at beta=1.
+// Runs that still end in a zero probability state have zero weight. Domain
+// evidence restricts both the uniform start and every sweep to the allowed
+// values.
+type AIS struct {
+	Temps int // Number of intermediate temperatures per run
+	Runs  int // Number of independent annealing runs
+
+	ZeroLog float64 // Log of a zero entry's tempered value at beta=1/2 (see AIS)
+
+	LogWeights []float64 // Log importance weight of each run from the last LogZ call
+
+	gen      *rand.Generator
+	pgm      *model.Model
+	weighted WeightedSampler
+	funcs    []*model.Function   // Natural log of the model's functions (log(0) is -Inf)
+	varFuncs [][]*model.Function // Functions for each variable
+}
+
+// NewAIS creates an AIS estimator with 1000 temperatures, 100 runs and a
+// ZeroLog of -10
+func NewAIS(gen *rand.Generator, m *model.Model) (*AIS, error) {
+	if m == nil {
+		return nil, errors.New("No model supplied")
+	}
+
+	uniform, err := NewUniformSampler(gen, len(m.Vars))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create uniform sampler for AIS")
+	}
+
+	a := &AIS{
+		Temps:    1000,
+		Runs:     100,
+		ZeroLog:  -10.0,
+		gen:      gen,
+		pgm:      m,
+		weighted: uniform,
+		funcs:    make([]*model.Function, len(m.Funcs)),
+		varFuncs: make([][]*model.Function, len(m.Vars)),
+	}
+
+	for i, v := range m.Vars {
+		if i != v.ID {
+			return nil, errors.Errorf("Invalid ID for var %s: expected %d but was %d", v.Name, i, v.ID)
+		}
+	}
+
+	for fi, f := range m.Funcs {
+		lf := f.Clone()
+		if !lf.IsLog {
+			for i, v := range lf.Table {
+				lf.Table[i] = math.Log(v)
+			}
+			lf.IsLog = true
+		}
+		a.funcs[fi] = lf
+
+		for _, v := range lf.Vars {
+			if v.ID < 0 || v.ID >= len(m.Vars) {
+				return nil, errors.Errorf("Function %s has invalid var ID %d", f.Name, v.ID)
+			}
+			a.varFuncs[v.ID] = append(a.varFuncs[v.ID], lf)
+		}
+	}
+
+	return a, nil
+}
+
+// LogZ returns the AIS estimate of the natural log of the partition function.
+// The log weight for each run is saved in LogWeights.
+func (a *AIS) LogZ() (float64, error) {
+	if a.Temps < 1 || a.Runs < 1 || !(a.ZeroLog < 0.0) || math.IsInf(a.ZeroLog, -1) {
+		return math.NaN(), errors.Errorf("Invalid AIS setup: Temps=%d, Runs=%d, ZeroLog=%v", a.Temps, a.Runs, a.ZeroLog)
+	}
+
+	// Our base distribution is uniform over the values the evidence allows
+	logZ0 := 0.0
+	for _, v := range a.pgm.Vars {
+		if v.FixedVal < 0 {
+			logZ0 += math.Log(float64(len(v.AllowedValues())))
+		}
+	}
+
+	a.LogWeights = make([]float64, a.Runs)
+	state := make([]int, len(a.pgm.Vars))
+	for r := range a.LogWeights {
+		lw, err := a.run(state)
+		if err != nil {
+			return math.NaN(), err
+		}
+		a.LogWeights[r] = lw
+	}
+
+	// log mean(exp(LogWeights)) computed without underflow
+	max := math.Inf(-1)
+	for _, lw := range a.LogWeights {
+		max = math.Max(max, lw)
+	}
+	if math.IsInf(max, -1) {
+		return math.Inf(-1), errors.New("Every AIS run ended in a zero probability state: the model's support is too hard to reach by annealing (or the evidence is impossible)")
+	}
+	sum := 0.0
+	for _, lw := range a.LogWeights {
+		sum += math.Exp(lw - max)
+	}
+
+	return logZ0 + max + math.Log(sum/float64(a.Runs)), nil
+}
+
+// ESS returns the effective sample size of the weights from the last call to
+// LogZ. It ranges from 1 (one run dominates) to Runs (all weights equal).
+func (a *AIS) ESS() float64 {
+	max := math.Inf(-1)
+	for _, lw := range a.LogWeights {
+		max = math.Max(max, lw)
+	}
+	if len(a.LogWeights) < 1 || math.IsInf(max, -1) {
+		return 0.0
+	}
+
+	sum, sumSq := 0.0, 0.0
+	for _, lw := range a.LogWeights {
+		w := math.Exp(lw - max)
+		sum += w
+		sumSq += w * w
+	}
+	return sum * sum / sumSq
+}
+
+// run performs a single annealing run and returns its log weight
+func (a *AIS) run(state []int) (float64, error) {
+	for i, v := range a.pgm.Vars {
+		if v.FixedVal >= 0 {
+			state[i] = v.FixedVal
+		} else {
+			allowed := v.AllowedValues()
+			state[i] = allowed[a.gen.Int31n(int32(len(allowed)))]
+		}
+	}
+
+	logWeight := 0.0
+	prevBeta := 0.0
+	for t := 1; t <= a.Temps; t++ {
+		// Power schedule: small steps at high temperature where the tempered
+		// distribution changes fastest
+		beta := math.Pow(float64(t)/float64(a.Temps), 4.0)
+
+		logP, err := a.logProb(state, beta)
+		if err != nil {
+			return math.NaN(), err
+		}
+		if math.IsInf(logP, -1) {
+			return math.Inf(-1), nil // Zero probability state: zero weight
+		}
+		prevLogP, err := a.logProb(state, prevBeta)
+		if err != nil {
+			return math.NaN(), err
+		}
+		logWeight += logP - prevLogP
+		prevBeta = beta
+
+		err = a.sweep(state, beta)
+		if err != nil {
+			return math.NaN(), err
+		}
+	}
+
+	return logWeight, nil
+}
+
+// tempered returns the log of a function value lv tempered by beta. Zero
+// entries are only -Inf at beta=1 (see AIS).
+func (a *AIS) tempered(lv float64, beta float64) float64 {
+	if !math.IsInf(lv, -1) {
+		return beta * lv
+	}
+	if beta >= 1.0 {
+		return lv
+	}
+	return a.ZeroLog * beta / (1.0 - beta)
+}
+
+// logProb is the unnormalized log probability of state tempered by beta
+func (a *AIS) logProb(state []int, beta float64) (float64, error) {
+	total := 0.0
+	vals := make([]int, 0, 8)
+	for _, f := range a.funcs {
+		vals = vals[:0]
+		for _, v := range f.Vars {
+			vals = append(vals, state[v.ID])
+		}
+		lv, err := f.Eval(vals)
+		if err != nil {
+			return math.NaN(), err
+		}
+		total += a.tempered(lv, beta)
+	}
+	return total, nil
+}
+
+// sweep performs one Gibbs sweep over the unfixed vars of P~(X)^beta. Only
+// the values allowed by the evidence are ever sampled.
+func (a *AIS) sweep(state []int, beta float64) error {
+	vals := make([]int, 0, 8)
+	for i, v := range a.pgm.Vars {
+		if v.FixedVal >= 0 {
+			continue
+		}
+
+		allowed := v.AllowedValues()
+		logW := make([]float64, len(allowed))
+		for _, f := range a.varFuncs[i] {
+			vals = vals[:0]
+			pos := -1
+			for j, fv := range f.Vars {
+				vals = append(vals, state[fv.ID])
+				if fv.ID == i {
+					pos = j
+				}
+			}
+			for k, c := range allowed {
+				vals[pos] = c
+				lv, err := f.Eval(vals)
+				if err != nil {
+					return err
+				}
+				logW[k] += a.tempered(lv, beta)
+			}
+		}
+
+		max := math.Inf(-1)
+		for _, lw := range logW {
+			max = math.Max(max, lw)
+		}
+		if math.IsInf(max, -1) {
+			continue // Every value is impossible: leave the var alone
+		}
+
+		weights := make([]float64, len(allowed))
+		for k, lw := range logW {
+			// Our weighted sampler requires positive weights
+			weights[k] = math.Max(math.Exp(lw-max), 1e-300)
+		}
+		k, err := a.weighted.WeightedSample(len(allowed), weights)
+		if err != nil {
+			return errors.Wrapf(err, "AIS could not sample var %s", v.Name)
+		}
+		state[i] = allowed[k]
+	}
+
+	return nil
+}
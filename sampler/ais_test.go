@@ -0,0 +1,122 @@
+package sampler
+
+import (
+	"math"
+	"testing"
+
+	"github.com/CraigKelly/grample/exact"
+	"github.com/CraigKelly/grample/model"
+	"github.com/CraigKelly/grample/rand"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAISLogZ(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := model.UAIReader{}
+	mod, err := model.NewModelFromFile(reader, "../res/sample.uai", false)
+	assert.NoError(err)
+
+	ve, err := exact.NewVarElim(mod)
+	assert.NoError(err)
+
+	gen, err := rand.NewGenerator(42)
+	assert.NoError(err)
+
+	ais, err := NewAIS(gen, mod)
+	assert.NoError(err)
+	ais.Temps = 100
+	ais.Runs = 200
+
+	for _, fixed := range []int{-1, 1} {
+		mod.Vars[1].FixedVal = fixed
+
+		expected, err := ve.LogZ()
+		assert.NoError(err)
+
+		logZ, err := ais.LogZ()
+		assert.NoError(err)
+		assert.InDelta(expected, logZ, 0.05)
+
+		assert.Equal(200, len(ais.LogWeights))
+		ess := ais.ESS()
+		assert.True(ess > 100.0 && ess <= 200.0)
+	}
+
+	ais.Runs = 0
+	_, err = ais.LogZ()
+	assert.Error(err)
+}
+
+// Zero entries are tempered so runs on a deterministic model still reach the
+// model's support
+func TestAISDeterministic(t *testing.T) {
+	assert := assert.New(t)
+
+	mod, err := model.NewModelFromFile(model.UAIReader{}, "../res/deterministic.uai", true)
+	assert.NoError(err)
+
+	ve, err := exact.NewVarElim(mod)
+	assert.NoError(err)
+	expected, err := ve.LogZ()
+	assert.NoError(err)
+
+	gen, err := rand.NewGenerator(42)
+	assert.NoError(err)
+
+	ais, err := NewAIS(gen, mod)
+	assert.NoError(err)
+	ais.Temps = 100
+	ais.Runs = 200
+
+	logZ, err := ais.LogZ()
+	assert.NoError(err)
+	assert.InDelta(expected, logZ, 0.05)
+
+	accepted := 0
+	for _, lw := range ais.LogWeights {
+		if !math.IsInf(lw, -1) {
+			accepted++
+		}
+	}
+	assert.True(accepted > 150)
+
+	ais.ZeroLog = 0.0
+	_, err = ais.LogZ()
+	assert.Error(err)
+}
+
+// Domain evidence restricts the uniform start and every sweep
+func TestAISDomain(t *testing.T) {
+	assert := assert.New(t)
+
+	mod, err := model.NewModelFromFile(model.UAIReader{}, "../res/sample.uai", false)
+	assert.NoError(err)
+	assert.NoError(mod.RestrictDomains(model.DomainEvidence{0: {1}, 2: {0, 2}}))
+
+	ve, err := exact.NewVarElim(mod)
+	assert.NoError(err)
+	expected, err := ve.LogZ()
+	assert.NoError(err)
+
+	gen, err := rand.NewGenerator(42)
+	assert.NoError(err)
+
+	ais, err := NewAIS(gen, mod)
+	assert.NoError(err)
+	ais.Temps = 100
+	ais.Runs = 200
+
+	logZ, err := ais.LogZ()
+	assert.NoError(err)
+	assert.InDelta(expected, logZ, 0.05)
+
+	state := make([]int, len(mod.Vars))
+	for i := 0; i < 20; i++ {
+		_, err = ais.run(state)
+		assert.NoError(err)
+		assert.Equal(1, state[0])
+		assert.NotEqual(1, state[2])
+	}
+}
@@ -0,0 +1,141 @@
+package sampler
+
+import (
+	"math"
+
+	"github.com/CraigKelly/grample/model"
+	"github.com/CraigKelly/grample/rand"
+	"github.com/pkg/errors"
+)
+
+// Annealer searches for the MPE state with simulated annealing. Each sweep
+// visits every unfixed variable with GibbsSimple.SampleVar at the current
+// temperature, and the temperature is lowered geometrically from StartTemp to
+// EndTemp. GibbsSimple smooths zero entries, so on a model with zeros we can
+// get stuck in impossible states that no single variable change escapes. For
+// those models (and for models with domain evidence) we start from a state in
+// the model's support (see Model.SupportState), and a run that starts in an
+// impossible state or one the evidence doesn't allow restarts from there.
+type Annealer struct {
+	StartTemp float64 // Starting temperature (1.0 is ordinary Gibbs sampling)
+	EndTemp   float64 // Final temperature: should be close to 0
+	Sweeps    int     // Number of sweeps over all variables
+
+	Best *MPETracker // Best state seen so far (across calls to Run)
+
+	gibbs   *GibbsSimple
+	pgm     *model.Model
+	state   []int
+	support []int // State with non-zero probability (nil if not needed or not found)
+}
+
+// NewAnnealer creates a simulated annealing MPE search for the given model
+// (which is not modified).
+func NewAnnealer(gen *rand.Generator, m *model.Model) (*Annealer, error) {
+	best, err := NewMPETracker(m)
+	if err != nil {
+		return nil, err
+	}
+
+	gibbs, err := NewGibbsSimple(gen, m.Clone())
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not create Gibbs sampler for annealing")
+	}
+
+	a := &Annealer{
+		StartTemp: 2.0,
+		EndTemp:   0.01,
+		Sweeps:    1000,
+		Best:      best,
+		gibbs:     gibbs,
+		pgm:       m,
+		state:     make([]int, len(m.Vars)),
+	}
+
+	if m.HasZeros() || hasDomainEvidence(m) {
+		a.support, _ = m.SupportState(model.SupportTries)
+	}
+	if a.support != nil {
+		copy(gibbs.last, a.support)
+	}
+	copy(a.state, gibbs.last)
+
+	return a, nil
+}
+
+// Run performs a single annealing schedule, starting from wherever the last
+// run ended. It returns the best state seen so far and its log prob.
+func (a *Annealer) Run() ([]int, float64, error) {
+	if a.StartTemp <= 0.0 || a.EndTemp <= 0.0 || a.Sweeps < 1 {
+		return nil, math.NaN(), errors.Errorf(
+			"Invalid annealing setup: StartTemp=%f, EndTemp=%f, Sweeps=%d",
+			a.StartTemp, a.EndTemp, a.Sweeps,
+		)
+	}
+	defer a.gibbs.SetTemperature(1.0)
+
+	if a.support != nil {
+		logProb, err := a.pgm.LogProb(a.state)
+		if err != nil {
+			return nil, math.NaN(), err
+		}
+		if math.IsInf(logProb, -1) || !allowedState(a.pgm, a.state) {
+			copy(a.gibbs.last, a.support)
+			copy(a.state, a.support)
+		}
+	}
+
+	_, err := a.Best.Update(a.state)
+	if err != nil {
+		return nil, math.NaN(), err
+	}
+
+	for s := 0; s < a.Sweeps; s++ {
+		frac := 0.0
+		if a.Sweeps > 1 {
+			frac = float64(s) / float64(a.Sweeps-1)
+		}
+		err := a.gibbs.SetTemperature(a.StartTemp * math.Pow(a.EndTemp/a.StartTemp, frac))
+		if err != nil {
+			return nil, math.NaN(), err
+		}
+
+		for i, v := range a.gibbs.pgm.Vars {
+			if v.FixedVal >= 0 {
+				continue
+			}
+			_, err := a.gibbs.SampleVar(i, a.state)
+			if err != nil {
+				return nil, math.NaN(), errors.Wrapf(err, "Annealing failed to sample var %s", v.Name)
+			}
+			_, err = a.Best.Update(a.state)
+			if err != nil {
+				return nil, math.NaN(), err
+			}
+		}
+	}
+
+	state := make([]int, len(a.Best.BestSample))
+	copy(state, a.Best.BestSample)
+	return state, a.Best.BestLogProb, nil
+}
+
+// hasDomainEvidence is true if any var in the model has domain evidence
+func hasDomainEvidence(m *model.Model) bool {
+	for _, v := range m.Vars {
+		if v.Allowed != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedState is true if the evidence allows every value in state
+func allowedState(m *model.Model, state []int) bool {
+	for i, v := range m.Vars {
+		if !v.Allows(state[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -17,6 +17,7 @@ type Chain struct {
 	ChainHistory      []*buffer.CircularInt
 	TotalSampleCount  int64
 	LastSample        []int
+	Best              *MPETracker // If not nil, tracks the best sample seen after burn in
 }
 
 // Measure is an error metric used by ChainConverge. One example is our
@@ -240,6 +241,13 @@ func (c *Chain) oneSample(updateVars bool) error {
 		}
 
 		c.TotalSampleCount++
+
+		if c.Best != nil {
+			_, err := c.Best.Update(c.LastSample)
+			if err != nil {
+				return errors.Wrap(err, "Error tracking best sample")
+			}
+		}
 	}
 
 	return nil
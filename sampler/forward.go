@@ -0,0 +1,208 @@
+package sampler
+
+import (
+	"math"
+
+	"github.com/CraigKelly/grample/model"
+	"github.com/CraigKelly/grample/rand"
+	"github.com/pkg/errors"
+)
+
+// ForwardSampler draws independent samples from a BAYES model by sampling
+// each variable from its CPT in topological order. There is no burn in and
+// no dependence between samples. Evidence is handled in one of two ways:
+//
+// Ancestral sampling samples the evidence variables too and rejects any
+// sample that doesn't match (it gets a weight of zero). This is exact but
+// wasteful when the evidence is unlikely.
+//
+// Likelihood weighting fixes the evidence variables and weights each sample
+// by the probability of the evidence given its parents. Domain evidence is
+// handled by only sampling allowed values (weighted by the allowed mass).
+//
+// Either way, any function that isn't a CPT (like soft evidence) contributes
+// to the weight.
+type ForwardSampler struct {
+	gen      *rand.Generator
+	pgm      *model.Model
+	order    []int
+	cpts     []*model.Function
+	others   []*model.Function
+	weighted bool
+}
+
+// NewAncestralSampler creates a forward sampler that uses rejection for
+// evidence
+func NewAncestralSampler(gen *rand.Generator, m *model.Model) (*ForwardSampler, error) {
+	return newForwardSampler(gen, m, false)
+}
+
+// NewLikelihoodWeighting creates a forward sampler that uses likelihood
+// weighting for evidence
+func NewLikelihoodWeighting(gen *rand.Generator, m *model.Model) (*ForwardSampler, error) {
+	return newForwardSampler(gen, m, true)
+}
+
+func newForwardSampler(gen *rand.Generator, m *model.Model, weighted bool) (*ForwardSampler, error) {
+	if m == nil {
+		return nil, errors.New("No model supplied")
+	}
+	if m.Type != model.BAYES {
+		return nil, errors.Errorf("Forward sampling requires a %s model but %s is %s", model.BAYES, m.Name, m.Type)
+	}
+	for i, v := range m.Vars {
+		if i != v.ID {
+			return nil, errors.Errorf("Invalid ID for var %s: expected %d but was %d", v.Name, i, v.ID)
+		}
+	}
+
+	order, err := m.TopoOrder()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not find a sampling order")
+	}
+	cpts, err := m.CPTs()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not find CPT's")
+	}
+
+	isCPT := make(map[*model.Function]bool, len(cpts))
+	for _, f := range cpts {
+		if f != nil {
+			isCPT[f] = true
+		}
+	}
+	others := []*model.Function{}
+	for _, f := range m.Funcs {
+		if !isCPT[f] {
+			others = append(others, f)
+		}
+	}
+
+	s := &ForwardSampler{
+		gen:      gen,
+		pgm:      m,
+		order:    order,
+		cpts:     cpts,
+		others:   others,
+		weighted: weighted,
+	}
+	return s, nil
+}
+
+// evalLinear evaluates f at the state in linear space
+func evalLinear(f *model.Function, state []int) (float64, error) {
+	val, err := f.EvalState(state)
+	if err != nil {
+		return math.NaN(), err
+	}
+	if f.IsLog {
+		return math.Exp(val), nil
+	}
+	return val, nil
+}
+
+// SampleWeighted populates s with an independent sample and returns its log
+// weight - implements WeightedFullSampler. A rejected sample has a log
+// weight of -Inf. Evidence variables always have their fixed value in s.
+func (f *ForwardSampler) SampleWeighted(s []int) (float64, error) {
+	if len(s) != len(f.pgm.Vars) {
+		return math.NaN(), errors.Errorf("Sample size %d != Var size %d in model %s", len(s), len(f.pgm.Vars), f.pgm.Name)
+	}
+
+	// A rejected sample still has the evidence in place
+	reject := func() (float64, error) {
+		for id, v := range f.pgm.Vars {
+			if v.FixedVal >= 0 {
+				s[id] = v.FixedVal
+			}
+		}
+		return math.Inf(-1), nil
+	}
+
+	logWeight := 0.0
+	probs := make([]float64, 0, 8)
+
+	for _, id := range f.order {
+		v := f.pgm.Vars[id]
+		cpt := f.cpts[id]
+		if cpt == nil {
+			// Fixed without a CPT: we're only conditioning on it
+			s[id] = v.FixedVal
+			continue
+		}
+
+		// The CPT row for our sampled parents
+		probs = probs[:0]
+		total, allowed := 0.0, 0.0
+		for val := 0; val < v.Card; val++ {
+			s[id] = val
+			p, err := evalLinear(cpt, s)
+			if err != nil {
+				return math.NaN(), errors.Wrapf(err, "Could not evaluate CPT %s", cpt.Name)
+			}
+			total += p
+			if f.weighted && !v.Allows(val) {
+				p = 0.0
+			}
+			allowed += p
+			probs = append(probs, p)
+		}
+
+		if f.weighted && v.FixedVal >= 0 {
+			// Likelihood weighting: evidence is fixed and weighted
+			s[id] = v.FixedVal
+			logWeight += math.Log(probs[v.FixedVal] / total)
+			continue
+		}
+		if f.weighted && v.Allowed != nil {
+			if allowed <= 0.0 {
+				return reject()
+			}
+			logWeight += math.Log(allowed / total)
+		}
+
+		val, err := sampleIndex(f.gen, probs, allowed)
+		if err != nil {
+			return math.NaN(), errors.Wrapf(err, "Could not sample %s from CPT %s", v.Name, cpt.Name)
+		}
+		s[id] = val
+		if !v.Allows(val) {
+			// Ancestral sampling: this sample disagrees with the evidence
+			return reject()
+		}
+	}
+
+	for _, fun := range f.others {
+		p, err := evalLinear(fun, s)
+		if err != nil {
+			return math.NaN(), errors.Wrapf(err, "Could not evaluate function %s", fun.Name)
+		}
+		logWeight += math.Log(p)
+	}
+
+	return logWeight, nil
+}
+
+// sampleIndex samples an index from the (non-negative) weights, which sum to
+// total. Unlike WeightedSample, zero weights are allowed.
+func sampleIndex(gen *rand.Generator, weights []float64, total float64) (int, error) {
+	if !(total > 0.0) || math.IsInf(total, 0) {
+		return -1, errors.Errorf("Can not sample from weights with total %v", total)
+	}
+
+	r := gen.Float64() * total
+	last := -1
+	for i, w := range weights {
+		if w <= 0.0 {
+			continue
+		}
+		if r < w {
+			return i, nil
+		}
+		r -= w
+		last = i
+	}
+
+	// Rounding can leave a tiny bit of r: use the last value we could pick
+	return last, nil
+}
@@ -0,0 +1,118 @@
+package sampler
+
+import (
+	"math"
+	"testing"
+
+	"github.com/CraigKelly/grample/exact"
+	"github.com/CraigKelly/grample/model"
+	"github.com/CraigKelly/grample/rand"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// forwardMarginals takes count samples and returns the weighted marginals
+func forwardMarginals(t *testing.T, samp WeightedFullSampler, mod *model.Model, count int) *WeightedMarginals {
+	marg, err := NewWeightedMarginals(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := make([]int, len(mod.Vars))
+	for i := 0; i < count; i++ {
+		lw, err := samp.SampleWeighted(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = marg.Add(s, lw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return marg
+}
+
+// Both forward samplers match exact marginals on asia with hard, domain and
+// soft evidence
+func TestForwardSamplers(t *testing.T) {
+	assert := assert.New(t)
+
+	mod, err := model.NewModelFromFile(model.BIFReader{}, "../res/asia.bif", false)
+	assert.NoError(err)
+	assert.NoError(mod.SetEvidence(model.Evidence{6: 0}))
+	assert.NoError(mod.RestrictDomains(model.DomainEvidence{4: {0}}))
+	assert.NoError(mod.AddSoftEvidence(model.SoftEvidence{0: {0.9, 0.3}}))
+
+	ve, err := exact.NewVarElim(mod)
+	assert.NoError(err)
+	want, err := ve.Marginals()
+	assert.NoError(err)
+
+	gen, err := rand.NewGenerator(42)
+	assert.NoError(err)
+
+	ctors := map[string]func(*rand.Generator, *model.Model) (*ForwardSampler, error){
+		"ancestral": NewAncestralSampler,
+		"lw":        NewLikelihoodWeighting,
+	}
+	for name, ctor := range ctors {
+		samp, err := ctor(gen, mod)
+		assert.NoError(err)
+
+		marg := forwardMarginals(t, samp, mod, 60000)
+		got, err := marg.Marginals()
+		assert.NoError(err)
+		for i, v := range got {
+			assert.InDeltaSlice(want[i].Marginal, v.Marginal, 0.02, "%s var %s", name, v.Name)
+		}
+		assert.Equal([]float64{1, 0}, got[6].Marginal)
+		assert.Equal([]float64{1, 0}, got[4].Marginal)
+
+		if name == "lw" {
+			assert.Equal(marg.SampleCount, marg.Accepted)
+		} else {
+			assert.True(marg.Accepted < marg.SampleCount/2)
+		}
+	}
+
+	// Only BAYES models can be forward sampled
+	mod.Type = model.MARKOV
+	_, err = NewLikelihoodWeighting(gen, mod)
+	assert.Error(err)
+}
+
+// Weighted marginals keep their precision with extreme weights
+func TestWeightedMarginals(t *testing.T) {
+	assert := assert.New(t)
+
+	mod, err := model.NewModelFromFile(model.UAIReader{}, "../res/sample.uai", false)
+	assert.NoError(err)
+
+	w1, err := NewWeightedMarginals(mod)
+	assert.NoError(err)
+	_, err = w1.Marginals()
+	assert.Error(err)
+
+	assert.NoError(w1.Add([]int{0, 0, 0}, -1000.0))
+	assert.NoError(w1.Add([]int{1, 0, 0}, -1000.0+math.Log(3.0)))
+	assert.NoError(w1.Add([]int{1, 1, 1}, math.Inf(-1)))
+	assert.Equal(int64(3), w1.SampleCount)
+	assert.Equal(int64(2), w1.Accepted)
+
+	got, err := w1.Marginals()
+	assert.NoError(err)
+	assert.InDeltaSlice([]float64{0.25, 0.75}, got[0].Marginal, 1e-9)
+	assert.InDeltaSlice([]float64{1, 0}, got[1].Marginal, 1e-9)
+
+	// A much heavier sample dominates after a merge
+	w2, err := NewWeightedMarginals(mod)
+	assert.NoError(err)
+	assert.NoError(w2.Add([]int{0, 1, 1}, 0.0))
+	assert.NoError(w1.Merge(w2))
+	assert.Equal(int64(4), w1.SampleCount)
+	got, err = w1.Marginals()
+	assert.NoError(err)
+	assert.InDeltaSlice([]float64{0, 1}, got[1].Marginal, 1e-9)
+
+	assert.Error(w1.Add([]int{0, 0}, 0.0))
+	assert.Error(w1.Add([]int{0, 0, 0}, math.NaN()))
+	assert.Error(w1.Add([]int{0, 2, 0}, 0.0))
+}
@@ -2,8 +2,8 @@ package sampler
 
 import (
 	"fmt"
-	"math"
 
+	"github.com/CraigKelly/grample/elim"
 	"github.com/CraigKelly/grample/model"
 	"github.com/CraigKelly/grample/rand"
 	"github.com/pkg/errors"
@@ -44,23 +44,21 @@ func NewGibbsCollapsed(gen *rand.Generator, m *model.Model) (*GibbsCollapsed, er
 func (g *GibbsCollapsed) FunctionsChanged() error {
 	base := g.baseSampler
 
-	// A lookup from variables to their neighbors
-	neighbors := make([]varSet, len(base.pgm.Vars))
-
-	// Create a neighbor entry per variable
+	// Make sure variable ID's match their index before we build the graph
 	for i, v := range base.pgm.Vars {
 		if i != v.ID {
 			return errors.Errorf("Invalid variable setup: [%d] => %+v", i, v)
 		}
-		neighbors[i] = make(varSet)
 	}
 
-	// Use the Gibbs Simple varFuncs lookup to find all connected variables
-	for idx, funcs := range base.varFuncs {
-		for _, f := range funcs {
-			for _, v := range f.Vars {
-				neighbors[idx][v.ID] = true
-			}
+	// A lookup from variables to their neighbors: note that a variable is in
+	// its own neighborhood if it appears in any function
+	graph := elim.NewGraph(base.pgm, false)
+	neighbors := make([]varSet, len(base.pgm.Vars))
+	for i, adj := range graph.Adj {
+		neighbors[i] = varSet(adj)
+		if len(base.varFuncs[i]) > 0 {
+			neighbors[i][i] = true
 		}
 	}
 
@@ -77,6 +75,12 @@ func (g *GibbsCollapsed) FunctionsChanged() error {
 	return nil
 }
 
+// SeedFrom passes the seed marginals to our base sampler - implements
+// SeededSampler
+func (g *GibbsCollapsed) SeedFrom(vars []*model.Variable) error {
+	return g.baseSampler.SeedFrom(vars)
+}
+
 // BlanketSize return the variable's neighborhood size
 func (g *GibbsCollapsed) BlanketSize(v *model.Variable) int {
 	return len(g.varNeighbors[v.ID])
@@ -137,43 +141,9 @@ func (g *GibbsCollapsed) Collapse(varIdx int) (*model.Variable, error) {
 	}
 	for i := 0; i < collVar.Card; i++ {
 		collVar.Marginal[i] = 1e-12 // We start small instead of just a zero value
-	}
-
-	// IMPORTANT: remember in our blanket array, the variable index is NO
-	// LONGER EQUAL to v.ID.  That's why we need an xref: we can get to an
-	// index in blanket (and varState defined below) from a variable ID via
-	// blanketXref. We also take this chance to grab the collapsing variable's
-	// index since we'll want want to know it value when we're iterating over
-	// the entire variable space below. Since our new function's domain is just
-	// the blanket less the collapsed variable, we also go ahead and create
-	// that array as well.
-	blanket := make([]*model.Variable, 0, len(pgm.Vars))
-	blanketXref := make(map[int]int)
-	collIdx := -1
-	newFuncVars := make([]*model.Variable, 0, len(pgm.Vars))
-	for vi, inBlanket := range g.varNeighbors[varIdx] {
-		if !inBlanket {
-			continue
+		if !collVar.Allows(i) {
+			collVar.Marginal[i] = 0.0 // Unless domain evidence rules it out
 		}
-
-		v := pgm.Vars[vi]
-		blanket = append(blanket, v)
-		blanketXref[v.ID] = len(blanket) - 1
-		if collVar.ID == v.ID {
-			collIdx = len(blanket) - 1 // collapsed: save index
-		} else {
-			newFuncVars = append(newFuncVars, v) // not collapsed: going in new function
-		}
-	}
-
-	if collIdx < 0 {
-		return nil, errors.Errorf("Collapsing variable not in its own blanket")
-	}
-	if len(newFuncVars) != len(blanket)-1 {
-		return nil, errors.Errorf("New function size %d != %d", len(newFuncVars), len(blanket)-1)
-	}
-	if len(newFuncVars) < 1 {
-		return nil, errors.Errorf("New function would have 0 variables")
 	}
 
 	// Get all the functions we'll need to collapse and pre-create a cross-ref.
@@ -187,85 +157,45 @@ func (g *GibbsCollapsed) Collapse(varIdx int) (*model.Variable, error) {
 		}
 	}
 
-	// We will be creating a new function from our collapsing work
-	// Note that we also override the name
-	postFunc, err := model.NewFunction(len(pgm.Funcs), newFuncVars)
+	// The product of our functions is over the entire blanket/neighborhood.
+	// We move to linear space and zero out everything that the evidence
+	// (including domain evidence) rules out.
+	prod, err := model.Product(funcs...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not collapse %v", collVar.Name)
+	}
+	prod, err = prod.ToLinear().Mask(pgm.Vars)
 	if err != nil {
 		return nil, err
 	}
-	postFunc.Name = fmt.Sprintf("COLLAPSE-%v", collVar.Name)
-
-	// We need a buffer to call each function AND a buffer to iterate function values
-	callValBuffer := base.varPool.Get().(*[]int)
-	defer base.varPool.Put(callValBuffer)
-
-	varState := base.varPool.Get().(*[]int)
-	defer base.varPool.Put(varState)
 
-	// Iterate over all configurations in the blanket/neighborhood
-	varIter, err := model.NewVariableIter(blanket, true)
+	// Summing out everything else gives our marginal
+	marg, err := prod.SumTo([]*model.Variable{collVar})
 	if err != nil {
 		return nil, err
 	}
-	for {
-		err := varIter.Val(*varState)
-		if err != nil {
-			return nil, err
-		}
-
-		// We need to know that current value of the variable we are collapsing
-		marginalVal := (*varState)[collIdx]
-
-		// Iterate over all functions, updating varState
-		funcResult := 0.0
-		for _, fun := range base.varFuncs[collVar.ID] {
-			// Populate call value slice
-			callVals := (*callValBuffer)[:len(fun.Vars)]
-			for i, v := range fun.Vars {
-				stateIdx := blanketXref[v.ID]
-				callVals[i] = (*varState)[stateIdx]
-			}
-
-			// Call function and add (in log space, so really multiply) to our
-			// function results.
-			result, err := fun.Eval(callVals)
-			if err != nil {
-				return nil, errors.Wrapf(err, "Collapsing error calling function %v (%+v)", fun.Name, callVals)
-			}
-
-			// Make sure to remove NaN if this is the first time we've seen this value
-			funcResult += result
-		}
-
-		// Now update our marginal with the final function result. Remember
-		// that we need to convert from log space first.
-		funcResult = math.Exp(funcResult)
-		collVar.Marginal[marginalVal] += funcResult
-
-		// Now we need to update our new function
-		callVals := (*callValBuffer)[:len(newFuncVars)]
-		for i, v := range newFuncVars {
-			stateIdx := blanketXref[v.ID]
-			callVals[i] = (*varState)[stateIdx]
-		}
-		err = postFunc.AddValue(callVals, funcResult)
-		if err != nil {
-			return nil, errors.Wrapf(err, "Could not add to postFunc (%v, %+v)", callVals, funcResult)
-		}
-
-		// Time for next variable state
-		if !varIter.Next() {
-			break
-		}
+	for i, m := range marg.Table {
+		collVar.Marginal[i] += m
 	}
-
-	// We have now collected an entire marginal
 	err = collVar.NormMarginal()
 	if err != nil {
 		return nil, err
 	}
 
-	// We also have a new function
+	// Summing out the collapsed variable gives our new function. Note that
+	// we override the name and make sure it uses the model's variables.
+	postFunc, _, err := prod.SumOut(collVar.ID)
+	if err != nil {
+		return nil, err
+	}
+	if postFunc == nil {
+		return nil, errors.Errorf("New function would have 0 variables")
+	}
+	postFunc.Name = fmt.Sprintf("COLLAPSE-%v", collVar.Name)
+	for i, v := range postFunc.Vars {
+		postFunc.Vars[i] = pgm.Vars[v.ID]
+	}
+
 	err = postFunc.UseLogSpace()
 	if err != nil {
 		return nil, err
@@ -289,6 +219,10 @@ func (g *GibbsCollapsed) Collapse(varIdx int) (*model.Variable, error) {
 	}
 	pgm.Funcs = pgm.Funcs[:insert+1]
 
+	// The new function isn't a CPT, so a Bayesian network is now a Markov
+	// network
+	pgm.Type = model.MARKOV
+
 	// Now we need to update internal tracking: both in this sampler and in the
 	// base/simple sampler. We also need to re-run model checking to make sure
 	// we haven't broken anything
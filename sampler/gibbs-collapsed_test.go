@@ -1,7 +1,9 @@
 package sampler
 
 import (
+	"bytes"
 	"fmt"
+	"math"
 	"testing"
 
 	"github.com/CraigKelly/grample/model"
@@ -10,6 +12,26 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// Collapsing a restricted variable only sums over its allowed values
+func TestGibbsCollapsedRestricted(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := model.UAIReader{}
+	mod, err := model.NewModelFromFile(reader, "../res/deterministic.uai", false)
+	assert.NoError(err)
+	assert.NoError(mod.RestrictDomains(model.DomainEvidence{0: {1}}))
+
+	gen, err := rand.NewGenerator(42)
+	assert.NoError(err)
+	samp, err := NewGibbsCollapsed(gen, mod)
+	assert.NoError(err)
+
+	v, err := samp.Collapse(0)
+	assert.NoError(err)
+	assert.Equal(0.0, v.Marginal[0])
+	assert.Equal(1.0, v.Marginal[1])
+}
+
 // Test that we can actually sample from a simple 1-var dist
 func TestWorkingGibbsCollapsed(t *testing.T) {
 	assert := assert.New(t)
@@ -164,3 +186,37 @@ func BenchmarkGibbsCollapsedWithEvidence(b *testing.B) {
 
 	runColBench(b, mod)
 }
+
+// Collapsed models can be saved and read back
+func TestCollapsedModelWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := model.UAIReader{}
+	writer := model.UAIWriter{}
+	mod, err := model.NewModelFromFile(reader, "../res/sample.uai", false)
+	assert.NoError(err)
+
+	gen, err := rand.NewGenerator(42)
+	assert.NoError(err)
+
+	samp, err := NewGibbsCollapsed(gen, mod.Clone())
+	assert.NoError(err)
+	_, err = samp.Collapse(1)
+	assert.NoError(err)
+
+	pgm := samp.baseSampler.pgm
+	buf := &bytes.Buffer{}
+	assert.NoError(writer.WriteModel(buf, pgm))
+
+	mod2, err := model.NewModelFromBuffer(reader, buf.Bytes())
+	assert.NoError(err)
+	assert.Equal(len(pgm.Funcs), len(mod2.Funcs))
+	for i, f := range pgm.Funcs {
+		assert.True(f.IsLog)
+		f2 := mod2.Funcs[i]
+		assert.Equal(len(f.Vars), len(f2.Vars))
+		for j, val := range f.Table {
+			assert.InDelta(math.Exp(val), f2.Table[j], 1e-12)
+		}
+	}
+}
@@ -17,6 +17,7 @@ type GibbsSimple struct {
 	weighted    WeightedSampler
 	varFuncs    map[int][]*model.Function
 	last        []int
+	temperature float64
 	valuePool   *sync.Pool
 	varPool     *sync.Pool
 }
@@ -64,16 +65,20 @@ func NewGibbsSimple(gen *rand.Generator, m *model.Model) (*GibbsSimple, error) {
 		weighted:    uniform,
 		varFuncs:    make(map[int][]*model.Function),
 		last:        make([]int, len(m.Vars)),
+		temperature: 1.0,
 		valuePool:   valuePool,
 		varPool:     varPool,
 	}
 
-	// Set up functions: use log space for factors and keep track of functions
-	// that involve each variable
+	// Set up functions: use log space for factors (some readers create
+	// functions already in log space) and keep track of functions that
+	// involve each variable
 	for _, f := range m.Funcs {
-		err := f.UseLogSpace()
-		if err != nil {
-			return nil, errors.Wrapf(err, "Could not convert function %v to Log Space", f.Name)
+		if !f.IsLog {
+			err := f.UseLogSpace()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Could not convert function %v to Log Space", f.Name)
+			}
 		}
 
 		for _, v := range f.Vars {
@@ -99,9 +104,17 @@ func NewGibbsSimple(gen *rand.Generator, m *model.Model) (*GibbsSimple, error) {
 		}
 
 		// Select value for every variable with uniform prob UNLESS it is Fixed
-		// (in that case we always know the value)
+		// (in that case we always know the value). Restricted variables get
+		// a uniform choice of their allowed values.
 		if v.FixedVal >= 0 {
 			s.last[i] = v.FixedVal
+		} else if v.Allowed != nil {
+			vals := v.AllowedValues()
+			val, err := uniform.UniSample(len(vals))
+			if err != nil {
+				return nil, errors.Wrapf(err, "Could not generate start sample for variable %v", v.Name)
+			}
+			s.last[i] = vals[val]
 		} else {
 			val, err := uniform.UniSample(v.Card)
 			if err != nil {
@@ -133,7 +146,7 @@ func (g *GibbsSimple) FunctionsChanged() error {
 		if v.FixedVal >= 0 {
 			g.last[i] = v.FixedVal
 		} else {
-			val, err := g.weighted.WeightedSample(v.Card, v.Marginal)
+			val, err := allowedSample(g.weighted, v, v.Marginal)
 			if err != nil {
 				return errors.Wrapf(err, "Could no generated a start sample for var %v", v.Name)
 			}
@@ -144,6 +157,53 @@ func (g *GibbsSimple) FunctionsChanged() error {
 	return nil
 }
 
+// SeedFrom replaces our current starting point with values drawn from the
+// given marginals (for instance from belief propagation) - implements
+// SeededSampler. Fixed variables keep their evidence value.
+func (g *GibbsSimple) SeedFrom(vars []*model.Variable) error {
+	if len(vars) != len(g.pgm.Vars) {
+		return errors.Errorf("Seed has %d vars but model %s has %d", len(vars), g.pgm.Name, len(g.pgm.Vars))
+	}
+
+	for i, v := range g.pgm.Vars {
+		if v.FixedVal >= 0 {
+			g.last[i] = v.FixedVal
+			continue
+		}
+
+		seed := vars[i]
+		if seed.Card != v.Card || len(seed.Marginal) != v.Card {
+			return errors.Errorf("Seed var %s does not match model var %s", seed.Name, v.Name)
+		}
+
+		// Our weighted sampler doesn't allow zero weights
+		weights := make([]float64, v.Card)
+		for c, p := range seed.Marginal {
+			weights[c] = math.Max(p, 1e-12)
+		}
+
+		val, err := allowedSample(g.weighted, v, weights)
+		if err != nil {
+			return errors.Wrapf(err, "Could not generate a seed sample for var %v", v.Name)
+		}
+		g.last[i] = val
+	}
+
+	return nil
+}
+
+// SetTemperature changes the temperature used by SampleVar: the conditional
+// distribution for a variable is raised to the power 1/t. The default of 1.0
+// is ordinary Gibbs sampling, and values close to 0 are nearly greedy (which
+// is how we use this for simulated annealing).
+func (g *GibbsSimple) SetTemperature(t float64) error {
+	if !(t > 0.0) || math.IsInf(t, 1) {
+		return errors.Errorf("Invalid temperature %f", t)
+	}
+	g.temperature = t
+	return nil
+}
+
 // Sample returns a single sample - implements FullSampler
 func (g *GibbsSimple) Sample(s []int) (int, error) {
 	if len(s) != len(g.pgm.Vars) {
@@ -224,21 +284,40 @@ func (g *GibbsSimple) SampleVar(varIdx int, s []int) (int, error) {
 	// that adding in log-space is equivalent to multiplication, we just add a constant
 	// to all weights if the minimum weight is too low. There is mainly for numerical
 	// stability, but it also helps with debugging things like our min weight check below
-	minWeight := sampleWeights[0]
-	for _, w := range sampleWeights[1:] {
-		if w < minWeight {
-			minWeight = w
+	// Values not allowed by domain evidence are ignored (and get zero weight)
+	restricted := sampleVar.Allowed != nil
+	if g.temperature != 1.0 {
+		// Tempered: scale relative to the max weight so that the best value
+		// has weight 1 no matter how low the temperature gets
+		maxWeight := math.Inf(-1)
+		for i, w := range sampleWeights {
+			if w > maxWeight && (!restricted || sampleVar.Allowed[i]) {
+				maxWeight = w
+			}
 		}
-	}
-	if minWeight < -8.0 {
 		for i, w := range sampleWeights {
-			sampleWeights[i] = w - (minWeight - 1.5) // should all be positive now
+			sampleWeights[i] = (w - maxWeight) / g.temperature
+		}
+	} else {
+		minWeight := math.Inf(1)
+		for i, w := range sampleWeights {
+			if w < minWeight && (!restricted || sampleVar.Allowed[i]) {
+				minWeight = w
+			}
+		}
+		if minWeight < -8.0 {
+			for i, w := range sampleWeights {
+				sampleWeights[i] = w - (minWeight - 1.5) // should all be positive now
+			}
 		}
 	}
 
 	totWeights := 0.0
 	for i, w := range sampleWeights {
-		v := math.Exp(w)
+		v := 0.0
+		if !restricted || sampleVar.Allowed[i] {
+			v = math.Exp(w)
+		}
 		totWeights += v
 		sampleWeights[i] = v
 	}
@@ -246,6 +325,9 @@ func (g *GibbsSimple) SampleVar(varIdx int, s []int) (int, error) {
 	// Remember that for Gibbs sampling to work, every option must be possible. As
 	// a result, we make sure that no weight results in a prob < minProb
 	for i, w := range sampleWeights {
+		if restricted && !sampleVar.Allowed[i] {
+			continue
+		}
 		if w/totWeights < 1e-6 {
 			delta := totWeights * 1e-6
 			if delta <= 1e-12 {
@@ -259,7 +341,7 @@ func (g *GibbsSimple) SampleVar(varIdx int, s []int) (int, error) {
 
 	// Select value based on the factor weights for our current variable and
 	// then update saved copy with new value and copy to caller's sample.
-	nextVal, err := g.weighted.WeightedSample(len(sampleWeights), sampleWeights)
+	nextVal, err := allowedSample(g.weighted, sampleVar, sampleWeights)
 	if err != nil {
 		return -1, nil
 	}
@@ -269,3 +351,24 @@ func (g *GibbsSimple) SampleVar(varIdx int, s []int) (int, error) {
 
 	return varIdx, nil
 }
+
+// allowedSample draws a value for v using weights (indexed by value), but
+// only from the values allowed by the variable's domain evidence. The weights
+// of the allowed values must be > 0.
+func allowedSample(ws WeightedSampler, v *model.Variable, weights []float64) (int, error) {
+	if v.Allowed == nil {
+		return ws.WeightedSample(v.Card, weights)
+	}
+
+	vals := v.AllowedValues()
+	allowed := make([]float64, len(vals))
+	for i, val := range vals {
+		allowed[i] = weights[val]
+	}
+
+	i, err := ws.WeightedSample(len(allowed), allowed)
+	if err != nil {
+		return -1, err
+	}
+	return vals[i], nil
+}
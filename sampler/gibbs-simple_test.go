@@ -3,6 +3,7 @@ package sampler
 import (
 	"testing"
 
+	"github.com/CraigKelly/grample/exact"
 	"github.com/CraigKelly/grample/model"
 	"github.com/CraigKelly/grample/rand"
 
@@ -37,6 +38,68 @@ func TestWorkingGibbsSimple(t *testing.T) {
 	assert.True(counts[1] > 0)
 }
 
+// Seeding from (nearly) one-hot marginals fixes the starting point
+func TestGibbsSimpleSeed(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := model.UAIReader{}
+	mod, err := model.NewModelFromFile(reader, "../res/sample.uai", false)
+	assert.NoError(err)
+	mod.Vars[2].FixedVal = 0
+
+	gen, err := rand.NewGenerator(42)
+	assert.NoError(err)
+
+	samp, err := NewGibbsSimple(gen, mod)
+	assert.NoError(err)
+
+	seed := make([]*model.Variable, len(mod.Vars))
+	for i, v := range mod.Vars {
+		seed[i] = v.Clone()
+		seed[i].Marginal[0] = 0.0
+		seed[i].Marginal[1] = 1.0
+	}
+	assert.NoError(samp.SeedFrom(seed))
+	assert.Equal([]int{1, 1, 0}, samp.last) // Evidence wins
+
+	assert.Error(samp.SeedFrom(seed[:2]))
+}
+
+// Domain evidence rules out values, and the marginals should match the exact
+// answer for the restricted model
+func TestGibbsSimpleRestricted(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := model.UAIReader{}
+	mod, err := model.NewModelFromFile(reader, "../res/sample.uai", false)
+	assert.NoError(err)
+	assert.NoError(mod.RestrictDomains(model.DomainEvidence{2: {0, 2}}))
+
+	ve, err := exact.NewVarElim(mod.Clone())
+	assert.NoError(err)
+	expected, err := ve.Marginal(2)
+	assert.NoError(err)
+	assert.Equal(0.0, expected.Marginal[1])
+
+	gen, err := rand.NewGenerator(42)
+	assert.NoError(err)
+	samp, err := NewGibbsSimple(gen, mod)
+	assert.NoError(err)
+
+	const iters = 30000
+	sample := make([]int, len(mod.Vars))
+	counts := make([]float64, 3)
+	for i := 0; i < iters; i++ {
+		_, err := samp.SampleVar(i%len(mod.Vars), sample)
+		assert.NoError(err)
+		counts[sample[2]]++
+	}
+
+	assert.Equal(0.0, counts[1])
+	assert.InDelta(expected.Marginal[0], counts[0]/iters, 0.02)
+	assert.InDelta(expected.Marginal[2], counts[2]/iters, 0.02)
+}
+
 var modIts int
 
 func runBench(b *testing.B, m *model.Model) {
@@ -84,3 +147,23 @@ func BenchmarkGibbsSimpleWithEvidence(b *testing.B) {
 
 	runBench(b, mod)
 }
+
+// Functions already in log space (e.g. soft WCNF clauses) are used as is
+func TestGibbsSimpleLogFuncs(t *testing.T) {
+	assert := assert.New(t)
+
+	mod, err := model.NewModelFromBuffer(model.DIMACSReader{}, []byte("h 1 2 0\n2.5 1 0\n1000 -2 0\n"))
+	assert.NoError(err)
+	assert.True(mod.Funcs[1].IsLog)
+
+	gen, err := rand.NewGenerator(42)
+	assert.NoError(err)
+
+	_, err = NewGibbsSimple(gen, mod)
+	assert.NoError(err)
+	for _, f := range mod.Funcs {
+		assert.True(f.IsLog)
+	}
+	assert.Equal([]float64{-2.5, 0}, mod.Funcs[1].Table)
+	assert.Equal([]float64{0, -1000}, mod.Funcs[2].Table)
+}
@@ -0,0 +1,189 @@
+package sampler
+
+import (
+	"math"
+
+	"github.com/CraigKelly/grample/model"
+	"github.com/CraigKelly/grample/rand"
+	"github.com/pkg/errors"
+)
+
+// A Proposal is a (normalized) distribution over full assignments to a
+// model's variables that we can sample from. Propose populates the given
+// array with a sample and returns the natural log of the sample's
+// probability under the proposal. Evidence variables must always be set to
+// their fixed value, and values ruled out by domain evidence must never be
+// proposed.
+type Proposal interface {
+	Propose([]int) (float64, error)
+}
+
+// ProposalMix is the weight of the uniform distribution mixed in to every
+// MarginalProposal. Importance sampling is only correct if the proposal is
+// non-zero wherever the model is, so we never trust a marginal estimate to
+// rule out a value entirely.
+const ProposalMix = 0.01
+
+// MarginalProposal is a fully factored proposal: each variable is sampled
+// independently from its own distribution. The distributions usually come
+// from an approximation like BP or the merged marginals of Gibbs chains.
+type MarginalProposal struct {
+	gen   *rand.Generator
+	probs [][]float64 // Probability of each value (indexed by var ID)
+}
+
+// NewUniformProposal creates a proposal that samples each variable uniformly
+// from its allowed values
+func NewUniformProposal(gen *rand.Generator, m *model.Model) (*MarginalProposal, error) {
+	if m == nil {
+		return nil, errors.New("No model supplied")
+	}
+
+	// No usable marginal estimates means uniform
+	vars := make([]*model.Variable, len(m.Vars))
+	for i, v := range m.Vars {
+		vars[i] = v.Clone()
+		for j := range vars[i].Marginal {
+			vars[i].Marginal[j] = 0.0
+		}
+	}
+	return NewMarginalProposal(gen, m, vars)
+}
+
+// NewMarginalProposal creates a proposal from the marginals of vars, which
+// must match the model's variables. Evidence in the model (not vars) is
+// respected, and ProposalMix of a uniform distribution is mixed in.
+func NewMarginalProposal(gen *rand.Generator, m *model.Model, vars []*model.Variable) (*MarginalProposal, error) {
+	if m == nil {
+		return nil, errors.New("No model supplied")
+	}
+	if len(vars) != len(m.Vars) {
+		return nil, errors.Errorf("Proposal has %d variables but model %s has %d", len(vars), m.Name, len(m.Vars))
+	}
+
+	p := &MarginalProposal{
+		gen:   gen,
+		probs: make([][]float64, len(m.Vars)),
+	}
+
+	for i, v := range m.Vars {
+		if v.ID != i {
+			return nil, errors.Errorf("Invalid ID for var %s: expected %d but was %d", v.Name, i, v.ID)
+		}
+		src := vars[i]
+		if src.Card != v.Card || len(src.Marginal) != v.Card {
+			return nil, errors.Errorf("Proposal variable %s does not match model variable %s", src.Name, v.Name)
+		}
+
+		allowed := v.AllowedValues()
+		if len(allowed) < 1 {
+			return nil, errors.Errorf("Variable %s has no allowed values", v.Name)
+		}
+
+		// Only usable estimates count (and we fall back to uniform)
+		usable := func(mp float64) bool {
+			return mp > 0.0 && !math.IsInf(mp, 0)
+		}
+		sum := 0.0
+		for _, val := range allowed {
+			if mp := src.Marginal[val]; usable(mp) {
+				sum += mp
+			}
+		}
+
+		probs := make([]float64, v.Card)
+		uniform := 1.0 / float64(len(allowed))
+		for _, val := range allowed {
+			est := uniform
+			if sum > 0.0 {
+				est = 0.0
+				if mp := src.Marginal[val]; usable(mp) {
+					est = mp / sum
+				}
+			}
+			probs[val] = (1.0-ProposalMix)*est + ProposalMix*uniform
+		}
+		p.probs[i] = probs
+	}
+
+	return p, nil
+}
+
+// Propose implements Proposal
+func (p *MarginalProposal) Propose(s []int) (float64, error) {
+	if len(s) != len(p.probs) {
+		return math.NaN(), errors.Errorf("Sample size %d != Var size %d", len(s), len(p.probs))
+	}
+
+	logProb := 0.0
+	for i, probs := range p.probs {
+		val, err := sampleIndex(p.gen, probs, 1.0)
+		if err != nil {
+			return math.NaN(), errors.Wrapf(err, "Could not propose a value for variable %d", i)
+		}
+		s[i] = val
+		logProb += math.Log(probs[val])
+	}
+
+	return logProb, nil
+}
+
+// ImportanceSampler draws samples from a Proposal and weights them by the
+// model: the weight is the unnormalized model probability (the product of
+// every function) over the proposal probability. The mean weight estimates
+// Z, and the weighted samples estimate the marginals.
+type ImportanceSampler struct {
+	pgm      *model.Model
+	proposal Proposal
+}
+
+// NewImportanceSampler creates an importance sampler for the model using the
+// given proposal
+func NewImportanceSampler(m *model.Model, proposal Proposal) (*ImportanceSampler, error) {
+	if m == nil {
+		return nil, errors.New("No model supplied")
+	}
+	if proposal == nil {
+		return nil, errors.New("No proposal supplied")
+	}
+
+	s := &ImportanceSampler{
+		pgm:      m,
+		proposal: proposal,
+	}
+	return s, nil
+}
+
+// SampleWeighted implements WeightedFullSampler
+func (is *ImportanceSampler) SampleWeighted(s []int) (float64, error) {
+	logQ, err := is.proposal.Propose(s)
+	if err != nil {
+		return math.NaN(), errors.Wrapf(err, "Proposal failed")
+	}
+
+	for i, v := range is.pgm.Vars {
+		if !v.Allows(s[i]) {
+			return math.NaN(), errors.Errorf("Proposal value %d for variable %s is not allowed by the evidence", s[i], v.Name)
+		}
+	}
+
+	logP, err := is.pgm.LogProb(s)
+	if err != nil {
+		return math.NaN(), err
+	}
+	if math.IsInf(logP, -1) {
+		return logP, nil
+	}
+
+	return logP - logQ, nil
+}
+
+// ChainProposal returns a MarginalProposal from the merged marginals of
+// chains that have already been run on the model
+func ChainProposal(gen *rand.Generator, m *model.Model, chains []*Chain) (*MarginalProposal, error) {
+	vars, err := MergeChains(chains)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not merge chains for proposal")
+	}
+	return NewMarginalProposal(gen, m, vars)
+}
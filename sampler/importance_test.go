@@ -0,0 +1,140 @@
+package sampler
+
+import (
+	"testing"
+
+	"github.com/CraigKelly/grample/approx"
+	"github.com/CraigKelly/grample/exact"
+	"github.com/CraigKelly/grample/model"
+	"github.com/CraigKelly/grample/rand"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// checkImportance samples the model with each proposal and compares the
+// marginals and log Z to variable elimination
+func checkImportance(t *testing.T, mod *model.Model, proposals map[string]Proposal) {
+	assert := assert.New(t)
+
+	ve, err := exact.NewVarElim(mod)
+	assert.NoError(err)
+	want, err := ve.Marginals()
+	assert.NoError(err)
+	wantLogZ, err := ve.LogZ()
+	assert.NoError(err)
+
+	for name, prop := range proposals {
+		samp, err := NewImportanceSampler(mod, prop)
+		assert.NoError(err)
+
+		marg := forwardMarginals(t, samp, mod, 60000)
+		got, err := marg.Marginals()
+		assert.NoError(err)
+		for i, v := range got {
+			assert.InDeltaSlice(want[i].Marginal, v.Marginal, 0.02, "%s var %s", name, v.Name)
+		}
+
+		assert.InDelta(wantLogZ, marg.LogZ(), 0.05, "%s log Z", name)
+		assert.True(marg.ESS() > 1.0)
+		assert.True(marg.ESS() <= float64(marg.Accepted))
+	}
+}
+
+// Importance sampling matches exact marginals and log Z on asia with hard,
+// domain and soft evidence (using uniform and BP proposals)
+func TestImportanceSampler(t *testing.T) {
+	assert := assert.New(t)
+
+	mod, err := model.NewModelFromFile(model.BIFReader{}, "../res/asia.bif", false)
+	assert.NoError(err)
+	assert.NoError(mod.SetEvidence(model.Evidence{6: 0}))
+	assert.NoError(mod.RestrictDomains(model.DomainEvidence{4: {0}}))
+	assert.NoError(mod.AddSoftEvidence(model.SoftEvidence{0: {0.9, 0.3}}))
+
+	gen, err := rand.NewGenerator(42)
+	assert.NoError(err)
+
+	bp, err := approx.NewBeliefProp(mod)
+	assert.NoError(err)
+	assert.NoError(bp.Run())
+	bpVars, err := bp.Marginals()
+	assert.NoError(err)
+
+	proposals := make(map[string]Proposal)
+	proposals["uniform"], err = NewUniformProposal(gen, mod)
+	assert.NoError(err)
+	proposals["bp"], err = NewMarginalProposal(gen, mod, bpVars)
+	assert.NoError(err)
+	checkImportance(t, mod, proposals)
+
+	// Evidence is never violated
+	s := make([]int, len(mod.Vars))
+	for i := 0; i < 100; i++ {
+		_, err = proposals["bp"].Propose(s)
+		assert.NoError(err)
+		assert.Equal(0, s[6])
+		assert.Equal(0, s[4])
+	}
+
+	// Bad parameters
+	_, err = NewImportanceSampler(nil, proposals["uniform"])
+	assert.Error(err)
+	_, err = NewImportanceSampler(mod, nil)
+	assert.Error(err)
+	_, err = NewMarginalProposal(gen, mod, bpVars[1:])
+	assert.Error(err)
+	_, err = proposals["uniform"].Propose(make([]int, 2))
+	assert.Error(err)
+}
+
+// Gibbs chain marginals make a usable proposal
+func TestChainProposal(t *testing.T) {
+	assert := assert.New(t)
+
+	mod, err := model.NewModelFromFile(model.UAIReader{}, "../res/sample.uai", false)
+	assert.NoError(err)
+
+	gen, err := rand.NewGenerator(42)
+	assert.NoError(err)
+
+	chains := make([]*Chain, 2)
+	for i := range chains {
+		modCopy := mod.Clone()
+		samp, err := NewGibbsSimple(gen, modCopy)
+		assert.NoError(err)
+		chains[i], err = NewChain(modCopy, samp, 100, 100)
+		assert.NoError(err)
+		for j := 0; j < 1000; j++ {
+			assert.NoError(chains[i].oneSample(true))
+		}
+	}
+
+	prop, err := ChainProposal(gen, mod, chains)
+	assert.NoError(err)
+	checkImportance(t, mod, map[string]Proposal{"chain": prop})
+
+	_, err = ChainProposal(gen, mod, nil)
+	assert.Error(err)
+}
+
+// ESS is the sample count for equal weights and falls as weights diverge
+func TestWeightedESS(t *testing.T) {
+	assert := assert.New(t)
+
+	mod, err := model.NewModelFromFile(model.UAIReader{}, "../res/sample.uai", false)
+	assert.NoError(err)
+
+	w, err := NewWeightedMarginals(mod)
+	assert.NoError(err)
+	assert.Equal(0.0, w.ESS())
+
+	for i := 0; i < 4; i++ {
+		assert.NoError(w.Add([]int{0, 0, 0}, -500.0))
+	}
+	assert.InDelta(4.0, w.ESS(), 1e-9)
+	assert.InDelta(-500.0, w.LogZ(), 1e-9)
+
+	assert.NoError(w.Add([]int{1, 1, 1}, -490.0))
+	assert.True(w.ESS() < 2.0)
+	assert.True(w.ESS() >= 1.0)
+}
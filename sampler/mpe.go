@@ -0,0 +1,145 @@
+package sampler
+
+import (
+	"math"
+
+	"github.com/CraigKelly/grample/model"
+	"github.com/pkg/errors"
+)
+
+// MPETracker keeps the highest scoring joint state it has seen. States are
+// scored on the ORIGINAL model (not a collapsed model), with exact zeros (so
+// an impossible state scores -Inf). Updates are incremental when only a single
+// variable changes, which is the usual case for Gibbs sampling.
+type MPETracker struct {
+	BestSample  []int   // Highest scoring state seen (nil if no updates yet)
+	BestLogProb float64 // Unnormalized log prob of BestSample
+
+	pgm      *model.Model
+	varFuncs [][]*model.Function
+	curr     []int
+	currLP   float64
+}
+
+// NewMPETracker creates a tracker that scores states on the given model. The
+// model's functions are copied, so later changes to the model (like
+// collapsing a variable) do not affect scoring.
+func NewMPETracker(m *model.Model) (*MPETracker, error) {
+	if m == nil {
+		return nil, errors.New("No model supplied")
+	}
+
+	t := &MPETracker{
+		BestSample:  nil,
+		BestLogProb: math.Inf(-1),
+		pgm:         m.Clone(),
+		varFuncs:    make([][]*model.Function, len(m.Vars)),
+		curr:        nil,
+		currLP:      math.Inf(-1),
+	}
+
+	for i, v := range t.pgm.Vars {
+		if i != v.ID {
+			return nil, errors.Errorf("Invalid ID for var %s: expected %d but was %d", v.Name, i, v.ID)
+		}
+	}
+	for _, f := range t.pgm.Funcs {
+		for _, v := range f.Vars {
+			t.varFuncs[v.ID] = append(t.varFuncs[v.ID], f)
+		}
+	}
+
+	return t, nil
+}
+
+// Update scores the given state and saves it if it is the best seen so far.
+// True is returned if the state is the new best.
+func (t *MPETracker) Update(state []int) (bool, error) {
+	if len(state) != len(t.pgm.Vars) {
+		return false, errors.Errorf("State size %d != var count %d", len(state), len(t.pgm.Vars))
+	}
+
+	changed := -1
+	if t.curr != nil {
+		for i, val := range state {
+			if val == t.curr[i] {
+				continue
+			}
+			if changed >= 0 {
+				changed = -2 // More than one change
+				break
+			}
+			changed = i
+		}
+	}
+
+	var err error
+	if changed == -1 && t.curr != nil {
+		// No change
+	} else if changed >= 0 && !math.IsInf(t.currLP, -1) {
+		// Only need the functions for the changed variable
+		before, err := t.funcLogProb(changed, t.curr)
+		if err != nil {
+			return false, err
+		}
+		after, err := t.funcLogProb(changed, state)
+		if err != nil {
+			return false, err
+		}
+		t.curr[changed] = state[changed]
+		t.currLP += after - before
+	} else {
+		t.curr = append(t.curr[:0], state...)
+		t.currLP, err = t.pgm.LogProb(t.curr)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if t.BestSample == nil || t.currLP > t.BestLogProb {
+		t.BestSample = append(t.BestSample[:0], t.curr...)
+		t.BestLogProb = t.currLP
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// funcLogProb is the log prob contribution of the functions for varIdx
+func (t *MPETracker) funcLogProb(varIdx int, state []int) (float64, error) {
+	total := 0.0
+	for _, f := range t.varFuncs[varIdx] {
+		val, err := f.EvalState(state)
+		if err != nil {
+			return math.NaN(), err
+		}
+		if f.IsLog {
+			total += val
+		} else {
+			total += math.Log(val)
+		}
+	}
+	return total, nil
+}
+
+// BestOfChains returns the best state (and its log prob) tracked by any of the
+// chains. An error is returned if no chain is tracking a best state.
+func BestOfChains(chains []*Chain) ([]int, float64, error) {
+	var best *MPETracker
+	for _, ch := range chains {
+		if ch.Best == nil || ch.Best.BestSample == nil {
+			continue
+		}
+		if best == nil || ch.Best.BestLogProb > best.BestLogProb {
+			best = ch.Best
+		}
+	}
+
+	if best == nil {
+		return nil, math.NaN(), errors.New("No chain has a tracked best state")
+	}
+
+	state := make([]int, len(best.BestSample))
+	copy(state, best.BestSample)
+	return state, best.BestLogProb, nil
+}
@@ -0,0 +1,223 @@
+package sampler
+
+import (
+	"math"
+	"testing"
+
+	"github.com/CraigKelly/grample/model"
+	"github.com/CraigKelly/grample/rand"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bruteMPE enumerates every (evidence consistent) state to find the MPE
+func bruteMPE(t *testing.T, m *model.Model) ([]int, float64) {
+	vi, err := model.NewVariableIter(m.Vars, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var best []int
+	bestLP := math.Inf(-1)
+	state := make([]int, len(m.Vars))
+	for {
+		if err := vi.Val(state); err != nil {
+			t.Fatal(err)
+		}
+		lp, err := m.LogProb(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if best == nil || lp > bestLP {
+			best = append(best[:0], state...)
+			bestLP = lp
+		}
+		if !vi.Next() {
+			break
+		}
+	}
+
+	return best, bestLP
+}
+
+func TestMPETracker(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := model.UAIReader{}
+	mod, err := model.NewModelFromFile(reader, "../res/sample.uai", false)
+	assert.NoError(err)
+
+	tr, err := NewMPETracker(mod)
+	assert.NoError(err)
+	assert.Nil(tr.BestSample)
+
+	_, err = tr.Update([]int{0})
+	assert.Error(err)
+
+	// Incremental updates (single var changes) match a full score
+	gen, err := rand.NewGenerator(42)
+	assert.NoError(err)
+	state := []int{0, 0, 0}
+	bestLP := math.Inf(-1)
+	for i := 0; i < 200; i++ {
+		if i%10 == 0 {
+			for j, v := range mod.Vars {
+				state[j] = int(gen.Int31n(int32(v.Card)))
+			}
+		} else {
+			j := int(gen.Int31n(int32(len(state))))
+			state[j] = int(gen.Int31n(int32(mod.Vars[j].Card)))
+		}
+
+		lp, err := mod.LogProb(state)
+		assert.NoError(err)
+		isBest, err := tr.Update(state)
+		assert.NoError(err)
+		if math.Abs(lp-bestLP) > 1e-9 {
+			assert.Equal(lp > bestLP, isBest)
+		}
+		bestLP = math.Max(bestLP, lp)
+		assert.InDelta(lp, tr.currLP, 1e-9)
+		assert.InDelta(bestLP, tr.BestLogProb, 1e-9)
+	}
+
+	expected, expectedLP := bruteMPE(t, mod)
+	assert.Equal(expected, tr.BestSample)
+	assert.InDelta(expectedLP, tr.BestLogProb, 1e-9)
+
+	// Best across chains
+	_, _, err = BestOfChains([]*Chain{{}})
+	assert.Error(err)
+
+	other, err := NewMPETracker(mod)
+	assert.NoError(err)
+	_, err = other.Update([]int{0, 1, 0})
+	assert.NoError(err)
+	best, lp, err := BestOfChains([]*Chain{{Best: other}, {}, {Best: tr}})
+	assert.NoError(err)
+	assert.Equal(expected, best)
+	assert.InDelta(expectedLP, lp, 1e-9)
+}
+
+func TestAnnealer(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := model.UAIReader{}
+	mod, err := model.NewModelFromFile(reader, "../res/sample.uai", false)
+	assert.NoError(err)
+
+	gen, err := rand.NewGenerator(42)
+	assert.NoError(err)
+
+	for _, fixed := range []int{-1, 1} {
+		mod.Vars[1].FixedVal = fixed
+		expected, expectedLP := bruteMPE(t, mod)
+
+		sa, err := NewAnnealer(gen, mod)
+		assert.NoError(err)
+
+		best, lp, err := sa.Run()
+		assert.NoError(err)
+		assert.Equal(expected, best)
+		assert.InDelta(expectedLP, lp, 1e-9)
+		if fixed < 0 {
+			assert.Equal([]int{0, 1, 0}, best)
+		}
+	}
+
+	// Our model is not changed by annealing
+	assert.False(mod.Funcs[0].IsLog)
+
+	sa, err := NewAnnealer(gen, mod)
+	assert.NoError(err)
+	sa.EndTemp = 0.0
+	_, _, err = sa.Run()
+	assert.Error(err)
+}
+
+// The MPE state respects domain evidence, even if a run starts from a state
+// the evidence doesn't allow
+func TestAnnealerDomain(t *testing.T) {
+	assert := assert.New(t)
+
+	mod, err := model.NewModelFromFile(model.UAIReader{}, "../res/sample.uai", false)
+	assert.NoError(err)
+	assert.NoError(mod.RestrictDomains(model.DomainEvidence{1: {0}, 2: {1, 2}}))
+	expected, expectedLP := bruteMPE(t, mod)
+
+	gen, err := rand.NewGenerator(42)
+	assert.NoError(err)
+
+	sa, err := NewAnnealer(gen, mod)
+	assert.NoError(err)
+	copy(sa.state, []int{0, 1, 0}) // The unrestricted MPE
+	copy(sa.gibbs.last, sa.state)
+
+	best, lp, err := sa.Run()
+	assert.NoError(err)
+	assert.Equal(expected, best)
+	assert.InDelta(expectedLP, lp, 1e-9)
+	assert.Equal(0, best[1])
+	assert.NotEqual(0, best[2])
+}
+
+// Models with deterministic functions and evidence still get a possible MPE
+// state (seeds that used to end in an impossible state)
+func TestAnnealerSupport(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		file string
+		seed int64
+	}{
+		{"../res/Pedigree_11.uai", 42},
+		{"../res/Promedus_13.uai", 2},
+	}
+	for _, c := range cases {
+		mod, err := model.NewModelFromFile(model.UAIReader{}, c.file, true)
+		assert.NoError(err)
+
+		gen, err := rand.NewGenerator(c.seed)
+		assert.NoError(err)
+		sa, err := NewAnnealer(gen, mod)
+		assert.NoError(err)
+		sa.Sweeps = 100
+
+		best, lp, err := sa.Run()
+		assert.NoError(err)
+		assert.False(math.IsInf(lp, -1), c.file)
+		check, err := mod.LogProb(best)
+		assert.NoError(err)
+		assert.InDelta(check, lp, 1e-9)
+	}
+}
+
+func TestGibbsTemperature(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := model.UAIReader{}
+	mod, err := model.NewModelFromFile(reader, "../res/sample.uai", false)
+	assert.NoError(err)
+
+	gen, err := rand.NewGenerator(42)
+	assert.NoError(err)
+	samp, err := NewGibbsSimple(gen, mod)
+	assert.NoError(err)
+
+	assert.Error(samp.SetTemperature(0.0))
+	assert.Error(samp.SetTemperature(-1.0))
+	assert.Error(samp.SetTemperature(math.NaN()))
+
+	// At a very low temperature sampling is greedy: with A=1 and B=0, C=2 is
+	// the best value and should (almost) always be selected
+	assert.NoError(samp.SetTemperature(1e-4))
+	state := []int{1, 0, 0}
+	copy(samp.last, state)
+	counts := make([]int, 3)
+	for i := 0; i < 100; i++ {
+		_, err := samp.SampleVar(2, state)
+		assert.NoError(err)
+		counts[state[2]]++
+	}
+	assert.True(counts[2] >= 99)
+}
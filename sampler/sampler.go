@@ -17,6 +17,21 @@ type FullSampler interface {
 	Sample([]int) (int, error)
 }
 
+// A WeightedFullSampler is the weighted-sample variant of FullSampler: the
+// given array is populated with an entire (independent) sample from the model
+// and the natural log of the sample's importance weight is returned. A weight
+// of zero (-Inf) means the sample was rejected.
+type WeightedFullSampler interface {
+	SampleWeighted([]int) (float64, error)
+}
+
+// A SeededSampler can choose its starting point from a set of (probably
+// approximate) marginals instead of uniformly at random. The variables passed
+// must match the model being sampled.
+type SeededSampler interface {
+	SeedFrom(vars []*model.Variable) error
+}
+
 // An AdaptiveSampler accepts a list of current chains and returns a new list
 // ready to advance. The simplest AdaptiveSampler just returns the chains
 // passed and is equivalent to whatever base sampler is currently in use.
@@ -0,0 +1,153 @@
+package sampler
+
+import (
+	"math"
+
+	"github.com/CraigKelly/grample/model"
+	"github.com/pkg/errors"
+)
+
+// WeightedMarginals accumulates marginals from weighted samples (like those
+// from a WeightedFullSampler). Weights can be very large or very small, so
+// every sum is kept relative to the largest log weight seen so far.
+type WeightedMarginals struct {
+	Vars        []*model.Variable // Marginal is the weighted count of each value (times exp(-LogScale))
+	LogScale    float64           // Log of the scale for every weighted sum
+	WeightSum   float64           // Sum of weights (times exp(-LogScale))
+	WeightSqSum float64           // Sum of squared weights (times exp(-2*LogScale))
+	SampleCount int64             // Samples added, including rejected samples
+	Accepted    int64             // Samples with non-zero weight
+}
+
+// NewWeightedMarginals creates an empty accumulator for the model's variables
+func NewWeightedMarginals(m *model.Model) (*WeightedMarginals, error) {
+	if m == nil {
+		return nil, errors.New("No model supplied")
+	}
+
+	vars := make([]*model.Variable, len(m.Vars))
+	for i, v := range m.Vars {
+		vars[i] = v.Clone()
+		for j := range vars[i].Marginal {
+			vars[i].Marginal[j] = 0.0
+		}
+	}
+
+	return &WeightedMarginals{Vars: vars, LogScale: math.Inf(-1)}, nil
+}
+
+// rescale moves all of our sums to the new log scale
+func (w *WeightedMarginals) rescale(logScale float64) {
+	mult := 0.0
+	if !math.IsInf(w.LogScale, -1) {
+		mult = math.Exp(w.LogScale - logScale)
+	}
+	for _, v := range w.Vars {
+		for i := range v.Marginal {
+			v.Marginal[i] *= mult
+		}
+	}
+	w.WeightSum *= mult
+	w.WeightSqSum *= mult * mult
+	w.LogScale = logScale
+}
+
+// Add accumulates a full sample with the given log weight
+func (w *WeightedMarginals) Add(sample []int, logWeight float64) error {
+	if len(sample) != len(w.Vars) {
+		return errors.Errorf("Sample size %d != Var size %d", len(sample), len(w.Vars))
+	}
+	if math.IsNaN(logWeight) || math.IsInf(logWeight, 1) {
+		return errors.Errorf("Invalid sample log weight %v", logWeight)
+	}
+
+	w.SampleCount++
+	if math.IsInf(logWeight, -1) {
+		return nil // Rejected
+	}
+	w.Accepted++
+
+	if logWeight > w.LogScale {
+		w.rescale(logWeight)
+	}
+	wt := math.Exp(logWeight - w.LogScale)
+
+	for i, v := range w.Vars {
+		val := sample[i]
+		if val < 0 || val >= v.Card {
+			return errors.Errorf("Invalid value %d for variable %s", val, v.Name)
+		}
+		v.Marginal[val] += wt
+	}
+	w.WeightSum += wt
+	w.WeightSqSum += wt * wt
+
+	return nil
+}
+
+// Merge adds the samples accumulated in other
+func (w *WeightedMarginals) Merge(other *WeightedMarginals) error {
+	if len(other.Vars) != len(w.Vars) {
+		return errors.Errorf("Can not merge %d vars into %d vars", len(other.Vars), len(w.Vars))
+	}
+
+	w.SampleCount += other.SampleCount
+	w.Accepted += other.Accepted
+	if other.Accepted < 1 {
+		return nil
+	}
+
+	if other.LogScale > w.LogScale {
+		w.rescale(other.LogScale)
+	}
+	mult := math.Exp(other.LogScale - w.LogScale)
+
+	for i, v := range w.Vars {
+		for j, m := range other.Vars[i].Marginal {
+			v.Marginal[j] += m * mult
+		}
+	}
+	w.WeightSum += other.WeightSum * mult
+	w.WeightSqSum += other.WeightSqSum * mult * mult
+
+	return nil
+}
+
+// Marginals returns normalized clones of our variables. It is an error if no
+// sample has been accepted.
+func (w *WeightedMarginals) Marginals() ([]*model.Variable, error) {
+	if w.Accepted < 1 {
+		return nil, errors.Errorf("No samples accepted out of %d", w.SampleCount)
+	}
+
+	vars := make([]*model.Variable, len(w.Vars))
+	for i, v := range w.Vars {
+		vars[i] = v.Clone()
+		if err := vars[i].NormMarginal(); err != nil {
+			return nil, errors.Wrapf(err, "Could not normalize marginal for %s", v.Name)
+		}
+	}
+
+	return vars, nil
+}
+
+// ESS returns the effective sample size (sum of weights squared over the sum
+// of squared weights). It is between 1 and the number of accepted samples
+// (or 0 if nothing has been accepted).
+func (w *WeightedMarginals) ESS() float64 {
+	if w.Accepted < 1 {
+		return 0.0
+	}
+	return w.WeightSum * w.WeightSum / w.WeightSqSum
+}
+
+// LogZ returns the importance sampling estimate of the log partition function:
+// the log of the mean weight over every sample (including rejected samples).
+// When weights are relative to a normalized proposal, the mean weight is an
+// unbiased estimate of Z.
+func (w *WeightedMarginals) LogZ() float64 {
+	if w.Accepted < 1 {
+		return math.Inf(-1)
+	}
+	return w.LogScale + math.Log(w.WeightSum) - math.Log(float64(w.SampleCount))
+}